@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/IvanBayan/nvmlfan/internal/gpu"
+)
+
+const (
+	// activityInterval is how often processes running on cards are listed.
+	activityInterval = 5 * time.Second
+	defaultCoolDown  = 2 * time.Minute
+)
+
+// cardActivity is what runs on a card, kept by UUID.
+type cardActivity struct {
+	profile     string
+	rank        int       // Position of the rule choosing profile, load and idle follow all rules.
+	last        time.Time // When processes chose profile last.
+	compute     bool      // Compute processes run on the card.
+	commands    []string  // Names and command lines of processes on the card.
+	unsupported bool      // Processes can't be listed, the card has no activity profile.
+}
+
+// clientProcess is a process running on a GPU.
+type clientProcess struct {
+	name, cmdline string
+}
+
+var (
+	activityMu    sync.Mutex
+	cardsActivity = map[string]cardActivity{}
+)
+
+// ActivityProfile returns the profile of GPU idx by processes running on
+// it, empty when it has none.
+func ActivityProfile(idx int) string {
+	if conf.Activity == nil {
+		return ""
+	}
+	activityMu.Lock()
+	defer activityMu.Unlock()
+	return cardsActivity[gpu.GetDeviceIdentity(idx).UUID].profile
+}
+
+// UpdateActivity lists processes on configured cards at now and switches
+// their profiles. A profile chosen by an earlier rule is switched to right
+// away, others only once the current profile wasn't chosen for the
+// cool-down.
+func UpdateActivity(now time.Time) {
+	if !watchingProcesses() {
+		return
+	}
+	var rules []*regexp.Regexp
+	if conf.Activity != nil {
+		for _, rule := range conf.Activity.Rules {
+			rules = append(rules, regexp.MustCompile(rule.Process))
+		}
+	}
+	changed := false
+	for idx := 0; idx < gpu.GetDeviceCount(); idx++ {
+		if _, ok := CardKey(conf, idx); !ok || Excluded(conf, idx) {
+			continue
+		}
+		if updateCardActivity(idx, now, rules) {
+			changed = true
+		}
+	}
+	if changed {
+		profileChanges.Add(1)
+	}
+}
+
+// updateCardActivity tells whether the profile of GPU idx changed.
+func updateCardActivity(idx int, now time.Time, rules []*regexp.Regexp) bool {
+	uuid := gpu.GetDeviceIdentity(idx).UUID
+	found, err := gpu.Processes(idx)
+	var processes []clientProcess
+	for _, p := range found {
+		name, cmdline := processCommand(p.PID)
+		processes = append(processes, clientProcess{name: name, cmdline: cmdline})
+	}
+	activityMu.Lock()
+	defer activityMu.Unlock()
+	a, seen := cardsActivity[uuid]
+	if err != nil {
+		if errors.Is(err, gpu.ErrLost) || errors.Is(err, gpu.ErrTransient) || a.unsupported {
+			return false
+		}
+		controllerLog.Warn("Can't list processes, the card keeps its settings regardless of activity", "GPU", idx, "error", err)
+		cardsActivity[uuid] = cardActivity{unsupported: true}
+		return a.profile != ""
+	}
+	a.compute = slices.ContainsFunc(found, func(p gpu.Process) bool { return p.Compute })
+	a.commands = nil
+	for _, p := range processes {
+		a.commands = append(a.commands, p.name, p.cmdline)
+	}
+	if conf.Activity == nil {
+		cardsActivity[uuid] = a
+		return false
+	}
+	profile, rank, reason := chooseProfile(processes, rules)
+	switch {
+	case seen && rank == a.rank:
+		a.last = now
+		cardsActivity[uuid] = a
+		return false
+	case seen && rank > a.rank && now.Sub(a.last) < coolDown():
+		cardsActivity[uuid] = a
+		return false
+	}
+	cardsActivity[uuid] = cardActivity{profile: profile, rank: rank, last: now, compute: a.compute, commands: a.commands}
+	if profile == a.profile {
+		return false
+	}
+	controllerLog.Info("GPU activity changed", "GPU", idx, "profile", profile, "reason", reason)
+	RecordEvent(idx, "activity", reason)
+	return true
+}
+
+// chooseProfile returns the activity profile for processes with its rank
+// and why it was chosen.
+func chooseProfile(processes []clientProcess, rules []*regexp.Regexp) (string, int, string) {
+	for i, rule := range conf.Activity.Rules {
+		matching := 0
+		for _, p := range processes {
+			if rules[i].MatchString(p.name) || rules[i].MatchString(p.cmdline) {
+				matching++
+			}
+		}
+		if matching > 0 && (!rule.Only || matching == len(processes)) {
+			return rule.Profile, i, fmt.Sprintf("%d processes match '%s'", matching, rule.Process)
+		}
+	}
+	if len(processes) > 0 && conf.Activity.Load != "" {
+		return conf.Activity.Load, len(rules), fmt.Sprintf("%d processes running", len(processes))
+	}
+	if len(processes) > 0 {
+		return conf.Activity.Idle, len(rules) + 1, "No rule matches running processes"
+	}
+	return conf.Activity.Idle, len(rules) + 1, "No processes running"
+}
+
+// ComputeRunning tells whether compute processes run on GPU idx as of the
+// last listing.
+func ComputeRunning(idx int) bool {
+	activityMu.Lock()
+	defer activityMu.Unlock()
+	return cardsActivity[gpu.GetDeviceIdentity(idx).UUID].compute
+}
+
+// ProcessCommands returns names and command lines of processes running on
+// GPU idx as of the last listing.
+func ProcessCommands(idx int) []string {
+	activityMu.Lock()
+	defer activityMu.Unlock()
+	return slices.Clone(cardsActivity[gpu.GetDeviceIdentity(idx).UUID].commands)
+}
+
+// watchingProcesses tells whether processes on cards are listed, for
+// activity profiles, rules or floors of busy cards.
+func watchingProcesses() bool {
+	if conf.Activity != nil {
+		return true
+	}
+	for _, rule := range cardRules() {
+		if rule.UsesProcesses() {
+			return true
+		}
+	}
+	for _, card := range conf.Cards {
+		if card.BusyFloor > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// WatchActivity lists processes on cards until ctx is canceled.
+func WatchActivity(ctx context.Context) {
+	if !watchingProcesses() {
+		return
+	}
+	go func() {
+		defer RestoreOnPanic()
+		ticker := daemonClock.NewTicker(activityInterval)
+		defer ticker.Stop()
+		for sleepCycle(ctx, ticker) {
+			UpdateActivity(daemonClock.Now())
+		}
+	}()
+}
+
+func coolDown() time.Duration {
+	if conf.Activity.CoolDown > 0 {
+		return conf.Activity.CoolDown
+	}
+	return defaultCoolDown
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/IvanBayan/nvmlfan/internal/config"
+)
+
+var apiMux = http.NewServeMux()
+
+func init() {
+	apiMux.HandleFunc("GET /explain", handleExplain)
+	apiMux.HandleFunc("GET /snapshot", handleSnapshot)
+}
+
+func apiSocket(cfg *config.APIConfig) string {
+	if cfg != nil && cfg.Socket != "" {
+		return cfg.Socket
+	}
+	return defaultAPISocket
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		apiLog.Error("Can't encode response", "error", err)
+	}
+}
+
+// handleSnapshot returns the latest snapshot of every polled GPU.
+func handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, Snapshots())
+}
+
+func handleExplain(w http.ResponseWriter, r *http.Request) {
+	gpu := r.URL.Query().Get("gpu")
+	if gpu == "" {
+		writeJSON(w, AllDecisions())
+		return
+	}
+	idx, err := strconv.Atoi(gpu)
+	if err != nil {
+		http.Error(w, "gpu must be an index", http.StatusBadRequest)
+		return
+	}
+	decision, ok := GetDecision(idx)
+	if !ok {
+		http.Error(w, fmt.Sprintf("GPU %d is not controlled", idx), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, []Decision{decision})
+}
+
+// apiPath is the absolute path of socket created by daemon, removed on exit.
+// Activated socket belongs to systemd and must survive restarts, it's kept.
+var apiPath string
+
+// ConfigureAPI starts serving the control API, the socket is accessible by
+// root only. Socket passed by systemd socket activation is preferred.
+func ConfigureAPI() {
+	if conf.API != nil && conf.API.Disabled {
+		return
+	}
+	path := apiSocket(conf.API)
+	listener, err := ActivationListener()
+	if err != nil {
+		apiLog.Error("Can't use activated API socket", "error", err)
+		return
+	}
+	if listener != nil {
+		path = listener.Addr().String()
+	} else {
+		path = absPath(path)
+		apiPath = path
+		// Remove socket left by a crashed instance
+		os.Remove(path)
+		// Socket is created 0600, there is no moment others can connect
+		umask := syscall.Umask(0o177)
+		listener, err = net.Listen("unix", path)
+		syscall.Umask(umask)
+		if err != nil {
+			apiLog.Error("Can't listen on API socket", "path", path, "error", err)
+			return
+		}
+	}
+	go func() {
+		apiLog.Info("Serving API", "socket", path)
+		if err := http.Serve(listener, apiMux); err != nil {
+			apiLog.Error("API server failed", "socket", path, "error", err)
+		}
+	}()
+}
+
+func CloseAPI() {
+	if apiPath != "" {
+		os.Remove(apiPath)
+	}
+}
+
+// APIGet performs a request to the running daemon and decodes JSON response into v.
+func APIGet(socket, path string, query url.Values, v any) error {
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+	u := url.URL{Scheme: "http", Host: "nvmlfan", Path: path, RawQuery: query.Encode()}
+	resp, err := client.Get(u.String())
+	if err != nil {
+		return fmt.Errorf("can't reach daemon at %s: %w", socket, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s", body)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
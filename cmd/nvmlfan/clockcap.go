@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/IvanBayan/nvmlfan/internal/gpu"
+)
+
+var (
+	clocksMu sync.Mutex
+	// cappedCards are UUIDs of cards whose clocks nvmlfan locked, they are
+	// reset on exit.
+	cappedCards = map[string]bool{}
+)
+
+// capClocks locks clocks of the card c below its caps once its fans ran at
+// maximum speed and it stayed above the ceiling, and resets them once the
+// card cooled down. temp was read at now.
+func (c *cardControl) capClocks(temp int, now time.Time) {
+	cfg := c.settings.ClockCap
+	clocksMu.Lock()
+	capped := cappedCards[c.uuid]
+	clocksMu.Unlock()
+	switch c.clocks.step(c.escalationTrend(temp, cfg.Ceiling, cfg.Recover, capped), now, cfg.After) {
+	case -1:
+		if capped {
+			return
+		}
+		if err := gpu.LockClocks(c.idx, cfg.Graphics, cfg.Memory); err != nil {
+			c.logger.Error("Can't cap clocks", "error", err)
+			return
+		}
+		clocksMu.Lock()
+		cappedCards[c.uuid] = true
+		clocksMu.Unlock()
+		c.logger.Warn("Cooling exhausted, clocks capped", "graphics", cfg.Graphics, "memory", cfg.Memory, "temp", temp)
+		RecordEvent(c.idx, "clock_cap", fmt.Sprintf("Clocks capped at %s at %d°C with fans at maximum", clockCaps(cfg.Graphics, cfg.Memory), temp))
+	case 1:
+		if err := gpu.ResetClocks(c.idx, cfg.Graphics > 0, cfg.Memory > 0); err != nil {
+			c.logger.Error("Can't reset clocks", "error", err)
+			return
+		}
+		clocksMu.Lock()
+		delete(cappedCards, c.uuid)
+		clocksMu.Unlock()
+		c.logger.Info("Temperature recovered, clock caps removed", "temp", temp)
+		ResolveEvent(c.idx, "clock_cap", fmt.Sprintf("Clock caps removed at %d°C", temp))
+	}
+}
+
+// RestoreClocks removes clock locks of GPU idx with clock caps configured.
+// They are reset even when this process didn't set them, like the
+// supervisor after a crash of the daemon.
+func RestoreClocks(idx int) {
+	card, _ := CardConfig(conf, idx)
+	cfg := card.ClockCap
+	if cfg == nil {
+		return
+	}
+	uuid := gpu.GetDeviceIdentity(idx).UUID
+	clocksMu.Lock()
+	capped := cappedCards[uuid]
+	delete(cappedCards, uuid)
+	clocksMu.Unlock()
+	if err := gpu.ResetClocks(idx, cfg.Graphics > 0, cfg.Memory > 0); err != nil {
+		controllerLog.Error("Can't reset clocks", "GPU", idx, "error", err)
+		return
+	}
+	if capped {
+		controllerLog.Info("Clock caps removed", "GPU", idx)
+		RecordEvent(idx, "clock_cap", "Clock caps removed")
+	}
+}
+
+// clockCaps describes caps of graphics and memory clocks in MHz.
+func clockCaps(graphics, memory int) string {
+	switch {
+	case graphics > 0 && memory > 0:
+		return fmt.Sprintf("graphics %d MHz, memory %d MHz", graphics, memory)
+	case graphics > 0:
+		return fmt.Sprintf("graphics %d MHz", graphics)
+	}
+	return fmt.Sprintf("memory %d MHz", memory)
+}
@@ -0,0 +1,502 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/IvanBayan/nvmlfan/internal/config"
+	"github.com/IvanBayan/nvmlfan/internal/gpu"
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+// Command is a nvmlfan subcommand, Run returns the process exit code.
+type Command struct {
+	Name    string
+	Summary string
+	Run     func(args []string) int
+}
+
+var commands []Command
+
+func init() {
+	commands = []Command{
+		{"run", "Control fans according to configuration (default)", RunCommand},
+		{"list", "List GPUs and their fans", ListCommand},
+		{"status", "Show temperatures, fan speeds and fan policies", StatusCommand},
+		{"set", "Set fixed fan speed", SetCommand},
+		{"restore", "Restore default fan control", RestoreCommand},
+		{"undrain", "Clear drained mark of GPUs left by emergency actions", UndrainCommand},
+		{"init", "Generate starter configuration for detected GPUs", InitCommand},
+		{"import", "Convert fan curves of other tools to configuration", ImportCommand},
+		{"install-service", "Install and enable systemd or OpenRC service", InstallServiceCommand},
+		{"check", "Validate configuration file", CheckCommand},
+		{"verify", "Check that a GPU honors manual fan control", VerifyCommand},
+		{"sweep", "Record steady temperature at every fan speed", SweepCommand},
+		{"doctor", "Diagnose NVML, driver, permissions and fan control support", DoctorCommand},
+		{"explain", "Ask running daemon how current fan speeds were chosen", ExplainCommand},
+		{"config", "Dump effective configuration of running daemon", ConfigCommand},
+		{"simulate", "Print temperature to fan speed mapping of a curve", SimulateCommand},
+		{"stats", "Query statistics database", StatsCommand},
+		{"version", "Show version, build and driver information", VersionCommand},
+		{"help", "Show this help", HelpCommand},
+	}
+}
+
+func FindCommand(name string) *Command {
+	for i := range commands {
+		if commands[i].Name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+func PrintUsage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [command] [flags]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-15s %s\n", cmd.Name, cmd.Summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' for flags of a command.\n", os.Args[0])
+}
+
+func HelpCommand(args []string) int {
+	PrintUsage()
+	return 0
+}
+
+func ListCommand(args []string) int {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	output := fs.String("output", "text", "Output format: text or json")
+	asJSON := fs.Bool("json", false, "Same as -output json")
+	watch := fs.Duration("watch", 0, "Refresh listing in place at this interval, e.g. 2s")
+	var filter DeviceFilter
+	filter.AddFlags(fs)
+	fs.Parse(args)
+	if *asJSON {
+		*output = "json"
+	}
+	if *output != "text" && *output != "json" {
+		fmt.Fprintf(os.Stderr, "Unknown output format '%s'\n", *output)
+		return 2
+	}
+	if err := gpu.InitNVML(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer gpu.Shutdown()
+
+	if *watch <= 0 {
+		return printListing(*output, &filter)
+	}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	ticker := time.NewTicker(*watch)
+	defer ticker.Stop()
+	for {
+		// Move cursor home and clear the screen
+		fmt.Print("\033[H\033[2J")
+		fmt.Printf("Every %v: nvmlfan list\t%s\n\n", *watch, time.Now().Format(time.DateTime))
+		if ret := printListing(*output, &filter); ret != 0 {
+			return ret
+		}
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return 0
+		}
+	}
+}
+
+func printListing(output string, filter *DeviceFilter) int {
+	info := gpu.GetSystemInfo()
+	code := 0
+	for idx := 0; idx < gpu.GetDeviceCount(); idx++ {
+		if !filter.Match(idx, gpu.GetDeviceIdentity(idx)) {
+			continue
+		}
+		var card gpu.CardInfo
+		err := gpu.Retry(func() (err error) {
+			card, err = gpu.GetCardInfo(idx)
+			return err
+		})
+		if err != nil {
+			// Other cards are still listed
+			fmt.Fprintln(os.Stderr, err)
+			code = 1
+			continue
+		}
+		info.Cards = append(info.Cards, card)
+	}
+	if output == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(info); err != nil {
+			fmt.Fprintf(os.Stderr, "Can't encode listing: %v\n", err)
+			return 1
+		}
+		return code
+	}
+	PrintSystemInfo(info)
+	return code
+}
+
+// loadExclude takes the exclude list from configuration at path for
+// commands touching fans outside the daemon, the rest of it doesn't apply to
+// them. A missing configuration excludes nothing unless it was asked for.
+func loadExclude(fs *flag.FlagSet, path string) error {
+	cfg, err := config.Load(path)
+	if errors.Is(err, os.ErrNotExist) && !isFlagPassed(fs, "config") {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	conf.Exclude = cfg.Exclude
+	return nil
+}
+
+// checkExcluded tells that GPU idx is excluded and can't be touched.
+func checkExcluded(idx int) error {
+	if Excluded(conf, idx) {
+		return fmt.Errorf("GPU %d is excluded in configuration", idx)
+	}
+	return nil
+}
+
+func RestoreCommand(args []string) int {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	idx := fs.Int("gpu", -1, "Restore only this GPU")
+	fan := fs.Int("fan", -1, "Restore only this fan of -gpu")
+	pidPath := fs.String("pidfile", defaultPidFile, "Pid file of the daemon")
+	configPath := fs.String("config", defaultConfigPath, "Configuration with GPUs to exclude")
+	fs.Parse(args)
+	if err := loadExclude(fs, *configPath); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if pid, ok := RunningDaemon(*pidPath); ok {
+		fmt.Fprintf(os.Stderr, "Warning: nvmlfan daemon (PID %d) is running and will take control back on its next cycle\n", pid)
+	}
+	if err := gpu.InitNVML(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return RestoreFans(*idx, *fan)
+}
+
+// RestoreFans returns fans to default control: all GPUs when idx is
+// negative, otherwise all fans of GPU idx or only the given fan.
+func RestoreFans(idx, fan int) int {
+	if idx < 0 {
+		if fan >= 0 {
+			fmt.Fprintln(os.Stderr, "-fan requires -gpu")
+			return 2
+		}
+		defer gpu.Shutdown()
+		ReleaseFans()
+		return 0
+	}
+	defer gpu.Shutdown()
+	if idx >= gpu.GetDeviceCount() {
+		fmt.Fprintf(os.Stderr, "GPU %d not found\n", idx)
+		return 1
+	}
+	if err := checkExcluded(idx); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if fan < 0 {
+		if err := gpu.DefaultFansSpeed(idx); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		ClearHeld(idx)
+		return 0
+	}
+	if fan >= gpu.GetNumFans(idx) {
+		fmt.Fprintf(os.Stderr, "GPU %d has no fan %d\n", idx, fan)
+		return 1
+	}
+	if ret := gpu.SetSingleFanDefault(idx, fan); ret != nvml.SUCCESS {
+		fmt.Fprintf(os.Stderr, "Can't restore GPU %d fan %d: %v\n", idx, fan, nvml.ErrorString(ret))
+		return 1
+	}
+	return 0
+}
+
+// SetCommand pins fans of one GPU to a fixed speed. The speed stays until
+// `nvmlfan restore`, with -hold the command keeps reapplying it and restores
+// default control itself when interrupted.
+func SetCommand(args []string) int {
+	fs := flag.NewFlagSet("set", flag.ExitOnError)
+	idx := fs.Int("gpu", -1, "GPU index")
+	fan := fs.Int("fan", -1, "Fan index, all fans of the GPU when omitted")
+	speed := fs.Int("speed", -1, "Fan speed in percents")
+	hold := fs.Bool("hold", false, "Keep running and holding the speed until interrupted, then restore defaults")
+	period := fs.Int("period", defaultPeriod, "Seconds between reapplying the speed with -hold")
+	pidPath := fs.String("pidfile", defaultPidFile, "Pid file of the daemon")
+	configPath := fs.String("config", defaultConfigPath, "Configuration with GPUs to exclude")
+	fs.Parse(args)
+
+	if *idx < 0 || *speed < 0 {
+		fmt.Fprintln(os.Stderr, "Both -gpu and -speed are required")
+		fs.Usage()
+		return 2
+	}
+	if *fan < -1 {
+		fmt.Fprintf(os.Stderr, "Invalid fan index %d\n", *fan)
+		fs.Usage()
+		return 2
+	}
+	if err := loadExclude(fs, *configPath); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if pid, ok := RunningDaemon(*pidPath); ok {
+		fmt.Fprintf(os.Stderr, "Warning: nvmlfan daemon (PID %d) is running and will take control back on its next cycle\n", pid)
+	}
+	if err := gpu.InitNVML(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer gpu.Shutdown()
+
+	if *idx >= gpu.GetDeviceCount() {
+		fmt.Fprintf(os.Stderr, "GPU %d not found\n", *idx)
+		return 1
+	}
+	if err := checkExcluded(*idx); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fanCount := gpu.GetNumFans(*idx)
+	if *fan >= fanCount {
+		fmt.Fprintf(os.Stderr, "GPU %d has only %d fans\n", *idx, fanCount)
+		return 1
+	}
+	device, err := gpu.DeviceGetHandleByIndex(*idx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	minSpeed, maxSpeed := gpu.GetMinMaxFanSpeed(device)
+	if *speed < minSpeed || *speed > maxSpeed {
+		fmt.Fprintf(os.Stderr, "Speed %d is out of GPU %d range %d-%d\n", *speed, *idx, minSpeed, maxSpeed)
+		return 1
+	}
+
+	var fans []int
+	for fi := 0; fi < fanCount; fi++ {
+		if *fan < 0 || fi == *fan {
+			fans = append(fans, fi)
+		}
+	}
+	apply := func() bool {
+		for _, fi := range fans {
+			if ret := gpu.SetSingleFanSpeed(*idx, fi, *speed); ret != nvml.SUCCESS {
+				fmt.Fprintf(os.Stderr, "Can't set GPU %d fan %d speed: %v\n", *idx, fi, nvml.ErrorString(ret))
+				return false
+			}
+		}
+		return true
+	}
+	// Only fans the command set are given back, others may be held by someone else
+	restore := func() int {
+		code := 0
+		for _, fi := range fans {
+			if ret := gpu.SetSingleFanDefault(*idx, fi); ret != nvml.SUCCESS {
+				fmt.Fprintf(os.Stderr, "Can't restore GPU %d fan %d: %v\n", *idx, fi, nvml.ErrorString(ret))
+				code = 1
+			}
+		}
+		return code
+	}
+	if !apply() {
+		restore()
+		return 1
+	}
+	if !*hold {
+		MarkHeld(*idx)
+		return 0
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			restore()
+			panic(r)
+		}
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	ticker := time.NewTicker(time.Duration(*period) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			apply()
+		case <-stop:
+			return restore()
+		}
+	}
+}
+
+// StatusCommand prints one line per GPU and fan with current temperature,
+// fan speed, target speed and control policy.
+func StatusCommand(args []string) int {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	pidPath := fs.String("pidfile", defaultPidFile, "Pid file of the daemon")
+	socket := fs.String("socket", defaultAPISocket, "API socket of the daemon, to show health of its cards")
+	fs.Parse(args)
+	if err := gpu.InitNVML(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer gpu.Shutdown()
+
+	states := map[string]Health{}
+	if pid, ok := RunningDaemon(*pidPath); ok {
+		fmt.Printf("Daemon is running, PID %d\n", pid)
+		var cards []CardHealth
+		if err := APIGet(*socket, "/health", nil, &cards); err == nil {
+			for _, h := range cards {
+				states[h.UUID] = h.State
+			}
+		}
+	} else {
+		fmt.Println("Daemon is not running")
+	}
+
+	for idx := 0; idx < gpu.GetDeviceCount(); idx++ {
+		device, err := gpu.DeviceGetHandleByIndex(idx)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		temp, err := gpu.GetTemperature(idx)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		id := gpu.GetDeviceIdentity(idx)
+		line := fmt.Sprintf("%2d: %v - %d°C", idx, id.Name, temp)
+		if state, ok := states[id.UUID]; ok {
+			line += ", " + string(state)
+		}
+		if Drained(id.UUID) {
+			line += ", drained"
+		}
+		fmt.Println(line)
+		for fi := 0; fi < gpu.GetNumFans(idx); fi++ {
+			speed, ret := device.GetFanSpeed_v2(fi)
+			if ret != nvml.SUCCESS {
+				fmt.Fprintf(os.Stderr, "Can't get speed of GPU %d fan %d: %v\n", idx, fi, nvml.ErrorString(ret))
+				continue
+			}
+			target, ret := device.GetTargetFanSpeed(fi)
+			if ret != nvml.SUCCESS {
+				fmt.Fprintf(os.Stderr, "Can't get target speed of GPU %d fan %d: %v\n", idx, fi, nvml.ErrorString(ret))
+				continue
+			}
+			policy, ret := device.GetFanControlPolicy_v2(fi)
+			name := gpu.FanPolicyName(policy)
+			if ret == nvml.ERROR_NOT_SUPPORTED {
+				name = "n/a"
+			} else if ret != nvml.SUCCESS {
+				fmt.Fprintf(os.Stderr, "Can't get policy of GPU %d fan %d: %v\n", idx, fi, nvml.ErrorString(ret))
+				continue
+			}
+			fmt.Printf("  +- Fan: %d Speed: %d%% Target: %d%% Policy: %s\n", fi, speed, target, name)
+		}
+	}
+	return 0
+}
+
+// DeviceFilter selects GPUs by index, UUID or product name glob. Empty
+// filter matches every GPU, otherwise a GPU must match every set criterion.
+type DeviceFilter struct {
+	Indexes []int
+	UUIDs   []string
+	Names   []string // path.Match patterns, e.g. "*RTX 3090*".
+}
+
+func (f *DeviceFilter) AddFlags(fs *flag.FlagSet) {
+	fs.Func("gpu", "Comma separated GPU indexes", func(value string) error {
+		for _, item := range splitList(value) {
+			idx, err := strconv.Atoi(item)
+			if err != nil {
+				return err
+			}
+			f.Indexes = append(f.Indexes, idx)
+		}
+		return nil
+	})
+	fs.Func("uuid", "Comma separated GPU UUIDs", func(value string) error {
+		f.UUIDs = append(f.UUIDs, splitList(value)...)
+		return nil
+	})
+	fs.Func("name", "GPU name glob, e.g. \"*RTX 3090*\"", func(value string) error {
+		if _, err := path.Match(value, ""); err != nil {
+			return err
+		}
+		f.Names = append(f.Names, value)
+		return nil
+	})
+}
+
+func (f *DeviceFilter) Match(idx int, id gpu.DeviceIdentity) bool {
+	if len(f.Indexes) > 0 && !slices.Contains(f.Indexes, idx) {
+		return false
+	}
+	if len(f.UUIDs) > 0 && !slices.Contains(f.UUIDs, id.UUID) {
+		return false
+	}
+	if len(f.Names) > 0 && !slices.ContainsFunc(f.Names, func(pattern string) bool {
+		matched, _ := path.Match(pattern, id.Name)
+		return matched
+	}) {
+		return false
+	}
+	return true
+}
+
+// splitList splits comma separated flag value, ignoring empty items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func CheckCommand(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "Path to configuration file")
+	fs.Parse(args)
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	errs := config.Validate(cfg)
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if len(errs) > 0 {
+		return 1
+	}
+	fmt.Printf("%s: OK\n", *configPath)
+	return 0
+}
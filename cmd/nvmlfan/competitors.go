@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/IvanBayan/nvmlfan/internal/gpu"
+)
+
+// knownControllers maps process names of other fan controllers to program
+// names. Two controllers setting the same fans make both of them useless.
+// nvidia-settings isn't one of them: it runs as a settings GUI and as the
+// fallback of nvmlfan itself, fans it set are found by their manual policy,
+// see FindExternalCards.
+var knownControllers = map[string]string{
+	"gwe":            "GreenWithEnvy",
+	"fancontrol":     "fancontrol",
+	"nvfancontrol":   "nvfancontrol",
+	"coolercontrold": "CoolerControl",
+	"nvmlfan":        "nvmlfan",
+}
+
+// competitorCheckInterval is how often processes are checked for fan
+// controllers started after the daemon.
+const competitorCheckInterval = time.Minute
+
+// forceControl is set by --force, control is taken even if another fan
+// controller is running.
+var forceControl bool
+
+type competitor struct {
+	Pid  int
+	Name string
+}
+
+// CheckCompetitors warns about fan controllers already running and refuses
+// to start unless --force is given. It runs before NVML is initialized, so
+// refusing leaves their fans untouched.
+func CheckCompetitors() error {
+	found := FindCompetitors()
+	if len(found) == 0 {
+		return nil
+	}
+	for _, c := range found {
+		controllerLog.Warn("Another fan controller is running", "program", c.Name, "pid", c.Pid)
+	}
+	if forceControl {
+		controllerLog.Warn("Taking control of fans anyway")
+		return nil
+	}
+	return errors.New("another fan controller is running, stop it or run with --force")
+}
+
+var (
+	skippedMu sync.Mutex
+	// skippedCards are configured cards left alone by UUID with the reason,
+	// e.g. another program controlling their fans.
+	skippedCards = map[string]string{}
+)
+
+// SkipCard leaves GPU idx alone for reason.
+func SkipCard(idx int, reason string) {
+	skippedMu.Lock()
+	skippedCards[gpu.GetDeviceIdentity(idx).UUID] = reason
+	skippedMu.Unlock()
+	SetHealth(idx, HealthReleased, reason)
+}
+
+// Skipped returns why GPU idx is left alone, if it is.
+func Skipped(idx int) (string, bool) {
+	skippedMu.Lock()
+	defer skippedMu.Unlock()
+	reason, ok := skippedCards[gpu.GetDeviceIdentity(idx).UUID]
+	return reason, ok
+}
+
+// FindExternalCards records cards of gpus whose fans were in manual mode
+// not left by nvmlfan itself, unless their configuration allows taking
+// them over. Fans have to be captured first.
+func FindExternalCards(gpus []int) {
+	for _, idx := range gpus {
+		fans := gpu.ManualFans(idx)
+		if len(fans) == 0 || HeldByUs(idx) {
+			continue
+		}
+		if card, _ := CardConfig(conf, idx); card.TakeOver {
+			controllerLog.Warn("Taking over fans set to manual mode by another program", "GPU", idx, "fans", fans)
+			continue
+		}
+		controllerLog.Warn("Fans are in manual mode set by another program, leaving the card alone, set take_over to control it", "GPU", idx, "fans", fans)
+		SkipCard(idx, "controlled by another program")
+	}
+}
+
+// WatchCompetitors checks for fan controllers started while the daemon
+// runs until ctx is canceled. Each one is reported once.
+func WatchCompetitors(ctx context.Context, gpus []int) {
+	seen := map[int]bool{}
+	for _, c := range FindCompetitors() {
+		seen[c.Pid] = true
+	}
+	go func() {
+		defer RestoreOnPanic()
+		ticker := time.NewTicker(competitorCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			for _, c := range FindCompetitors() {
+				if seen[c.Pid] {
+					continue
+				}
+				seen[c.Pid] = true
+				controllerLog.Warn("Another fan controller started", "program", c.Name, "pid", c.Pid)
+				for _, idx := range gpus {
+					RecordEvent(idx, "competitor", fmt.Sprintf("%s (pid %d) started", c.Name, c.Pid))
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FindCompetitors returns running fan controllers found in /proc, except
+// this process, its ancestors, which are the supervisor and the parent
+// waiting for the daemon to start, and its descendants, e.g. hooks.
+func FindCompetitors() []competitor {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+	own := map[int]bool{}
+	for pid := os.Getpid(); pid > 1 && !own[pid]; pid = parentPid(pid) {
+		own[pid] = true
+	}
+	var found []competitor
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || own[pid] {
+			continue
+		}
+		comm, err := os.ReadFile(filepath.Join("/proc", entry.Name(), "comm"))
+		if err != nil {
+			continue
+		}
+		name := strings.TrimSpace(string(comm))
+		program, ok := knownControllers[name]
+		if !ok {
+			continue
+		}
+		if name == "nvmlfan" && !controlsFans(pid) || descendant(pid, os.Getpid()) {
+			continue
+		}
+		found = append(found, competitor{Pid: pid, Name: program})
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].Pid < found[j].Pid })
+	return found
+}
+
+// parentPid returns the parent of pid, 0 if it can't be read.
+func parentPid(pid int) int {
+	stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return 0
+	}
+	// Command name in parentheses may contain spaces
+	i := bytes.LastIndexByte(stat, ')')
+	if i < 0 {
+		return 0
+	}
+	fields := strings.Fields(string(stat[i+1:]))
+	if len(fields) < 2 {
+		return 0
+	}
+	ppid, _ := strconv.Atoi(fields[1])
+	return ppid
+}
+
+// descendant tells if pid is a child of ancestor or of one of its children.
+func descendant(pid, ancestor int) bool {
+	for pid > 1 {
+		if pid = parentPid(pid); pid == ancestor {
+			return true
+		}
+	}
+	return false
+}
+
+// controlsFans tells if nvmlfan process pid runs the daemon rather than a
+// short command like list or status.
+func controlsFans(pid int) bool {
+	cmdline, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "cmdline"))
+	if err != nil {
+		return false
+	}
+	args := strings.Split(strings.TrimRight(string(cmdline), "\x00"), "\x00")
+	return len(args) < 2 || args[1] == "run" || strings.HasPrefix(args[1], "-")
+}
+
+// processCommand returns the name and command line of pid, empty when it
+// can't be read, e.g. the process runs in another PID namespace.
+func processCommand(pid int) (string, string) {
+	dir := filepath.Join("/proc", strconv.Itoa(pid))
+	comm, err := os.ReadFile(filepath.Join(dir, "comm"))
+	if err != nil {
+		return "", ""
+	}
+	cmdline, _ := os.ReadFile(filepath.Join(dir, "cmdline"))
+	return strings.TrimSpace(string(comm)), strings.Join(strings.Split(strings.TrimRight(string(cmdline), "\x00"), "\x00"), " ")
+}
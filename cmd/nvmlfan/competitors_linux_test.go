@@ -0,0 +1,104 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeController copies sleep under the name of a known fan controller.
+func fakeController(t *testing.T) string {
+	t.Helper()
+	sleep, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skip("sleep isn't installed")
+	}
+	data, err := os.ReadFile(sleep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "fancontrol")
+	if err := os.WriteFile(path, data, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// found tells if FindCompetitors reports pid.
+func found(pid int) bool {
+	for _, c := range FindCompetitors() {
+		if c.Pid == pid {
+			return true
+		}
+	}
+	return false
+}
+
+func TestFindCompetitors(t *testing.T) {
+	program := fakeController(t)
+
+	// Children, e.g. hooks, and their children belong to nvmlfan
+	child := exec.Command("sh", "-c", program+" 30 & echo $!; wait")
+	out, err := child.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := child.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		child.Process.Kill()
+		child.Wait()
+	})
+	var line [16]byte
+	n, _ := out.Read(line[:])
+	grandchild, err := strconv.Atoi(strings.TrimSpace(string(line[:n])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { killProcess(grandchild) })
+	waitForComm(t, grandchild)
+	if found(grandchild) {
+		t.Errorf("own descendant %d reported as a competitor", grandchild)
+	}
+
+	// A controller left to init isn't
+	orphan := exec.Command("sh", "-c", program+" 30 >/dev/null 2>&1 & echo $!")
+	data, err := orphan.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { killProcess(pid) })
+	waitForComm(t, pid)
+	if descendant(pid, os.Getpid()) {
+		t.Skip("orphans are reparented to this process, it is a subreaper")
+	}
+	if !found(pid) {
+		t.Errorf("fancontrol %d not reported", pid)
+	}
+}
+
+// waitForComm waits until pid runs the fake controller.
+func waitForComm(t *testing.T, pid int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if name, _ := processCommand(pid); name == "fancontrol" {
+			return
+		}
+	}
+	t.Fatalf("process %d didn't start", pid)
+}
+
+func killProcess(pid int) {
+	if p, err := os.FindProcess(pid); err == nil {
+		p.Kill()
+	}
+}
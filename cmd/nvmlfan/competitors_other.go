@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+// FindCompetitors finds nothing, processes are only inspected on Linux.
+func FindCompetitors() []competitor {
+	return nil
+}
+
+// processCommand knows nothing, processes are only inspected on Linux.
+func processCommand(pid int) (string, string) {
+	return "", ""
+}
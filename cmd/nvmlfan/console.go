@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	colorReset  = "\033[0m"
+	colorGray   = "\033[90m"
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+	colorCyan   = "\033[36m"
+
+	// Messages are padded to this width so attributes line up.
+	consoleMessageWidth = 36
+)
+
+// ConsoleHandler is a human-friendly handler for interactive foreground use:
+// short time, colored level, GPU and component prefix and aligned attributes.
+type ConsoleHandler struct {
+	mu        *sync.Mutex
+	w         io.Writer
+	level     slog.Leveler
+	color     bool
+	source    bool   // Print file:line of the log call.
+	gpu       string // Prefix taken from GPU attribute.
+	component string
+	attrs     string // Preformatted attributes added by WithAttrs.
+	group     string // Current group prefix, e.g. "a.b.".
+}
+
+// NewConsoleHandler creates handler writing to w, colors are used only when
+// w is a terminal and NO_COLOR isn't set.
+func NewConsoleHandler(w *os.File, level slog.Leveler, source bool) *ConsoleHandler {
+	color := false
+	if info, err := w.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		color = os.Getenv("NO_COLOR") == ""
+	}
+	return &ConsoleHandler{mu: &sync.Mutex{}, w: w, level: level, color: color, source: source}
+}
+
+func (h *ConsoleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *ConsoleHandler) paint(color, s string) string {
+	if !h.color {
+		return s
+	}
+	return color + s + colorReset
+}
+
+func (h *ConsoleHandler) levelString(level slog.Level) string {
+	switch {
+	case level >= slog.LevelError:
+		return h.paint(colorRed, "ERR")
+	case level >= slog.LevelWarn:
+		return h.paint(colorYellow, "WRN")
+	case level >= slog.LevelInfo:
+		return h.paint(colorGreen, "INF")
+	default:
+		return h.paint(colorGray, "DBG")
+	}
+}
+
+// appendAttr formats attribute as key=value, flattening groups. GPU and
+// component are returned separately since they go into the prefix.
+func (h *ConsoleHandler) appendAttr(b *bytes.Buffer, group string, a slog.Attr, gpu, component *string) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if group == "" {
+		switch a.Key {
+		case "GPU":
+			*gpu = a.Value.String()
+			return
+		case "component":
+			*component = a.Value.String()
+			return
+		}
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		prefix := group
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			h.appendAttr(b, prefix, ga, gpu, component)
+		}
+		return
+	}
+	value := a.Value.String()
+	if a.Value.Kind() == slog.KindTime {
+		value = a.Value.Time().Format(time.RFC3339)
+	}
+	if strings.ContainsAny(value, " \t\"=") || value == "" {
+		value = fmt.Sprintf("%q", value)
+	}
+	fmt.Fprintf(b, " %s=%s", h.paint(colorCyan, group+a.Key), value)
+}
+
+func (h *ConsoleHandler) Handle(_ context.Context, r slog.Record) error {
+	gpu, component := h.gpu, h.component
+	var attrs bytes.Buffer
+	attrs.WriteString(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		h.appendAttr(&attrs, h.group, a, &gpu, &component)
+		return true
+	})
+
+	var b bytes.Buffer
+	b.WriteString(h.paint(colorGray, r.Time.Format("15:04:05.000")))
+	b.WriteString(" ")
+	b.WriteString(h.levelString(r.Level))
+	prefix := ""
+	if gpu != "" {
+		prefix = "GPU" + gpu
+	}
+	if component != "" {
+		if prefix != "" {
+			prefix += "/"
+		}
+		prefix += component
+	}
+	fmt.Fprintf(&b, " %-16s ", prefix)
+	if attrs.Len() > 0 {
+		fmt.Fprintf(&b, "%-*s", consoleMessageWidth, r.Message)
+	} else {
+		b.WriteString(r.Message)
+	}
+	b.Write(attrs.Bytes())
+	if h.source && r.PC != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+		fmt.Fprintf(&b, " %s", h.paint(colorGray, fmt.Sprintf("(%s:%d)", filepath.Base(frame.File), frame.Line)))
+	}
+	b.WriteString("\n")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.w.Write(b.Bytes())
+	return err
+}
+
+func (h *ConsoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	var b bytes.Buffer
+	b.WriteString(h.attrs)
+	for _, a := range attrs {
+		h.appendAttr(&b, h.group, a, &clone.gpu, &clone.component)
+	}
+	clone.attrs = b.String()
+	return &clone
+}
+
+func (h *ConsoleHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	clone := *h
+	clone.group += name + "."
+	return &clone
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/IvanBayan/nvmlfan/internal/config"
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+// InContainer guesses whether nvmlfan runs in a container from markers left
+// by Docker, Podman and Kubernetes.
+func InContainer() bool {
+	for _, marker := range []string{"/.dockerenv", "/run/.containerenv"} {
+		if _, err := os.Stat(marker); err == nil {
+			return true
+		}
+	}
+	return os.Getenv("KUBERNETES_SERVICE_HOST") != "" || os.Getenv("container") != ""
+}
+
+// ApplyContainerMode adjusts configuration for containers: no daemonization,
+// no pid file and logs only to stdout, where the container runtime collects them.
+func ApplyContainerMode() {
+	conf.Foreground = true
+	conf.PidFile = "-"
+	var outputs config.LoggingConfig
+	for _, output := range conf.Logging {
+		switch output["type"] {
+		case "stdout", "console":
+			outputs = append(outputs, output)
+		case "json":
+			if output["path"] == "" {
+				outputs = append(outputs, output)
+			}
+		}
+	}
+	if len(outputs) == 0 && len(conf.Logging) > 0 {
+		outputs = config.LoggingConfig{{"type": defaultLoggingType, "level": conf.Logging[0]["level"]}}
+	}
+	conf.Logging = outputs
+}
+
+// CheckContainerDevices returns an actionable error when GPU isn't passed
+// into the container, before NVML reports a bare error code.
+func CheckContainerDevices() error {
+	if _, err := os.Stat("/dev/nvidiactl"); err != nil {
+		return fmt.Errorf("/dev/nvidiactl is missing, start the container with NVIDIA container runtime (docker run --gpus all) or pass /dev/nvidia* devices")
+	}
+	if ret := nvml.Init(); ret != nvml.SUCCESS {
+		if ret == nvml.ERROR_LIBRARY_NOT_FOUND {
+			return fmt.Errorf("libnvidia-ml.so.1 is not found, set NVIDIA_DRIVER_CAPABILITIES=utility so the runtime mounts it")
+		}
+		return fmt.Errorf("NVML initialization failed: %v", nvml.ErrorString(ret))
+	}
+	nvml.Shutdown()
+	return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	// daemonEnv marks the detached child, its value is the readiness pipe descriptor.
+	daemonEnv = "NVMLFAN_DAEMON"
+	// daemonStartTimeout limits how long the parent waits for readiness.
+	daemonStartTimeout = time.Minute
+)
+
+// IsDaemonChild reports whether this process is the detached daemon started by Daemonize.
+func IsDaemonChild() bool {
+	return os.Getenv(daemonEnv) != ""
+}
+
+// absPath makes path absolute, the daemon leaves working directory after
+// startup.
+func absPath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	return abs
+}
+
+// daemonOutput returns where stdout and stderr of the daemon go: the first
+// log file of configuration, or /dev/null.
+func daemonOutput() (*os.File, error) {
+	for _, output := range conf.Logging {
+		if output["type"] == "file" && output["path"] != "" {
+			return os.OpenFile(output["path"], os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		}
+	}
+	return os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+}
+
+// Daemonize starts a copy of the process in a new session, detached from the
+// controlling terminal, and waits until it reports readiness, so the exit
+// code tells whether the daemon actually started. Go runtime can't fork, the
+// copy is started by re-executing the binary. It never opens terminals, so
+// the second fork, which prevents reacquiring one, isn't needed.
+func Daemonize() int {
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer devNull.Close()
+	output, err := daemonOutput()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer output.Close()
+	ready, notify, err := os.Pipe()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer ready.Close()
+
+	exe, err := os.Executable()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	attr := &os.ProcAttr{
+		Env: append(os.Environ(), daemonEnv+"=3"),
+		// Readiness pipe becomes descriptor 3 of the child
+		Files: []*os.File{devNull, output, output, notify},
+		Sys:   &syscall.SysProcAttr{Setsid: true},
+	}
+	proc, err := os.StartProcess(exe, os.Args, attr)
+	notify.Close()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	pid := proc.Pid
+	proc.Release()
+
+	result := make(chan string, 1)
+	go func() {
+		data, _ := io.ReadAll(ready)
+		result <- string(data)
+	}()
+	select {
+	case state := <-result:
+		if state != "READY" {
+			fmt.Fprintf(os.Stderr, "Daemon (PID %d) failed to start, check the log\n", pid)
+			return 1
+		}
+	case <-time.After(daemonStartTimeout):
+		fmt.Fprintf(os.Stderr, "Daemon (PID %d) didn't report readiness in %v\n", pid, daemonStartTimeout)
+		return 1
+	}
+	return 0
+}
+
+// NotifyParent reports readiness to the process waiting in Daemonize and
+// moves the daemon out of the directory it was started from.
+func NotifyParent() {
+	if !IsDaemonChild() {
+		return
+	}
+	fd, err := strconv.Atoi(os.Getenv(daemonEnv))
+	os.Unsetenv(daemonEnv)
+	if err != nil {
+		controllerLog.Error("Invalid readiness descriptor", "error", err)
+		return
+	}
+	if err := os.Chdir("/"); err != nil {
+		controllerLog.Error("Can't change directory", "error", err)
+	}
+	pipe := os.NewFile(uintptr(fd), "ready")
+	defer pipe.Close()
+	if _, err := pipe.WriteString("READY"); err != nil {
+		controllerLog.Error("Can't report readiness", "error", err)
+	}
+}
+
+// takeReadinessPipe returns the readiness pipe inherited from Daemonize, so
+// the supervisor can hand it over to the controller.
+func takeReadinessPipe() *os.File {
+	if !IsDaemonChild() {
+		return nil
+	}
+	fd, err := strconv.Atoi(os.Getenv(daemonEnv))
+	os.Unsetenv(daemonEnv)
+	if err != nil {
+		return nil
+	}
+	syscall.CloseOnExec(fd)
+	return os.NewFile(uintptr(fd), "ready")
+}
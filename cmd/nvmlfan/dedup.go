@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultDedupWindow = time.Minute
+
+type dedupEntry struct {
+	handler slog.Handler // Handler which logged the first occurrence.
+	record  slog.Record  // First occurrence.
+	first   time.Time
+	count   int // Suppressed occurrences.
+}
+
+type dedupState struct {
+	mu      sync.Mutex
+	window  time.Duration
+	entries map[string]*dedupEntry
+	timer   *time.Timer // Fires when the oldest entry's window is over.
+	closed  bool
+}
+
+// sweep summarizes messages whose window is over and arms the timer for the
+// next one. Must be called with mu held.
+func (s *dedupState) sweep(now time.Time) {
+	var next time.Duration
+	for k, e := range s.entries {
+		if left := s.window - now.Sub(e.first); left > 0 {
+			if next == 0 || left < next {
+				next = left
+			}
+			continue
+		}
+		if e.count > 0 {
+			summarize(context.Background(), e, now)
+		}
+		delete(s.entries, k)
+	}
+	switch {
+	case next == 0 && s.timer != nil:
+		s.timer.Stop()
+		s.timer = nil
+	case next > 0 && s.timer == nil:
+		s.timer = time.AfterFunc(next, s.expire)
+	case next > 0:
+		s.timer.Reset(next)
+	}
+}
+
+func (s *dedupState) expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.sweep(time.Now())
+	}
+}
+
+// DedupHandler drops identical records (same level, message and attributes)
+// repeated within window and logs a "Message repeated" summary instead, when
+// the window is over or the handler is closed.
+type DedupHandler struct {
+	inner  slog.Handler
+	prefix string // Attributes and groups added by WithAttrs/WithGroup.
+	state  *dedupState
+}
+
+func NewDedupHandler(inner slog.Handler, window time.Duration) *DedupHandler {
+	return &DedupHandler{
+		inner: inner,
+		state: &dedupState{window: window, entries: map[string]*dedupEntry{}},
+	}
+}
+
+func (h *DedupHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+func (h *DedupHandler) key(r slog.Record) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d|%s|%s", r.Level, h.prefix, r.Message)
+	r.Attrs(func(a slog.Attr) bool {
+		fmt.Fprintf(&b, "|%s=%v", a.Key, a.Value)
+		return true
+	})
+	return b.String()
+}
+
+func (h *DedupHandler) Handle(ctx context.Context, r slog.Record) error {
+	key := h.key(r)
+	now := time.Now()
+
+	h.state.mu.Lock()
+	defer h.state.mu.Unlock()
+
+	if h.state.closed {
+		return h.inner.Handle(ctx, r)
+	}
+	// Timer may be late, summaries go before a new occurrence
+	h.state.sweep(now)
+	if e, ok := h.state.entries[key]; ok {
+		e.count++
+		return nil
+	}
+	h.state.entries[key] = &dedupEntry{handler: h.inner, record: r.Clone(), first: now}
+	if h.state.timer == nil {
+		h.state.timer = time.AfterFunc(h.state.window, h.state.expire)
+	}
+	return h.inner.Handle(ctx, r)
+}
+
+// Close logs summaries of pending repeats, records are passed through
+// afterwards.
+func (h *DedupHandler) Close() {
+	h.state.mu.Lock()
+	defer h.state.mu.Unlock()
+	if h.state.closed {
+		return
+	}
+	h.state.closed = true
+	if h.state.timer != nil {
+		h.state.timer.Stop()
+		h.state.timer = nil
+	}
+	now := time.Now()
+	for _, e := range h.state.entries {
+		if e.count > 0 {
+			summarize(context.Background(), e, now)
+		}
+	}
+	clear(h.state.entries)
+}
+
+func summarize(ctx context.Context, e *dedupEntry, now time.Time) {
+	summary := slog.NewRecord(now, e.record.Level, "Message repeated", 0)
+	summary.AddAttrs(slog.String("message", e.record.Message), slog.Int("count", e.count),
+		slog.Duration("period", now.Sub(e.first).Round(time.Second)))
+	e.record.Attrs(func(a slog.Attr) bool {
+		summary.AddAttrs(a)
+		return true
+	})
+	e.handler.Handle(ctx, summary)
+}
+
+func (h *DedupHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var b strings.Builder
+	b.WriteString(h.prefix)
+	for _, a := range attrs {
+		fmt.Fprintf(&b, "|%s=%v", a.Key, a.Value)
+	}
+	return &DedupHandler{inner: h.inner.WithAttrs(attrs), prefix: b.String(), state: h.state}
+}
+
+func (h *DedupHandler) WithGroup(name string) slog.Handler {
+	return &DedupHandler{inner: h.inner.WithGroup(name), prefix: h.prefix + "|" + name + ".", state: h.state}
+}
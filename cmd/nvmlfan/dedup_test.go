@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is written by the dedup timer and read by the test.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestDedupHandler(t *testing.T) {
+	tests := []struct {
+		name  string
+		close bool // Close instead of waiting for the window
+		want  string
+	}{
+		{"window over", false, "count=2"},
+		{"closed", true, "count=2"},
+	}
+	for _, tt := range tests {
+		var out syncBuffer
+		window := 50 * time.Millisecond
+		if tt.close {
+			window = time.Hour
+		}
+		h := NewDedupHandler(slog.NewTextHandler(&out, nil), window)
+		logger := slog.New(h).With("GPU", 0)
+		for range 3 {
+			logger.Info("Skip, speed unchanged")
+		}
+		if strings.Contains(out.String(), "Message repeated") {
+			t.Errorf("%s: summary logged before the window is over", tt.name)
+		}
+		if tt.close {
+			h.Close()
+		} else {
+			time.Sleep(4 * window)
+		}
+		got := out.String()
+		if strings.Count(got, "Skip, speed unchanged") != 2 || !strings.Contains(got, "Message repeated") || !strings.Contains(got, tt.want) {
+			t.Errorf("%s: output\n%s\nwant one record and a summary with %s", tt.name, got, tt.want)
+		}
+		// Closed handler passes records through
+		h.Close()
+		logger.Info("After close")
+		logger.Info("After close")
+		if tt.close && strings.Count(out.String(), "After close") != 2 {
+			t.Errorf("%s: closed handler dropped records:\n%s", tt.name, out.String())
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/IvanBayan/nvmlfan/internal/gpu"
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+// Oldest driver branch providing SetFanSpeed_v2 and GetMinMaxFanSpeed.
+const minDriverMajor = 515
+
+// NVML functions nvmlfan can't work without.
+var requiredSymbols = []string{
+	"nvmlDeviceGetNumFans",
+	"nvmlDeviceGetMinMaxFanSpeed",
+	"nvmlDeviceGetFanControlPolicy_v2",
+	"nvmlDeviceGetTargetFanSpeed",
+	"nvmlDeviceSetFanSpeed_v2",
+	"nvmlDeviceSetDefaultFanSpeed_v2",
+}
+
+type doctor struct {
+	failures int
+	warnings int
+}
+
+func (d *doctor) ok(format string, args ...any) {
+	fmt.Printf("[ OK ] "+format+"\n", args...)
+}
+
+func (d *doctor) warn(hint, format string, args ...any) {
+	d.warnings++
+	fmt.Printf("[WARN] "+format+"\n", args...)
+	if hint != "" {
+		fmt.Printf("       %s\n", hint)
+	}
+}
+
+func (d *doctor) fail(hint, format string, args ...any) {
+	d.failures++
+	fmt.Printf("[FAIL] "+format+"\n", args...)
+	if hint != "" {
+		fmt.Printf("       %s\n", hint)
+	}
+}
+
+func (d *doctor) checkDeviceNodes() {
+	nodes, _ := filepath.Glob("/dev/nvidia*")
+	if len(nodes) == 0 {
+		d.fail("Make sure nvidia kernel module is loaded (lsmod | grep nvidia).", "No /dev/nvidia* device nodes")
+		return
+	}
+	for _, node := range nodes {
+		info, err := os.Stat(node)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		if err := NodeAccessError(node); err != nil {
+			d.fail("Run nvmlfan as root or grant access to the device node.", "%v", err)
+			continue
+		}
+		d.ok("%s is accessible", node)
+	}
+}
+
+func (d *doctor) checkDriver() {
+	version, ret := nvml.SystemGetDriverVersion()
+	if ret != nvml.SUCCESS {
+		d.warn("", "Can't get driver version: %v", nvml.ErrorString(ret))
+		return
+	}
+	major, err := strconv.Atoi(strings.SplitN(version, ".", 2)[0])
+	if err != nil {
+		d.warn("", "Can't parse driver version '%s'", version)
+		return
+	}
+	if major < minDriverMajor {
+		d.fail(fmt.Sprintf("Upgrade to driver %d or newer.", minDriverMajor),
+			"Driver %s is too old for fan control API", version)
+		return
+	}
+	d.ok("Driver %s", version)
+
+	for _, symbol := range requiredSymbols {
+		if err := nvml.Extensions().LookupSymbol(symbol); err != nil {
+			d.fail("Upgrade libnvidia-ml to match the kernel driver.", "NVML library lacks %s", symbol)
+		}
+	}
+}
+
+func (d *doctor) checkGPU(idx int) {
+	device, ret := nvml.DeviceGetHandleByIndex(idx)
+	if ret != nvml.SUCCESS {
+		d.fail("", "GPU %d: can't get handle: %v", idx, nvml.ErrorString(ret))
+		return
+	}
+	name, _ := device.GetName()
+	fans, ret := device.GetNumFans()
+	if ret != nvml.SUCCESS {
+		d.fail("The card can't be controlled by nvmlfan.", "GPU %d (%s): GetNumFans: %v", idx, name, nvml.ErrorString(ret))
+		return
+	}
+	if fans == 0 {
+		d.warn("Passively cooled cards have nothing to control.", "GPU %d (%s): no fans", idx, name)
+		return
+	}
+	minSpeed, maxSpeed, ret := device.GetMinMaxFanSpeed()
+	if ret != nvml.SUCCESS {
+		d.fail("The card doesn't report its fan range, SetFanSpeed_v2 is likely unsupported too.",
+			"GPU %d (%s): GetMinMaxFanSpeed: %v", idx, name, nvml.ErrorString(ret))
+		return
+	}
+	d.ok("GPU %d (%s): %d fans, speed range %d-%d%%", idx, name, fans, minSpeed, maxSpeed)
+	for fi := 0; fi < fans; fi++ {
+		policy, ret := device.GetFanControlPolicy_v2(fi)
+		if ret != nvml.SUCCESS {
+			d.warn("", "GPU %d fan %d: GetFanControlPolicy_v2: %v", idx, fi, nvml.ErrorString(ret))
+			continue
+		}
+		if policy == nvml.FAN_POLICY_MANUAL {
+			d.warn("Another tool or a previous nvmlfan run controls it, `nvmlfan restore` returns it to the driver.",
+				"GPU %d fan %d: fan is under manual control", idx, fi)
+		} else {
+			d.ok("GPU %d fan %d: %s policy", idx, fi, gpu.FanPolicyName(policy))
+		}
+	}
+}
+
+// DoctorCommand checks the environment and prints actionable findings.
+func DoctorCommand(args []string) int {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	fs.Parse(args)
+	d := &doctor{}
+
+	if !IsPrivileged() {
+		d.warn("NVML lets only administrators change fan policy.", "Insufficient privileges: %s", privilegeHint)
+	}
+	d.checkDeviceNodes()
+
+	if ret := nvml.Init(); ret != nvml.SUCCESS {
+		hint := "Check that the nvidia driver is loaded and nvidia-smi works."
+		if ret == nvml.ERROR_LIBRARY_NOT_FOUND {
+			hint = "Install libnvidia-ml (for debian `apt install libnvidia-ml1`)."
+		}
+		d.fail(hint, "NVML is not available: %v", nvml.ErrorString(ret))
+	} else {
+		d.ok("NVML initialized")
+		d.checkDriver()
+		count, ret := nvml.DeviceGetCount()
+		if ret != nvml.SUCCESS {
+			d.fail("", "Can't get device count: %v", nvml.ErrorString(ret))
+		}
+		for idx := 0; idx < count; idx++ {
+			d.checkGPU(idx)
+		}
+		nvml.Shutdown()
+	}
+
+	var procs []string
+	for _, c := range FindCompetitors() {
+		procs = append(procs, fmt.Sprintf("%s (%d)", c.Name, c.Pid))
+	}
+	if len(procs) > 0 {
+		d.warn("Two fan controllers will fight over the same fans, stop the other one.",
+			"Other fan control software is running: %s", strings.Join(procs, ", "))
+	} else {
+		d.ok("No competing fan control software found")
+	}
+
+	fmt.Printf("\n%d failures, %d warnings\n", d.failures, d.warnings)
+	if d.failures > 0 {
+		return 1
+	}
+	return 0
+}
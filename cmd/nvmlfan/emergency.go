@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/IvanBayan/nvmlfan/internal/config"
+	"github.com/IvanBayan/nvmlfan/internal/gpu"
+)
+
+const (
+	defaultEmergencyCoolDown = 10 * time.Minute
+	// emergencyExecTimeout limits a single run of the emergency command.
+	emergencyExecTimeout = time.Minute
+)
+
+var emergencySignals = map[string]os.Signal{"TERM": syscall.SIGTERM, "INT": os.Interrupt, "KILL": os.Kill}
+
+// AuditEntry is a line of the audit log, one per emergency action or power
+// limit change.
+type AuditEntry struct {
+	Time   time.Time `json:"time"`
+	GPU    int       `json:"gpu"`
+	UUID   string    `json:"uuid"`
+	Name   string    `json:"name"`
+	Temp   int       `json:"temp,omitempty"`
+	Action string    `json:"action"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+var auditMu sync.Mutex
+
+func auditPath(cfg *config.EmergencyConfig) string {
+	if cfg != nil && cfg.AuditLog != "" {
+		return cfg.AuditLog
+	}
+	return defaultAuditPath
+}
+
+// audit appends entry to the audit log at path, an action isn't taken
+// without its entry.
+func audit(path string, entry AuditEntry) error {
+	var data bytes.Buffer
+	encoder := json.NewEncoder(&data)
+	// Commands are logged as they are, with their < and >
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(entry); err != nil {
+		return err
+	}
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// emergency acts as configured once the card c reached the critical
+// temperature with its fans at maximum speed, at most once per cool-down.
+// temp was read at now.
+func (c *cardControl) emergency(temp int, now time.Time) {
+	cfg := c.settings.Emergency
+	if c.output < c.maxSpeed || temp < cfg.Critical {
+		return
+	}
+	coolDown := cfg.CoolDown
+	if coolDown <= 0 {
+		coolDown = defaultEmergencyCoolDown
+	}
+	if !c.emergencyAt.IsZero() && now.Sub(c.emergencyAt) < coolDown {
+		return
+	}
+	c.emergencyAt = now
+	c.logger.Error("Critical temperature with fans at maximum, emergency actions taken", "temp", temp, "critical", cfg.Critical)
+	RecordEvent(c.idx, "emergency", fmt.Sprintf("Critical temperature %d°C with fans at maximum", temp))
+
+	id := gpu.GetDeviceIdentity(c.idx)
+	path := auditPath(cfg)
+	record := func(action, detail string) bool {
+		entry := AuditEntry{Time: now, GPU: c.idx, UUID: id.UUID, Name: id.Name, Temp: temp, Action: action, Detail: detail}
+		if err := audit(path, entry); err != nil {
+			c.logger.Error("Can't write audit log, emergency action isn't taken", "action", action, "path", path, "error", err)
+			return false
+		}
+		return true
+	}
+	var pids []int
+	if processes, err := gpu.Processes(c.idx); err == nil {
+		for _, p := range processes {
+			if p.Compute && p.PID != os.Getpid() {
+				pids = append(pids, p.PID)
+			}
+		}
+	}
+	if cfg.Drain && !Drained(id.UUID) && record("drain", "") {
+		if err := MarkDrained(c.idx); err != nil {
+			c.logger.Error("Can't mark GPU drained", "error", err)
+		} else {
+			c.logger.Warn("GPU marked drained")
+			RecordEvent(c.idx, "emergency", "Marked drained")
+		}
+	}
+	if cfg.Signal != "" && len(pids) > 0 && record("signal", fmt.Sprintf("SIG%s to %s", cfg.Signal, joinPIDs(pids))) {
+		c.signalProcesses(cfg.Signal, pids)
+	}
+	if len(cfg.Exec) > 0 && record("exec", strings.Join(cfg.Exec, " ")) {
+		env := []string{
+			"NVMLFAN_GPU=" + strconv.Itoa(c.idx),
+			"NVMLFAN_UUID=" + id.UUID,
+			"NVMLFAN_TEMP=" + strconv.Itoa(temp),
+			"NVMLFAN_PIDS=" + joinPIDs(pids),
+		}
+		// The control loop keeps running while the command does
+		go c.runEmergencyCommand(cfg.Exec, env)
+	}
+}
+
+// signalProcesses sends signal to processes pids of the card c.
+func (c *cardControl) signalProcesses(signal string, pids []int) {
+	// Simulated processes don't exist, their PIDs may belong to real ones
+	if gpu.Backend() != "nvml" {
+		c.logger.Warn("Processes of a simulated GPU aren't signaled", "signal", signal, "pids", pids)
+		return
+	}
+	for _, pid := range pids {
+		process, err := os.FindProcess(pid)
+		if err == nil {
+			err = process.Signal(emergencySignals[signal])
+		}
+		if err != nil {
+			c.logger.Error("Can't signal GPU process", "pid", pid, "signal", signal, "error", err)
+			continue
+		}
+		name, _ := processCommand(pid)
+		c.logger.Warn("GPU process signaled", "pid", pid, "process", name, "signal", signal)
+		RecordEvent(c.idx, "emergency", fmt.Sprintf("SIG%s sent to process %d %s", signal, pid, name))
+	}
+}
+
+// runEmergencyCommand runs command with env added to the environment.
+func (c *cardControl) runEmergencyCommand(command, env []string) {
+	defer RestoreOnPanic()
+	out, err := runCommand(command, env, emergencyExecTimeout)
+	if err != nil {
+		c.logger.Error("Emergency command failed", "command", command, "error", err, "output", out)
+		return
+	}
+	c.logger.Info("Emergency command finished", "command", command, "output", out)
+}
+
+func joinPIDs(pids []int) string {
+	s := make([]string, len(pids))
+	for i, pid := range pids {
+		s[i] = strconv.Itoa(pid)
+	}
+	return strings.Join(s, " ")
+}
+
+// UUIDs of GPUs marked drained by an emergency are kept in the drained file
+// for schedulers and scripts to check, until `nvmlfan undrain`.
+
+// MarkDrained marks GPU idx drained.
+func MarkDrained(idx int) error {
+	uuid := gpu.GetDeviceIdentity(idx).UUID
+	drained := readUUIDs(defaultDrainedPath)
+	if uuid == "" || slices.Contains(drained, uuid) {
+		return nil
+	}
+	return writeUUIDs(defaultDrainedPath, append(drained, uuid))
+}
+
+// Drained tells if the GPU with uuid is marked drained.
+func Drained(uuid string) bool {
+	return uuid != "" && slices.Contains(readUUIDs(defaultDrainedPath), uuid)
+}
+
+// UndrainCommand implements `nvmlfan undrain`, it clears the drained mark of
+// one GPU or all of them.
+func UndrainCommand(args []string) int {
+	fs := flag.NewFlagSet("undrain", flag.ExitOnError)
+	idx := fs.Int("gpu", -1, "Undrain only this GPU")
+	fs.Parse(args)
+	var drained []string
+	if *idx >= 0 {
+		if err := gpu.InitNVML(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer gpu.Shutdown()
+		if *idx >= gpu.GetDeviceCount() {
+			fmt.Fprintf(os.Stderr, "GPU %d not found\n", *idx)
+			return 1
+		}
+		uuid := gpu.GetDeviceIdentity(*idx).UUID
+		drained = slices.DeleteFunc(readUUIDs(defaultDrainedPath), func(u string) bool { return u == uuid })
+	}
+	if err := writeUUIDs(defaultDrainedPath, drained); err != nil {
+		fmt.Fprintf(os.Stderr, "Can't undrain: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,46 @@
+package main
+
+import "time"
+
+// defaultEscalationAfter is how long a card has to stay too hot, or cool
+// again, before the next escalation step by default.
+const defaultEscalationAfter = 30 * time.Second
+
+// escalation tracks how long a card has been too hot with its fans at
+// maximum speed, or cool again while an escalation is in effect.
+type escalation struct {
+	trend int       // -1 while too hot, 1 while cool again, 0 otherwise.
+	since time.Time // Since the trend holds or the last step.
+}
+
+// step takes trend at now and returns it once it held for after, then
+// again after as long, 0 meanwhile.
+func (e *escalation) step(trend int, now time.Time, after time.Duration) int {
+	if trend != e.trend {
+		e.trend, e.since = trend, now
+	}
+	if after <= 0 {
+		after = defaultEscalationAfter
+	}
+	if trend == 0 || now.Sub(e.since) < after {
+		return 0
+	}
+	e.since = now
+	return trend
+}
+
+// escalationTrend returns the trend of card c at temp: too hot above
+// ceiling with fans at maximum, or cool again at recoverTemp (5°C below
+// ceiling when 0) while active.
+func (c *cardControl) escalationTrend(temp, ceiling, recoverTemp int, active bool) int {
+	if recoverTemp == 0 {
+		recoverTemp = ceiling - 5
+	}
+	switch {
+	case c.output >= c.maxSpeed && temp > ceiling:
+		return -1
+	case active && temp <= recoverTemp:
+		return 1
+	}
+	return 0
+}
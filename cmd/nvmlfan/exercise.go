@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/IvanBayan/nvmlfan/internal/config"
+	"github.com/IvanBayan/nvmlfan/internal/gpu"
+)
+
+const (
+	defaultExerciseDuration  = 3 * time.Minute
+	defaultExerciseSettle    = 30 * time.Second
+	defaultExerciseTolerance = 10
+)
+
+// A fan exercise runs fans at maximum speed for a while, which blows dust
+// out of heatsinks and shows fans which can't reach their speed anymore
+// before they are needed. Cards take turns, fans of a whole rig don't get
+// loud at once.
+
+var (
+	exerciseMu sync.Mutex
+	// exerciseRequested is when the last exercise was requested, cards
+	// exercised for an older one take their turn.
+	exerciseRequested time.Time
+	exerciseOwner     string // UUID of the card being exercised.
+	exerciseSince     time.Time
+)
+
+// fanExercise is the exercise of one card in progress.
+type fanExercise struct {
+	request    time.Time
+	start      time.Time
+	checked    bool // Speeds were checked after settling.
+	failed     int
+	unverified bool // Fan speeds couldn't be measured.
+}
+
+func exerciseSettings() config.ExerciseConfig {
+	e := *conf.Exercise
+	if e.Duration == 0 {
+		e.Duration = defaultExerciseDuration
+	}
+	if e.Settle == 0 {
+		e.Settle = defaultExerciseSettle
+	}
+	if e.Tolerance == 0 {
+		e.Tolerance = defaultExerciseTolerance
+	}
+	return e
+}
+
+// RequestExercise starts an exercise of all controlled cards requested at t.
+func RequestExercise(t time.Time) {
+	exerciseMu.Lock()
+	defer exerciseMu.Unlock()
+	if !t.After(exerciseRequested) {
+		return
+	}
+	controllerLog.Info("Fan exercise started")
+	exerciseRequested = t
+}
+
+// WatchExercise requests exercises as scheduled until ctx is canceled.
+func WatchExercise(ctx context.Context) {
+	if conf.Exercise == nil {
+		return
+	}
+	cron, err := config.ParseCron(conf.Exercise.Cron)
+	if err != nil {
+		controllerLog.Error("Fan exercise is disabled", "error", err)
+		return
+	}
+	go func() {
+		defer RestoreOnPanic()
+		ticker := daemonClock.NewTicker(scheduleInterval)
+		defer ticker.Stop()
+		for sleepCycle(ctx, ticker) {
+			// Checked more often than every minute, a minute requests once
+			if now := daemonClock.Now(); cron.Matches(now) {
+				RequestExercise(now.Truncate(time.Minute))
+			}
+		}
+	}()
+}
+
+// checkExercise starts the exercise of card c when it's its turn, checks
+// measured fan speeds once they had time to settle and finishes it.
+func (c *cardControl) checkExercise(snapshot gpu.Snapshot) {
+	settings := exerciseSettings()
+	if c.exercise == nil {
+		c.startExercise(snapshot.Time, settings)
+		return
+	}
+	e := c.exercise
+	elapsed := snapshot.Time.Sub(e.start)
+	if !e.checked && elapsed >= settings.Settle {
+		e.checked = true
+		c.checkFans(e, settings)
+	}
+	if elapsed < settings.Duration {
+		return
+	}
+	exerciseMu.Lock()
+	if exerciseOwner == c.uuid {
+		exerciseOwner = ""
+	}
+	exerciseMu.Unlock()
+	c.exercise, c.exercisedFor = nil, e.request
+	message := fmt.Sprintf("Fans ran at %d%% for %v", c.maxSpeed, settings.Duration)
+	switch {
+	case e.unverified:
+		message += ", their speed isn't measured so they weren't checked"
+	case e.failed > 0:
+		message += fmt.Sprintf(", %d of %d didn't reach it", e.failed, len(snapshot.Fans))
+	}
+	c.logger.Info("Fan exercise finished", "failed", e.failed, "unverified", e.unverified)
+	RecordEvent(c.idx, "exercise", message)
+}
+
+// checkFans compares measured speeds of fans of card c running at maximum,
+// the speed NVML reports is only what fans are driven at. A fan fails when it
+// stands still or runs more than the tolerance slower than the fastest fan
+// of the card.
+func (c *cardControl) checkFans(e *fanExercise, settings config.ExerciseConfig) {
+	rpms := make([]int, gpu.GetNumFans(c.idx))
+	fastest := 0
+	for fi := range rpms {
+		rpm, err := gpu.GetFanRPM(c.idx, fi)
+		if errors.Is(err, gpu.ErrNotMeasured) {
+			e.unverified = true
+			c.logger.Warn("Fan speed isn't measured, fans can't be checked", "fan", fi)
+			return
+		}
+		if err != nil {
+			e.unverified = true
+			c.logger.Error("Can't measure fan speed, fans can't be checked", "fan", fi, "error", err)
+			return
+		}
+		rpms[fi], fastest = rpm, max(fastest, rpm)
+	}
+	for fi, rpm := range rpms {
+		var problem string
+		switch {
+		case rpm == 0:
+			problem = fmt.Sprintf("Fan %d stands still at %d%%", fi, c.maxSpeed)
+		case rpm < fastest*(100-settings.Tolerance)/100:
+			problem = fmt.Sprintf("Fan %d runs at %d RPM, %d%% slower than the fastest fan of the card at %d%%",
+				fi, rpm, 100-rpm*100/fastest, c.maxSpeed)
+		default:
+			c.logger.Debug("Fan reached its speed", "fan", fi, "rpm", rpm)
+			continue
+		}
+		e.failed++
+		c.logger.Error("Fan doesn't reach its speed", "fan", fi, "rpm", rpm, "fastest", fastest, "after", settings.Settle)
+		RecordEvent(c.idx, "fan_failure", problem)
+	}
+}
+
+// startExercise starts the exercise of card c at now unless it was
+// exercised already or another card runs its own.
+func (c *cardControl) startExercise(now time.Time, settings config.ExerciseConfig) {
+	exerciseMu.Lock()
+	defer exerciseMu.Unlock()
+	if !exerciseRequested.After(c.exercisedFor) {
+		return
+	}
+	// A card which went away in its turn doesn't hold the others
+	if exerciseOwner != "" && exerciseOwner != c.uuid && now.Sub(exerciseSince) < settings.Duration+time.Minute {
+		return
+	}
+	if c.failsafe {
+		c.logger.Warn("Fan exercise skipped, fans run at failsafe speed")
+		c.exercisedFor = exerciseRequested
+		return
+	}
+	if c.released {
+		c.logger.Info("Fan control taken back from the driver for exercise")
+		RecordEvent(c.idx, "release", "Fan control taken back from the driver for exercise")
+		// Released again once idle as long as configured
+		c.interval, c.released, c.idle, c.last = 0, false, false, time.Time{}
+	}
+	exerciseOwner, exerciseSince = c.uuid, now
+	c.exercise = &fanExercise{request: exerciseRequested, start: now}
+	c.logger.Info("Exercising fans", "speed", c.maxSpeed, "duration", settings.Duration)
+}
+
+// exerciseSpeed raises speed to maximum while fans of c are exercised.
+func (c *cardControl) exerciseSpeed(speed int) int {
+	if c.exercise == nil {
+		return speed
+	}
+	AddFilter(c.idx, fmt.Sprintf("raised to %d%% for fan exercise", c.maxSpeed), c.maxSpeed)
+	return c.maxSpeed
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/IvanBayan/nvmlfan/internal/config"
+)
+
+func TestExerciseChecksMeasuredSpeed(t *testing.T) {
+	tests := []struct {
+		name       string
+		gpu        config.SimGPUConfig
+		failed     int
+		unverified bool
+	}{
+		{"healthy fans", config.SimGPUConfig{Fans: 2}, 0, false},
+		{"worn fan", config.SimGPUConfig{Fans: 3, WornFans: []int{1}}, 1, false},
+		{"speed not measured", config.SimGPUConfig{Fans: 2, WornFans: []int{1}, Consumer: true}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Cleanup(func() {
+				exerciseRequested, exerciseOwner, exerciseSince = time.Time{}, "", time.Time{}
+			})
+			s, clk := simScheduler(t, config.Config{
+				Cards:    map[string]config.GPUConfig{"0": {Mode: "curve", Curve: [][2]int{{40, 30}, {80, 100}}}},
+				Sim:      config.SimConfig{GPUs: []config.SimGPUConfig{tt.gpu}},
+				Exercise: &config.ExerciseConfig{Cron: "0 4 * * *", Duration: time.Minute, Settle: 20 * time.Second},
+			})
+			RequestExercise(clk.Now())
+			runRounds(t, s, clk, 30)
+			e := s.cards[0].exercise
+			if e == nil || !e.checked {
+				t.Fatal("fans weren't exercised and checked")
+			}
+			if e.failed != tt.failed || e.unverified != tt.unverified {
+				t.Errorf("%d fans failed, unverified %v, want %d and %v", e.failed, e.unverified, tt.failed, tt.unverified)
+			}
+			runRounds(t, s, clk, 40)
+			if s.cards[0].exercise != nil {
+				t.Error("exercise didn't finish")
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IvanBayan/nvmlfan/internal/config"
+	"github.com/IvanBayan/nvmlfan/internal/gpu"
+	"github.com/IvanBayan/nvmlfan/pkg/controller"
+)
+
+// Decision explains how the last fan speed of a GPU was chosen.
+type Decision struct {
+	Time    time.Time            `json:"time"`
+	GPU     int                  `json:"gpu"`
+	UUID    string               `json:"uuid"`
+	Name    string               `json:"name"`
+	Mode    string               `json:"mode"`
+	Temp    int                  `json:"temp"`              // Raw sensor reading.
+	Segment string               `json:"segment,omitempty"` // Curve segment used in curve mode.
+	PID     *controller.PIDTerms `json:"pid,omitempty"`     // PID terms in target mode.
+	Raw     int                  `json:"raw"`               // Controller output before clamps.
+	Clamps  []string             `json:"clamps"`            // Limits which modified the output.
+	Filters []string             `json:"filters"`           // Card settings which changed the clamped output.
+	Output  int                  `json:"output"`
+}
+
+var (
+	decisionsMu sync.Mutex
+	decisions   = map[int]Decision{}
+)
+
+func RecordDecision(d Decision) {
+	id := gpu.GetDeviceIdentity(d.GPU)
+	d.UUID, d.Name = id.UUID, id.Name
+	d.Time = time.Now()
+	if d.Filters == nil {
+		d.Filters = []string{}
+	}
+	if d.Clamps == nil {
+		d.Clamps = []string{}
+	}
+	decisionsMu.Lock()
+	defer decisionsMu.Unlock()
+	decisions[d.GPU] = d
+}
+
+// AddFilter notes that filter changed the output of the last decision of
+// GPU idx to output.
+func AddFilter(idx int, filter string, output int) {
+	decisionsMu.Lock()
+	defer decisionsMu.Unlock()
+	d, ok := decisions[idx]
+	if !ok {
+		return
+	}
+	d.Filters = append(slices.Clip(d.Filters), filter)
+	d.Output = output
+	decisions[idx] = d
+}
+
+func GetDecision(idx int) (Decision, bool) {
+	decisionsMu.Lock()
+	defer decisionsMu.Unlock()
+	d, ok := decisions[idx]
+	return d, ok
+}
+
+func AllDecisions() []Decision {
+	decisionsMu.Lock()
+	defer decisionsMu.Unlock()
+	result := make([]Decision, 0, len(decisions))
+	for _, d := range decisions {
+		result = append(result, d)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].GPU < result[j].GPU })
+	return result
+}
+
+func printDecision(d Decision) {
+	fmt.Printf("%2d: %v - %v\n", d.GPU, d.Name, d.UUID)
+	fmt.Printf("  +- Decided: %v ago, mode: %v\n", time.Since(d.Time).Round(time.Second), d.Mode)
+	fmt.Printf("  +- Temperature: %d°C\n", d.Temp)
+	if d.Segment != "" {
+		fmt.Printf("  +- Curve: %s\n", d.Segment)
+	}
+	if d.PID != nil {
+		p := d.PID
+		fmt.Printf("  +- PID: target %d°C error %.1f\n", p.Target, p.Error)
+		fmt.Printf("  |    P = %.2f * %.1f = %.2f\n", p.Kp, p.Error, p.P)
+		fmt.Printf("  |    I = %.2f (accumulated, ki %.2f, antiwindup: %v)\n", p.I, p.Ki, p.Antiwindup)
+		fmt.Printf("  |    D = %.2f\n", p.D)
+	}
+	fmt.Printf("  +- Controller output: %d%%\n", d.Raw)
+	if len(d.Clamps) > 0 {
+		fmt.Printf("  +- Clamps: %s\n", strings.Join(d.Clamps, ", "))
+	}
+	if len(d.Filters) > 0 {
+		fmt.Printf("  +- Filters: %s\n", strings.Join(d.Filters, ", "))
+	}
+	fmt.Printf("  +- Fan speed: %d%%\n", d.Output)
+}
+
+// ExplainCommand asks the running daemon why fans have their current speed.
+func ExplainCommand(args []string) int {
+	fs := flag.NewFlagSet("explain", flag.ExitOnError)
+	configPath := fs.String("config", "", "Path to configuration file, to find API socket")
+	socket := fs.String("socket", "", "Path to API socket (overrides config)")
+	gpu := fs.Int("gpu", -1, "Explain only this GPU")
+	fs.Parse(args)
+
+	path := *socket
+	if path == "" {
+		var cfg config.Config
+		if *configPath != "" {
+			var err error
+			if cfg, err = config.Load(*configPath); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+		}
+		path = apiSocket(cfg.API)
+	}
+	query := url.Values{}
+	if *gpu >= 0 {
+		query.Set("gpu", strconv.Itoa(*gpu))
+	}
+	var result []Decision
+	if err := APIGet(path, "/explain", query, &result); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	for _, d := range result {
+		printDecision(d)
+	}
+	return 0
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IvanBayan/nvmlfan/internal/config"
+)
+
+func TestDecisionFilters(t *testing.T) {
+	curve := [][2]int{{40, 30}, {80, 100}}
+	s, clk := simScheduler(t, config.Config{
+		Cards: map[string]config.GPUConfig{
+			"0": {Mode: "curve", Curve: curve, Group: "rig"},
+			"1": {Mode: "curve", Curve: curve, Group: "rig"},
+		},
+		Sim: config.SimConfig{GPUs: []config.SimGPUConfig{
+			{Profile: "full", TimeConstant: 30 * time.Second},
+			{Profile: "idle", TimeConstant: 30 * time.Second},
+		}},
+	})
+	t.Cleanup(func() {
+		groupsMu.Lock()
+		clear(groups)
+		groupsMu.Unlock()
+	})
+	runRounds(t, s, clk, 120)
+	d, ok := GetDecision(1)
+	if !ok {
+		t.Fatal("no decision recorded")
+	}
+	if len(d.Filters) != 1 || !strings.HasPrefix(d.Filters[0], "following GPU 0") {
+		t.Errorf("filters %q, want following GPU 0", d.Filters)
+	}
+	if d.Output != s.cards[0].output || d.Output == d.Raw {
+		t.Errorf("output %d%% from %d%%, want %d%% of the leader", d.Output, d.Raw, s.cards[0].output)
+	}
+	if leader, _ := GetDecision(0); len(leader.Filters) != 0 {
+		t.Errorf("leader filters %q, want none", leader.Filters)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+
+	"github.com/IvanBayan/nvmlfan/internal/config"
+	"github.com/IvanBayan/nvmlfan/internal/telemetry"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	effectiveMu     sync.Mutex
+	effectiveCurves = map[int][][2]int{}
+)
+
+func init() {
+	apiMux.HandleFunc("GET /config", handleConfig)
+}
+
+// SetEffectiveCurve remembers curve of GPU idx after clamping against hardware limits.
+func SetEffectiveCurve(idx int, curve [][2]int) {
+	effectiveMu.Lock()
+	defer effectiveMu.Unlock()
+	effectiveCurves[idx] = curve
+}
+
+// EffectiveConfig returns configuration actually in force: defaults are
+// filled in, command line overrides applied and curves of controlled cards
+// are clamped to their limits.
+func EffectiveConfig() config.Config {
+	confMu.RLock()
+	defer confMu.RUnlock()
+	cfg := conf
+	if cfg.Period == 0 {
+		cfg.Period = defaultPeriod
+	}
+	if len(cfg.Logging) == 0 {
+		cfg.Logging = config.LoggingConfig{{"type": defaultLoggingType, "level": defaultLoggingLevel}}
+	}
+	if cfg.Telemetry != nil {
+		tel := *cfg.Telemetry
+		if tel.Path == "" {
+			tel.Path = defaultTelemetryPath
+		}
+		if tel.MaxFiles <= 0 {
+			tel.MaxFiles = telemetry.DefaultCSVMaxFiles
+		}
+		cfg.Telemetry = &tel
+	}
+	if cfg.Stats != nil {
+		stats := *cfg.Stats
+		if stats.Path == "" {
+			stats.Path = defaultStatsPath
+		}
+		if stats.Retention <= 0 {
+			stats.Retention = telemetry.DefaultStatsRetention
+		}
+		cfg.Stats = &stats
+	}
+	if !cfg.Summary.Disabled {
+		if cfg.Summary.Interval <= 0 {
+			cfg.Summary.Interval = telemetry.DefaultSummaryInterval
+		}
+		if cfg.Summary.Warning <= 0 {
+			cfg.Summary.Warning = telemetry.DefaultSummaryWarning
+		}
+	}
+	api := config.APIConfig{Socket: apiSocket(cfg.API)}
+	if cfg.API != nil {
+		api.Disabled = cfg.API.Disabled
+	}
+	cfg.API = &api
+
+	effectiveMu.Lock()
+	defer effectiveMu.Unlock()
+	cfg.Cards = make(map[string]config.GPUConfig, len(conf.Cards))
+	for key, card := range conf.Cards {
+		cfg.Cards[key] = card
+	}
+	for idx, curve := range effectiveCurves {
+		if key, ok := CardKey(conf, idx); ok {
+			card := cfg.Cards[key]
+			card.Curve = curve
+			card.Preset = ""
+			cfg.Cards[key] = card
+		}
+	}
+	return cfg
+}
+
+func handleConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, EffectiveConfig())
+}
+
+// ConfigCommand prints effective configuration of the running daemon as YAML.
+func ConfigCommand(args []string) int {
+	fs := flag.NewFlagSet("config", flag.ExitOnError)
+	configPath := fs.String("config", "", "Path to configuration file, to find API socket")
+	socket := fs.String("socket", "", "Path to API socket (overrides config)")
+	output := fs.String("output", "-", "Where to write configuration, - for stdout")
+	fs.Parse(args)
+
+	path := *socket
+	if path == "" {
+		var cfg config.Config
+		if *configPath != "" {
+			var err error
+			if cfg, err = config.Load(*configPath); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+		}
+		path = apiSocket(cfg.API)
+	}
+	var cfg config.Config
+	if err := APIGet(path, "/config", url.Values{}, &cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *output == "-" {
+		os.Stdout.Write(data)
+		return 0
+	}
+	if err := os.WriteFile(*output, data, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
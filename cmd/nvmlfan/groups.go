@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Fans of all cards in a group follow the controller output of the hottest
+// of them, in a tightly stacked rig the exhaust of one card heats its
+// neighbors. Every controlled member publishes its temperature and own
+// output each cycle and applies the output of the hottest member seen
+// recently, so a member lags the hottest one by at most a cycle.
+
+// groupMember is the last cycle of a card in a group.
+type groupMember struct {
+	idx     int
+	uuid    string
+	temp    int
+	output  int
+	expires time.Time // Members which stopped publishing leave the group.
+}
+
+var (
+	groupsMu sync.Mutex
+	// groups holds members of every group by their UUID.
+	groups = map[string]map[string]groupMember{}
+)
+
+// follow publishes output of card c chosen for temp at now to its group and
+// returns the output of the hottest member, own output without a group.
+func (c *cardControl) follow(output, temp int, now time.Time) int {
+	group := c.settings.Group
+	if group == "" {
+		return output
+	}
+	interval := max(c.interval, time.Duration(conf.Period)*time.Second)
+	self := groupMember{idx: c.idx, uuid: c.uuid, temp: temp, output: output, expires: now.Add(3 * interval)}
+	hottest := self
+	groupsMu.Lock()
+	members := groups[group]
+	if members == nil {
+		members = map[string]groupMember{}
+		groups[group] = members
+	}
+	members[c.uuid] = self
+	for uuid, m := range members {
+		if now.After(m.expires) {
+			delete(members, uuid)
+			continue
+		}
+		if m.temp > hottest.temp || m.temp == hottest.temp && m.output > hottest.output {
+			hottest = m
+		}
+	}
+	groupsMu.Unlock()
+
+	following := hottest.uuid != c.uuid
+	if following != c.following {
+		c.logger.Debug("Group leader changed", "group", group, "leader", hottest.idx, "temp", hottest.temp)
+		c.following = following
+	}
+	if !following {
+		return output
+	}
+	speed := min(max(hottest.output, c.minSpeed), c.maxSpeed)
+	AddFilter(c.idx, fmt.Sprintf("following GPU %d at %d°C in group %s", hottest.idx, hottest.temp, group), speed)
+	return speed
+}
+
+// leaveGroup removes card c from its group, its fans no longer follow it.
+func (c *cardControl) leaveGroup() {
+	if c.settings.Group == "" {
+		return
+	}
+	groupsMu.Lock()
+	delete(groups[c.settings.Group], c.uuid)
+	groupsMu.Unlock()
+	c.following = false
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/IvanBayan/nvmlfan/internal/gpu"
+)
+
+// Health is what nvmlfan does with a card.
+type Health string
+
+const (
+	HealthInitializing Health = "initializing" // Controller is built, no cycle ran yet.
+	HealthControlling  Health = "controlling"
+	HealthDegraded     Health = "degraded" // Calls fail, control is retried.
+	HealthFailsafe     Health = "failsafe" // Fans are held at failsafe speed.
+	HealthReleased     Health = "released" // Fans are left to the driver or another program.
+	HealthLost         Health = "lost"     // GPU went away.
+)
+
+var healthStates = []Health{HealthInitializing, HealthControlling, HealthDegraded, HealthFailsafe, HealthReleased, HealthLost}
+
+// CardHealth is the state of one card, kept by UUID.
+type CardHealth struct {
+	GPU    int       `json:"gpu"` // Index when the state was last set.
+	UUID   string    `json:"uuid"`
+	Name   string    `json:"name"`
+	State  Health    `json:"state"`
+	Since  time.Time `json:"since"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+var (
+	healthMu sync.Mutex
+	health   = map[string]CardHealth{}
+)
+
+func init() {
+	apiMux.HandleFunc("GET /health", handleHealth)
+}
+
+// SetHealth moves GPU idx to state for reason. Changes of state are logged
+// and recorded as a health event, a new reason alone is only kept.
+func SetHealth(idx int, state Health, reason string) {
+	id := gpu.GetDeviceIdentity(idx)
+	old, changed := updateHealth(CardHealth{GPU: idx, UUID: id.UUID, Name: id.Name, State: state, Reason: reason})
+	if !changed {
+		return
+	}
+	level := slog.LevelInfo
+	switch state {
+	case HealthDegraded, HealthFailsafe, HealthLost:
+		level = slog.LevelWarn
+	}
+	controllerLog.Log(context.Background(), level, "GPU health changed", "GPU", idx, "from", old.State, "to", state, "reason", reason)
+	message := string(state)
+	if reason != "" {
+		message += ": " + reason
+	}
+	RecordEvent(idx, "health", message)
+}
+
+// MarkRemoved moves the card with uuid, which is no longer enumerated, to
+// the lost state. Its last index may belong to another card already.
+func MarkRemoved(uuid string) {
+	healthMu.Lock()
+	h, ok := health[uuid]
+	healthMu.Unlock()
+	if ok {
+		h.State, h.Reason = HealthLost, "GPU removed"
+		updateHealth(h)
+	}
+}
+
+// updateHealth stores h, keeping the time of its state when unchanged. It
+// returns the previous state and whether it changed.
+func updateHealth(h CardHealth) (CardHealth, bool) {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	old, ok := health[h.UUID]
+	changed := !ok || old.State != h.State
+	h.Since = old.Since
+	if changed {
+		h.Since = daemonClock.Now()
+	}
+	health[h.UUID] = h
+	return old, changed
+}
+
+// CardsHealth returns the state of every card seen since start by index.
+func CardsHealth() []CardHealth {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	result := make([]CardHealth, 0, len(health))
+	for _, h := range health {
+		result = append(result, h)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].GPU < result[j].GPU })
+	return result
+}
+
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, CardsHealth())
+}
+
+// healthLabels are labels of h, its index may belong to another card by now.
+func healthLabels(h CardHealth) string {
+	return fmt.Sprintf("gpu=\"%d\",uuid=\"%s\",name=\"%s\"", h.GPU, labelValue(h.UUID), labelValue(h.Name))
+}
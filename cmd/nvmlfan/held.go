@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/IvanBayan/nvmlfan/internal/gpu"
+)
+
+// UUIDs of GPUs whose fans nvmlfan left in manual mode are kept in the held
+// file, so the next start doesn't take them for fans set by another program.
+
+func readHeld() []string {
+	return readUUIDs(defaultHeldPath)
+}
+
+func writeHeld(uuids []string) {
+	if err := writeUUIDs(defaultHeldPath, uuids); err != nil {
+		controllerLog.Warn("Can't record held fans", "error", err)
+	}
+}
+
+// readUUIDs returns UUIDs listed in the file at path, none when it's missing.
+func readUUIDs(path string) []string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	return strings.Fields(string(data))
+}
+
+// writeUUIDs lists uuids in the file at path, one per line.
+func writeUUIDs(path string, uuids []string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data := strings.Join(uuids, "\n")
+	if len(uuids) > 0 {
+		data += "\n"
+	}
+	return os.WriteFile(path, []byte(data), 0o644)
+}
+
+// MarkHeld records that nvmlfan leaves fans of GPU idx in manual mode.
+func MarkHeld(idx int) {
+	uuid := gpu.GetDeviceIdentity(idx).UUID
+	held := readHeld()
+	if uuid == "" || slices.Contains(held, uuid) {
+		return
+	}
+	writeHeld(append(held, uuid))
+}
+
+// ClearHeld forgets GPU idx, nvmlfan controls its fans again.
+func ClearHeld(idx int) {
+	uuid := gpu.GetDeviceIdentity(idx).UUID
+	held := readHeld()
+	if i := slices.Index(held, uuid); i >= 0 {
+		writeHeld(slices.Delete(held, i, i+1))
+	}
+}
+
+// HeldByUs tells if nvmlfan left fans of GPU idx in manual mode.
+func HeldByUs(idx int) bool {
+	uuid := gpu.GetDeviceIdentity(idx).UUID
+	return uuid != "" && slices.Contains(readHeld(), uuid)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IvanBayan/nvmlfan/internal/config"
+	"github.com/IvanBayan/nvmlfan/internal/gpu"
+)
+
+const (
+	hookPreTakeover = "pre_takeover"
+	hookPostRestore = "post_restore"
+	// defaultHookTimeout is how long a hook may run by default.
+	defaultHookTimeout = 10 * time.Second
+)
+
+// runCommand runs command and its arguments with env added to the
+// environment and returns its output. It's killed after timeout.
+func runCommand(command, env []string, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Env = append(os.Environ(), env...)
+	out, err := cmd.CombinedOutput()
+	return strings.TrimSpace(string(out)), err
+}
+
+// runHook runs the hook command of hooks for event, if there is one, and
+// waits for it.
+func runHook(logger *slog.Logger, hooks *config.HooksConfig, event string, env []string) {
+	if hooks == nil {
+		return
+	}
+	command := hooks.PreTakeover
+	if event == hookPostRestore {
+		command = hooks.PostRestore
+	}
+	if len(command) == 0 {
+		return
+	}
+	timeout := hooks.Timeout
+	if timeout <= 0 {
+		timeout = defaultHookTimeout
+	}
+	env = append(env, "NVMLFAN_HOOK="+event)
+	out, err := runCommand(command, env, timeout)
+	if err != nil {
+		logger.Warn("Hook failed", "hook", event, "command", command, "error", err, "output", out)
+		return
+	}
+	logger.Debug("Hook finished", "hook", event, "command", command, "output", out)
+}
+
+// RunDaemonHook runs the global hook for event around control of gpus.
+func RunDaemonHook(event string, gpus []int) {
+	indexes := make([]string, len(gpus))
+	for i, idx := range gpus {
+		indexes[i] = strconv.Itoa(idx)
+	}
+	runHook(controllerLog, conf.Hooks, event, []string{"NVMLFAN_GPUS=" + strings.Join(indexes, " ")})
+}
+
+// RunCardHook runs the hook of GPU idx for event.
+func RunCardHook(event string, idx int) {
+	card, _ := CardConfig(conf, idx)
+	id := gpu.GetDeviceIdentity(idx)
+	runHook(controllerLog.With("GPU", idx), card.Hooks, event, []string{
+		"NVMLFAN_GPU=" + strconv.Itoa(idx),
+		"NVMLFAN_UUID=" + id.UUID,
+		"NVMLFAN_NAME=" + id.Name,
+		"NVMLFAN_MODE=" + card.Mode,
+	})
+}
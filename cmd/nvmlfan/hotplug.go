@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/IvanBayan/nvmlfan/internal/gpu"
+)
+
+// hotplugInterval is how often GPU topology is checked, it costs no NVML
+// call. Rescans requested by controllers wait for it too.
+const hotplugInterval = 5 * time.Second
+
+// rescanRequests is signaled by controllers which lost their GPU.
+var rescanRequests = make(chan struct{}, 1)
+
+// RequestRescan asks for GPUs to be enumerated again, e.g. after a driver
+// reload or a GPU reset.
+func RequestRescan() {
+	select {
+	case rescanRequests <- struct{}{}:
+	default:
+	}
+}
+
+// WatchDevices enumerates GPUs again when they are attached or removed,
+// e.g. an eGPU is plugged in or a GPU is rebound to VFIO, or a controller
+// lost its GPU, until ctx is canceled.
+func WatchDevices(ctx context.Context) {
+	last := gpu.Topology()
+	if last == "" {
+		controllerLog.Debug("GPU topology is unknown, attached GPUs are only found on restart")
+	}
+	loops.Add(1)
+	go func() {
+		defer RestoreOnPanic()
+		defer loops.Done()
+		ticker := time.NewTicker(hotplugInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			topology := gpu.Topology()
+			requested := false
+			select {
+			case <-rescanRequests:
+				requested = true
+			default:
+			}
+			switch {
+			case topology != last:
+				last = topology
+				controllerLog.Info("GPU topology changed, enumerating GPUs again")
+			case requested:
+				controllerLog.Warn("GPU lost, enumerating GPUs again")
+			default:
+				continue
+			}
+			if err := rescanDevices(ctx); err != nil {
+				Fail(err)
+				return
+			}
+			// Requested by stopped controllers meanwhile
+			select {
+			case <-rescanRequests:
+			default:
+			}
+		}
+	}()
+}
+
+// rescanDevices stops the control loop, enumerates GPUs again and starts
+// the loop over GPUs now present. Controllers follow their cards by UUID to
+// new indexes keeping their state, those of removed GPUs are dropped and
+// configured GPUs which appeared are checked like on startup.
+func rescanDevices(ctx context.Context) error {
+	old := stopScheduler()
+	if err := gpu.Rescan(); err != nil {
+		return err
+	}
+	reloads.Add(1)
+	ForgetSnapshots()
+	ForgetHeartbeats()
+	present := map[string]int{}
+	for idx := 0; idx < gpu.GetDeviceCount(); idx++ {
+		present[gpu.GetDeviceIdentity(idx).UUID] = idx
+	}
+
+	next := &scheduler{}
+	bound := map[string]bool{}
+	if old != nil {
+		next.restarts = old.restarts
+		for _, c := range old.cards {
+			idx, ok := present[c.uuid]
+			if !ok {
+				c.logger.Warn("GPU removed, its controller is stopped", "uuid", c.uuid)
+				MarkRemoved(c.uuid)
+				continue
+			}
+			if idx != c.idx {
+				c.logger.Info("GPU index changed, controller follows the card", "index", idx, "uuid", c.uuid)
+				c.idx, c.logger = idx, controllerLog.With("GPU", idx)
+				if c.curve != nil {
+					SetEffectiveCurve(idx, c.curve)
+				}
+			}
+			Heartbeat(idx)
+			bound[c.uuid] = true
+			next.cards = append(next.cards, c)
+		}
+	}
+	for idx := 0; idx < gpu.GetDeviceCount(); idx++ {
+		if bound[gpu.GetDeviceIdentity(idx).UUID] {
+			continue
+		}
+		if card := attachCard(idx); card != nil {
+			next.cards = append(next.cards, card)
+		}
+	}
+	next.outputs = hwmonControls()
+	next.start(ctx)
+	return nil
+}
+
+// attachCard returns a controller of GPU idx which appeared after startup,
+// nil when it isn't to be controlled.
+func attachCard(idx int) *cardControl {
+	if Excluded(conf, idx) {
+		return nil
+	}
+	mode := "monitor"
+	if !conf.Monitor {
+		card, ok := CardConfig(conf, idx)
+		if _, skipped := Skipped(idx); !ok || skipped {
+			return nil
+		}
+		mode = card.Mode
+	}
+	controllerLog.Info("GPU attached", "GPU", idx, "uuid", gpu.GetDeviceIdentity(idx).UUID)
+	RecordEvent(idx, "hotplug", "GPU attached")
+	if mode != "monitor" {
+		if err := gpu.CaptureFans(idx); err != nil {
+			controllerLog.Warn("Can't record fan state, default control is restored on exit", "GPU", idx, "error", err)
+		}
+		FindExternalCards([]int{idx})
+		if _, skipped := Skipped(idx); skipped {
+			return nil
+		}
+		RunCardHook(hookPreTakeover, idx)
+		if errs := CheckControlPermissions([]int{idx}); len(errs) > 0 {
+			for _, err := range errs {
+				controllerLog.Error("Can't control fans", "error", err)
+			}
+			SkipCard(idx, "without fan control permission")
+			return nil
+		}
+		if _, skipped := Skipped(idx); skipped {
+			return nil
+		}
+		RecordEvent(idx, "control", "Taking fan control in "+mode+" mode")
+		ClearHeld(idx)
+	}
+	card, err := newCardControl(idx, mode)
+	if err != nil {
+		controllerLog.Error("Can't control attached GPU", "GPU", idx, "error", err)
+		return nil
+	}
+	return card
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/IvanBayan/nvmlfan/internal/config"
+	"github.com/IvanBayan/nvmlfan/internal/gpu"
+	"github.com/IvanBayan/nvmlfan/internal/hwmon"
+	"github.com/IvanBayan/nvmlfan/pkg/controller"
+)
+
+// defaultHwmonMaxTemp is the top of presets scaled to hwmon outputs, which
+// have no slowdown threshold to scale to.
+const defaultHwmonMaxTemp = 90
+
+// An output which can't be written runs at full speed, control is retried
+// after a delay doubling from hwmonFirstRetry up to hwmonMaxRetry.
+const (
+	hwmonFirstRetry = 10 * time.Second
+	hwmonMaxRetry   = 5 * time.Minute
+)
+
+var (
+	hwmonMu sync.Mutex
+	// Opened outputs by position in configuration, they remember the mode
+	// to return to on exit.
+	hwmonOutputs = map[int]*hwmon.PWM{}
+)
+
+// hwmonName returns the name of output i for logs.
+func hwmonName(i int) string {
+	if name := conf.Hwmon[i].Name; name != "" {
+		return name
+	}
+	return strconv.Itoa(i)
+}
+
+// hwmonControl is the controller of a hwmon output.
+type hwmonControl struct {
+	cfg     config.HwmonConfig
+	pwm     *hwmon.PWM
+	logger  *slog.Logger
+	gpus    []int // GPUs whose hottest temperature drives the output, unless it has its own input.
+	control func(temp int) int
+	// Failed writes in a row, control is retried at retryAt.
+	failures int
+	retryAt  time.Time
+}
+
+// hwmonControls prepares control of configured hwmon outputs, outputs which
+// can't be opened are skipped.
+func hwmonControls() []*hwmonControl {
+	var outputs []*hwmonControl
+	for i := range conf.Hwmon {
+		o, err := newHwmonControl(i)
+		if err != nil {
+			controllerLog.Error("Skipping hwmon output", "hwmon", hwmonName(i), "error", err)
+			continue
+		}
+		outputs = append(outputs, o)
+	}
+	return outputs
+}
+
+// newHwmonControl opens output i and builds its curve or PID controller.
+// An output opened before, when the scheduler is restarted, is reused so it
+// keeps the mode to return to.
+func newHwmonControl(i int) (*hwmonControl, error) {
+	cfg := conf.Hwmon[i]
+	hwmonMu.Lock()
+	pwm, ok := hwmonOutputs[i]
+	hwmonMu.Unlock()
+	if !ok {
+		var err error
+		if pwm, err = hwmon.Open(cfg.PWM); err != nil {
+			return nil, err
+		}
+	}
+	o := &hwmonControl{cfg: cfg, pwm: pwm, logger: controllerLog.With("hwmon", hwmonName(i))}
+	if cfg.Temp == "" {
+		for idx := 0; idx < gpu.GetDeviceCount(); idx++ {
+			uuid := gpu.GetDeviceIdentity(idx).UUID
+			if cfg.GPU == "*" && !Excluded(conf, idx) || cfg.GPU == strconv.Itoa(configIndex(idx, uuid)) || cfg.GPU == uuid {
+				o.gpus = append(o.gpus, idx)
+			}
+		}
+		if len(o.gpus) == 0 {
+			return nil, fmt.Errorf("no GPU matches '%s'", cfg.GPU)
+		}
+	}
+
+	minSpeed, maxSpeed := cfg.MinSpeed, 100
+	maxTemp := cfg.MaxTemp
+	if maxTemp == 0 {
+		maxTemp = defaultHwmonMaxTemp
+	}
+	switch cfg.Mode {
+	case "curve":
+		curve := controller.ClampCurve(CardCurve(cfg.GPUConfig, minSpeed, maxSpeed, maxTemp), minSpeed, maxSpeed, maxTemp, o.logger)
+		o.control = func(temp int) int {
+			return controller.ComputeFanSpeed(temp, curve, minSpeed, maxSpeed)
+		}
+	case "target":
+		pid := controller.PID{Target: cfg.Target, Kp: cfg.PID[0], Ki: cfg.PID[1], Kd: cfg.PID[2],
+			Min: minSpeed, Max: maxSpeed}
+		o.control = func(temp int) int {
+			speed, _ := pid.Update(temp)
+			return min(max(speed, minSpeed), maxSpeed)
+		}
+	default:
+		return nil, fmt.Errorf("unknown mode '%s'", cfg.Mode)
+	}
+
+	hwmonMu.Lock()
+	hwmonOutputs[i] = pwm
+	hwmonMu.Unlock()
+	o.logger.Info("Taking control of hwmon output", "pwm", cfg.PWM, "mode", cfg.Mode)
+	return o, nil
+}
+
+// step sets the output from its hwmon input or the hottest of its GPUs at
+// now. The output keeps the last speed when the temperature can't be read.
+func (o *hwmonControl) step(readings map[int]reading, now time.Time) {
+	if now.Before(o.retryAt) {
+		return
+	}
+	temp, err := o.temp(readings)
+	if err != nil {
+		o.logger.Error("Skipping cycle", "error", err)
+		return
+	}
+	speed := o.control(temp)
+	o.logger.Debug("Setting new speed", "speed", speed, "temp", temp)
+	if err := o.pwm.SetSpeed(speed); err != nil {
+		o.failsafe(err, now)
+		return
+	}
+	if o.failures > 0 {
+		o.logger.Info("hwmon output recovered, control resumed", "failures", o.failures)
+		o.failures, o.retryAt = 0, time.Time{}
+	}
+}
+
+// failsafe runs output o at full speed after a failed write, or returns it
+// to the driver when even that fails, and retries control later. Other
+// outputs and cards are controlled on.
+func (o *hwmonControl) failsafe(err error, now time.Time) {
+	o.failures++
+	retry := hwmonMaxRetry
+	if o.failures < 10 {
+		retry = min(hwmonFirstRetry<<(o.failures-1), hwmonMaxRetry)
+	}
+	o.retryAt = now.Add(retry)
+	if o.failures == 1 {
+		failsafes.Add(1)
+	}
+	if fullErr := o.pwm.SetSpeed(100); fullErr == nil {
+		o.logger.Error("Can't set hwmon output, running it at full speed", "pwm", o.cfg.PWM, "error", err, "retry", retry)
+		return
+	}
+	if restoreErr := o.pwm.Restore(); restoreErr != nil {
+		o.logger.Error("Can't set hwmon output nor return it to the driver", "pwm", o.cfg.PWM, "error", err, "restore_error", restoreErr, "retry", retry)
+		return
+	}
+	o.logger.Error("Can't set hwmon output, returned it to the driver", "pwm", o.cfg.PWM, "error", err, "retry", retry)
+}
+
+func (o *hwmonControl) temp(readings map[int]reading) (int, error) {
+	if o.cfg.Temp != "" {
+		return hwmon.ReadTemp(o.cfg.Temp)
+	}
+	hottest := 0
+	for _, idx := range o.gpus {
+		r := readings[idx]
+		if r.err != nil {
+			return 0, r.err
+		}
+		hottest = max(hottest, r.Temp)
+	}
+	return hottest, nil
+}
+
+// ReleaseHwmon applies exit behavior to hwmon outputs. Outputs not opened
+// by this process, e.g. when the supervisor cleans up after a crash, are
+// opened now and go to automatic mode.
+func ReleaseHwmon() {
+	hwmonMu.Lock()
+	defer hwmonMu.Unlock()
+	for i, output := range conf.Hwmon {
+		logger := controllerLog.With("hwmon", hwmonName(i))
+		pwm, ok := hwmonOutputs[i]
+		if !ok {
+			var err error
+			if pwm, err = hwmon.Open(output.PWM); err != nil {
+				logger.Error("Can't release hwmon output", "error", err)
+				continue
+			}
+		}
+		switch output.OnExit {
+		case config.ExitHold:
+			logger.Info("Holding last fan speed")
+			continue
+		case config.ExitFixed:
+			logger.Info("Setting fan to exit speed", "speed", output.ExitSpeed)
+			err := pwm.SetSpeed(output.ExitSpeed)
+			if err == nil {
+				continue
+			}
+			logger.Error("Can't set exit speed, restoring automatic mode", "error", err)
+		default:
+			logger.Info("Returning fan to automatic mode")
+		}
+		if err := pwm.Restore(); err != nil {
+			logger.Error("Can't restore fan mode", "error", err)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IvanBayan/nvmlfan/internal/config"
+	"github.com/IvanBayan/nvmlfan/internal/hwmon"
+)
+
+func TestHwmonFailsafe(t *testing.T) {
+	dir := t.TempDir()
+	pwmPath, tempPath := filepath.Join(dir, "pwm1"), filepath.Join(dir, "temp1_input")
+	for path, value := range map[string]string{pwmPath: "0", pwmPath + "_enable": "2", tempPath: "50000"} {
+		if err := os.WriteFile(path, []byte(value), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pwm, err := hwmon.Open(pwmPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := &hwmonControl{cfg: config.HwmonConfig{PWM: pwmPath, Temp: tempPath}, pwm: pwm, logger: controllerLog,
+		control: func(temp int) int { return temp }}
+	read := func(path string) string {
+		data, _ := os.ReadFile(path)
+		return strings.TrimSpace(string(data))
+	}
+
+	now := simStart
+	o.step(nil, now)
+	if value := read(pwmPath); value != "128" {
+		t.Fatalf("output %s, want 128", value)
+	}
+	// Writes fail, the output goes back to the driver
+	os.Remove(pwmPath)
+	os.Mkdir(pwmPath, 0o755)
+	tests := []struct {
+		advance time.Duration
+		retry   time.Duration // Zero when the output is controlled.
+	}{
+		{time.Second, hwmonFirstRetry},
+		{time.Second, hwmonFirstRetry - time.Second},
+		{hwmonFirstRetry, 2 * hwmonFirstRetry},
+		{2 * hwmonFirstRetry, 4 * hwmonFirstRetry},
+	}
+	for i, tt := range tests {
+		now = now.Add(tt.advance)
+		o.step(nil, now)
+		if retry := o.retryAt.Sub(now); retry != tt.retry {
+			t.Errorf("step %d: retry in %v, want %v", i, retry, tt.retry)
+		}
+		if mode := read(pwmPath + "_enable"); mode != "2" {
+			t.Errorf("step %d: mode %s, want automatic", i, mode)
+		}
+	}
+	// Recovered output is controlled again once the retry is due
+	os.Remove(pwmPath)
+	now = o.retryAt
+	o.step(nil, now)
+	if value, mode := read(pwmPath), read(pwmPath+"_enable"); value != "128" || mode != "1" {
+		t.Errorf("recovered output %s in mode %s, want 128 in manual mode", value, mode)
+	}
+	if o.failures != 0 || !o.retryAt.IsZero() {
+		t.Errorf("%d failures left, retry at %v", o.failures, o.retryAt)
+	}
+}
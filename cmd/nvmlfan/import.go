@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// ImportedCurve is a fan curve found in a profile of another tool.
+type ImportedCurve struct {
+	Name  string
+	Curve [][2]int
+}
+
+// importers parse profile files of other fan control tools.
+var importers = map[string]func(path string) ([]ImportedCurve, error){
+	"afterburner": importAfterburner,
+	"gwe":         importGWE,
+}
+
+// importAfterburner reads SwAutoFanControlCurve entries of MSI Afterburner
+// profiles. Values are hex encoded little endian: uint32 version, uint32
+// point count and count pairs of float32 temperature and fan speed.
+func importAfterburner(path string) ([]ImportedCurve, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var curves []ImportedCurve
+	section := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.Trim(line, "[]")
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || !strings.HasPrefix(key, "SwAutoFanControlCurve") || value == "" {
+			continue
+		}
+		curve, err := decodeAfterburnerCurve(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %w", section, key, err)
+		}
+		curves = append(curves, ImportedCurve{Name: section + "/" + key, Curve: curve})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return curves, nil
+}
+
+func decodeAfterburnerCurve(value string) ([][2]int, error) {
+	data, err := hex.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 8 {
+		return nil, fmt.Errorf("curve is too short")
+	}
+	count := int(binary.LittleEndian.Uint32(data[4:8]))
+	// Newer versions append more data after points, it isn't needed
+	if count == 0 || len(data) < 8+count*8 {
+		return nil, fmt.Errorf("curve has %d points but only %d bytes", count, len(data))
+	}
+	var curve [][2]int
+	for i := 0; i < count; i++ {
+		offset := 8 + i*8
+		temp := math.Float32frombits(binary.LittleEndian.Uint32(data[offset:]))
+		speed := math.Float32frombits(binary.LittleEndian.Uint32(data[offset+4:]))
+		point := [2]int{int(math.Round(float64(temp))), int(math.Round(float64(speed)))}
+		// Afterburner allows flat segments, nvmlfan needs increasing temperatures
+		if len(curve) > 0 && point[0] <= curve[len(curve)-1][0] {
+			curve[len(curve)-1][1] = max(curve[len(curve)-1][1], point[1])
+			continue
+		}
+		curve = append(curve, point)
+	}
+	return curve, nil
+}
+
+// gweQuery reads speed steps of fan profiles from GreenWithEnvy database.
+const gweQuery = "SELECT p.name AS profile, s.temperature AS temperature, s.duty AS duty " +
+	"FROM speed_step s JOIN fan_profile p ON p.id = s.profile_id ORDER BY p.id, s.temperature"
+
+// importGWE reads fan profiles from GreenWithEnvy database, usually
+// ~/.config/gwe/gwe.db. The sqlite3 command line tool is used to avoid
+// linking an SQLite driver for a one time migration.
+func importGWE(path string) ([]ImportedCurve, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	out, err := exec.Command("sqlite3", "-json", "-readonly", path, gweQuery).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return nil, fmt.Errorf("sqlite3: %s", bytes.TrimSpace(exitErr.Stderr))
+		}
+		return nil, fmt.Errorf("sqlite3 is required to read GWE database: %w", err)
+	}
+	var steps []struct {
+		Profile     string  `json:"profile"`
+		Temperature float64 `json:"temperature"`
+		Duty        float64 `json:"duty"`
+	}
+	// sqlite3 prints nothing when there are no rows
+	if len(bytes.TrimSpace(out)) > 0 {
+		if err := json.Unmarshal(out, &steps); err != nil {
+			return nil, err
+		}
+	}
+	var curves []ImportedCurve
+	for _, step := range steps {
+		if len(curves) == 0 || curves[len(curves)-1].Name != step.Profile {
+			curves = append(curves, ImportedCurve{Name: step.Profile})
+		}
+		c := &curves[len(curves)-1]
+		point := [2]int{int(math.Round(step.Temperature)), int(math.Round(step.Duty))}
+		if len(c.Curve) > 0 && point[0] <= c.Curve[len(c.Curve)-1][0] {
+			c.Curve[len(c.Curve)-1][1] = max(c.Curve[len(c.Curve)-1][1], point[1])
+			continue
+		}
+		c.Curve = append(c.Curve, point)
+	}
+	return curves, nil
+}
+
+// writeCardCurve writes card configuration with curve in the layout of example configuration.
+func writeCardCurve(b *strings.Builder, key string, curve [][2]int, prefix string) {
+	fmt.Fprintf(b, "%s  %q:\n", prefix, key)
+	fmt.Fprintf(b, "%s    mode: curve\n", prefix)
+	fmt.Fprintf(b, "%s    curve:\n", prefix)
+	for _, point := range curve {
+		fmt.Fprintf(b, "%s      - [ %d, %d ]\n", prefix, point[0], point[1])
+	}
+}
+
+// ImportCommand converts fan curves of other tools into cards configuration.
+func ImportCommand(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	format := fs.String("format", "", "Profile format: afterburner or gwe")
+	card := fs.String("card", "0", "Card key (index, UUID or name glob) of imported curve")
+	profile := fs.String("profile", "", "Name of curve to import, first found by default")
+	fs.Parse(args)
+
+	importer, ok := importers[*format]
+	if !ok || fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: import --format afterburner|gwe [--card key] [--profile name] file")
+		return 2
+	}
+	path := fs.Arg(0)
+	curves, err := importer(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		return 1
+	}
+	if len(curves) == 0 {
+		fmt.Fprintf(os.Stderr, "%s: no fan curves found\n", path)
+		return 1
+	}
+	if *profile != "" {
+		i := slices.IndexFunc(curves, func(c ImportedCurve) bool { return c.Name == *profile })
+		if i < 0 {
+			fmt.Fprintf(os.Stderr, "%s: no curve named '%s'\n", path, *profile)
+			return 1
+		}
+		// Selected curve goes first
+		curves[0], curves[i] = curves[i], curves[0]
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Imported from %s (%s), review before use.\n", path, curves[0].Name)
+	b.WriteString("cards:\n")
+	writeCardCurve(&b, *card, curves[0].Curve, "")
+	// Only one curve can be active per card, keep the others for reference
+	for _, c := range curves[1:] {
+		fmt.Fprintf(&b, "# Also found %s:\n", c.Name)
+		writeCardCurve(&b, *card, c.Curve, "#")
+	}
+	fmt.Print(b.String())
+	return 0
+}
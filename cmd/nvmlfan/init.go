@@ -0,0 +1,119 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/IvanBayan/nvmlfan/internal/gpu"
+	"github.com/IvanBayan/nvmlfan/pkg/controller"
+)
+
+// Card limits assumed for the starter curve when the card doesn't report
+// usable ones.
+const (
+	starterMinSpeed = 30
+	starterMaxSpeed = 100
+	starterMaxTemp  = 90
+)
+
+// DefaultCurve returns a starter curve for card limits, the balanced preset.
+func DefaultCurve(minSpeed, maxSpeed, maxTemp int) [][2]int {
+	curve, _ := controller.ScalePreset("balanced", minSpeed, maxSpeed, maxTemp)
+	return curve
+}
+
+// checkLimits tells why card limits can't be used for the starter curve.
+// The balanced preset starts 50°C below maxTemp, which has to stay above
+// freezing.
+func checkLimits(minSpeed, maxSpeed, maxTemp int) error {
+	if minSpeed < 0 || maxSpeed > 100 || minSpeed >= maxSpeed {
+		return fmt.Errorf("invalid fan speed range %d-%d%%", minSpeed, maxSpeed)
+	}
+	if maxTemp <= 50 || maxTemp > 150 {
+		return fmt.Errorf("invalid maximum operating temperature %d°C", maxTemp)
+	}
+	return nil
+}
+
+// StarterConfig renders commented configuration for all detected GPUs.
+func StarterConfig() string {
+	var b strings.Builder
+	b.WriteString("# Generated by nvmlfan init, review the curves before use.\n")
+	fmt.Fprintf(&b, "period: %d\n", defaultPeriod)
+	b.WriteString("logging:\n")
+	fmt.Fprintf(&b, "  type: %s\n", defaultLoggingType)
+	fmt.Fprintf(&b, "  level: %s\n", defaultLoggingLevel)
+	b.WriteString("cards:\n")
+	for idx := 0; idx < gpu.GetDeviceCount(); idx++ {
+		id := gpu.GetDeviceIdentity(idx)
+		fmt.Fprintf(&b, "  # GPU %d: %s, %d fan(s)\n", idx, id.Name, gpu.GetNumFans(idx))
+		minSpeed, maxSpeed, maxTemp, err := gpu.GetThermalInfo(idx)
+		if err == nil {
+			err = checkLimits(minSpeed, maxSpeed, maxTemp)
+		}
+		if err != nil {
+			fmt.Fprintf(&b, "  # Card limits are unknown (%v), the curve assumes %d-%d%% and %d°C\n", err, starterMinSpeed, starterMaxSpeed, starterMaxTemp)
+			minSpeed, maxSpeed, maxTemp = starterMinSpeed, starterMaxSpeed, starterMaxTemp
+		} else {
+			fmt.Fprintf(&b, "  # Fan speed range %d-%d%%, maximum operating temperature %d°C\n", minSpeed, maxSpeed, maxTemp)
+		}
+		key := id.UUID
+		if key == "" {
+			key = fmt.Sprint(idx)
+		}
+		fmt.Fprintf(&b, "  %s:\n", key)
+		b.WriteString("    mode: curve\n")
+		b.WriteString("    curve:\n")
+		b.WriteString("    # - [ temperature, fan_speed ]\n")
+		for _, point := range DefaultCurve(minSpeed, maxSpeed, maxTemp) {
+			fmt.Fprintf(&b, "      - [ %d, %d ]\n", point[0], point[1])
+		}
+		b.WriteString("    # Or use a built-in curve scaled to the card: silent, balanced, aggressive or max\n")
+		b.WriteString("    # preset: balanced\n")
+		b.WriteString("    # Target mode keeps constant temperature instead, PID needs tuning:\n")
+		b.WriteString("    # mode: target\n")
+		fmt.Fprintf(&b, "    # target: %d\n", maxTemp-25)
+		b.WriteString("    # pid: [ 20, 0.1, 0 ]\n")
+	}
+	return b.String()
+}
+
+func InitCommand(args []string) int {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	output := fs.String("output", "-", "Where to write configuration, - for stdout")
+	force := fs.Bool("force", false, "Overwrite existing file")
+	fs.Parse(args)
+
+	if err := gpu.InitNVML(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer gpu.Shutdown()
+	if gpu.GetDeviceCount() == 0 {
+		fmt.Fprintln(os.Stderr, "No GPUs found")
+		return 1
+	}
+	data := StarterConfig()
+	if *output == "-" {
+		fmt.Print(data)
+		return 0
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !*force {
+		flags |= os.O_EXCL
+	}
+	file, err := os.OpenFile(*output, flags, 0644)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer file.Close()
+	if _, err := file.WriteString(data); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("Configuration written to %s, check it with '%s check --config %s'\n", *output, os.Args[0], *output)
+	return 0
+}
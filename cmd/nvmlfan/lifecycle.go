@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/IvanBayan/nvmlfan/internal/clock"
+	"github.com/IvanBayan/nvmlfan/internal/gpu"
+)
+
+// Lifecycle of the daemon. StartDaemon brings components up in order,
+// WaitDaemon blocks until a stop request or a fatal error, Shutdown tears
+// down whatever was started. Helpers return errors instead of exiting, so
+// the teardown always runs.
+
+// shutdownTimeout bounds the whole shutdown, it is shorter than stop
+// timeouts of the supervisor and systemd.
+const shutdownTimeout = 20 * time.Second
+
+// Exit codes of the controller. The supervisor restarts a controller whose
+// control loop failed, but not one which couldn't start.
+const (
+	exitFailure        = 1
+	exitRuntimeFailure = 3
+)
+
+var (
+	shutdownOnce sync.Once
+	// nvmlReady is set once NVML is initialized, there is nothing to
+	// release before.
+	nvmlReady bool
+	// daemonCtx is canceled on shutdown, ending all control loops.
+	daemonCtx, stopDaemon = context.WithCancel(context.Background())
+	loops                 sync.WaitGroup
+	// failures receives fatal errors of control loops.
+	failures = make(chan error, 1)
+	// daemonClock paces control loops, simulated backends may speed it up.
+	daemonClock = clock.Real
+)
+
+// CaptureCards records fan state of configured cards before they are
+// touched and returns their indexes.
+func CaptureCards() []int {
+	var gpus []int
+	for idx := 0; idx < gpu.GetDeviceCount(); idx++ {
+		if _, ok := CardConfig(conf, idx); !ok {
+			continue
+		}
+		gpus = append(gpus, idx)
+		if err := gpu.CaptureFans(idx); err != nil {
+			controllerLog.Warn("Can't record fan state, default control is restored on exit", "GPU", idx, "error", err)
+		}
+	}
+	return gpus
+}
+
+// StartDaemon initializes NVML and daemon components, then starts the
+// scheduler controlling configured cards, or monitoring all of them in
+// monitor mode.
+func StartDaemon() error {
+	if err := gpu.InitNVML(); err != nil {
+		return err
+	}
+	nvmlReady = true
+	if err := ConfigureLogging(); err != nil {
+		return err
+	}
+	slog.Debug("Config successfully loaded", "dump", conf)
+	if Sandboxed() {
+		slog.Info("Running in sandbox")
+	}
+	ConfigureTelemetry()
+	ConfigureStats()
+	ConfigureNotify()
+	ConfigureSummary()
+	ConfigureMetrics()
+	ConfigureAPI()
+	if err := ApplyPriority(); err != nil {
+		slog.Warn("Can't change scheduling priority", "error", err)
+	}
+
+	confMu.Lock()
+	if conf.Period == 0 {
+		conf.Period = defaultPeriod
+	}
+	confMu.Unlock()
+
+	// Handle graceful shutdown
+	signal.Notify(stopRequests, syscall.SIGINT, syscall.SIGTERM)
+
+	status := "Controlling fans"
+	if conf.Monitor {
+		slog.Info("Starting monitoring, fans are left to the driver")
+		MonitorGPUs(daemonCtx)
+		status = "Monitoring, fans are left to the driver"
+	} else {
+		// Before the permission probe switches a fan to manual
+		gpus := CaptureCards()
+		FindExternalCards(gpus)
+		gpus = slices.DeleteFunc(gpus, func(idx int) bool {
+			_, skipped := Skipped(idx)
+			return skipped
+		})
+		RunDaemonHook(hookPreTakeover, gpus)
+		for _, idx := range gpus {
+			RunCardHook(hookPreTakeover, idx)
+		}
+		if errs := CheckControlPermissions(gpus); len(errs) > 0 {
+			for _, err := range errs {
+				controllerLog.Error("Can't control fans", "error", err)
+			}
+			return errors.New("no permission to control fans")
+		}
+		slog.Info("Starting fan control")
+		UpdateProfile(daemonClock.Now())
+		UpdateActivity(daemonClock.Now())
+		ControlFans(daemonCtx)
+		WatchCompetitors(daemonCtx, gpus)
+		WatchSchedule(daemonCtx)
+		WatchExercise(daemonCtx)
+		WatchActivity(daemonCtx)
+	}
+	WatchDevices(daemonCtx)
+	NotifyReady(status)
+	NotifyParent()
+	StartWatchdog()
+	return nil
+}
+
+// WaitDaemon waits for a stop request, the end of duration when it is set,
+// or a fatal error of a control loop, and returns the exit code.
+func WaitDaemon(duration time.Duration) int {
+	var expired <-chan time.Time
+	if duration > 0 {
+		slog.Info("Fan control is time limited", "duration", duration)
+		expired = time.After(duration)
+	}
+	code := 0
+	select {
+	case <-stopRequests:
+	case <-expired:
+		slog.Info("Duration elapsed", "duration", duration)
+	case err := <-failures:
+		controllerLog.Error("Control loop failed", "error", err)
+		code = exitRuntimeFailure
+	}
+	slog.Info("Shutting down fan control")
+	SdNotify("STOPPING=1")
+	return code
+}
+
+// Fail reports a fatal error of a control loop, the daemon shuts down.
+func Fail(err error) {
+	select {
+	case failures <- err:
+	default:
+	}
+}
+
+// NVMLHung records a hung NVML call. Other GPUs are controlled on, cycles
+// of the hung one are skipped and failsafe speed is tried, see
+// cardControl.step.
+func NVMLHung(call string, idx int) {
+	nvmlHangs.Add(1)
+	if idx >= 0 {
+		RecordEvent(idx, "hang", fmt.Sprintf("NVML call %s hung for %s", call, gpu.CallTimeout))
+	}
+}
+
+// sleepCycle waits for the next cycle of a control loop, it returns false
+// when ctx is canceled.
+func sleepCycle(ctx context.Context, ticker clock.Ticker) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-ticker.C():
+		return true
+	}
+}
+
+// RestoreOnPanic must be deferred by every goroutine touching fans: a panic
+// would otherwise leave them frozen at the last set speed.
+func RestoreOnPanic() {
+	if r := recover(); r != nil {
+		controllerLog.Error("Panic, restoring default fan control", "panic", r, "stack", string(debug.Stack()))
+		Shutdown(2)
+	}
+}
+
+// Shutdown stops control loops, so none of them touches fans afterwards,
+// flushes telemetry, releases fans and exits. A hung NVML call can't hold
+// the process: after shutdownTimeout it exits anyway with a non-zero code,
+// so the supervisor releases the fans. Shutdown never returns, later callers
+// wait for the first one to exit.
+func Shutdown(ret int) {
+	shutdownOnce.Do(func() {
+		done := make(chan struct{})
+		go func() {
+			shutdown()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(shutdownTimeout):
+			controllerLog.Error("Shutdown timed out", "timeout", shutdownTimeout)
+			if ret == 0 {
+				ret = exitFailure
+			}
+		}
+		os.Exit(ret)
+	})
+	select {}
+}
+
+func shutdown() {
+	stopDaemon()
+	loops.Wait()
+	FlushTelemetry()
+	// Monitor mode never took control, fans may be managed by someone else
+	if nvmlReady && !conf.Monitor {
+		ReleaseFans()
+	}
+	CloseTelemetry()
+	CloseNotify()
+	CloseAPI()
+	ReleasePidFile()
+	if nvmlReady {
+		gpu.Shutdown()
+	}
+	CloseLogging()
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/IvanBayan/nvmlfan/internal/config"
+	"github.com/IvanBayan/nvmlfan/internal/gpu"
+	"github.com/IvanBayan/nvmlfan/internal/notify"
+	"github.com/IvanBayan/nvmlfan/internal/telemetry"
+)
+
+// Loggers of daemon components, recreated by ConfigureLogging.
+var (
+	controllerLog = slog.With("component", "controller")
+	metricsLog    = slog.With("component", "metrics")
+	telemetryLog  = slog.With("component", "telemetry")
+	apiLog        = slog.With("component", "api")
+	supervisorLog = slog.With("component", "supervisor")
+)
+
+// Dedup handlers and outputs (files and syslog connections) of the current
+// logging configuration, closed by CloseLogging.
+var (
+	dedupHandlers []*DedupHandler
+	logOutputs    []io.Closer
+)
+
+// FanoutHandler passes every record to all handlers which accept its level.
+type FanoutHandler []slog.Handler
+
+func (f FanoutHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range f {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f FanoutHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, h := range f {
+		if h.Enabled(ctx, r.Level) {
+			if err := h.Handle(ctx, r.Clone()); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (f FanoutHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(FanoutHandler, len(f))
+	for i, h := range f {
+		handlers[i] = h.WithAttrs(attrs)
+	}
+	return handlers
+}
+
+func (f FanoutHandler) WithGroup(name string) slog.Handler {
+	handlers := make(FanoutHandler, len(f))
+	for i, h := range f {
+		handlers[i] = h.WithGroup(name)
+	}
+	return handlers
+}
+
+// LevelFilterHandler applies per-GPU and per-component levels. A level set for
+// a GPU wins over one set for a component, both win over the output level.
+type LevelFilterHandler struct {
+	inner      slog.Handler // Must accept the lowest of all levels.
+	base       slog.Level
+	components map[string]slog.Level
+	gpus       map[int]slog.Level
+	component  string // Set by WithAttrs.
+	gpu        int
+	hasGPU     bool
+}
+
+// minLevel returns the lowest level which can pass the filter.
+func (h *LevelFilterHandler) minLevel() slog.Level {
+	level := h.base
+	if l, ok := h.components[h.component]; ok {
+		level = l
+	}
+	if h.hasGPU {
+		if l, ok := h.gpus[h.gpu]; ok {
+			return l
+		}
+		return level
+	}
+	for _, l := range h.gpus {
+		level = min(level, l)
+	}
+	return level
+}
+
+func (h *LevelFilterHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.minLevel() && h.inner.Enabled(ctx, level)
+}
+
+func (h *LevelFilterHandler) Handle(ctx context.Context, r slog.Record) error {
+	component, gpu, hasGPU := h.component, h.gpu, h.hasGPU
+	r.Attrs(func(a slog.Attr) bool {
+		switch a.Key {
+		case "component":
+			component = a.Value.String()
+		case "GPU":
+			if a.Value.Kind() == slog.KindInt64 {
+				gpu, hasGPU = int(a.Value.Int64()), true
+			}
+		}
+		return true
+	})
+	level := h.base
+	if l, ok := h.components[component]; ok {
+		level = l
+	}
+	if l, ok := h.gpus[gpu]; ok && hasGPU {
+		level = l
+	}
+	if r.Level < level {
+		return nil
+	}
+	return h.inner.Handle(ctx, r)
+}
+
+func (h *LevelFilterHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.inner = h.inner.WithAttrs(attrs)
+	for _, a := range attrs {
+		switch a.Key {
+		case "component":
+			clone.component = a.Value.String()
+		case "GPU":
+			if a.Value.Kind() == slog.KindInt64 {
+				clone.gpu, clone.hasGPU = int(a.Value.Int64()), true
+			}
+		}
+	}
+	return &clone
+}
+
+func (h *LevelFilterHandler) WithGroup(name string) slog.Handler {
+	clone := *h
+	clone.inner = h.inner.WithGroup(name)
+	return &clone
+}
+
+// handlerOptions returns options of output handlers, verbosity 2 and above
+// adds source locations to records.
+func handlerOptions(level slog.Leveler) *slog.HandlerOptions {
+	return &slog.HandlerOptions{Level: level, AddSource: conf.Verbosity >= 2}
+}
+
+func parseLogLevel(logLevel string) slog.Level {
+	switch logLevel {
+	case "debug":
+		return slog.LevelDebug
+	case "info":
+		return slog.LevelInfo
+	case "warn":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		slog.Warn("Invalid log level, defaulting to 'info'.", "logLevel", logLevel)
+		return slog.LevelInfo
+	}
+}
+
+// newLogHandler creates handler for a single output of logging configuration,
+// repeated messages are suppressed unless "dedup" is set to 0.
+func newLogHandler(output map[string]string) (slog.Handler, error) {
+	logLevel := output["level"]
+	if logLevel == "" {
+		logLevel = defaultLoggingLevel
+	}
+	level := parseLogLevel(logLevel)
+	if conf.Verbosity >= 1 {
+		level = slog.LevelDebug
+	}
+
+	components := map[string]slog.Level{}
+	for component, l := range conf.LogLevels.Components {
+		components[component] = parseLogLevel(l)
+	}
+	gpus := map[int]slog.Level{}
+	for gpu, l := range conf.LogLevels.GPUs {
+		gpus[gpu] = parseLogLevel(l)
+	}
+	filter := &LevelFilterHandler{base: level, components: components, gpus: gpus}
+	handler, err := newOutputHandler(output, filter.minLevel())
+	if err != nil {
+		return nil, err
+	}
+	window := defaultDedupWindow
+	if value, ok := output["dedup"]; ok {
+		var err error
+		if window, err = time.ParseDuration(value); err != nil {
+			slog.Warn("Invalid dedup window, using default.", "dedup", value, "default", defaultDedupWindow)
+			window = defaultDedupWindow
+		}
+	}
+	if window > 0 {
+		dedup := NewDedupHandler(handler, window)
+		dedupHandlers = append(dedupHandlers, dedup)
+		handler = dedup
+	}
+	if len(components) == 0 && len(gpus) == 0 {
+		return handler, nil
+	}
+	filter.inner = handler
+	return filter, nil
+}
+
+func newOutputHandler(output map[string]string, level slog.Level) (slog.Handler, error) {
+	logType := output["type"]
+
+	switch logType {
+	case "stdout":
+		return slog.NewTextHandler(os.Stdout, handlerOptions(level)), nil
+	case "json":
+		filePath := output["path"]
+		if filePath == "" {
+			return slog.NewJSONHandler(os.Stdout, handlerOptions(level)), nil
+		}
+		file, err := openLogFile(filePath)
+		if err != nil {
+			return nil, err
+		}
+		logOutputs = append(logOutputs, file)
+		return slog.NewJSONHandler(file, handlerOptions(level)), nil
+	case "file":
+		filePath := output["path"]
+		if filePath == "" {
+			filePath = defaultLogPath
+		}
+		file, err := openLogFile(filePath)
+		if err != nil {
+			return nil, err
+		}
+		logOutputs = append(logOutputs, file)
+		return slog.NewTextHandler(file, handlerOptions(level)), nil
+	case "console":
+		return NewConsoleHandler(os.Stdout, level, conf.Verbosity >= 2), nil
+	case "syslog":
+		handler, err := NewSyslogHandler(output["network"], output["address"], output["facility"], output["tag"], level)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to syslog: %w", err)
+		}
+		logOutputs = append(logOutputs, handler.w)
+		return handler, nil
+	default:
+		slog.Warn("Invalid log type, defaulting to 'stdout'.", "logType", logType)
+		return slog.NewTextHandler(os.Stdout, handlerOptions(level)), nil
+	}
+}
+
+func openLogFile(filePath string) (*os.File, error) {
+	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file '%s': %w", filePath, err)
+	}
+	return file, nil
+}
+
+// ConfigureLogging replaces default logger with configured outputs, on
+// error the previous logger stays.
+func ConfigureLogging() error {
+	outputs := conf.Logging
+	if len(outputs) == 0 {
+		slog.Warn("No logging configuration provided, using default settings.")
+		outputs = config.LoggingConfig{{"type": defaultLoggingType, "level": defaultLoggingLevel}}
+	}
+
+	previousDedups, previousOutputs := dedupHandlers, logOutputs
+	dedupHandlers, logOutputs = nil, nil
+	failed := func(err error) error {
+		closeLogging(dedupHandlers, logOutputs)
+		dedupHandlers, logOutputs = previousDedups, previousOutputs
+		return err
+	}
+	var handler slog.Handler
+	if len(outputs) == 1 {
+		var err error
+		if handler, err = newLogHandler(outputs[0]); err != nil {
+			return failed(err)
+		}
+	} else {
+		fanout := make(FanoutHandler, 0, len(outputs))
+		for _, output := range outputs {
+			h, err := newLogHandler(output)
+			if err != nil {
+				return failed(err)
+			}
+			fanout = append(fanout, h)
+		}
+		handler = fanout
+	}
+
+	slog.SetDefault(slog.New(handler))
+	closeLogging(previousDedups, previousOutputs)
+	controllerLog = slog.With("component", "controller")
+	gpu.Log = slog.With("component", "nvml")
+	metricsLog = slog.With("component", "metrics")
+	telemetryLog = slog.With("component", "telemetry")
+	telemetry.Log = telemetryLog
+	notify.Log = slog.With("component", "notify")
+	apiLog = slog.With("component", "api")
+	supervisorLog = slog.With("component", "supervisor")
+	slog.Debug("Global logging configured successfully.")
+	return nil
+}
+
+// CloseLogging logs summaries of repeated messages still pending and closes
+// log files and syslog connections.
+func CloseLogging() {
+	closeLogging(dedupHandlers, logOutputs)
+	dedupHandlers, logOutputs = nil, nil
+}
+
+// closeLogging closes dedups before outputs, they flush summaries into them.
+func closeLogging(dedups []*DedupHandler, outputs []io.Closer) {
+	for _, dedup := range dedups {
+		dedup.Close()
+	}
+	for _, output := range outputs {
+		output.Close()
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/IvanBayan/nvmlfan/internal/config"
+	"github.com/IvanBayan/nvmlfan/internal/gpu"
+	"github.com/IvanBayan/nvmlfan/internal/notify"
+	"github.com/IvanBayan/nvmlfan/internal/telemetry"
+)
+
+func openFiles(t *testing.T) int {
+	t.Helper()
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("no /proc/self/fd")
+	}
+	return len(fds)
+}
+
+func TestConfigureLoggingClosesOutputs(t *testing.T) {
+	savedConf, savedDefault := conf, slog.Default()
+	saved := []*slog.Logger{controllerLog, gpu.Log, metricsLog, telemetryLog, notify.Log, apiLog, supervisorLog}
+	t.Cleanup(func() {
+		CloseLogging()
+		conf = savedConf
+		slog.SetDefault(savedDefault)
+		controllerLog, gpu.Log, metricsLog, telemetryLog, notify.Log, apiLog, supervisorLog =
+			saved[0], saved[1], saved[2], saved[3], saved[4], saved[5], saved[6]
+		telemetry.Log = telemetryLog
+	})
+	path := filepath.Join(t.TempDir(), "nvmlfan.log")
+	file := map[string]string{"type": "file", "path": path, "level": "error"}
+	broken := map[string]string{"type": "syslog", "facility": "bogus"}
+
+	base := openFiles(t)
+	conf = config.Config{Logging: config.LoggingConfig{file, broken}}
+	if err := ConfigureLogging(); err == nil {
+		t.Fatal("ConfigureLogging() with unknown facility succeeded")
+	}
+	if n := openFiles(t); n != base {
+		t.Errorf("open files after failed configuration = %d, want %d", n, base)
+	}
+
+	conf = config.Config{Logging: config.LoggingConfig{file}}
+	for i := 0; i < 2; i++ {
+		if err := ConfigureLogging(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := openFiles(t); n != base+1 {
+		t.Errorf("open files after reconfiguration = %d, want %d", n, base+1)
+	}
+	CloseLogging()
+	if n := openFiles(t); n != base {
+		t.Errorf("open files after CloseLogging = %d, want %d", n, base)
+	}
+}
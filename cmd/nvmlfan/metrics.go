@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/IvanBayan/nvmlfan/internal/gpu"
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+// Upper bounds of latency histogram buckets in seconds.
+var latencyBuckets = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+type histogram struct {
+	counts []uint64 // Per bucket, not cumulative.
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(v float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(latencyBuckets))
+	}
+	for i, bound := range latencyBuckets {
+		if v <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) write(w io.Writer, name, labels string) {
+	var cumulative uint64
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	for i, bound := range latencyBuckets {
+		if h.counts != nil {
+			cumulative += h.counts[i]
+		}
+		fmt.Fprintf(w, "%s_bucket{%s%sle=\"%g\"} %d\n", name, labels, sep, bound, cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, sep, h.count)
+	fmt.Fprintf(w, "%s_sum{%s} %g\n", name, labels, h.sum)
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, h.count)
+}
+
+// Metrics of the daemon itself, label sets are pre-rendered strings.
+var (
+	metricsMu      sync.Mutex
+	cycleDurations = map[string]*histogram{}
+	nvmlLatencies  = map[string]*histogram{}
+	nvmlErrors     = map[string]uint64{}
+
+	reloads           atomic.Uint64
+	goroutineRestarts atomic.Uint64
+	nvmlHangs         atomic.Uint64
+	reasserts         atomic.Uint64
+	failsafes         atomic.Uint64
+)
+
+func init() {
+	gpu.Observe = ObserveNVMLCall
+	gpu.OnHang = NVMLHung
+}
+
+func labelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// ObserveNVMLCall records latency and result of an NVML call started at start.
+func ObserveNVMLCall(call string, start time.Time, ret nvml.Return) {
+	elapsed := time.Since(start).Seconds()
+	labels := fmt.Sprintf("call=%q", call)
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	h, ok := nvmlLatencies[labels]
+	if !ok {
+		h = &histogram{}
+		nvmlLatencies[labels] = h
+	}
+	h.observe(elapsed)
+	if ret != nvml.SUCCESS {
+		nvmlErrors[fmt.Sprintf("call=%q,error=\"%s\"", call, labelValue(nvml.ErrorString(ret)))]++
+	}
+}
+
+// ObserveCycle records how long one control loop iteration of GPU idx took.
+func ObserveCycle(idx int, elapsed time.Duration) {
+	labels := gpuLabels(idx)
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	h, ok := cycleDurations[labels]
+	if !ok {
+		h = &histogram{}
+		cycleDurations[labels] = h
+	}
+	h.observe(elapsed.Seconds())
+}
+
+func gpuLabels(idx int) string {
+	id := gpu.GetDeviceIdentity(idx)
+	return fmt.Sprintf("gpu=\"%d\",uuid=\"%s\",name=\"%s\"", idx, labelValue(id.UUID), labelValue(id.Name))
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// WriteMetrics renders all metrics in Prometheus text exposition format.
+func WriteMetrics(w io.Writer) {
+	// GPU state comes from the snapshots of the last cycles. Its labels are
+	// resolved before taking metricsMu: an identity missing from the cache is
+	// an NVML call, whose broker records the latency under the same lock.
+	snapshots := Snapshots()
+	labels := make([]string, len(snapshots))
+	for i, s := range snapshots {
+		labels[i] = gpuLabels(s.GPU)
+	}
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+
+	fmt.Fprintln(w, "# HELP nvmlfan_cycle_duration_seconds Duration of one control loop iteration.")
+	fmt.Fprintln(w, "# TYPE nvmlfan_cycle_duration_seconds histogram")
+	for _, labels := range sortedKeys(cycleDurations) {
+		cycleDurations[labels].write(w, "nvmlfan_cycle_duration_seconds", labels)
+	}
+
+	fmt.Fprintln(w, "# HELP nvmlfan_nvml_call_duration_seconds Latency of NVML calls.")
+	fmt.Fprintln(w, "# TYPE nvmlfan_nvml_call_duration_seconds histogram")
+	for _, labels := range sortedKeys(nvmlLatencies) {
+		nvmlLatencies[labels].write(w, "nvmlfan_nvml_call_duration_seconds", labels)
+	}
+
+	fmt.Fprintln(w, "# HELP nvmlfan_nvml_errors_total NVML calls which returned an error.")
+	fmt.Fprintln(w, "# TYPE nvmlfan_nvml_errors_total counter")
+	for _, labels := range sortedKeys(nvmlErrors) {
+		fmt.Fprintf(w, "nvmlfan_nvml_errors_total{%s} %d\n", labels, nvmlErrors[labels])
+	}
+
+	fmt.Fprintln(w, "# HELP nvmlfan_reloads_total NVML reloads to enumerate GPUs again.")
+	fmt.Fprintln(w, "# TYPE nvmlfan_reloads_total counter")
+	fmt.Fprintf(w, "nvmlfan_reloads_total %d\n", reloads.Load())
+
+	fmt.Fprintln(w, "# HELP nvmlfan_goroutine_restarts_total Restarts of control goroutines.")
+	fmt.Fprintln(w, "# TYPE nvmlfan_goroutine_restarts_total counter")
+	fmt.Fprintf(w, "nvmlfan_goroutine_restarts_total %d\n", goroutineRestarts.Load())
+
+	fmt.Fprintln(w, "# HELP nvmlfan_nvml_hangs_total NVML calls which exceeded nvml_timeout.")
+	fmt.Fprintln(w, "# TYPE nvmlfan_nvml_hangs_total counter")
+	fmt.Fprintf(w, "nvmlfan_nvml_hangs_total %d\n", nvmlHangs.Load())
+
+	fmt.Fprintln(w, "# HELP nvmlfan_reasserts_total Fans put back after something else changed them.")
+	fmt.Fprintln(w, "# TYPE nvmlfan_reasserts_total counter")
+	fmt.Fprintf(w, "nvmlfan_reasserts_total %d\n", reasserts.Load())
+
+	fmt.Fprintln(w, "# HELP nvmlfan_failsafe_total Cards put at failsafe speed after consecutive failed readings.")
+	fmt.Fprintln(w, "# TYPE nvmlfan_failsafe_total counter")
+	fmt.Fprintf(w, "nvmlfan_failsafe_total %d\n", failsafes.Load())
+
+	if len(conf.Profiles) > 0 {
+		fmt.Fprintln(w, "# HELP nvmlfan_profile_active Profile of card settings, 1 for the active one.")
+		fmt.Fprintln(w, "# TYPE nvmlfan_profile_active gauge")
+		active := ActiveProfile()
+		for _, name := range sortedKeys(conf.Profiles) {
+			value := 0
+			if name == active {
+				value = 1
+			}
+			fmt.Fprintf(w, "nvmlfan_profile_active{profile=\"%s\"} %d\n", labelValue(name), value)
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP nvmlfan_gpu_health What nvmlfan does with the card, 1 for its current state.")
+	fmt.Fprintln(w, "# TYPE nvmlfan_gpu_health gauge")
+	for _, h := range CardsHealth() {
+		for _, state := range healthStates {
+			value := 0
+			if h.State == state {
+				value = 1
+			}
+			fmt.Fprintf(w, "nvmlfan_gpu_health{%s,state=\"%s\"} %d\n", healthLabels(h), state, value)
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP nvmlfan_gpu_temperature_celsius GPU temperature.")
+	fmt.Fprintln(w, "# TYPE nvmlfan_gpu_temperature_celsius gauge")
+	for i, s := range snapshots {
+		fmt.Fprintf(w, "nvmlfan_gpu_temperature_celsius{%s} %d\n", labels[i], s.Temp)
+	}
+	fmt.Fprintln(w, "# HELP nvmlfan_gpu_fan_speed_percent Fan speed reported by the card.")
+	fmt.Fprintln(w, "# TYPE nvmlfan_gpu_fan_speed_percent gauge")
+	for i, s := range snapshots {
+		for fi, speed := range s.Fans {
+			fmt.Fprintf(w, "nvmlfan_gpu_fan_speed_percent{%s,fan=\"%d\"} %d\n", labels[i], fi, speed)
+		}
+	}
+	fmt.Fprintln(w, "# HELP nvmlfan_gpu_power_watts GPU power draw.")
+	fmt.Fprintln(w, "# TYPE nvmlfan_gpu_power_watts gauge")
+	for i, s := range snapshots {
+		fmt.Fprintf(w, "nvmlfan_gpu_power_watts{%s} %g\n", labels[i], s.Power)
+	}
+	fmt.Fprintln(w, "# HELP nvmlfan_gpu_utilization_percent GPU utilization.")
+	fmt.Fprintln(w, "# TYPE nvmlfan_gpu_utilization_percent gauge")
+	for i, s := range snapshots {
+		if s.Utilization >= 0 {
+			fmt.Fprintf(w, "nvmlfan_gpu_utilization_percent{%s} %d\n", labels[i], s.Utilization)
+		}
+	}
+}
+
+func ConfigureMetrics() {
+	if conf.Metrics == nil || conf.Metrics.Listen == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		WriteMetrics(w)
+	})
+	go func() {
+		metricsLog.Info("Serving metrics", "listen", conf.Metrics.Listen)
+		if err := http.ListenAndServe(conf.Metrics.Listen, mux); err != nil {
+			metricsLog.Error("Metrics server failed", "listen", conf.Metrics.Listen, "error", err)
+		}
+	}()
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IvanBayan/nvmlfan/internal/config"
+	"github.com/IvanBayan/nvmlfan/internal/gpu"
+)
+
+func TestWriteMetricsResolvesIdentityOutsideLock(t *testing.T) {
+	s, clk := simScheduler(t, config.Config{
+		Cards: map[string]config.GPUConfig{"0": {Mode: "curve", Curve: [][2]int{{40, 30}, {80, 100}}}},
+		Sim:   config.SimConfig{GPUs: []config.SimGPUConfig{{Name: "Sim GPU"}}},
+	})
+	savedObserve, savedTimeout := gpu.Observe, gpu.CallTimeout
+	t.Cleanup(func() {
+		gpu.Observe, gpu.CallTimeout = savedObserve, savedTimeout
+		ForgetSnapshots()
+	})
+	// Calls of the broker record their latency under metricsMu
+	gpu.Observe = ObserveNVMLCall
+	gpu.CallTimeout = 5 * time.Second
+	runRounds(t, s, clk, 1)
+	// Identity lookup of the scrape has to go to NVML, several calls
+	gpu.ResetCache()
+
+	done := make(chan string)
+	go func() {
+		var b strings.Builder
+		WriteMetrics(&b)
+		done <- b.String()
+	}()
+	select {
+	case out := <-done:
+		if !strings.Contains(out, `name="Sim GPU"`) {
+			t.Errorf("metrics lack GPU identity:\n%s", out)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("WriteMetrics blocked the NVML broker")
+	}
+}
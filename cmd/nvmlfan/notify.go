@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/IvanBayan/nvmlfan/internal/gpu"
+	"github.com/IvanBayan/nvmlfan/internal/notify"
+)
+
+const (
+	defaultWebhookRetries = 3
+	defaultWebhookTimeout = 10 * time.Second
+	defaultOverheat       = 90
+	defaultOverheatFor    = 30 * time.Second
+	defaultFlapWindow     = 30 * time.Minute
+	// notifyCloseTimeout is how long queued notifications may delay exit.
+	notifyCloseTimeout = 5 * time.Second
+)
+
+// defaultNotifyEvents are event types sent when a sink doesn't choose: failures
+// and what fans were left in on exit.
+var defaultNotifyEvents = []string{"failsafe", "throttle", "overheat", "emergency", "hang", "fan_failure", "watchdog", "restore", "exit", "hold"}
+
+// defaultEmailEvents are critical ones, mail shouldn't flood an inbox.
+var defaultEmailEvents = []string{"failsafe", "overheat", "emergency", "hang", "fan_failure"}
+
+var notifier *notify.Notifier
+
+// ConfigureNotify starts sending events to configured sinks.
+func ConfigureNotify() {
+	if conf.Notify == nil {
+		return
+	}
+	flapWindow := conf.Notify.FlapWindow
+	if flapWindow == 0 {
+		flapWindow = defaultFlapWindow
+	}
+	n := notify.New(notify.Policy{
+		Cooldown:   conf.Notify.Cooldown,
+		Cooldowns:  conf.Notify.Cooldowns,
+		FlapCount:  conf.Notify.FlapCount,
+		FlapWindow: flapWindow,
+	})
+	for i, w := range conf.Notify.Webhooks {
+		timeout := w.Timeout
+		if timeout <= 0 {
+			timeout = defaultWebhookTimeout
+		}
+		webhook, err := notify.NewWebhook(w.URL, w.Format, w.Template, w.Headers, timeout)
+		if err != nil {
+			notify.Log.Error("Webhook is ignored", "webhook", i, "error", err)
+			continue
+		}
+		retries := w.Retries
+		if retries == 0 {
+			retries = defaultWebhookRetries
+		}
+		n.Add(fmt.Sprintf("webhook %d", i), webhook, notifyEvents(w.Events), retries)
+	}
+	if e := conf.Notify.Email; e != nil {
+		server := notify.EmailServer{Host: e.Host, Port: e.Port, Security: e.Security, Username: e.Username, Password: e.Password}
+		email, err := notify.NewEmail(server, e.From, e.To, e.Subject, e.Body)
+		if err != nil {
+			notify.Log.Error("Email is ignored", "error", err)
+		} else {
+			retries := e.Retries
+			if retries == 0 {
+				retries = defaultWebhookRetries
+			}
+			events := e.Events
+			if len(events) == 0 {
+				events = defaultEmailEvents
+			}
+			n.Add("email", email, events, retries)
+		}
+	}
+	if d := conf.Notify.Desktop; d != nil {
+		// A desktop which isn't there now won't be in a second either
+		n.Add("desktop", notify.NewDesktop(d.Bus), notifyEvents(d.Events), 0)
+	}
+	notifier = n
+}
+
+func notifyEvents(events []string) []string {
+	if len(events) == 0 {
+		return defaultNotifyEvents
+	}
+	return events
+}
+
+// CloseNotify sends queued notifications before exit.
+func CloseNotify() {
+	if notifier != nil {
+		notifier.Close(notifyCloseTimeout)
+	}
+}
+
+// checkOverheat records an overheat event once the card c stayed above the
+// overheat temperature with fans at maximum in snapshot, and resolves it
+// once it was out of that state as long.
+func (c *cardControl) checkOverheat(snapshot gpu.Snapshot) {
+	limit, after := conf.Notify.Overheat, conf.Notify.OverheatFor
+	if limit == 0 {
+		limit = defaultOverheat
+	}
+	if after == 0 {
+		after = defaultOverheatFor
+	}
+	hot := snapshot.Temp > limit && max(c.output, snapshot.Speed) >= c.maxSpeed
+	st := &c.overheat
+	if hot != st.holds || st.since.IsZero() {
+		st.holds, st.since = hot, snapshot.Time
+	}
+	if st.holds == st.active || snapshot.Time.Sub(st.since) < after {
+		return
+	}
+	st.active = st.holds
+	if st.active {
+		c.logger.Warn("Temperature stays above overheat limit with fans at maximum", "temp", snapshot.Temp, "limit", limit)
+		RecordEvent(c.idx, "overheat", fmt.Sprintf("Reached %d°C, fans at maximum", snapshot.Temp))
+	} else {
+		c.logger.Info("Temperature back under overheat limit", "temp", snapshot.Temp, "limit", limit)
+		ResolveEvent(c.idx, "overheat", fmt.Sprintf("Back at %d°C", snapshot.Temp))
+	}
+}
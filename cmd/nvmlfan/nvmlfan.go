@@ -0,0 +1,466 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/IvanBayan/nvmlfan/internal/clock"
+	"github.com/IvanBayan/nvmlfan/internal/config"
+	"github.com/IvanBayan/nvmlfan/internal/gpu"
+	"github.com/IvanBayan/nvmlfan/internal/sim"
+	"github.com/IvanBayan/nvmlfan/pkg/controller"
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+const (
+	defaultPeriod = 1
+	defaultLoggingType = "stdout"
+	defaultLoggingLevel = "info"
+)
+var conf config.Config
+
+// confMu guards conf once the API serves it, startup writes before that
+// don't need it.
+var confMu sync.RWMutex
+
+// stopRequests ends the daemon, it receives termination signals.
+var stopRequests = make(chan os.Signal, 1)
+
+func isFlagPassed(fs *flag.FlagSet, name string) bool {
+    found := false
+    fs.Visit(func(f *flag.Flag) {
+        if f.Name == name {
+            found = true
+        }
+    })
+    return found
+}
+
+var (
+	boundMu sync.Mutex
+	// Index of every controlled GPU when its controller was first built, by
+	// UUID. Configuration keyed by index follows the card when indexes shift.
+	boundIndexes = map[string]int{}
+)
+
+// BindCard ties configuration of GPU idx to its UUID.
+func BindCard(idx int) {
+	uuid := gpu.GetDeviceIdentity(idx).UUID
+	boundMu.Lock()
+	defer boundMu.Unlock()
+	if _, ok := boundIndexes[uuid]; !ok && uuid != "" {
+		boundIndexes[uuid] = idx
+	}
+}
+
+// configIndex returns the index GPU idx with uuid has in configuration: the
+// one it was bound with, or -1 when another card was bound with idx.
+func configIndex(idx int, uuid string) int {
+	boundMu.Lock()
+	defer boundMu.Unlock()
+	if bound, ok := boundIndexes[uuid]; ok {
+		return bound
+	}
+	for _, bound := range boundIndexes {
+		if bound == idx {
+			return -1
+		}
+	}
+	return idx
+}
+
+// CardKey returns the key of GPU idx in cards configuration.
+func CardKey(cfg config.Config, idx int) (string, bool) {
+	id := gpu.GetDeviceIdentity(idx)
+	return cfg.CardKey(configIndex(idx, id.UUID), id.UUID, id.Name)
+}
+
+// Excluded reports whether GPU idx is in the exclude list.
+func Excluded(cfg config.Config, idx int) bool {
+	if len(cfg.Exclude) == 0 {
+		return false
+	}
+	id := gpu.GetDeviceIdentity(idx)
+	return cfg.Excluded(configIndex(idx, id.UUID), id.UUID, id.Name)
+}
+
+// CardConfig returns configuration of GPU idx with the active profile
+// applied.
+func CardConfig(cfg config.Config, idx int) (config.GPUConfig, bool) {
+	key, ok := CardKey(cfg, idx)
+	card := cfg.Cards[key]
+	if !ok {
+		return card, ok
+	}
+	for _, profile := range cardProfiles(idx) {
+		card = card.Merge(cfg.Profiles[profile].Cards[key])
+	}
+	return card, ok
+}
+
+func PrintSystemInfo(info gpu.SystemInfo) {
+	fmt.Printf("Driver: %v NVML: %v\n", info.DriverVersion, info.NVMLVersion)
+	for _, card := range info.Cards {
+		PrintCardInfo(card)
+	}
+}
+
+func PrintCardInfo(info gpu.CardInfo) {
+	fmt.Printf("%2d: %v (s/n: %v) - %v\n", info.Index, info.Name, orNA(info.Serial), info.UUID)
+	persistence := "n/a"
+	if info.Persistence != nil {
+		persistence = map[bool]string{true: "on", false: "off"}[*info.Persistence]
+	}
+	fmt.Printf("  +- PCI: %v VBIOS: %v Persistence: %v\n", orNA(info.PCI), orNA(info.VBIOS), persistence)
+	fmt.Printf("  +- Power: %.1f/%.1f W Clocks: graphics %d MHz, sm %d MHz, memory %d MHz\n",
+		info.Power, info.PowerLimit, info.GraphicsMHz, info.SMMHz, info.MemoryMHz)
+	maxTemp := "n/a"
+	if info.MaxTemp > 0 {
+		maxTemp = fmt.Sprint(info.MaxTemp)
+	}
+	fmt.Printf("  +- Temp: %d Max temp: %v\n", info.Temperature, maxTemp)
+	for _, fan := range info.Fans {
+		fmt.Printf("  +- Fan: %d Speed: %d Range: %d-%d Policy: %v\n", fan.Index, fan.Speed, fan.MinSpeed, fan.MaxSpeed, orNA(fan.Policy))
+	}
+}
+
+// orNA returns s, or "n/a" for a value the card didn't report.
+func orNA(s string) string {
+	if s == "" {
+		return "n/a"
+	}
+	return s
+}
+
+// ReleaseFans applies exit behavior to all controlled GPUs.
+func ReleaseFans() {
+	controllerLog.Info("Releasing fan control")
+	deviceCount := gpu.GetDeviceCount()
+
+	var gpus []int
+	for i := 0; i < deviceCount; i++ {
+		if _, skipped := Skipped(i); skipped || Excluded(conf, i) {
+			continue
+		}
+		ApplyExitBehavior(i)
+		RunCardHook(hookPostRestore, i)
+		if _, ok := CardConfig(conf, i); ok {
+			gpus = append(gpus, i)
+		}
+	}
+	ReleaseHwmon()
+	RunDaemonHook(hookPostRestore, gpus)
+}
+
+// ApplyExitBehavior leaves fans of GPU idx as its card configuration asks.
+// Fans which can't be set to the exit speed are returned to the driver.
+func ApplyExitBehavior(idx int) {
+	RestorePowerLimit(idx)
+	RestoreClocks(idx)
+	card, _ := CardConfig(conf, idx)
+	logger := controllerLog.With("GPU", idx)
+	switch card.OnExit {
+	case config.ExitHold:
+		logger.Info("Holding last fan speed")
+		RecordEvent(idx, "hold", "Fans left at last speed")
+		MarkHeld(idx)
+	case config.ExitFixed:
+		device, err := gpu.DeviceGetHandleByIndex(idx)
+		if err != nil {
+			logger.Error("Can't set exit speed", "error", err)
+			return
+		}
+		minSpeed, maxSpeed := gpu.GetMinMaxFanSpeed(device)
+		speed := min(max(card.ExitSpeed, minSpeed), maxSpeed)
+		logger.Info("Setting fans to exit speed", "speed", speed)
+		for fi := 0; fi < gpu.GetNumFans(idx); fi++ {
+			if ret := gpu.SetSingleFanSpeed(idx, fi, speed); ret != nvml.SUCCESS {
+				gpu.Log.Error("Can't set exit speed, restoring default", "GPU", idx, "fan", fi, "error", nvml.ErrorString(ret))
+				gpu.SetSingleFanDefault(idx, fi)
+			}
+		}
+		RecordEvent(idx, "exit", fmt.Sprintf("Fans set to exit speed %d%%", speed))
+		MarkHeld(idx)
+	default:
+		logger.Info("Restoring original fan control")
+		gpu.RestoreFans(idx)
+		RecordEvent(idx, "restore", "Original fan control restored")
+		ClearHeld(idx)
+	}
+}
+
+// ControlFans starts the scheduler with configured cards and hwmon outputs.
+func ControlFans(ctx context.Context) {
+	controllerLog.Debug("Cards configurations", "dump", conf.Cards)
+	s := &scheduler{}
+	deviceCount := gpu.GetDeviceCount()
+	for idx := 0; idx < deviceCount; idx++ {
+		if Excluded(conf, idx) {
+			controllerLog.Info("Skipping excluded card", "GPU", idx)
+			continue
+		}
+		gpu_config, ok := CardConfig(conf, idx)
+		if  ! ok {
+			controllerLog.Info("Skipping card, not found in config.", "GPU", idx)
+			continue
+		} else if reason, skipped := Skipped(idx); skipped {
+			controllerLog.Info("Skipping card "+reason, "GPU", idx)
+			continue
+		} else {
+			controllerLog.Info("Taking FAN controls of card.", "GPU", idx)
+			ClearHeld(idx)
+		}
+		RecordEvent(idx, "control", "Taking fan control in "+gpu_config.Mode+" mode")
+		card, err := newCardControl(idx, gpu_config.Mode)
+		if err != nil {
+			Fail(fmt.Errorf("GPU %d: %w", idx, err))
+			continue
+		}
+		s.cards = append(s.cards, card)
+	}
+	s.outputs = hwmonControls()
+	s.start(ctx)
+}
+
+// MonitorGPUs starts the scheduler recording stock behavior of all GPUs
+// without touching their fans.
+func MonitorGPUs(ctx context.Context) {
+	s := &scheduler{}
+	for idx := 0; idx < gpu.GetDeviceCount(); idx++ {
+		if Excluded(conf, idx) {
+			controllerLog.Info("Skipping excluded card", "GPU", idx)
+			continue
+		}
+		card, err := newCardControl(idx, "monitor")
+		if err != nil {
+			Fail(fmt.Errorf("GPU %d: %w", idx, err))
+			continue
+		}
+		s.cards = append(s.cards, card)
+	}
+	s.start(ctx)
+}
+
+// RunOnce applies fan speeds of all configured cards a single time and leaves
+// them in manual mode, for running from cron or timers. PID needs state between
+// cycles, so only curve mode is supported.
+func RunOnce() int {
+	if err := gpu.InitNVML(); err != nil {
+		slog.Error("Can't apply speeds", "error", err)
+		return 1
+	}
+	defer gpu.Shutdown()
+	if err := ConfigureLogging(); err != nil {
+		slog.Error("Can't configure logging", "error", err)
+		return 1
+	}
+	UpdateProfile(daemonClock.Now())
+	UpdateActivity(daemonClock.Now())
+	code := 0
+	for idx := 0; idx < gpu.GetDeviceCount(); idx++ {
+		card, ok := CardConfig(conf, idx)
+		if !ok {
+			continue
+		}
+		logger := controllerLog.With("GPU", idx)
+		if err := gpu.CaptureFans(idx); err == nil {
+			FindExternalCards([]int{idx})
+			if _, skipped := Skipped(idx); skipped {
+				continue
+			}
+		}
+		if err := gpu.CheckSupport(idx); errors.Is(err, gpu.ErrUnsupported) {
+			logger.Warn("Fan control unsupported, leaving the card under driver control", "reason", err)
+			continue
+		}
+		if card.Mode != "curve" {
+			logger.Error("Only curve mode can be applied once", "mode", card.Mode)
+			code = 1
+			continue
+		}
+		minSpeed, maxSpeed, maxTemp, err := gpu.GetThermalInfo(idx)
+		if err != nil {
+			logger.Error("Can't apply speed", "error", err)
+			code = 1
+			continue
+		}
+		curve := controller.ClampCurve(CardCurve(card, minSpeed, maxSpeed, maxTemp), minSpeed, maxSpeed, maxTemp, logger)
+		temp, err := gpu.GetTemperature(idx)
+		if err != nil {
+			logger.Error("Can't apply speed", "error", err)
+			code = 1
+			continue
+		}
+		speed := controller.ComputeFanSpeed(temp, curve, minSpeed, maxSpeed)
+		if err := gpu.Retry(func() error { return gpu.SetFanSpeed(idx, speed) }); err != nil {
+			logger.Error("Can't apply speed", "error", err)
+			code = 1
+			continue
+		}
+		logger.Info("Fan speed applied", "temp", temp, "speed", speed)
+		MarkHeld(idx)
+	}
+	return code
+}
+
+func main() {
+	args := os.Args[1:]
+	name := "run"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	cmd := FindCommand(name)
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "Unknown command '%s'\n\n", name)
+		PrintUsage()
+		os.Exit(2)
+	}
+	os.Exit(cmd.Run(args))
+}
+
+// UseBackend selects the library behind GPU calls by name.
+func UseBackend(name string) error {
+	switch name {
+	case "", "nvml":
+		if s := conf.NvidiaSettings; s != nil {
+			gpu.Settings = &gpu.NvidiaSettings{Path: s.Path, Display: s.Display, Xauthority: s.Xauthority}
+		}
+	case "sim":
+		if conf.Sim.Speed > 0 {
+			daemonClock = clock.Scaled(conf.Sim.Speed)
+		}
+		gpu.Use(name, sim.New(conf.Sim, daemonClock))
+	case "replay":
+		if conf.Replay.Speed > 0 {
+			daemonClock = clock.Scaled(conf.Replay.Speed)
+		}
+		lib, err := sim.NewReplay(conf.Replay, daemonClock)
+		if err != nil {
+			return err
+		}
+		gpu.Use(name, lib)
+	default:
+		return fmt.Errorf("unknown backend '%s', use nvml, sim or replay", name)
+	}
+	return nil
+}
+
+// RunCommand implements `nvmlfan run`, the fan control daemon. It is also
+// used when no command is given, -list and -restore are kept as aliases of
+// the list and restore commands.
+func RunCommand(args []string) int {
+	// Old style flags come first and are handled by their commands, which
+	// accept the rest of arguments, e.g. -restore -gpu 0.
+	if len(args) > 0 {
+		switch args[0] {
+		case "-list", "--list":
+			return ListCommand(args[1:])
+		case "-restore", "--restore":
+			return RestoreCommand(args[1:])
+		}
+	}
+
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	foreground := fs.Bool("foreground", false, "Run in foreground")
+	configPath := fs.String("config", defaultConfigPath, "Path to configuration file")
+	fs.Bool("list", false, "List GPUs (same as list command)")
+	fs.Bool("restore", false, "Restore fan controll (same as restore command)")
+	verbose := fs.Bool("v", false, "Verbose logging, debug level on all outputs")
+	veryVerbose := fs.Bool("vv", false, "Very verbose logging, debug level with source locations")
+	duration := fs.Duration("duration", 0, "Restore defaults and exit after this time, e.g. 2h")
+	once := fs.Bool("once", false, "Apply speeds once and exit without restoring defaults")
+	monitor := fs.Bool("monitor", false, "Only record telemetry, don't control fans")
+	force := fs.Bool("force", false, "Take control even if another fan controller is running")
+	container := fs.Bool("container", InContainer(), "Container mode: foreground, stdout logging, no pid file")
+	backend := fs.String("backend", "", "GPU backend: nvml, sim for simulated GPUs or replay of telemetry (overrides config)")
+	fs.Parse(args)
+	if isFlagPassed(fs, "list") || isFlagPassed(fs, "restore") {
+		fmt.Fprintln(os.Stderr, "-list and -restore must be the first argument")
+		return 2
+	}
+
+	// Load configuration
+	var err error
+	if conf, err = config.Load(*configPath); err != nil {
+		slog.Error("Can't load configuration", "error", err)
+		return 1
+	}
+	if isFlagPassed(fs, "v") && *verbose {
+		conf.Verbosity = 1
+	}
+	if isFlagPassed(fs, "vv") && *veryVerbose {
+		conf.Verbosity = 2
+	}
+	if isFlagPassed(fs, "monitor") {
+		conf.Monitor = *monitor
+	}
+	forceControl = *force
+	if isFlagPassed(fs, "backend") {
+		conf.Backend = *backend
+	}
+	if err := UseBackend(conf.Backend); err != nil {
+		slog.Error("Can't start", "error", err)
+		return 1
+	}
+	if conf.NVMLTimeout > 0 {
+		gpu.CallTimeout = conf.NVMLTimeout
+	}
+	// Parent of a daemon or supervised controller has checked already
+	if !conf.Monitor && !IsDaemonChild() && !Supervised() {
+		if err := CheckCompetitors(); err != nil {
+			slog.Error("Can't start", "error", err)
+			return 1
+		}
+	}
+	if *once {
+		if pid, ok := RunningDaemon(pidFilePath(conf.PidFile)); ok {
+			slog.Error("Daemon is controlling fans", "pid", pid)
+			return 1
+		}
+		return RunOnce()
+	}
+	// Conditionally override configuration only if the flags are passed by the user
+	if isFlagPassed(fs, "foreground") {
+		conf.Foreground = *foreground
+	}
+	if *container {
+		ApplyContainerMode()
+		if err := CheckContainerDevices(); err != nil && gpu.Backend() == "nvml" {
+			slog.Error("GPU is not available in container", "error", err)
+			return 1
+		}
+	}
+	if !conf.Foreground && !IsDaemonChild() && !Supervised() {
+		return Daemonize()
+	}
+	if !conf.Supervisor.Disabled && !conf.Monitor && !Supervised() {
+		return Supervise()
+	}
+	if conf.Sandbox && !Sandboxed() {
+		if err := EnterSandbox(*configPath); err != nil {
+			slog.Error("Can't enter sandbox", "error", err)
+			return 1
+		}
+	}
+	// Supervisor holds the pid file
+	if !Supervised() {
+		if err := AcquirePidFile(pidFilePath(conf.PidFile)); err != nil {
+			slog.Error("Can't start", "error", err)
+			return 1
+		}
+	}
+
+	defer RestoreOnPanic()
+	if err := StartDaemon(); err != nil {
+		slog.Error("Can't start", "error", err)
+		Shutdown(exitFailure)
+	}
+	Shutdown(WaitDaemon(*duration))
+	panic("unreachable")
+}
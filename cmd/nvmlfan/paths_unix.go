@@ -0,0 +1,14 @@
+package main
+
+const (
+	defaultConfigPath    = "config.yaml"
+	defaultLogPath       = "/var/log/nvmlfan.log"
+	defaultTelemetryPath = "/var/log/nvmlfan.csv"
+	defaultStatsPath     = "/var/lib/nvmlfan/stats.db"
+	defaultAPISocket     = "/run/nvmlfan.sock"
+	defaultPidFile       = "/run/nvmlfan.pid"
+	defaultHeldPath      = "/var/lib/nvmlfan/held"
+	defaultDrainedPath   = "/var/lib/nvmlfan/drained"
+	defaultPowerPath     = "/var/lib/nvmlfan/power"
+	defaultAuditPath     = "/var/log/nvmlfan-audit.log"
+)
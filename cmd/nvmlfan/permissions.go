@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/IvanBayan/nvmlfan/internal/gpu"
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+// CheckControlPermissions verifies that fans of gpus can be controlled before
+// taking control, so missing permissions are reported precisely at startup
+// instead of failing on the first SetFanSpeed. Fans are switched to manual
+// policy and one of them is set to its current target speed as a probe.
+// Cards which don't support fan control aren't errors, they are skipped and
+// left to the driver.
+func CheckControlPermissions(gpus []int) []error {
+	var errs []error
+	if gpu.Backend() == "nvml" {
+		errs = checkDeviceAccess(gpus)
+	}
+	for _, idx := range gpus {
+		if err := gpu.CheckSupport(idx); errors.Is(err, gpu.ErrUnsupported) {
+			skipUnsupported(idx, err)
+			continue
+		} else if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		device, err := gpu.DeviceGetHandleByIndex(idx)
+		if err != nil {
+			continue
+		}
+		target, ret := device.GetTargetFanSpeed(0)
+		if ret != nvml.SUCCESS {
+			errs = append(errs, fmt.Errorf("GPU %d: NVML returned %s on GetTargetFanSpeed", idx, nvml.ErrorString(ret)))
+			continue
+		}
+		if err := gpu.SetManualPolicy(idx); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		switch ret := gpu.SetSingleFanSpeed(idx, 0, target); ret {
+		case nvml.SUCCESS:
+		case nvml.ERROR_NO_PERMISSION:
+			errs = append(errs, fmt.Errorf("GPU %d: NVML returned NO_PERMISSION on SetFanSpeed_v2, run nvmlfan as root", idx))
+		case nvml.ERROR_NOT_SUPPORTED:
+			skipUnsupported(idx, fmt.Errorf("%w: GPU %d doesn't allow setting fan speed", gpu.ErrUnsupported, idx))
+		default:
+			errs = append(errs, fmt.Errorf("GPU %d: NVML returned %s on SetFanSpeed_v2", idx, nvml.ErrorString(ret)))
+		}
+	}
+	return errs
+}
+
+// skipUnsupported leaves GPU idx, which can't have its fans controlled for
+// err, to the driver.
+func skipUnsupported(idx int, err error) {
+	controllerLog.Warn("Fan control unsupported, leaving the card under driver control", "GPU", idx, "reason", err)
+	RecordEvent(idx, "unsupported", err.Error())
+	SkipCard(idx, "unsupported")
+}
+
+// checkDeviceAccess reports device nodes and privileges NVML needs to
+// change fan policy.
+func checkDeviceAccess(gpus []int) []error {
+	var errs []error
+	nodes := []string{"/dev/nvidiactl"}
+	for _, idx := range gpus {
+		device, err := gpu.DeviceGetHandleByIndex(idx)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		minor, ret := device.GetMinorNumber()
+		if ret == nvml.SUCCESS {
+			nodes = append(nodes, fmt.Sprintf("/dev/nvidia%d", minor))
+		}
+	}
+	for _, node := range nodes {
+		if err := NodeAccessError(node); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if !IsPrivileged() {
+		errs = append(errs, fmt.Errorf("%s, NVML lets only administrators change fan policy", privilegeHint))
+	}
+	return errs
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const privilegeHint = "not running as root and CAP_SYS_ADMIN is missing"
+
+// capSysAdmin is the capability NVML requires for changing fan policy.
+const capSysAdmin = 21
+
+// HasCapSysAdmin reports whether CAP_SYS_ADMIN is in the effective set.
+func HasCapSysAdmin() bool {
+	status, err := os.ReadFile("/proc/self/status")
+	if err != nil {
+		return os.Geteuid() == 0
+	}
+	for _, line := range strings.Split(string(status), "\n") {
+		if value, ok := strings.CutPrefix(line, "CapEff:"); ok {
+			caps, err := strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+			return err == nil && caps&(1<<capSysAdmin) != 0
+		}
+	}
+	return false
+}
+
+// NodeAccessError explains why device node can't be opened for reading and
+// writing, nil when it can.
+func NodeAccessError(node string) error {
+	err := syscall.Access(node, 0x2|0x4) // R_OK|W_OK
+	if err == nil {
+		return nil
+	}
+	var st syscall.Stat_t
+	if syscall.Stat(node, &st) != nil {
+		return fmt.Errorf("%s is missing", node)
+	}
+	gid := strconv.Itoa(int(st.Gid))
+	groups, _ := os.Getgroups()
+	if st.Mode&0o060 == 0o060 && !slices.Contains(groups, int(st.Gid)) {
+		name := gid
+		if group, err := user.LookupGroupId(gid); err == nil {
+			name = group.Name
+		}
+		login := strconv.Itoa(os.Getuid())
+		if u, err := user.Current(); err == nil {
+			login = u.Username
+		}
+		return fmt.Errorf("user %s is not in group %s owning %s", login, name, node)
+	}
+	return fmt.Errorf("%s is not accessible: %v", node, err)
+}
+
+// IsPrivileged reports whether the process may change fan policy.
+func IsPrivileged() bool {
+	return os.Geteuid() == 0 || HasCapSysAdmin()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// pidFile stays open and locked while the daemon runs.
+var pidFile *os.File
+
+var errLocked = errors.New("file is locked")
+
+func pidFilePath(path string) string {
+	if path != "" {
+		return path
+	}
+	return defaultPidFile
+}
+
+// AcquirePidFile takes an exclusive lock on the pid file and writes our PID
+// into it, so only one instance controls the fans. Path "-" disables it.
+func AcquirePidFile(path string) error {
+	if path == "-" {
+		return nil
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if err := lockFile(file); err != nil {
+		file.Close()
+		if errors.Is(err, errLocked) {
+			if pid, ok := RunningDaemon(path); ok {
+				return fmt.Errorf("nvmlfan is already running with PID %d", pid)
+			}
+			return fmt.Errorf("nvmlfan is already running, %s is locked", path)
+		}
+		return err
+	}
+	if err := file.Truncate(0); err != nil {
+		file.Close()
+		return err
+	}
+	if _, err := file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+		file.Close()
+		return err
+	}
+	pidFile = file
+	return nil
+}
+
+// ReleasePidFile removes the pid file, the lock goes away with the descriptor.
+func ReleasePidFile() {
+	if pidFile == nil {
+		return
+	}
+	os.Remove(pidFile.Name())
+	pidFile.Close()
+	pidFile = nil
+}
+
+// RunningDaemon returns PID of the daemon holding the pid file lock.
+func RunningDaemon(path string) (int, bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, false
+	}
+	defer file.Close()
+	// Stale file of a crashed daemon isn't locked
+	if !isLocked(file) {
+		return 0, false
+	}
+	data := make([]byte, 32)
+	n, _ := file.Read(data)
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data[:n])))
+	if err != nil {
+		return 0, false
+	}
+	return pid, true
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IvanBayan/nvmlfan/internal/config"
+	"github.com/IvanBayan/nvmlfan/internal/gpu"
+)
+
+var (
+	powerMu sync.Mutex
+	// powerOriginals are limits of cards before nvmlfan lowered them by
+	// UUID, they are restored on exit. nil until read from the power file.
+	powerOriginals map[string]int
+)
+
+// Limits of cards before nvmlfan lowered them are kept in the power file, a
+// "UUID watts" line per card, so a process which didn't lower them, like the
+// supervisor after a crash, restores them too.
+
+// originalPowerLimit returns the limit of the card with uuid before nvmlfan
+// lowered it, false when it isn't lowered.
+func originalPowerLimit(uuid string) (int, bool) {
+	powerMu.Lock()
+	defer powerMu.Unlock()
+	original, ok := loadPowerOriginals()[uuid]
+	return original, ok
+}
+
+// setOriginalPowerLimit records the original limit of the card with uuid,
+// zero forgets it.
+func setOriginalPowerLimit(uuid string, watts int) {
+	powerMu.Lock()
+	defer powerMu.Unlock()
+	originals := loadPowerOriginals()
+	if watts > 0 {
+		originals[uuid] = watts
+	} else {
+		delete(originals, uuid)
+	}
+	if err := writePowerOriginals(defaultPowerPath, originals); err != nil {
+		controllerLog.Warn("Can't record original power limits", "error", err)
+	}
+}
+
+func loadPowerOriginals() map[string]int {
+	if powerOriginals == nil {
+		powerOriginals = readPowerOriginals(defaultPowerPath)
+	}
+	return powerOriginals
+}
+
+// readPowerOriginals returns limits listed in the file at path, none when
+// it's missing.
+func readPowerOriginals(path string) map[string]int {
+	originals := map[string]int{}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return originals
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		uuid, value, _ := strings.Cut(line, " ")
+		if watts, err := strconv.Atoi(value); err == nil && uuid != "" {
+			originals[uuid] = watts
+		}
+	}
+	return originals
+}
+
+func writePowerOriginals(path string, originals map[string]int) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	var data strings.Builder
+	for uuid, watts := range originals {
+		fmt.Fprintf(&data, "%s %d\n", uuid, watts)
+	}
+	return os.WriteFile(path, []byte(data.String()), 0o644)
+}
+
+// auditPowerLimit writes a power limit change of GPU idx to the audit log.
+func auditPowerLimit(idx int, emergency *config.EmergencyConfig, temp int, action string, from, to int) {
+	id := gpu.GetDeviceIdentity(idx)
+	path := auditPath(emergency)
+	entry := AuditEntry{Time: daemonClock.Now(), GPU: idx, UUID: id.UUID, Name: id.Name, Temp: temp, Action: action,
+		Detail: fmt.Sprintf("%d W -> %d W", from, to)}
+	if err := audit(path, entry); err != nil {
+		controllerLog.Error("Can't write audit log", "GPU", idx, "action", action, "path", path, "error", err)
+	}
+}
+
+// limitPower lowers the power limit of the card c in steps while its fans
+// run at maximum speed and it stays above the ceiling, and raises it back
+// once the card cooled down. temp was read at now.
+func (c *cardControl) limitPower(temp int, now time.Time) {
+	cfg := c.settings.PowerLimit
+	original, lowered := originalPowerLimit(c.uuid)
+	trend := c.power.step(c.escalationTrend(temp, cfg.Ceiling, cfg.Recover, lowered), now, cfg.After)
+	if trend == 0 {
+		return
+	}
+	limits, err := gpu.GetPowerLimits(c.idx)
+	if err != nil {
+		c.logger.Error("Can't manage power limit", "error", err)
+		return
+	}
+	step := cfg.Step
+	if step <= 0 {
+		step = max(limits.Default/10, 1)
+	}
+	if trend < 0 {
+		target := max(limits.Current-step, cfg.Min, limits.Min)
+		if target >= limits.Current {
+			c.logger.Warn("Cooling exhausted and power limit is at its minimum", "limit", limits.Current, "temp", temp)
+			return
+		}
+		if err := gpu.SetPowerLimit(c.idx, target); err != nil {
+			c.logger.Error("Can't lower power limit", "error", err)
+			return
+		}
+		if !lowered {
+			setOriginalPowerLimit(c.uuid, limits.Current)
+		}
+		auditPowerLimit(c.idx, c.settings.Emergency, temp, "power_limit_lower", limits.Current, target)
+		c.logger.Warn("Cooling exhausted, power limit lowered", "from", limits.Current, "to", target, "temp", temp)
+		RecordEvent(c.idx, "power_limit", fmt.Sprintf("Lowered from %d W to %d W at %d°C with fans at maximum", limits.Current, target, temp))
+		return
+	}
+	target := min(limits.Current+step, original)
+	if err := gpu.SetPowerLimit(c.idx, target); err != nil {
+		c.logger.Error("Can't raise power limit", "error", err)
+		return
+	}
+	if target >= original {
+		setOriginalPowerLimit(c.uuid, 0)
+	}
+	auditPowerLimit(c.idx, c.settings.Emergency, temp, "power_limit_raise", limits.Current, target)
+	c.logger.Info("Temperature recovered, power limit raised", "from", limits.Current, "to", target, "temp", temp)
+	RecordEvent(c.idx, "power_limit", fmt.Sprintf("Raised from %d W to %d W at %d°C", limits.Current, target, temp))
+}
+
+// RestorePowerLimit puts back the power limit of GPU idx lowered by
+// nvmlfan, by this process or one which crashed. Limits nvmlfan didn't lower
+// are left alone.
+func RestorePowerLimit(idx int) {
+	card, _ := CardConfig(conf, idx)
+	if card.PowerLimit == nil {
+		return
+	}
+	uuid := gpu.GetDeviceIdentity(idx).UUID
+	original, lowered := originalPowerLimit(uuid)
+	if !lowered {
+		return
+	}
+	limits, err := gpu.GetPowerLimits(idx)
+	if err != nil {
+		controllerLog.Error("Can't restore power limit", "GPU", idx, "error", err)
+		return
+	}
+	if limits.Current != original {
+		if err := gpu.SetPowerLimit(idx, original); err != nil {
+			controllerLog.Error("Can't restore power limit", "GPU", idx, "error", err)
+			return
+		}
+		auditPowerLimit(idx, card.Emergency, 0, "power_limit_restore", limits.Current, original)
+		controllerLog.Info("Power limit restored", "GPU", idx, "limit", original)
+		RecordEvent(idx, "power_limit", fmt.Sprintf("Restored to %d W", original))
+	}
+	setOriginalPowerLimit(uuid, 0)
+}
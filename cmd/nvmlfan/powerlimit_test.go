@@ -0,0 +1,27 @@
+package main
+
+import (
+	"maps"
+	"path/filepath"
+	"testing"
+)
+
+func TestPowerOriginalsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lib", "power")
+	if got := readPowerOriginals(path); len(got) != 0 {
+		t.Errorf("missing file read as %v", got)
+	}
+	originals := map[string]int{"GPU-1": 350, "GPU-2": 450}
+	if err := writePowerOriginals(path, originals); err != nil {
+		t.Fatal(err)
+	}
+	if got := readPowerOriginals(path); !maps.Equal(got, originals) {
+		t.Errorf("read %v, want %v", got, originals)
+	}
+	if err := writePowerOriginals(path, map[string]int{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := readPowerOriginals(path); len(got) != 0 {
+		t.Errorf("emptied file read as %v", got)
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"github.com/IvanBayan/nvmlfan/internal/config"
+	"github.com/IvanBayan/nvmlfan/pkg/controller"
+)
+
+// CardCurve returns curve of card, scaling its preset when curve isn't written
+// out. Unknown presets are rejected by configuration check, here they fall
+// back to balanced.
+func CardCurve(card config.GPUConfig, minSpeed, maxSpeed, maxTemp int) [][2]int {
+	if card.Preset == "" {
+		return card.Curve
+	}
+	curve, err := controller.ScalePreset(card.Preset, minSpeed, maxSpeed, maxTemp)
+	if err != nil {
+		controllerLog.Error("Using balanced preset", "error", err)
+		curve, _ = controller.ScalePreset("balanced", minSpeed, maxSpeed, maxTemp)
+	}
+	return curve
+}
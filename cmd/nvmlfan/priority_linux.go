@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Real-time policies of sched_setscheduler(2).
+const (
+	schedFIFO = 1
+	schedRR   = 2
+
+	defaultRealtimePriority = 10
+)
+
+// ApplyPriority sets nice value and real-time policy configured in
+// priority. Linux applies both per thread, so every thread of the daemon is
+// changed and threads started later inherit them.
+func ApplyPriority() error {
+	cfg := conf.Priority
+	if cfg == nil {
+		return nil
+	}
+	policy, priority := 0, cfg.Priority
+	switch cfg.Policy {
+	case "fifo":
+		policy = schedFIFO
+	case "rr":
+		policy = schedRR
+	}
+	if priority == 0 {
+		priority = defaultRealtimePriority
+	}
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		if cfg.Nice != 0 {
+			err := unix.Setpriority(unix.PRIO_PROCESS, tid, cfg.Nice)
+			if err != nil && !errors.Is(err, unix.ESRCH) {
+				return fmt.Errorf("can't set nice %d: %w", cfg.Nice, err)
+			}
+		}
+		if policy != 0 {
+			param := struct{ priority int32 }{int32(priority)}
+			_, _, errno := unix.Syscall(unix.SYS_SCHED_SETSCHEDULER, uintptr(tid), uintptr(policy), uintptr(unsafe.Pointer(&param)))
+			if errno != 0 && errno != unix.ESRCH {
+				return fmt.Errorf("can't set %s policy with priority %d: %w", cfg.Policy, priority, errno)
+			}
+		}
+	}
+	if policy != 0 {
+		slog.Info("Real-time scheduling enabled", "policy", cfg.Policy, "priority", priority, "nice", cfg.Nice)
+	} else {
+		slog.Info("Nice value changed", "nice", cfg.Nice)
+	}
+	return nil
+}
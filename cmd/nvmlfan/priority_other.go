@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import "fmt"
+
+func ApplyPriority() error {
+	if conf.Priority == nil {
+		return nil
+	}
+	return fmt.Errorf("priority is supported on Linux only")
+}
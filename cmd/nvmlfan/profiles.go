@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/IvanBayan/nvmlfan/internal/config"
+	"github.com/IvanBayan/nvmlfan/internal/gpu"
+)
+
+const (
+	// scheduleInterval is how often the schedule is checked for a profile
+	// to activate.
+	scheduleInterval  = 30 * time.Second
+	defaultTransition = time.Minute
+	// defaultUtilizationWindow is the time constant of average utilization
+	// choosing utilization bands.
+	defaultUtilizationWindow = time.Minute
+)
+
+var (
+	profileMu     sync.Mutex
+	activeProfile string
+	// profileChanges counts switches of the active profile, controllers
+	// which saw fewer reconfigure their cards.
+	profileChanges atomic.Int64
+)
+
+// ActiveProfile returns the name of the active profile, empty when cards use
+// their own settings.
+func ActiveProfile() string {
+	profileMu.Lock()
+	defer profileMu.Unlock()
+	return activeProfile
+}
+
+// UpdateProfile activates the profile scheduled at t.
+func UpdateProfile(t time.Time) {
+	name := conf.Schedule.Profile(t)
+	profileMu.Lock()
+	defer profileMu.Unlock()
+	if name == activeProfile {
+		return
+	}
+	if name == "" {
+		controllerLog.Info("Profile ended, cards use their own settings", "profile", activeProfile)
+	} else {
+		controllerLog.Info("Profile activated", "profile", name)
+	}
+	activeProfile = name
+	profileChanges.Add(1)
+}
+
+// WatchSchedule switches profiles as scheduled until ctx is canceled.
+func WatchSchedule(ctx context.Context) {
+	if conf.Schedule == nil || len(conf.Schedule.Rules) == 0 {
+		return
+	}
+	go func() {
+		defer RestoreOnPanic()
+		ticker := daemonClock.NewTicker(scheduleInterval)
+		defer ticker.Stop()
+		for sleepCycle(ctx, ticker) {
+			UpdateProfile(daemonClock.Now())
+		}
+	}()
+}
+
+func profileTransition() time.Duration {
+	if conf.Schedule != nil && conf.Schedule.Transition > 0 {
+		return conf.Schedule.Transition
+	}
+	return defaultTransition
+}
+
+// switchProfile reconfigures the card c after the active profile changed.
+func (c *cardControl) switchProfile(changes int64) {
+	c.profiles = changes
+	if c.reconfigure() {
+		c.recordProfiles()
+	}
+}
+
+// recordProfiles records profiles the card c switched to as an event.
+func (c *cardControl) recordProfiles() {
+	profiles := cardProfiles(c.idx)
+	if len(profiles) == 0 {
+		RecordEvent(c.idx, "profile", "Own settings restored")
+	} else {
+		RecordEvent(c.idx, "profile", "Switched to profile "+strings.Join(profiles, " + "))
+	}
+}
+
+// switchPState reconfigures the card c when it entered another performance
+// state with settings of its own.
+func (c *cardControl) switchPState(pstate int) {
+	c.pstate = pstate
+	if c.reconfigure() {
+		RecordEvent(c.idx, "pstate", fmt.Sprintf("Settings of P%d applied", pstate))
+	}
+}
+
+// trackUtilization averages utilization of the card c in snapshot and
+// switches to settings of the band it falls into.
+func (c *cardControl) trackUtilization(snapshot gpu.Snapshot) {
+	if snapshot.Utilization < 0 {
+		return
+	}
+	window := c.settings.Utilization.Window
+	if window <= 0 {
+		window = defaultUtilizationWindow
+	}
+	if c.utilizationAt.IsZero() {
+		c.utilization = float64(snapshot.Utilization)
+	} else {
+		dt := snapshot.Time.Sub(c.utilizationAt)
+		c.utilization += (float64(snapshot.Utilization) - c.utilization) * (1 - math.Exp(-float64(dt)/float64(window)))
+	}
+	c.utilizationAt = snapshot.Time
+	band := -1
+	for i, b := range c.settings.Utilization.Bands {
+		if b.Below == 0 || c.utilization < float64(b.Below) {
+			band = i
+			break
+		}
+	}
+	if band == c.band {
+		return
+	}
+	c.band = band
+	if c.reconfigure() {
+		RecordEvent(c.idx, "utilization", fmt.Sprintf("Settings for %.0f%% average utilization applied", c.utilization))
+	}
+}
+
+// reconfigure applies current settings of the card c, from its profiles,
+// utilization band and performance state, and tells whether they changed. Its fans move from the
+// last set speed into the new settings over the transition.
+func (c *cardControl) reconfigure() bool {
+	card, _ := CardConfig(conf, c.idx)
+	card = card.Merge(bandSettings(card, c.band)).Merge(pstateSettings(card, c.pstate))
+	if reflect.DeepEqual(card, c.settings) {
+		return false
+	}
+	if err := c.configure(card); err != nil {
+		c.logger.Error("Can't change settings", "error", err)
+		return false
+	}
+	c.blendFrom, c.blendStart = c.output, daemonClock.Now()
+	return true
+}
+
+// bandSettings returns settings of card replaced in utilization band.
+func bandSettings(card config.GPUConfig, band int) config.GPUConfig {
+	if card.Utilization == nil || band < 0 || band >= len(card.Utilization.Bands) {
+		return config.GPUConfig{}
+	}
+	return card.Utilization.Bands[band].GPUConfig
+}
+
+// pstateSettings returns settings of card replaced in performance state
+// pstate.
+func pstateSettings(card config.GPUConfig, pstate int) config.GPUConfig {
+	for key, override := range card.PStates {
+		if n, err := config.ParsePState(key); err == nil && n == pstate {
+			return override
+		}
+	}
+	return config.GPUConfig{}
+}
+
+// cardProfiles returns profiles applied to GPU idx in order, the scheduled
+// one, the one by its activity and the one of a matching rule.
+func cardProfiles(idx int) []string {
+	var profiles []string
+	for _, profile := range []string{ActiveProfile(), ActivityProfile(idx), RuleProfile(idx)} {
+		if profile != "" {
+			profiles = append(profiles, profile)
+		}
+	}
+	return profiles
+}
+
+// blend returns speed chosen by the new settings moved toward the speed set
+// when the profile changed, fully used once the transition is over.
+func (c *cardControl) blend(speed int) int {
+	if c.blendStart.IsZero() {
+		return speed
+	}
+	elapsed, transition := daemonClock.Now().Sub(c.blendStart), profileTransition()
+	if elapsed >= transition || c.blendFrom == 0 {
+		c.blendStart = time.Time{}
+		return speed
+	}
+	blended := c.blendFrom + int(math.Round(float64(speed-c.blendFrom)*float64(elapsed)/float64(transition)))
+	AddFilter(c.idx, fmt.Sprintf("blended from %d%% after profile change", c.blendFrom), blended)
+	return blended
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/IvanBayan/nvmlfan/internal/gpu"
+	"github.com/IvanBayan/nvmlfan/internal/rules"
+)
+
+// ruleState is a rule evaluated for a card.
+type ruleState struct {
+	holds  bool      // Condition is true.
+	since  time.Time // Condition is what holds says since.
+	active bool      // Condition held for the rule's duration.
+}
+
+var (
+	rulesMu sync.Mutex
+	// ruleProfiles are profiles activated by rules by UUID of the card.
+	ruleProfiles = map[string]string{}
+)
+
+// cardRules returns configured rules, those which don't parse are logged
+// and left out.
+var cardRules = sync.OnceValue(func() []*rules.Rule {
+	var parsed []*rules.Rule
+	for _, source := range conf.Rules {
+		rule, err := rules.Parse(source)
+		if err != nil {
+			controllerLog.Error("Invalid rule is ignored", "rule", source, "error", err)
+			continue
+		}
+		parsed = append(parsed, rule)
+	}
+	return parsed
+})
+
+// RuleProfile returns the profile activated by rules for GPU idx, empty
+// when none is active.
+func RuleProfile(idx int) string {
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+	return ruleProfiles[gpu.GetDeviceIdentity(idx).UUID]
+}
+
+// evaluateRules checks rules against snapshot of the card c. A rule
+// becomes active once its condition held for its duration and inactive
+// once it didn't as long, the first active rule chooses the profile.
+func (c *cardControl) evaluateRules(snapshot gpu.Snapshot) {
+	list := cardRules()
+	if len(c.rules) != len(list) {
+		c.rules = make([]ruleState, len(list))
+	}
+	state := rules.State{Temp: float64(snapshot.Temp), Power: snapshot.Power, Utilization: float64(snapshot.Utilization),
+		Fan: float64(snapshot.Speed), PState: float64(snapshot.PState), Time: snapshot.Time, Processes: ProcessCommands(c.idx)}
+	profile := ""
+	for i, rule := range list {
+		st := &c.rules[i]
+		if holds := rule.Holds(state); holds != st.holds || st.since.IsZero() {
+			st.holds, st.since = holds, snapshot.Time
+		}
+		if st.holds != st.active && snapshot.Time.Sub(st.since) >= rule.For {
+			st.active = st.holds
+			if st.active {
+				c.logger.Info("Rule matches", "rule", rule.Source)
+				RecordEvent(c.idx, "rule", "Matches: "+rule.Source)
+			} else {
+				c.logger.Info("Rule no longer matches", "rule", rule.Source)
+				RecordEvent(c.idx, "rule", "No longer matches: "+rule.Source)
+			}
+		}
+		if st.active && profile == "" {
+			profile = rule.Profile
+		}
+	}
+	rulesMu.Lock()
+	changed := ruleProfiles[c.uuid] != profile
+	ruleProfiles[c.uuid] = profile
+	rulesMu.Unlock()
+	if changed && c.reconfigure() {
+		c.recordProfiles()
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// sandboxEnv marks the process re-executed inside the sandbox.
+const sandboxEnv = "NVMLFAN_SANDBOXED"
+
+const (
+	seccompSetModeFilter   = 1
+	seccompFilterFlagTsync = 1
+	seccompRetAllow        = 0x7fff0000
+	seccompRetErrno        = 0x00050000
+	// x32 syscalls on amd64 have this bit set, Go never uses them
+	x32SyscallBit = 0x40000000
+
+	landlockCreateRulesetVersion = 1
+)
+
+// deniedSyscalls are never needed by the daemon but are the usual tools of
+// an attacker with root, they fail with EPERM. An allow list would be
+// stricter, but NVML issues driver specific calls which can't be listed.
+var deniedSyscalls = []uint32{
+	unix.SYS_PTRACE, unix.SYS_PROCESS_VM_READV, unix.SYS_PROCESS_VM_WRITEV,
+	unix.SYS_MOUNT, unix.SYS_UMOUNT2, unix.SYS_PIVOT_ROOT, unix.SYS_CHROOT,
+	unix.SYS_SETNS, unix.SYS_UNSHARE,
+	unix.SYS_INIT_MODULE, unix.SYS_FINIT_MODULE, unix.SYS_DELETE_MODULE,
+	unix.SYS_KEXEC_LOAD, unix.SYS_REBOOT, unix.SYS_SWAPON, unix.SYS_SWAPOFF,
+	unix.SYS_BPF, unix.SYS_PERF_EVENT_OPEN, unix.SYS_USERFAULTFD,
+	unix.SYS_KEYCTL, unix.SYS_ADD_KEY, unix.SYS_REQUEST_KEY,
+	unix.SYS_OPEN_BY_HANDLE_AT, unix.SYS_ACCT,
+	unix.SYS_SETTIMEOFDAY, unix.SYS_CLOCK_SETTIME, unix.SYS_SETHOSTNAME, unix.SYS_SETDOMAINNAME,
+}
+
+// Sandboxed reports whether this process runs inside the sandbox.
+func Sandboxed() bool {
+	return os.Getenv(sandboxEnv) != ""
+}
+
+func auditArch() (uint32, bool) {
+	switch runtime.GOARCH {
+	case "amd64":
+		return unix.AUDIT_ARCH_X86_64, true
+	case "arm64":
+		return unix.AUDIT_ARCH_AARCH64, true
+	}
+	return 0, false
+}
+
+func applySeccomp() error {
+	arch, ok := auditArch()
+	if !ok {
+		return fmt.Errorf("seccomp filter isn't defined for %s", runtime.GOARCH)
+	}
+	deny := uint32(seccompRetErrno | uint32(syscall.EPERM))
+	// seccomp_data: nr at offset 0, arch at offset 4
+	filter := []unix.SockFilter{
+		{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: 4},
+		{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: 1, K: arch},
+		{Code: unix.BPF_RET | unix.BPF_K, K: deny},
+		{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: 0},
+		{Code: unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K, Jf: 1, K: x32SyscallBit},
+		{Code: unix.BPF_RET | unix.BPF_K, K: deny},
+	}
+	for i, nr := range deniedSyscalls {
+		// Jump to the deny return after the list
+		filter = append(filter, unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K,
+			Jt: uint8(len(deniedSyscalls) - i), K: nr})
+	}
+	filter = append(filter,
+		unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: seccompRetAllow},
+		unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: deny})
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	_, _, errno := unix.Syscall(unix.SYS_SECCOMP, seccompSetModeFilter, seccompFilterFlagTsync, uintptr(unsafe.Pointer(&prog)))
+	if errno != 0 {
+		return fmt.Errorf("seccomp: %w", errno)
+	}
+	return nil
+}
+
+const (
+	landlockRead = unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_READ_DIR
+	landlockExec = landlockRead | unix.LANDLOCK_ACCESS_FS_EXECUTE
+	// Rights on the files in state directories: logs, telemetry, database, socket and pid file.
+	landlockState = landlockRead | unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+		unix.LANDLOCK_ACCESS_FS_REMOVE_FILE | unix.LANDLOCK_ACCESS_FS_MAKE_REG |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SOCK
+	// Rights handled by Landlock ABI 1, everything not granted by rules is denied.
+	landlockHandled = landlockExec | unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+		unix.LANDLOCK_ACCESS_FS_REMOVE_DIR | unix.LANDLOCK_ACCESS_FS_REMOVE_FILE |
+		unix.LANDLOCK_ACCESS_FS_MAKE_CHAR | unix.LANDLOCK_ACCESS_FS_MAKE_DIR |
+		unix.LANDLOCK_ACCESS_FS_MAKE_REG | unix.LANDLOCK_ACCESS_FS_MAKE_SOCK |
+		unix.LANDLOCK_ACCESS_FS_MAKE_FIFO | unix.LANDLOCK_ACCESS_FS_MAKE_BLOCK |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SYM
+	// Only these rights apply to files rather than directories.
+	landlockFileRights = unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_FILE
+)
+
+// sandboxPaths returns paths the daemon needs with their Landlock rights.
+func sandboxPaths(configPath string) map[string]uint64 {
+	paths := map[string]uint64{
+		// Libraries, NVML is loaded after the sandbox is entered, and hook programs
+		"/usr": landlockExec, "/lib": landlockExec, "/lib64": landlockExec, "/lib32": landlockExec,
+		"/bin": landlockExec, "/sbin": landlockExec, "/opt": landlockExec,
+		"/etc": landlockRead, "/proc": landlockRead, "/sys": landlockRead,
+		"/dev/null":    landlockRead | unix.LANDLOCK_ACCESS_FS_WRITE_FILE,
+		"/dev/urandom": landlockRead,
+	}
+	nodes, _ := filepath.Glob("/dev/nvidia*")
+	for _, node := range nodes {
+		paths[node] = landlockRead | unix.LANDLOCK_ACCESS_FS_WRITE_FILE
+	}
+	for _, output := range conf.Hwmon {
+		paths[output.PWM] = landlockRead | unix.LANDLOCK_ACCESS_FS_WRITE_FILE
+		paths[output.PWM+"_enable"] = landlockRead | unix.LANDLOCK_ACCESS_FS_WRITE_FILE
+	}
+	if conf.NvidiaSettings != nil && conf.NvidiaSettings.Xauthority != "" {
+		paths[conf.NvidiaSettings.Xauthority] = landlockRead
+	}
+	// Session buses of logged in users are looked up there
+	if conf.Notify != nil && conf.Notify.Desktop != nil {
+		paths["/run/user"] = landlockRead
+	}
+	if exe, err := os.Executable(); err == nil {
+		paths[exe] = landlockExec
+	}
+	if abs, err := filepath.Abs(configPath); err == nil {
+		paths[abs] = landlockRead
+	}
+	state := []string{apiSocket(conf.API), pidFilePath(conf.PidFile), defaultHeldPath, defaultDrainedPath, defaultPowerPath}
+	// Hook scripts may live outside of system directories
+	var commands [][]string
+	if conf.Hooks != nil {
+		commands = append(commands, conf.Hooks.PreTakeover, conf.Hooks.PostRestore)
+	}
+	for _, card := range conf.Cards {
+		if card.Emergency != nil || card.PowerLimit != nil {
+			state = append(state, auditPath(card.Emergency))
+		}
+		if card.Emergency != nil {
+			commands = append(commands, card.Emergency.Exec)
+		}
+		if card.Hooks != nil {
+			commands = append(commands, card.Hooks.PreTakeover, card.Hooks.PostRestore)
+		}
+		for _, h := range thresholdHooks(card) {
+			commands = append(commands, h.Exec)
+		}
+	}
+	for _, command := range commands {
+		if len(command) > 0 && filepath.IsAbs(command[0]) {
+			paths[command[0]] |= landlockExec
+		}
+	}
+	for _, output := range conf.Logging {
+		if output["path"] != "" {
+			state = append(state, output["path"])
+		}
+	}
+	if conf.Telemetry != nil {
+		state = append(state, conf.Telemetry.Path)
+	}
+	if conf.Stats != nil {
+		state = append(state, conf.Stats.Path)
+	}
+	for _, path := range state {
+		if path == "" {
+			continue
+		}
+		if abs, err := filepath.Abs(path); err == nil {
+			paths[filepath.Dir(abs)] |= landlockState
+		}
+	}
+	return paths
+}
+
+func applyLandlock(configPath string) error {
+	abi, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, landlockCreateRulesetVersion)
+	if errno != 0 {
+		return fmt.Errorf("landlock isn't supported by kernel: %w", errno)
+	}
+	attr := unix.LandlockRulesetAttr{Access_fs: landlockHandled}
+	fd, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return fmt.Errorf("landlock ABI %d: %w", abi, errno)
+	}
+	defer unix.Close(int(fd))
+	for path, access := range sandboxPaths(configPath) {
+		pathFd, err := unix.Open(path, unix.O_PATH|unix.O_CLOEXEC, 0)
+		if err != nil {
+			// Optional directories like /lib32 may be missing
+			continue
+		}
+		var st unix.Stat_t
+		if err := unix.Fstat(pathFd, &st); err == nil && st.Mode&unix.S_IFMT != unix.S_IFDIR {
+			access &= landlockFileRights
+		}
+		rule := unix.LandlockPathBeneathAttr{Allowed_access: access, Parent_fd: int32(pathFd)}
+		_, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, fd, unix.LANDLOCK_RULE_PATH_BENEATH, uintptr(unsafe.Pointer(&rule)), 0, 0, 0)
+		unix.Close(pathFd)
+		if errno != 0 {
+			return fmt.Errorf("landlock rule for %s: %w", path, errno)
+		}
+	}
+	if _, _, errno := unix.Syscall(unix.SYS_LANDLOCK_RESTRICT_SELF, fd, 0, 0); errno != 0 {
+		return fmt.Errorf("landlock: %w", errno)
+	}
+	return nil
+}
+
+// EnterSandbox restricts the process with Landlock and seccomp and
+// re-executes it, it returns only on failure. Landlock applies to the calling
+// thread only and Go can't restrict all its threads, but restrictions
+// survive exec, so the new process starts with all threads restricted.
+// Kernels without Landlock get only the seccomp filter.
+func EnterSandbox(configPath string) error {
+	// Without /proc executable can't be resolved, but it's what we were started as
+	exe, err := os.Executable()
+	if err != nil {
+		exe = os.Args[0]
+	}
+	runtime.LockOSThread()
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("no_new_privs: %w", err)
+	}
+	if err := applyLandlock(configPath); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v, only seccomp is applied\n", err)
+	}
+	if err := applySeccomp(); err != nil {
+		return err
+	}
+	return syscall.Exec(exe, os.Args, append(os.Environ(), sandboxEnv+"=1"))
+}
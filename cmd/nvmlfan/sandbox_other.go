@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import "fmt"
+
+func Sandboxed() bool {
+	return false
+}
+
+func EnterSandbox(configPath string) error {
+	return fmt.Errorf("sandbox is supported on Linux only")
+}
@@ -0,0 +1,689 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/IvanBayan/nvmlfan/internal/config"
+	"github.com/IvanBayan/nvmlfan/internal/gpu"
+	"github.com/IvanBayan/nvmlfan/pkg/controller"
+)
+
+// Control of all GPUs and hwmon outputs runs from a single loop: every
+// period the scheduler takes a snapshot of each GPU, runs its
+// controller and applies the speed, then runs hwmon outputs with the
+// readings of the period. Shutdown has only one loop to stop.
+
+// reading is the snapshot of a GPU taken at the start of a cycle.
+type reading struct {
+	gpu.Snapshot
+	err error
+}
+
+// cardControl is the controller of one GPU in curve, target or monitor mode.
+type cardControl struct {
+	idx      int
+	uuid     string // Follows the card when its index changes.
+	mode     string
+	logger   *slog.Logger
+	minSpeed int
+	maxSpeed int
+	curve    [][2]int
+	pid      controller.PID
+	maxTemp  int
+	cycle    int
+	last     time.Time // Time of the last PID update.
+
+	// Adaptive polling
+	interval  time.Duration
+	next      time.Time // Cycles before it are skipped.
+	prevTemp  int       // Temperature of the last change.
+	prevAt    time.Time
+	idle      bool
+	idleSince time.Time
+	released  bool // Fans are left to the driver while idle.
+	restarts  int
+	failed    bool // Controller couldn't be restarted, fans run at failsafe speed.
+	failures  int  // Consecutive failed readings.
+	failsafe  bool // Readings can't be trusted, fans run at failsafe speed.
+	retries   int  // Consecutive cycles which failed to set fans.
+	manual    bool // Fans were switched to manual policy.
+
+	// Profile switching
+	settings   config.GPUConfig // Control settings in use.
+	profiles   int64            // Profile changes the controller followed.
+	pstate     int              // Performance state the settings were chosen for.
+	band       int              // Utilization band the settings were chosen for.
+	output     int              // Last set speed.
+	blendFrom  int              // Speed set when the profile changed at blendStart.
+	blendStart time.Time
+	// Average utilization at utilizationAt
+	utilization   float64
+	utilizationAt time.Time
+	rules         []ruleState
+	thresholds    []ruleState // Of threshold hooks in order.
+	overheat      ruleState
+
+	// Escalation when fans at maximum can't hold the temperature
+	power  escalation
+	clocks escalation
+	// Last emergency action, the next waits for the cool-down.
+	emergencyAt time.Time
+	// Fan exercise in progress and the request the card was last exercised for
+	exercise     *fanExercise
+	exercisedFor time.Time
+	following    bool // Fans follow another card of the group.
+}
+
+// newCardControl prepares control of GPU idx in mode, reading its fan range
+// and temperature threshold.
+func newCardControl(idx int, mode string) (*cardControl, error) {
+	c := &cardControl{idx: idx, uuid: gpu.GetDeviceIdentity(idx).UUID, mode: mode, logger: controllerLog.With("GPU", idx),
+		profiles: profileChanges.Load(), pstate: -1, band: -1}
+	Heartbeat(idx)
+	BindCard(idx)
+	SetHealth(idx, HealthInitializing, "")
+	if mode == "monitor" {
+		c.logger.Info("Monitoring only")
+		device, err := gpu.DeviceGetHandleByIndex(idx)
+		if err != nil {
+			return nil, err
+		}
+		_, c.maxSpeed = gpu.GetMinMaxFanSpeed(device)
+		c.maxTemp = gpu.GetMaxGPUTempThreshold(device)
+		// Only for threshold hooks, nothing is controlled
+		c.settings, _ = CardConfig(conf, idx)
+		return c, nil
+	}
+	minSpeed, maxSpeed, maxTemp, err := gpu.GetThermalInfo(idx)
+	if err != nil {
+		return nil, err
+	}
+	c.minSpeed, c.maxSpeed, c.maxTemp = minSpeed, maxSpeed, maxTemp
+	card, _ := CardConfig(conf, idx)
+	card.Mode = mode
+	if err := c.configure(card); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// configure applies control settings of card to c.
+func (c *cardControl) configure(card config.GPUConfig) error {
+	switch card.Mode {
+	case "curve":
+		c.logger.Info("Curve control")
+		c.curve = controller.ClampCurve(CardCurve(card, c.minSpeed, c.maxSpeed, c.maxTemp), c.minSpeed, c.maxSpeed, c.maxTemp, c.logger)
+		SetEffectiveCurve(c.idx, c.curve)
+	case "target":
+		c.logger.Info("Target control")
+		c.curve, c.last = nil, time.Time{}
+		c.pid = controller.PID{Target: card.Target, Kp: card.PID[0], Ki: card.PID[1], Kd: card.PID[2],
+			Min: c.minSpeed, Max: c.maxSpeed}
+	default:
+		return fmt.Errorf("unknown mode '%s'", card.Mode)
+	}
+	c.mode, c.settings = card.Mode, card
+	return nil
+}
+
+// decide returns the fan speed for temp and records how it was chosen.
+func (c *cardControl) decide(temp int) int {
+	if c.mode == "curve" {
+		speed := controller.ComputeFanSpeed(temp, c.curve, c.minSpeed, c.maxSpeed)
+		RecordDecision(Decision{GPU: c.idx, Mode: "curve", Temp: temp, Segment: controller.CurveSegment(temp, c.curve), Raw: speed, Output: speed})
+		c.logger.Debug("Setting new speed", "speed", speed, "temp", temp)
+		return speed
+	}
+
+	// Cycles stretched by a missed tick count for more than one period
+	now, dt := daemonClock.Now(), 1.0
+	if !c.last.IsZero() {
+		dt = float64(now.Sub(c.last)) / float64(time.Duration(conf.Period)*time.Second)
+	}
+	c.last = now
+	output, terms := c.pid.UpdateDt(temp, dt)
+	if terms.Antiwindup {
+		c.logger.Debug("PID antiwindup triggered", "iacc", terms.I)
+	}
+	decision := Decision{GPU: c.idx, Mode: "target", Temp: temp, Raw: output, PID: &terms}
+	if output < c.minSpeed {
+		c.logger.Debug("PID clamping output to min", "output", output, "min", c.minSpeed)
+		output = c.minSpeed
+		decision.Clamps = append(decision.Clamps, fmt.Sprintf("raised to minimum fan speed %d%%", c.minSpeed))
+	} else if output > c.maxSpeed {
+		c.logger.Debug("PID clamping output to max", "output", output, "max", c.maxSpeed)
+		output = c.maxSpeed
+		decision.Clamps = append(decision.Clamps, fmt.Sprintf("lowered to maximum fan speed %d%%", c.maxSpeed))
+	}
+	decision.Output = output
+	RecordDecision(decision)
+	c.logger.Debug("PID state", "kp", terms.Kp, "ki", terms.Ki, "kd", terms.Kd,
+		"pTerm", terms.P, "iacc", terms.I, "dTerm", terms.D,
+		"input", temp, "output", output, "pid_error", terms.Error)
+	return output
+}
+
+// floor raises speed to the busy floor of the card while compute processes
+// run on it.
+func (c *cardControl) floor(speed int) int {
+	floor := min(c.settings.BusyFloor, c.maxSpeed)
+	if speed >= floor || !ComputeRunning(c.idx) {
+		return speed
+	}
+	AddFilter(c.idx, fmt.Sprintf("raised to %d%% while compute processes run", floor), floor)
+	return floor
+}
+
+// step runs one cycle of the GPU started at start. A failed reading skips
+// the cycle, fans keep the last speed and PID state waits for the next one.
+// A hung GPU doesn't wait for the error budget, failsafe speed is tried
+// every cycle until the GPU takes calls again.
+func (c *cardControl) step(r reading, start time.Time) error {
+	c.cycle++
+	if r.err != nil {
+		gpu.Log.Error("Skipping cycle", "GPU", c.idx, "error", r.err)
+		c.failures++
+		if errors.Is(r.err, gpu.ErrHung) {
+			c.failures = max(c.failures, errorBudget())
+		}
+		Heartbeat(c.idx)
+		c.escalate(r.err)
+		switch {
+		case errors.Is(r.err, gpu.ErrLost):
+			SetHealth(c.idx, HealthLost, r.err.Error())
+		case c.failsafe:
+			SetHealth(c.idx, HealthFailsafe, fmt.Sprintf("%d readings failed", c.failures))
+		default:
+			SetHealth(c.idx, HealthDegraded, r.err.Error())
+		}
+		return nil
+	}
+	if c.failures > 0 {
+		c.recover()
+	}
+	var speed int
+	if c.mode == "monitor" || c.released {
+		// Speed chosen by the driver is reported as controller output
+		speed = r.Speed
+	} else {
+		if !c.manual {
+			if err := gpu.SetManualPolicy(c.idx); err != nil {
+				return err
+			}
+			c.manual = true
+		}
+		speed = c.exerciseSpeed(c.floor(c.follow(c.blend(c.decide(r.Temp)), r.Temp, r.Time)))
+		if err := gpu.SetFanSpeed(c.idx, speed); err != nil {
+			return fmt.Errorf("GPU %d: %w", c.idx, err)
+		}
+		c.output = speed
+		if c.cycle%reassertEvery() == 0 {
+			if err := c.reassert(); err != nil {
+				return fmt.Errorf("GPU %d: %w", c.idx, err)
+			}
+		}
+	}
+	switch {
+	case c.mode == "monitor":
+		SetHealth(c.idx, HealthReleased, "monitor mode")
+	case c.released:
+		SetHealth(c.idx, HealthReleased, "idle")
+	default:
+		SetHealth(c.idx, HealthControlling, "")
+	}
+	RecordTelemetry(r.Snapshot, speed, c.maxSpeed)
+	LogStatus(r.Snapshot, c.cycle, speed, c.mode)
+	ObserveCycle(c.idx, time.Since(start))
+	Heartbeat(c.idx)
+	return nil
+}
+
+// defaultErrorBudget is how many readings in a row may fail by default
+// before fans get failsafe speed.
+const defaultErrorBudget = 5
+
+func errorBudget() int {
+	if conf.ErrorBudget > 0 {
+		return conf.ErrorBudget
+	}
+	return defaultErrorBudget
+}
+
+// escalate sets failsafe speed once the error budget of card c is spent
+// by readings failing in a row, the last one with err. Setting it is
+// retried every cycle until it succeeds.
+func (c *cardControl) escalate(err error) {
+	if c.failsafe || c.failures < errorBudget() || c.mode == "monitor" || c.released {
+		return
+	}
+	speed := failsafeSpeed(c.idx)
+	if err := gpu.SetFanSpeed(c.idx, speed); err != nil {
+		c.logger.Error("Can't set failsafe speed", "error", err)
+		return
+	}
+	c.failsafe = true
+	c.leaveGroup()
+	failsafes.Add(1)
+	c.logger.Error("Readings keep failing, fans set to failsafe speed", "failures", c.failures, "speed", speed, "error", err)
+	RecordEvent(c.idx, "failsafe", fmt.Sprintf("%d readings failed (%v), fans set to %d%%", c.failures, err, speed))
+}
+
+// recover resumes control of card c after failed readings. PID state isn't
+// updated over the gap, the next update counts as a single period.
+func (c *cardControl) recover() {
+	if c.failsafe {
+		c.logger.Warn("Readings recovered, resuming control", "failures", c.failures)
+		ResolveEvent(c.idx, "failsafe", fmt.Sprintf("Readings recovered after %d failures, control resumed", c.failures))
+		c.failsafe, c.last = false, time.Time{}
+	}
+	c.failures = 0
+}
+
+// defaultReassertEvery is how often fans are checked by default, in cycles.
+const defaultReassertEvery = 60
+
+func reassertEvery() int {
+	if conf.ReassertEvery > 0 {
+		return conf.ReassertEvery
+	}
+	return defaultReassertEvery
+}
+
+// reassert puts back fans whose mode or speed was changed by something
+// else, which would silently take control away otherwise.
+func (c *cardControl) reassert() error {
+	fans, err := gpu.VerifyFans(c.idx)
+	if len(fans) > 0 {
+		reasserts.Add(uint64(len(fans)))
+		RecordEvent(c.idx, "reassert", fmt.Sprintf("Fans %v lost commanded state, control reasserted", fans))
+	}
+	return err
+}
+
+// Defaults of adaptive and idle polling.
+const (
+	defaultAdaptiveMin     = 500 * time.Millisecond
+	defaultAdaptiveMax     = 5 * time.Second
+	defaultAdaptiveRate    = 0.5
+	defaultAdaptiveMargin  = 5
+	defaultIdleInterval    = 30 * time.Second
+	defaultIdleUtilization = 5
+	defaultIdleTemp        = 50
+)
+
+// scheduler drives controlled GPUs and hwmon outputs from one loop. Its
+// round, the period or the minimum interval of adaptive polling, is split
+// into phases, one per card and one for all hwmon outputs, so GPUs are
+// polled in turn rather than all at once. Cards and outputs which aren't due
+// yet skip their phase.
+type scheduler struct {
+	cards       []*cardControl
+	outputs     []*hwmonControl
+	readings    map[int]reading // Latest snapshot of every followed GPU.
+	phase       int
+	round       time.Duration
+	adaptive    *config.AdaptiveConfig // Nil polls every period.
+	idle        *config.IdleConfig     // Nil polls idle cards as usual.
+	outputsNext time.Time
+	done        chan struct{} // Closed when the loop exits.
+
+	// Watchdog state
+	cancel     context.CancelFunc
+	phaseStart atomic.Int64 // Unix nanoseconds, zero between phases.
+	current    atomic.Int64 // Card of the running phase, -1 for hwmon outputs.
+	restarts   int          // Restarts of stalled loops not blamed on a card.
+}
+
+// phases returns the number of phases of a period.
+func (s *scheduler) phases() int {
+	if len(s.outputs) > 0 {
+		return len(s.cards) + 1
+	}
+	return len(s.cards)
+}
+
+// read takes a snapshot of GPU idx and shares it with the API and metrics.
+func (s *scheduler) read(idx int) reading {
+	snapshot, err := gpu.TakeSnapshot(idx, daemonClock.Now())
+	r := reading{snapshot, err}
+	s.readings[idx] = r
+	if err == nil {
+		RecordSnapshot(snapshot)
+	}
+	return r
+}
+
+// runPhase runs the controllers of the current phase: a card, or hwmon
+// outputs. Outputs reuse the latest readings of GPUs controlled by cards,
+// other GPUs are read for them.
+func (s *scheduler) runPhase() error {
+	defer func() {
+		s.phase = (s.phase + 1) % s.phases()
+	}()
+	start, now := time.Now(), daemonClock.Now()
+	s.phaseStart.Store(start.UnixNano())
+	defer s.phaseStart.Store(0)
+	if s.phase < len(s.cards) {
+		s.current.Store(int64(s.phase))
+		return s.runCard(s.phase, start, now)
+	}
+	s.current.Store(-1)
+	if now.Before(s.outputsNext) {
+		return nil
+	}
+	// Phases arrive a bit early or late, don't let outputs skip a whole round
+	s.outputsNext = now.Add(time.Duration(conf.Period)*time.Second - s.round/2)
+	// Cards read their GPUs during the period, or less often while stable
+	fresh := map[int]bool{}
+	for _, c := range s.cards {
+		fresh[c.idx] = true
+	}
+	for _, o := range s.outputs {
+		for _, idx := range o.gpus {
+			if _, ok := s.readings[idx]; !ok || !fresh[idx] {
+				s.read(idx)
+				fresh[idx] = true
+			}
+		}
+	}
+	for _, o := range s.outputs {
+		o.step(s.readings, now)
+	}
+	return nil
+}
+
+// runCard runs the phase of card i. A panicking controller is restarted,
+// see restartCard.
+func (s *scheduler) runCard(i int, start, now time.Time) (err error) {
+	c := s.cards[i]
+	defer func() {
+		if r := recover(); r != nil {
+			c.logger.Error("Controller panicked", "panic", r, "stack", string(debug.Stack()))
+			var card *cardControl
+			if card, err = restartCard(c, fmt.Sprintf("panicked: %v", r)); err == nil {
+				s.cards[i] = card
+			}
+		}
+	}()
+	if c.failed || now.Before(c.next) {
+		Heartbeat(c.idx)
+		return nil
+	}
+	if changes := profileChanges.Load(); c.profiles != changes && c.mode != "monitor" {
+		c.switchProfile(changes)
+	}
+	r := s.read(c.idx)
+	if errors.Is(r.err, gpu.ErrLost) {
+		RequestRescan()
+	}
+	if r.err == nil && len(conf.Rules) > 0 && c.mode != "monitor" {
+		c.evaluateRules(r.Snapshot)
+	}
+	if r.err == nil {
+		c.checkThresholds(r.Snapshot)
+	}
+	if r.err == nil && conf.Notify != nil {
+		c.checkOverheat(r.Snapshot)
+	}
+	if r.err == nil && c.settings.Utilization != nil && c.mode != "monitor" {
+		c.trackUtilization(r.Snapshot)
+	}
+	if r.err == nil && r.PState != c.pstate && len(c.settings.PStates) > 0 && c.mode != "monitor" {
+		c.switchPState(r.PState)
+	}
+	if r.err == nil && conf.Exercise != nil && c.mode != "monitor" {
+		c.checkExercise(r.Snapshot)
+	}
+	// Idle cards aren't released while their fans are exercised
+	if r.err == nil && c.exercise == nil && s.checkIdle(c, r.Snapshot) {
+		if err := s.release(c, now); err != nil {
+			s.backOff(c, err, now)
+			return nil
+		}
+		if err := c.step(r, start); err != nil {
+			s.backOff(c, err, now)
+			return nil
+		}
+		c.next = now.Add(s.idle.Interval - s.round/2)
+		return nil
+	}
+	if err := c.step(r, start); err != nil {
+		s.backOff(c, err, now)
+		return nil
+	}
+	if c.retries > 0 {
+		c.logger.Info("Fan control recovered", "retries", c.retries)
+		c.retries = 0
+	}
+	if r.err == nil && c.mode != "monitor" && !c.released {
+		if c.settings.PowerLimit != nil {
+			c.limitPower(r.Temp, now)
+		}
+		if c.settings.ClockCap != nil {
+			c.capClocks(r.Temp, now)
+		}
+		if c.settings.Emergency != nil {
+			c.emergency(r.Temp, now)
+		}
+	}
+	if r.err == nil {
+		s.adapt(c, r.Temp, now)
+	}
+	return nil
+}
+
+// maxRetryDelay caps the delay between retries of a card failing to control
+// its fans.
+const maxRetryDelay = time.Minute
+
+// backOff postpones the next cycle of card c after it failed with err at now,
+// twice longer after every failure in a row, while other cards go on.
+func (s *scheduler) backOff(c *cardControl, err error, now time.Time) {
+	if errors.Is(err, gpu.ErrLost) {
+		RequestRescan()
+	}
+	delay := s.round << min(c.retries, 16)
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	c.retries++
+	c.next = now.Add(delay - s.round/2)
+	c.logger.Warn("Can't control fans, retrying", "in", delay, "error", err)
+	SetHealth(c.idx, HealthDegraded, err.Error())
+}
+
+// adapt chooses when card c runs next after reading temp at now: after
+// the minimum interval while the temperature moves or is close to the
+// slowdown threshold, otherwise after twice the last interval up to the
+// maximum. Changes of 1°C are sensor noise, the rate is measured from the
+// last larger change.
+func (s *scheduler) adapt(c *cardControl, temp int, now time.Time) {
+	a := s.adaptive
+	if a == nil {
+		return
+	}
+	// Cards following their group react to the temperature of another one
+	fast := temp >= c.maxTemp-a.Margin || c.following
+	if delta := math.Abs(float64(temp - c.prevTemp)); c.prevAt.IsZero() || delta > 1 {
+		fast = fast || !c.prevAt.IsZero() && delta/now.Sub(c.prevAt).Seconds() >= a.Rate
+		c.prevTemp, c.prevAt = temp, now
+	}
+	interval := a.Min
+	if !fast && c.interval > 0 {
+		interval = min(2*c.interval, a.Max)
+	}
+	if interval != c.interval {
+		c.logger.Debug("Polling interval changed", "interval", interval)
+		c.interval = interval
+	}
+	c.next = now.Add(c.interval - s.round/2)
+}
+
+// checkIdle tells whether GPU of card c is idle according to its
+// snapshot, it logs when the card enters or leaves idle state. GPUs whose
+// activity can't be read are never idle.
+func (s *scheduler) checkIdle(c *cardControl, snapshot gpu.Snapshot) bool {
+	if s.idle == nil {
+		return false
+	}
+	idle := snapshot.Temp <= s.idle.Temp &&
+		snapshot.Utilization >= 0 && snapshot.Utilization <= s.idle.Utilization &&
+		(s.idle.Power == 0 || snapshot.Power > 0 && snapshot.Power <= s.idle.Power)
+	if idle == c.idle {
+		return idle
+	}
+	c.idle = idle
+	if idle {
+		c.idleSince = snapshot.Time
+		c.logger.Info("GPU is idle, polling less often", "interval", s.idle.Interval)
+		return true
+	}
+	c.logger.Info("GPU is busy, polling as usual")
+	if c.released {
+		RecordEvent(c.idx, "release", "Fan control taken back from the driver")
+	}
+	// Fans are taken back if released, the next PID update counts as a
+	// single period
+	c.interval, c.released, c.last = 0, false, time.Time{}
+	return false
+}
+
+// release returns fans of the idle card c to the driver when configured
+// and it has been idle long enough at now.
+func (s *scheduler) release(c *cardControl, now time.Time) error {
+	if !s.idle.Release || c.released || c.mode == "monitor" || now.Sub(c.idleSince) < s.idle.ReleaseAfter {
+		return nil
+	}
+	if err := gpu.DefaultFansSpeed(c.idx); err != nil {
+		return fmt.Errorf("GPU %d: %w", c.idx, err)
+	}
+	c.logger.Info("Fans returned to the driver while idle", "idle", now.Sub(c.idleSince).Round(time.Second))
+	RecordEvent(c.idx, "release", "Fans returned to the driver while idle")
+	c.released, c.manual = true, false
+	c.leaveGroup()
+	return nil
+}
+
+// idleSettings returns idle polling configuration with defaults filled in,
+// or nil when it is disabled.
+func idleSettings() *config.IdleConfig {
+	if conf.Idle == nil {
+		return nil
+	}
+	i := *conf.Idle
+	if i.Interval == 0 {
+		i.Interval = defaultIdleInterval
+	}
+	if i.Utilization == 0 {
+		i.Utilization = defaultIdleUtilization
+	}
+	if i.Temp == 0 {
+		i.Temp = defaultIdleTemp
+	}
+	return &i
+}
+
+// adaptiveSettings returns adaptive polling configuration with defaults
+// filled in, or nil when it is disabled.
+func adaptiveSettings() *config.AdaptiveConfig {
+	if conf.Adaptive == nil {
+		return nil
+	}
+	a := *conf.Adaptive
+	if a.Min == 0 {
+		a.Min = defaultAdaptiveMin
+	}
+	if a.Max == 0 {
+		a.Max = max(defaultAdaptiveMax, a.Min)
+	}
+	if a.Rate == 0 {
+		a.Rate = defaultAdaptiveRate
+	}
+	if a.Margin == 0 {
+		a.Margin = defaultAdaptiveMargin
+	}
+	return &a
+}
+
+// start runs the scheduler until ctx is canceled or a controller fails.
+func (s *scheduler) start(parent context.Context) {
+	if s.phases() == 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(parent)
+	s.cancel = cancel
+	s.done = make(chan struct{})
+	s.readings = map[int]reading{}
+	s.adaptive = adaptiveSettings()
+	s.idle = idleSettings()
+	s.round = time.Duration(conf.Period) * time.Second
+	if s.adaptive != nil {
+		s.round = s.adaptive.Min
+	}
+	loops.Add(1)
+	go func() {
+		// Done first, shutdown started by a panic waits for the loop
+		defer RestoreOnPanic()
+		defer loops.Done()
+		defer close(s.done)
+		// Phases start every slot however long the work in them takes
+		slot := s.round / time.Duration(s.phases())
+		ticker := daemonClock.NewTicker(slot)
+		defer ticker.Stop()
+		for {
+			start := daemonClock.Now()
+			if err := s.runPhase(); err != nil {
+				if ctx.Err() == nil {
+					Fail(err)
+				}
+				return
+			}
+			// The ticker drops ticks of an overrun phase instead of drifting
+			if took := daemonClock.Now().Sub(start); took > slot {
+				controllerLog.Warn("Control phase overran its slot, next phase is delayed", "took", took, "slot", slot)
+			}
+			if !sleepCycle(ctx, ticker) {
+				return
+			}
+		}
+	}()
+	go func() {
+		defer RestoreOnPanic()
+		s.watch(parent, ctx)
+	}()
+	schedulerMu.Lock()
+	running = s
+	schedulerMu.Unlock()
+}
+
+var (
+	schedulerMu sync.Mutex
+	// running is the scheduler started last, a watchdog successor replaces it.
+	running *scheduler
+)
+
+// stopScheduler stops the running scheduler, waits for its loop to exit
+// and returns it, nil if none was running.
+func stopScheduler() *scheduler {
+	var stopped *scheduler
+	for {
+		schedulerMu.Lock()
+		s := running
+		running = nil
+		schedulerMu.Unlock()
+		if s == nil {
+			return stopped
+		}
+		s.cancel()
+		<-s.done
+		stopped = s
+	}
+}
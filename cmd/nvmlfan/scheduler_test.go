@@ -0,0 +1,285 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/IvanBayan/nvmlfan/internal/clock"
+	"github.com/IvanBayan/nvmlfan/internal/config"
+	"github.com/IvanBayan/nvmlfan/internal/gpu"
+	"github.com/IvanBayan/nvmlfan/internal/sim"
+	"github.com/IvanBayan/nvmlfan/pkg/controller"
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+var simStart = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// simScheduler builds a scheduler of the cards of cfg running on simulated
+// GPUs, whose time moves only when the test advances the returned clock.
+func simScheduler(t *testing.T, cfg config.Config) (*scheduler, *clock.Manual) {
+	t.Helper()
+	savedConf, savedClock := conf, daemonClock
+	t.Cleanup(func() {
+		conf, daemonClock = savedConf, savedClock
+		gpu.ResetCache()
+	})
+	if cfg.Period == 0 {
+		cfg.Period = 1
+	}
+	conf = cfg
+	clk := clock.NewManual(simStart)
+	daemonClock = clk
+	gpu.Use("sim", sim.New(cfg.Sim, clk))
+	if err := gpu.InitNVML(); err != nil {
+		t.Fatal(err)
+	}
+	s := &scheduler{readings: map[int]reading{}, adaptive: adaptiveSettings(), idle: idleSettings(),
+		round: time.Duration(cfg.Period) * time.Second}
+	if s.adaptive != nil {
+		s.round = s.adaptive.Min
+	}
+	for idx := range cfg.Sim.GPUs {
+		card, _ := CardConfig(conf, idx)
+		c, err := newCardControl(idx, card.Mode)
+		if err != nil {
+			t.Fatalf("GPU %d: %v", idx, err)
+		}
+		s.cards = append(s.cards, c)
+	}
+	return s, clk
+}
+
+// runRounds runs n rounds of s, each after advancing clk by a round.
+func runRounds(t *testing.T, s *scheduler, clk *clock.Manual, n int) {
+	t.Helper()
+	for range n {
+		clk.Advance(s.round)
+		for range s.phases() {
+			if err := s.runPhase(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+// cardHealth returns the health of card c.
+func cardHealth(c *cardControl) Health {
+	for _, h := range CardsHealth() {
+		if h.UUID == c.uuid {
+			return h.State
+		}
+	}
+	return ""
+}
+
+func TestSchedulerModes(t *testing.T) {
+	curve := [][2]int{{40, 30}, {80, 100}}
+	tests := []struct {
+		name    string
+		card    config.GPUConfig
+		profile string
+		check   func(t *testing.T, c *cardControl, r reading)
+	}{
+		{
+			name:    "curve follows temperature",
+			card:    config.GPUConfig{Mode: "curve", Curve: curve},
+			profile: "full",
+			check: func(t *testing.T, c *cardControl, r reading) {
+				if want := controller.ComputeFanSpeed(r.Temp, c.curve, c.minSpeed, c.maxSpeed); c.output != want {
+					t.Errorf("output %d%% at %d°C, want %d%%", c.output, r.Temp, want)
+				}
+				if r.Temp <= 40 {
+					t.Errorf("loaded GPU at %d°C didn't heat up", r.Temp)
+				}
+			},
+		},
+		{
+			name:    "target holds temperature",
+			card:    config.GPUConfig{Mode: "target", Target: 70, PID: []float64{3, 0.5, 1}},
+			profile: "full",
+			check: func(t *testing.T, c *cardControl, r reading) {
+				if r.Temp < 68 || r.Temp > 72 {
+					t.Errorf("temperature %d°C, want 70°C", r.Temp)
+				}
+			},
+		},
+		{
+			name:    "target rests at minimum speed on idle GPU",
+			card:    config.GPUConfig{Mode: "target", Target: 70, PID: []float64{3, 0.5, 1}},
+			profile: "idle",
+			check: func(t *testing.T, c *cardControl, r reading) {
+				if c.output != c.minSpeed {
+					t.Errorf("output %d%%, want minimum %d%%", c.output, c.minSpeed)
+				}
+			},
+		},
+		{
+			name:    "monitor leaves fans to the driver",
+			card:    config.GPUConfig{Mode: "monitor"},
+			profile: "full",
+			check: func(t *testing.T, c *cardControl, r reading) {
+				device, _ := gpu.DeviceGetHandleByIndex(c.idx)
+				if policy, _ := device.GetFanControlPolicy_v2(0); policy == nvml.FAN_POLICY_MANUAL {
+					t.Error("fans were switched to manual policy")
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, clk := simScheduler(t, config.Config{
+				Cards: map[string]config.GPUConfig{"0": tt.card},
+				Sim:   config.SimConfig{GPUs: []config.SimGPUConfig{{Profile: tt.profile, TimeConstant: 30 * time.Second}}},
+			})
+			runRounds(t, s, clk, 600)
+			c, r := s.cards[0], s.readings[0]
+			if r.err != nil {
+				t.Fatal(r.err)
+			}
+			tt.check(t, c, r)
+		})
+	}
+}
+
+func TestSchedulerDetachedGPU(t *testing.T) {
+	s, clk := simScheduler(t, config.Config{
+		Cards: map[string]config.GPUConfig{"0": {Mode: "curve", Curve: [][2]int{{40, 30}, {80, 100}}}},
+		Sim:   config.SimConfig{GPUs: []config.SimGPUConfig{{Detach: 10 * time.Second}}},
+	})
+	runRounds(t, s, clk, 9)
+	if h := cardHealth(s.cards[0]); h != HealthControlling {
+		t.Errorf("attached GPU is %s, want %s", h, HealthControlling)
+	}
+	runRounds(t, s, clk, 5)
+	if h := cardHealth(s.cards[0]); h != HealthLost {
+		t.Errorf("detached GPU is %s, want %s", h, HealthLost)
+	}
+}
+
+// restoreFailing is a simulated library whose fans can't be returned to
+// the driver.
+type restoreFailing struct{ *sim.Library }
+
+func (l restoreFailing) DeviceGetHandleByIndex(idx int) (nvml.Device, nvml.Return) {
+	device, ret := l.Library.DeviceGetHandleByIndex(idx)
+	if ret != nvml.SUCCESS {
+		return nil, ret
+	}
+	return restoreFailingDevice{device}, ret
+}
+
+type restoreFailingDevice struct{ nvml.Device }
+
+func (d restoreFailingDevice) SetDefaultFanSpeed_v2(fan int) nvml.Return {
+	return nvml.ERROR_UNKNOWN
+}
+
+func TestSchedulerIdleReleaseFailure(t *testing.T) {
+	cfg := config.Config{
+		Cards: map[string]config.GPUConfig{"0": {Mode: "curve", Curve: [][2]int{{40, 30}, {80, 100}}}},
+		Idle:  &config.IdleConfig{Release: true, Temp: 100},
+		Sim:   config.SimConfig{GPUs: []config.SimGPUConfig{{Profile: "idle"}}},
+	}
+	s, clk := simScheduler(t, cfg)
+	gpu.Use("sim", restoreFailing{sim.New(cfg.Sim, clk)})
+	if err := gpu.InitNVML(); err != nil {
+		t.Fatal(err)
+	}
+	// A failed release backs off the card instead of failing the scheduler
+	runRounds(t, s, clk, 5)
+	c := s.cards[0]
+	if c.released {
+		t.Error("card is released although its fans weren't given back")
+	}
+	if c.retries == 0 {
+		t.Error("failed release wasn't retried")
+	}
+	if h := cardHealth(c); h != HealthDegraded {
+		t.Errorf("card is %s, want %s", h, HealthDegraded)
+	}
+}
+
+func TestDecideMissedTicks(t *testing.T) {
+	s, clk := simScheduler(t, config.Config{
+		Cards: map[string]config.GPUConfig{"0": {Mode: "target", Target: 60, PID: []float64{0, 1, 0}}},
+		Sim:   config.SimConfig{GPUs: []config.SimGPUConfig{{}}},
+	})
+	c := s.cards[0]
+	tests := []struct {
+		name     string
+		advance  time.Duration
+		integral float64
+	}{
+		{"first update counts as a period", 0, 10},
+		{"on time", time.Second, 20},
+		{"two missed ticks", 3 * time.Second, 50},
+		{"half period", 500 * time.Millisecond, 55},
+	}
+	for _, tt := range tests {
+		clk.Advance(tt.advance)
+		c.decide(70)
+		d, ok := GetDecision(c.idx)
+		if !ok || d.PID == nil {
+			t.Fatalf("%s: no PID decision recorded", tt.name)
+		}
+		if d.PID.I != tt.integral {
+			t.Errorf("%s: integral %v, want %v", tt.name, d.PID.I, tt.integral)
+		}
+	}
+}
+
+func TestAdaptivePolling(t *testing.T) {
+	s, clk := simScheduler(t, config.Config{
+		Cards:    map[string]config.GPUConfig{"0": {Mode: "curve", Curve: [][2]int{{40, 30}, {80, 100}}}},
+		Sim:      config.SimConfig{GPUs: []config.SimGPUConfig{{}}},
+		Adaptive: &config.AdaptiveConfig{Min: time.Second, Max: 8 * time.Second, Rate: 0.5, Margin: 5},
+	})
+	c := s.cards[0]
+	c.maxTemp = 88
+	tests := []struct {
+		name     string
+		advance  time.Duration
+		temp     int
+		interval time.Duration
+	}{
+		{"first reading", 0, 50, time.Second},
+		{"stable", time.Second, 50, 2 * time.Second},
+		{"noise ignored", 2 * time.Second, 51, 4 * time.Second},
+		{"still stable", 4 * time.Second, 51, 8 * time.Second},
+		{"capped at maximum", 8 * time.Second, 51, 8 * time.Second},
+		{"slow drift", 8 * time.Second, 60, 8 * time.Second},
+		{"fast rise", time.Second, 63, time.Second},
+		{"settled", time.Second, 63, 2 * time.Second},
+		{"close to threshold", 2 * time.Second, 84, time.Second},
+		{"stable close to threshold", time.Second, 84, time.Second},
+	}
+	for _, tt := range tests {
+		clk.Advance(tt.advance)
+		now := clk.Now()
+		s.adapt(c, tt.temp, now)
+		if c.interval != tt.interval {
+			t.Errorf("%s: interval %v, want %v", tt.name, c.interval, tt.interval)
+		}
+		if want := now.Add(tt.interval - s.round/2); !c.next.Equal(want) {
+			t.Errorf("%s: next cycle at %v, want %v", tt.name, c.next, want)
+		}
+	}
+}
+
+func TestAdaptiveSchedulerSkipsStableCards(t *testing.T) {
+	s, clk := simScheduler(t, config.Config{
+		Cards:    map[string]config.GPUConfig{"0": {Mode: "curve", Curve: [][2]int{{40, 30}, {80, 100}}}},
+		Sim:      config.SimConfig{GPUs: []config.SimGPUConfig{{Profile: "idle"}}},
+		Adaptive: &config.AdaptiveConfig{Min: time.Second, Max: 8 * time.Second, Rate: 0.5, Margin: 5},
+	})
+	c := s.cards[0]
+	runRounds(t, s, clk, 60)
+	if c.interval != 8*time.Second {
+		t.Fatalf("idle GPU polled every %v, want 8s", c.interval)
+	}
+	cycles := c.cycle
+	runRounds(t, s, clk, 80)
+	if polled := c.cycle - cycles; polled != 10 {
+		t.Errorf("idle GPU polled %d times in 80s, want 10", polled)
+	}
+}
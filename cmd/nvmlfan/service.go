@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const systemdUnit = `[Unit]
+Description=Control of nvidia GPUs fans
+After=systemd-modules-load.service nvidia-persistenced.service
+
+[Service]
+User=root
+Type=notify
+NotifyAccess=all
+WatchdogSec=30
+
+ExecStart={{binary}} run --foreground --config {{config}}
+ExecStopPost={{binary}} restore
+
+[Install]
+WantedBy=multi-user.target
+`
+
+const openrcScript = `#!/sbin/openrc-run
+
+description="Control of nvidia GPUs fans"
+command="{{binary}}"
+command_args="run --foreground --config {{config}}"
+command_background=true
+pidfile="/run/${RC_SVCNAME}.pid"
+
+depend() {
+	after modules nvidia-persistenced
+}
+
+stop_post() {
+	"{{binary}}" restore
+}
+`
+
+// detectInit returns init system of the host: systemd or openrc.
+func detectInit() string {
+	if _, err := os.Stat("/run/systemd/system"); err == nil {
+		return "systemd"
+	}
+	if _, err := os.Stat("/sbin/openrc-run"); err == nil {
+		return "openrc"
+	}
+	return ""
+}
+
+func runCommands(commands [][]string) error {
+	for _, args := range commands {
+		fmt.Printf("# %s\n", strings.Join(args, " "))
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s: %w", args[0], err)
+		}
+	}
+	return nil
+}
+
+// InstallServiceCommand writes service definition for the init system and enables it.
+func InstallServiceCommand(args []string) int {
+	fs := flag.NewFlagSet("install-service", flag.ExitOnError)
+	initSystem := fs.String("init", detectInit(), "Init system: systemd or openrc")
+	configPath := fs.String("config", "/usr/local/etc/nvmlfan.yaml", "Configuration file used by the service")
+	binary := fs.String("binary", "", "Path to nvmlfan binary, this one by default")
+	dryRun := fs.Bool("dry-run", false, "Print service definition instead of installing it")
+	enable := fs.Bool("enable", true, "Enable and start the service")
+	force := fs.Bool("force", false, "Overwrite existing service definition")
+	fs.Parse(args)
+
+	if *binary == "" {
+		exe, err := os.Executable()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		*binary = exe
+	}
+	config, err := filepath.Abs(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	var template, path string
+	var mode os.FileMode
+	var enableCommands [][]string
+	switch *initSystem {
+	case "systemd":
+		template, path, mode = systemdUnit, "/etc/systemd/system/nvmlfan.service", 0644
+		enableCommands = [][]string{{"systemctl", "daemon-reload"}, {"systemctl", "enable", "--now", "nvmlfan.service"}}
+	case "openrc":
+		template, path, mode = openrcScript, "/etc/init.d/nvmlfan", 0755
+		enableCommands = [][]string{{"rc-update", "add", "nvmlfan", "default"}, {"rc-service", "nvmlfan", "start"}}
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown init system '%s', use -init systemd or -init openrc\n", *initSystem)
+		return 2
+	}
+	data := strings.NewReplacer("{{binary}}", *binary, "{{config}}", config).Replace(template)
+	if *dryRun {
+		fmt.Printf("# %s\n%s", path, data)
+		return 0
+	}
+
+	if _, err := os.Stat(config); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v, create it with '%s init --output %s'\n", err, *binary, config)
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !*force {
+		flags |= os.O_EXCL
+	}
+	file, err := os.OpenFile(path, flags, mode)
+	if errors.Is(err, os.ErrExist) {
+		fmt.Fprintf(os.Stderr, "%s already exists, use -force to overwrite it\n", path)
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	// Mode of an overwritten file is kept by open
+	err = file.Chmod(mode)
+	if err == nil {
+		_, err = file.WriteString(data)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("Service written to %s\n", path)
+	if !*enable {
+		return 0
+	}
+	if err := runCommands(enableCommands); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/IvanBayan/nvmlfan/internal/config"
+	"github.com/IvanBayan/nvmlfan/internal/gpu"
+	"github.com/IvanBayan/nvmlfan/pkg/controller"
+)
+
+const (
+	// Limits used by simulation when the GPU can't be queried.
+	defaultSimMinSpeed = 30
+	defaultSimMaxSpeed = 100
+	defaultSimMaxTemp  = 90
+)
+
+// parseRange parses "from:to" temperature range.
+func parseRange(value string) (int, int, error) {
+	from, to, ok := strings.Cut(value, ":")
+	if !ok {
+		return 0, 0, fmt.Errorf("range must look like from:to, got '%s'", value)
+	}
+	lo, err := strconv.Atoi(from)
+	if err != nil {
+		return 0, 0, err
+	}
+	hi, err := strconv.Atoi(to)
+	if err != nil {
+		return 0, 0, err
+	}
+	if lo > hi {
+		return 0, 0, fmt.Errorf("range start %d is above end %d", lo, hi)
+	}
+	return lo, hi, nil
+}
+
+// SimulateCommand prints the temperature to fan speed mapping the daemon
+// would apply to a card in curve mode, after clamping against card limits.
+// Limits are read from the GPU when NVML is available, flags override them.
+func SimulateCommand(args []string) int {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "Path to configuration file")
+	idx := fs.Int("gpu", 0, "Card from configuration to simulate")
+	temps := fs.String("temps", "30:95", "Temperature range, from:to")
+	step := fs.Int("step", 1, "Temperature step")
+	plot := fs.Bool("plot", false, "Render speeds as ASCII bars")
+	minSpeed := fs.Int("min-speed", -1, "Minimum fan speed of the card")
+	maxSpeed := fs.Int("max-speed", -1, "Maximum fan speed of the card")
+	maxTemp := fs.Int("max-temp", -1, "Maximum temperature threshold of the card")
+	fs.Parse(args)
+
+	lo, hi, err := parseRange(*temps)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if *step <= 0 {
+		fmt.Fprintln(os.Stderr, "-step must be positive")
+		return 2
+	}
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	// Without NVML only index keys can be matched
+	available := gpu.InitNVML() == nil
+	if available {
+		defer gpu.Shutdown()
+		available = *idx < gpu.GetDeviceCount()
+	}
+	var card config.GPUConfig
+	var ok bool
+	if available {
+		card, ok = CardConfig(cfg, *idx)
+	} else {
+		card, ok = cfg.Cards[strconv.Itoa(*idx)]
+	}
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Card %d not found in config\n", *idx)
+		return 1
+	}
+	if card.Mode != "curve" {
+		fmt.Fprintf(os.Stderr, "Card %d uses %s mode, only curve mode has a static mapping\n", *idx, card.Mode)
+		return 1
+	}
+	if len(card.Curve) == 0 && card.Preset == "" {
+		fmt.Fprintf(os.Stderr, "Card %d has empty curve\n", *idx)
+		return 1
+	}
+
+	cardMin, cardMax, cardTemp := defaultSimMinSpeed, defaultSimMaxSpeed, defaultSimMaxTemp
+	source := "defaults"
+	if available {
+		if cardMin, cardMax, cardTemp, err = gpu.GetThermalInfo(*idx); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		source = "GPU"
+	}
+	if *minSpeed >= 0 {
+		cardMin, source = *minSpeed, "flags"
+	}
+	if *maxSpeed >= 0 {
+		cardMax, source = *maxSpeed, "flags"
+	}
+	if *maxTemp >= 0 {
+		cardTemp, source = *maxTemp, "flags"
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+	curve := controller.ClampCurve(CardCurve(card, cardMin, cardMax, cardTemp), cardMin, cardMax, cardTemp, logger)
+	fmt.Printf("Card %d: speed %d-%d%%, max temp %d°C (from %s)\n", *idx, cardMin, cardMax, cardTemp, source)
+	fmt.Printf("Clamped curve: %v\n", curve)
+	for temp := lo; temp <= hi; temp += *step {
+		speed := controller.ComputeFanSpeed(temp, curve, cardMin, cardMax)
+		note := ""
+		switch {
+		case temp < curve[0][0]:
+			note = "below curve, min speed"
+		case temp > curve[len(curve)-1][0]:
+			note = "above curve, max speed"
+		}
+		line := fmt.Sprintf("%4d°C %4d%%", temp, speed)
+		if *plot {
+			line += fmt.Sprintf(" |%-50s|", strings.Repeat("#", speed/2))
+		}
+		fmt.Println(strings.TrimRight(line+" "+note, " "))
+	}
+	return 0
+}
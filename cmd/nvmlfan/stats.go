@@ -0,0 +1,180 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/IvanBayan/nvmlfan/internal/config"
+	"github.com/IvanBayan/nvmlfan/internal/gpu"
+	"github.com/IvanBayan/nvmlfan/internal/telemetry"
+	bolt "go.etcd.io/bbolt"
+)
+
+var stats *telemetry.StatsStore
+
+func init() {
+	apiMux.HandleFunc("GET /stats", handleStats)
+}
+
+func ConfigureStats() {
+	if conf.Stats == nil {
+		return
+	}
+	path := conf.Stats.Path
+	if path == "" {
+		path = defaultStatsPath
+	}
+	path = absPath(path)
+	store, err := telemetry.NewStatsStore(path, conf.Stats.Retention)
+	if err != nil {
+		telemetryLog.Error("Can't open statistics database", "path", path, "error", err)
+		return
+	}
+	stats = store
+	sinks = append(sinks, store)
+	telemetryLog.Debug("Statistics database configured", "path", path)
+}
+
+// RecordEvent stores an event of GPU idx if the statistics database is
+// enabled and passes it to notifications.
+func RecordEvent(idx int, eventType, message string) {
+	recordEvent(idx, eventType, message, false)
+}
+
+// ResolveEvent records the end of the condition reported by an earlier event
+// of eventType on GPU idx.
+func ResolveEvent(idx int, eventType, message string) {
+	recordEvent(idx, eventType, message, true)
+}
+
+func recordEvent(idx int, eventType, message string, resolved bool) {
+	if stats == nil && notifier == nil {
+		return
+	}
+	id := gpu.GetDeviceIdentity(idx)
+	event := telemetry.Event{Time: daemonClock.Now(), GPU: idx, UUID: id.UUID, Name: id.Name, Type: eventType, Message: message, Resolved: resolved}
+	if stats != nil {
+		stats.RecordEvent(event)
+	}
+	if notifier != nil {
+		notifier.Notify(event)
+	}
+}
+
+// handleStats serves statistics or with events=true events since the time
+// in since, the database of the daemon can't be opened by other processes.
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	if stats == nil {
+		http.Error(w, "statistics aren't enabled", http.StatusNotFound)
+		return
+	}
+	query := r.URL.Query()
+	since, err := time.Parse(time.RFC3339, query.Get("since"))
+	if err != nil {
+		http.Error(w, "since must be an RFC 3339 time", http.StatusBadRequest)
+		return
+	}
+	err = stats.View(func(db *bolt.DB) error {
+		if query.Get("events") == "true" {
+			events, err := telemetry.QueryEvents(db, since, query.Get("gpu"))
+			if err == nil {
+				writeJSON(w, events)
+			}
+			return err
+		}
+		result, err := telemetry.QueryStats(db, since, query.Get("gpu"))
+		if err == nil {
+			writeJSON(w, result)
+		}
+		return err
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// readStats answers query with the running daemon at socket into v, or
+// when there is none calls read with the database at path.
+func readStats(socket, path string, query url.Values, v any, read func(db *bolt.DB) error) error {
+	if socket != "" && APIGet(socket, "/stats", query, v) == nil {
+		return nil
+	}
+	db, err := telemetry.OpenStatsDB(path, true)
+	if err != nil {
+		return fmt.Errorf("can't open statistics database '%s': %w", path, err)
+	}
+	defer db.Close()
+	return read(db)
+}
+
+// StatsCommand implements `nvmlfan stats`, it returns the process exit code.
+func StatsCommand(args []string) int {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "Path to configuration file")
+	dbPath := fs.String("db", "", "Path to statistics database (overrides config), read directly rather than through the daemon")
+	days := fs.Int("days", 7, "Number of days to report")
+	gpu := fs.String("gpu", "", "Report only GPU with this index or UUID")
+	events := fs.Bool("events", false, "List recorded events instead of daily statistics")
+	fs.Parse(args)
+
+	path, socket := *dbPath, ""
+	if path == "" {
+		cfg, err := config.Load(*configPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if cfg.Stats != nil && cfg.Stats.Path != "" {
+			path = cfg.Stats.Path
+		} else {
+			path = defaultStatsPath
+		}
+		// Running daemon keeps the database open
+		socket = apiSocket(cfg.API)
+	}
+
+	now := time.Now()
+	since := time.Date(now.Year(), now.Month(), now.Day()-*days+1, 0, 0, 0, 0, time.Local)
+	query := url.Values{"since": {since.Format(time.RFC3339)}, "gpu": {*gpu}, "events": {strconv.FormatBool(*events)}}
+	if *events {
+		var events []telemetry.Event
+		err := readStats(socket, path, query, &events, func(db *bolt.DB) (err error) {
+			events, err = telemetry.QueryEvents(db, since, *gpu)
+			return err
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Can't read events: %v\n", err)
+			return 1
+		}
+		for _, e := range events {
+			fmt.Printf("%s GPU %d (%s) %-10s %s\n", e.Time.Local().Format(time.DateTime), e.GPU, e.UUID, e.Type, e.Message)
+		}
+		return 0
+	}
+
+	var result []*telemetry.DayStats
+	err := readStats(socket, path, query, &result, func(db *bolt.DB) (err error) {
+		result, err = telemetry.QueryStats(db, since, *gpu)
+		return err
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Can't read statistics: %v\n", err)
+		return 1
+	}
+	uuid := ""
+	for _, d := range result {
+		if d.UUID != uuid {
+			uuid = d.UUID
+			fmt.Printf("%2d: %v - %v\n", d.GPU, d.Name, d.UUID)
+			fmt.Printf("  %-10s  %4s  %5s  %4s  %10s  %10s\n", "Date", "Min", "Avg", "Max", "Max fan", "Throttle")
+		}
+		fmt.Printf("  %-10s  %4d  %5.1f  %4d  %10s  %10s\n", d.Day, d.Min, d.Avg, d.Max,
+			d.AtMax.Round(time.Second), d.Throttle.Round(time.Second))
+	}
+	return 0
+}
@@ -0,0 +1,11 @@
+package main
+
+import "github.com/IvanBayan/nvmlfan/internal/telemetry"
+
+func ConfigureSummary() {
+	if conf.Summary.Disabled {
+		return
+	}
+	sinks = append(sinks, telemetry.NewSummarySink(conf.Summary.Interval, conf.Summary.Warning))
+	telemetryLog.Debug("Thermal summary configured", "interval", conf.Summary.Interval)
+}
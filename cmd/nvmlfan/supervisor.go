@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/IvanBayan/nvmlfan/internal/gpu"
+)
+
+const (
+	// supervisedEnv marks the controller started by the supervisor.
+	supervisedEnv         = "NVMLFAN_SUPERVISED"
+	defaultMaxRestarts    = 5
+	restartDelay          = 5 * time.Second
+	controllerStopTimeout = 30 * time.Second
+)
+
+// Supervised reports whether this process is the controller run by Supervise.
+func Supervised() bool {
+	return os.Getenv(supervisedEnv) != ""
+}
+
+// controllerEnv is the environment of the controller. The systemd watchdog
+// is addressed to the supervisor, the controller pets it on its behalf,
+// which needs NotifyAccess=all.
+func controllerEnv() []string {
+	env := []string{supervisedEnv + "=1"}
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, "WATCHDOG_PID=") {
+			env = append(env, kv)
+		}
+	}
+	return env
+}
+
+// Supervise runs the controller in a child process and waits for it. A
+// controller that stops cleanly restores fans itself; when it is killed,
+// runs out of memory or crashes, the supervisor applies exit behavior of
+// cards, so manual control never outlives the software managing it, and
+// starts the controller again if configured. The supervisor loads NVML only
+// to restore, so there is little in it to fail.
+func Supervise() int {
+	if err := ConfigureLogging(); err != nil {
+		slog.Error("Can't start", "error", err)
+		return 1
+	}
+	if err := AcquirePidFile(pidFilePath(conf.PidFile)); err != nil {
+		supervisorLog.Error("Can't start", "error", err)
+		return 1
+	}
+	defer ReleasePidFile()
+	signal.Notify(stopRequests, syscall.SIGINT, syscall.SIGTERM)
+
+	findExternalCards()
+
+	exe, err := os.Executable()
+	if err != nil {
+		exe = os.Args[0]
+	}
+	ready := takeReadinessPipe()
+	sockets := activationFiles()
+	maxRestarts := conf.Supervisor.MaxRestarts
+	if maxRestarts == 0 {
+		maxRestarts = defaultMaxRestarts
+	}
+	for restarts := 0; ; restarts++ {
+		cmd := exec.Command(exe, os.Args[1:]...)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		cmd.Env = controllerEnv()
+		// Activated sockets keep descriptors from 3 on, the pipe follows them
+		cmd.ExtraFiles = slices.Clone(sockets)
+		if ready != nil {
+			cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%d", daemonEnv, listenFdsStart+len(cmd.ExtraFiles)))
+			cmd.ExtraFiles = append(cmd.ExtraFiles, ready)
+		}
+		if err := cmd.Start(); err != nil {
+			supervisorLog.Error("Can't start controller", "error", err)
+			return 1
+		}
+		// Readiness is reported once, by the first controller
+		if ready != nil {
+			ready.Close()
+			ready = nil
+		}
+		supervisorLog.Info("Controller started", "pid", cmd.Process.Pid)
+
+		code, stopping := waitController(cmd)
+		if code == 0 {
+			return 0
+		}
+		supervisorLog.Error("Controller exited abnormally", "pid", cmd.Process.Pid, "state", cmd.ProcessState.String())
+		releaseFans()
+		// Controller which failed to start won't start again
+		if stopping || code == exitFailure || !conf.Supervisor.Restart {
+			return code
+		}
+		if restarts >= maxRestarts {
+			supervisorLog.Error("Controller keeps crashing, giving up", "restarts", restarts)
+			return code
+		}
+		select {
+		case <-stopRequests:
+			return code
+		case <-time.After(restartDelay):
+		}
+	}
+}
+
+// waitController waits for the controller to exit and returns its exit
+// code, 128 plus the signal number when it was killed. Stop requests are
+// passed to the controller, it is killed if it doesn't exit in time.
+func waitController(cmd *exec.Cmd) (int, bool) {
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	stopping := false
+	select {
+	case err := <-done:
+		return exitCode(cmd, err), stopping
+	case <-stopRequests:
+		stopping = true
+	}
+	supervisorLog.Info("Stopping controller", "pid", cmd.Process.Pid)
+	if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		cmd.Process.Kill()
+	}
+	select {
+	case err := <-done:
+		return exitCode(cmd, err), stopping
+	case <-time.After(controllerStopTimeout):
+		supervisorLog.Error("Controller didn't stop in time, killing it", "timeout", controllerStopTimeout)
+		cmd.Process.Kill()
+		// Killed controller restored nothing
+		<-done
+		return 128 + int(syscall.SIGKILL), stopping
+	}
+}
+
+func exitCode(cmd *exec.Cmd, err error) int {
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		supervisorLog.Error("Can't wait for controller", "error", err)
+		return 1
+	}
+	if code := cmd.ProcessState.ExitCode(); code >= 0 {
+		return code
+	}
+	if status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return 1
+}
+
+// releaseFans applies exit behavior of cards for the dead controller.
+func releaseFans() {
+	if err := gpu.InitNVML(); err != nil {
+		supervisorLog.Error("Can't release fan control", "error", err)
+		return
+	}
+	defer gpu.Shutdown()
+	ReleaseFans()
+}
+
+// findExternalCards finds cards another program controls the same way the
+// controller will, so fans of a dead controller are released without
+// touching them. Captured state lets other cards be restored exactly.
+func findExternalCards() {
+	if err := gpu.InitNVML(); err != nil {
+		supervisorLog.Warn("Can't check fan state", "error", err)
+		return
+	}
+	defer gpu.Shutdown()
+	FindExternalCards(CaptureCards())
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/IvanBayan/nvmlfan/internal/gpu"
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+// SweepStep is the steady state reached at one fan speed.
+type SweepStep struct {
+	Speed    int
+	Measured int
+	Temp     int
+	Power    float64 // Watts, to confirm that load stayed constant.
+	Steady   bool
+	Elapsed  time.Duration
+}
+
+// settleTemperature waits until temperature of GPU idx stays within 1°C for
+// window, giving up after timeout.
+func settleTemperature(idx int, window, timeout time.Duration) (int, bool, time.Duration, error) {
+	type reading struct {
+		time time.Time
+		temp int
+	}
+	start := time.Now()
+	var readings []reading
+	for {
+		now := time.Now()
+		temp, err := gpu.GetTemperature(idx)
+		if err != nil {
+			return 0, false, time.Since(start), err
+		}
+		readings = append(readings, reading{now, temp})
+		for len(readings) > 1 && now.Sub(readings[1].time) >= window {
+			readings = readings[1:]
+		}
+		if now.Sub(readings[0].time) >= window {
+			lo, hi := temp, temp
+			for _, r := range readings {
+				lo, hi = min(lo, r.temp), max(hi, r.temp)
+			}
+			if hi-lo <= 1 {
+				return temp, true, time.Since(start), nil
+			}
+		}
+		if time.Since(start) >= timeout {
+			return temp, false, time.Since(start), nil
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// SweepCommand steps fan speed of a GPU from minimum to maximum and records
+// steady state temperature for every step, data for designing a curve.
+func SweepCommand(args []string) int {
+	fs := flag.NewFlagSet("sweep", flag.ExitOnError)
+	idx := fs.Int("gpu", -1, "GPU index")
+	step := fs.Int("step", 10, "Fan speed step")
+	window := fs.Duration("window", 30*time.Second, "Temperature must stay within 1°C this long to be steady")
+	timeout := fs.Duration("timeout", 10*time.Minute, "Maximum time to wait for steady temperature on one step")
+	output := fs.String("output", "sweep.csv", "CSV file to write results, - for stdout")
+	configPath := fs.String("config", defaultConfigPath, "Configuration with GPUs to exclude")
+	fs.Parse(args)
+
+	if *idx < 0 {
+		fmt.Fprintln(os.Stderr, "-gpu is required")
+		fs.Usage()
+		return 2
+	}
+	if *step <= 0 {
+		fmt.Fprintln(os.Stderr, "-step must be positive")
+		return 2
+	}
+	if err := loadExclude(fs, *configPath); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := gpu.InitNVML(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer gpu.Shutdown()
+	if *idx >= gpu.GetDeviceCount() {
+		fmt.Fprintf(os.Stderr, "GPU %d not found\n", *idx)
+		return 1
+	}
+	if err := checkExcluded(*idx); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	out := os.Stdout
+	if *output != "-" {
+		file, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer file.Close()
+		out = file
+	}
+	w := csv.NewWriter(out)
+	w.Write([]string{"speed", "measured", "temp", "power", "steady", "seconds"})
+
+	defer gpu.DefaultFansSpeed(*idx)
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-stop
+		w.Flush()
+		gpu.DefaultFansSpeed(*idx)
+		os.Exit(1)
+	}()
+
+	device, err := gpu.DeviceGetHandleByIndex(*idx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	minSpeed, maxSpeed := gpu.GetMinMaxFanSpeed(device)
+	fmt.Fprintf(os.Stderr, "Sweeping GPU %d from %d%% to %d%%, keep the load constant until it finishes.\n", *idx, minSpeed, maxSpeed)
+	for speed := minSpeed; ; speed += *step {
+		speed = min(speed, maxSpeed)
+		for fi := 0; fi < gpu.GetNumFans(*idx); fi++ {
+			if ret := gpu.SetSingleFanSpeed(*idx, fi, speed); ret != nvml.SUCCESS {
+				fmt.Fprintf(os.Stderr, "Can't set fan %d speed: %v\n", fi, nvml.ErrorString(ret))
+				return 1
+			}
+		}
+		s := SweepStep{Speed: speed}
+		s.Temp, s.Steady, s.Elapsed, err = settleTemperature(*idx, *window, *timeout)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		s.Measured = gpu.GetFanSpeed(*idx)
+		if power, ret := device.GetPowerUsage(); ret == nvml.SUCCESS {
+			s.Power = float64(power) / 1000
+		}
+		fmt.Fprintf(os.Stderr, "%3d%%: %d°C, fans at %d%%, %.0f W, steady %v after %v\n",
+			s.Speed, s.Temp, s.Measured, s.Power, s.Steady, s.Elapsed.Round(time.Second))
+		w.Write([]string{strconv.Itoa(s.Speed), strconv.Itoa(s.Measured), strconv.Itoa(s.Temp),
+			strconv.FormatFloat(s.Power, 'f', 1, 64), strconv.FormatBool(s.Steady),
+			strconv.Itoa(int(s.Elapsed.Seconds()))})
+		w.Flush()
+		if speed >= maxSpeed {
+			break
+		}
+	}
+	if err := w.Error(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"log/syslog"
+	"sync"
+)
+
+const (
+	defaultSyslogTag      = "nvmlfan"
+	defaultSyslogFacility = "daemon"
+)
+
+var syslogFacilities = map[string]syslog.Priority{
+	"kern":     syslog.LOG_KERN,
+	"user":     syslog.LOG_USER,
+	"mail":     syslog.LOG_MAIL,
+	"daemon":   syslog.LOG_DAEMON,
+	"auth":     syslog.LOG_AUTH,
+	"syslog":   syslog.LOG_SYSLOG,
+	"lpr":      syslog.LOG_LPR,
+	"news":     syslog.LOG_NEWS,
+	"uucp":     syslog.LOG_UUCP,
+	"cron":     syslog.LOG_CRON,
+	"authpriv": syslog.LOG_AUTHPRIV,
+	"ftp":      syslog.LOG_FTP,
+	"local0":   syslog.LOG_LOCAL0,
+	"local1":   syslog.LOG_LOCAL1,
+	"local2":   syslog.LOG_LOCAL2,
+	"local3":   syslog.LOG_LOCAL3,
+	"local4":   syslog.LOG_LOCAL4,
+	"local5":   syslog.LOG_LOCAL5,
+	"local6":   syslog.LOG_LOCAL6,
+	"local7":   syslog.LOG_LOCAL7,
+}
+
+// SyslogHandler formats records like the text handler and sends them to
+// syslog with a priority matching the record level. Time and level are
+// omitted from the message, syslog records them itself.
+type SyslogHandler struct {
+	mu    *sync.Mutex
+	buf   *bytes.Buffer
+	inner slog.Handler // Writes into buf.
+	w     *syslog.Writer
+}
+
+// NewSyslogHandler connects to the local syslog daemon when network is empty,
+// otherwise to a remote one at address over "udp" or "tcp".
+func NewSyslogHandler(network, address, facility, tag string, level slog.Leveler) (*SyslogHandler, error) {
+	if facility == "" {
+		facility = defaultSyslogFacility
+	}
+	priority, ok := syslogFacilities[facility]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility '%s'", facility)
+	}
+	if tag == "" {
+		tag = defaultSyslogTag
+	}
+	w, err := syslog.Dial(network, address, priority|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	options := handlerOptions(level)
+	options.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+		if len(groups) == 0 && (a.Key == slog.TimeKey || a.Key == slog.LevelKey) {
+			return slog.Attr{}
+		}
+		return a
+	}
+	inner := slog.NewTextHandler(buf, options)
+	return &SyslogHandler{mu: &sync.Mutex{}, buf: buf, inner: inner, w: w}, nil
+}
+func (h *SyslogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+func (h *SyslogHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.buf.Reset()
+	if err := h.inner.Handle(ctx, r); err != nil {
+		return err
+	}
+	msg := string(bytes.TrimRight(h.buf.Bytes(), "\n"))
+	switch {
+	case r.Level >= slog.LevelError:
+		return h.w.Err(msg)
+	case r.Level >= slog.LevelWarn:
+		return h.w.Warning(msg)
+	case r.Level >= slog.LevelInfo:
+		return h.w.Info(msg)
+	default:
+		return h.w.Debug(msg)
+	}
+}
+
+func (h *SyslogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &SyslogHandler{mu: h.mu, buf: h.buf, inner: h.inner.WithAttrs(attrs), w: h.w}
+}
+
+func (h *SyslogHandler) WithGroup(name string) slog.Handler {
+	return &SyslogHandler{mu: h.mu, buf: h.buf, inner: h.inner.WithGroup(name), w: h.w}
+}
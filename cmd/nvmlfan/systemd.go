@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	heartbeatMu sync.Mutex
+	heartbeats  = map[int]time.Time{}
+)
+
+// SdNotify sends state to systemd when running as Type=notify service, it
+// does nothing without NOTIFY_SOCKET.
+func SdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// Abstract namespace sockets are passed with @ prefix
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// Heartbeat marks that the control loop of GPU idx completed a cycle, the
+// first call registers the loop for watchdog.
+func Heartbeat(idx int) {
+	heartbeatMu.Lock()
+	defer heartbeatMu.Unlock()
+	heartbeats[idx] = time.Now()
+}
+
+// ForgetHeartbeats unregisters loops of all GPUs, e.g. when they are
+// rebuilt for GPUs which changed.
+func ForgetHeartbeats() {
+	heartbeatMu.Lock()
+	defer heartbeatMu.Unlock()
+	heartbeats = map[int]time.Time{}
+}
+
+// stalledLoop returns a GPU whose loop didn't cycle within timeout, or -1.
+func stalledLoop(timeout time.Duration) int {
+	heartbeatMu.Lock()
+	defer heartbeatMu.Unlock()
+	for idx, last := range heartbeats {
+		if time.Since(last) > timeout {
+			return idx
+		}
+	}
+	return -1
+}
+
+// watchdogInterval returns watchdog timeout requested by systemd for this process.
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// StartWatchdog pets systemd watchdog at half of its timeout while all
+// control loops keep cycling. A loop stuck in NVML call stops the petting,
+// so systemd restarts the service.
+func StartWatchdog() {
+	timeout := watchdogInterval()
+	if timeout == 0 {
+		return
+	}
+	// A cycle can't take less than the period, don't report it as stalled
+	stall := max(timeout, 2*time.Duration(conf.Period)*time.Second)
+	controllerLog.Debug("Systemd watchdog enabled", "timeout", timeout)
+	go func() {
+		defer RestoreOnPanic()
+		stalled := -1
+		for range time.Tick(timeout / 2) {
+			idx := stalledLoop(stall)
+			if idx >= 0 {
+				if idx != stalled {
+					controllerLog.Error("Control loop stalled, not petting watchdog", "GPU", idx)
+				}
+				stalled = idx
+				continue
+			}
+			stalled = -1
+			if err := SdNotify("WATCHDOG=1"); err != nil {
+				controllerLog.Error("Can't notify systemd", "error", err)
+			}
+		}
+	}()
+}
+
+// NotifyReady tells systemd that startup is complete.
+func NotifyReady(status string) {
+	if err := SdNotify(fmt.Sprintf("READY=1\nSTATUS=%s", status)); err != nil {
+		controllerLog.Error("Can't notify systemd", "error", err)
+	}
+}
+
+// listenFdsStart is the first file descriptor passed by socket activation.
+const listenFdsStart = 3
+
+// ActivationListener returns the socket passed by systemd socket activation,
+// nil when the process wasn't socket activated.
+func ActivationListener() (net.Listener, error) {
+	// Supervisor passes its sockets to the controller
+	pid := os.Getpid()
+	if Supervised() {
+		pid = os.Getppid()
+	}
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(pid) {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 1 {
+		return nil, nil
+	}
+	// Children must not think sockets are passed to them
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if count > 1 {
+		controllerLog.Warn("Only the first activated socket is used", "count", count)
+	}
+	syscall.CloseOnExec(listenFdsStart)
+	file := os.NewFile(listenFdsStart, "LISTEN_FD_3")
+	defer file.Close()
+	return net.FileListener(file)
+}
+
+// activationFiles returns sockets passed by socket activation, the
+// supervisor keeps them open for every controller it starts.
+func activationFiles() []*os.File {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 1 {
+		return nil
+	}
+	files := make([]*os.File, count)
+	for i := range files {
+		files[i] = os.NewFile(uintptr(listenFdsStart+i), fmt.Sprintf("LISTEN_FD_%d", listenFdsStart+i))
+	}
+	return files
+}
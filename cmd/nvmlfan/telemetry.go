@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/IvanBayan/nvmlfan/internal/gpu"
+	"github.com/IvanBayan/nvmlfan/internal/telemetry"
+)
+
+var sinks []telemetry.Sink
+
+var (
+	throttleMu    sync.Mutex
+	lastThrottled = map[int]bool{}
+)
+
+var (
+	snapshotsMu sync.Mutex
+	snapshots   = map[int]gpu.Snapshot{}
+)
+
+func ConfigureTelemetry() {
+	if conf.Telemetry == nil {
+		return
+	}
+	switch conf.Telemetry.Type {
+	case "csv":
+		path := conf.Telemetry.Path
+		if path == "" {
+			path = defaultTelemetryPath
+		}
+		path = absPath(path)
+		sink, err := telemetry.NewCSVSink(path, conf.Telemetry.MaxSize, conf.Telemetry.MaxFiles)
+		if err != nil {
+			telemetryLog.Error("Can't open telemetry file", "path", path, "error", err)
+			return
+		}
+		sinks = append(sinks, sink)
+		telemetryLog.Debug("CSV telemetry configured", "path", path)
+	default:
+		telemetryLog.Warn("Invalid telemetry type, telemetry disabled.", "type", conf.Telemetry.Type)
+	}
+}
+
+// RecordSnapshot keeps the latest snapshot of its GPU for the API and
+// metrics.
+func RecordSnapshot(s gpu.Snapshot) {
+	snapshotsMu.Lock()
+	defer snapshotsMu.Unlock()
+	snapshots[s.GPU] = s
+}
+
+// ForgetSnapshots drops snapshots of all GPUs, their indexes changed.
+func ForgetSnapshots() {
+	snapshotsMu.Lock()
+	defer snapshotsMu.Unlock()
+	snapshots = map[int]gpu.Snapshot{}
+}
+
+// Snapshots returns the latest snapshot of every polled GPU by index.
+func Snapshots() []gpu.Snapshot {
+	snapshotsMu.Lock()
+	defer snapshotsMu.Unlock()
+	result := make([]gpu.Snapshot, 0, len(snapshots))
+	for _, s := range snapshots {
+		result = append(result, s)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].GPU < result[j].GPU })
+	return result
+}
+
+// RecordTelemetry reports one control cycle of a GPU to the configured
+// sinks. Start and end of thermal throttling are recorded as events even
+// without sinks.
+func RecordTelemetry(snapshot gpu.Snapshot, output, maxSpeed int) {
+	idx, temp := snapshot.GPU, snapshot.Temp
+	throttleMu.Lock()
+	if snapshot.Throttled != lastThrottled[idx] {
+		if snapshot.Throttled {
+			RecordEvent(idx, "throttle", fmt.Sprintf("Thermal throttling started at %d°C", temp))
+		} else {
+			ResolveEvent(idx, "throttle", fmt.Sprintf("Thermal throttling stopped at %d°C", temp))
+		}
+		lastThrottled[idx] = snapshot.Throttled
+	}
+	throttleMu.Unlock()
+
+	if len(sinks) == 0 {
+		return
+	}
+	id := gpu.GetDeviceIdentity(idx)
+	sample := telemetry.Sample{
+		Time:      snapshot.Time,
+		GPU:       idx,
+		UUID:      id.UUID,
+		Name:      id.Name,
+		Temp:      temp,
+		Speed:     snapshot.Speed,
+		Output:    output,
+		MaxFan:    output >= maxSpeed,
+		Throttled: snapshot.Throttled,
+		Power:     snapshot.Power,
+		Graphics:  snapshot.Graphics,
+		Memory:    snapshot.Memory,
+	}
+	for _, sink := range sinks {
+		sink.Record(sample)
+	}
+}
+
+// LogStatus writes a compact info line about a GPU every conf.StatusEvery cycles,
+// so normal operation is visible without debug logging.
+func LogStatus(snapshot gpu.Snapshot, cycle, output int, mode string) {
+	if conf.StatusEvery <= 0 || cycle%conf.StatusEvery != 0 {
+		return
+	}
+	telemetryLog.Info("GPU status", "GPU", snapshot.GPU, "temp", snapshot.Temp, "fan", output, "mode", mode,
+		"power", snapshot.Power)
+}
+
+// FlushTelemetry writes out data buffered by sinks, so it survives a
+// shutdown which doesn't complete.
+func FlushTelemetry() {
+	for _, sink := range sinks {
+		if f, ok := sink.(interface{ Flush() }); ok {
+			f.Flush()
+		}
+	}
+}
+
+func CloseTelemetry() {
+	for _, sink := range sinks {
+		sink.Close()
+	}
+	sinks = nil
+}
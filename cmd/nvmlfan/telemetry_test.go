@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IvanBayan/nvmlfan/internal/clock"
+	"github.com/IvanBayan/nvmlfan/internal/config"
+	"github.com/IvanBayan/nvmlfan/internal/gpu"
+	"github.com/IvanBayan/nvmlfan/internal/notify"
+	"github.com/IvanBayan/nvmlfan/internal/sim"
+	"github.com/IvanBayan/nvmlfan/internal/telemetry"
+)
+
+// recorder is a notification sink keeping events it was sent.
+type recorder struct {
+	mu     sync.Mutex
+	events []telemetry.Event
+}
+
+func (r *recorder) Send(ctx context.Context, e telemetry.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+	return nil
+}
+
+func (r *recorder) sent() []telemetry.Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]telemetry.Event(nil), r.events...)
+}
+
+func TestThrottleEventsWithoutSinks(t *testing.T) {
+	savedSinks, savedNotifier, savedClock := sinks, notifier, daemonClock
+	t.Cleanup(func() {
+		sinks, notifier, daemonClock = savedSinks, savedNotifier, savedClock
+		gpu.ResetCache()
+		throttleMu.Lock()
+		clear(lastThrottled)
+		throttleMu.Unlock()
+	})
+	sinks = nil
+	clk := clock.NewManual(simStart)
+	daemonClock = clk
+	gpu.Use("sim", sim.New(config.SimConfig{}, clk))
+	if err := gpu.InitNVML(); err != nil {
+		t.Fatal(err)
+	}
+	rec := &recorder{}
+	notifier = notify.New(notify.Policy{})
+	notifier.Add("test", rec, []string{"throttle"}, 0)
+
+	for _, throttled := range []bool{false, true, true, false} {
+		RecordTelemetry(gpu.Snapshot{GPU: 0, Temp: 90, Throttled: throttled}, 100, 100)
+	}
+	notifier.Close(time.Second)
+	events := rec.sent()
+	if len(events) != 2 || events[0].Resolved || !events[1].Resolved {
+		t.Fatalf("events %+v, want throttling started and stopped", events)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/IvanBayan/nvmlfan/internal/config"
+	"github.com/IvanBayan/nvmlfan/internal/gpu"
+)
+
+// thresholdHook is a configured threshold hook with what it watches.
+type thresholdHook struct {
+	config.ThresholdHook
+	event string // Configuration key, e.g. on_temp_above.
+	fan   bool   // Watches average fan speed instead of temperature.
+	above bool
+}
+
+// thresholdHooks returns threshold hooks of card in order.
+func thresholdHooks(card config.GPUConfig) []thresholdHook {
+	var hooks []thresholdHook
+	for _, kind := range []struct {
+		event      string
+		fan, above bool
+		hooks      []config.ThresholdHook
+	}{
+		{"on_temp_above", false, true, card.OnTempAbove},
+		{"on_temp_below", false, false, card.OnTempBelow},
+		{"on_fan_above", true, true, card.OnFanAbove},
+		{"on_fan_below", true, false, card.OnFanBelow},
+	} {
+		for _, h := range kind.hooks {
+			hooks = append(hooks, thresholdHook{h, kind.event, kind.fan, kind.above})
+		}
+	}
+	return hooks
+}
+
+// checkThresholds runs commands of threshold hooks of the card c whose
+// value in snapshot crossed the threshold and stayed past it for their
+// duration. A hook is armed again once the value was back as long. The
+// first snapshot only sets the state, a value past a threshold from the
+// start isn't a crossing.
+func (c *cardControl) checkThresholds(snapshot gpu.Snapshot) {
+	hooks := thresholdHooks(c.settings)
+	if len(hooks) == 0 {
+		return
+	}
+	first := len(c.thresholds) != len(hooks)
+	if first {
+		c.thresholds = make([]ruleState, len(hooks))
+	}
+	for i, h := range hooks {
+		value := snapshot.Temp
+		if h.fan {
+			value = snapshot.Speed
+		}
+		past := value < h.Threshold
+		if h.above {
+			past = value > h.Threshold
+		}
+		st := &c.thresholds[i]
+		if first {
+			st.holds, st.since, st.active = past, snapshot.Time, past
+			continue
+		}
+		if past != st.holds {
+			st.holds, st.since = past, snapshot.Time
+		}
+		if st.holds == st.active || snapshot.Time.Sub(st.since) < h.For {
+			continue
+		}
+		st.active = st.holds
+		if !st.active {
+			continue
+		}
+		c.logger.Info("Threshold crossed, running hook", "hook", h.event, "threshold", h.Threshold, "value", value, "command", h.Exec)
+		RecordEvent(c.idx, "threshold", fmt.Sprintf("%s %d crossed at %d", h.event, h.Threshold, value))
+		env := []string{
+			"NVMLFAN_GPU=" + strconv.Itoa(c.idx),
+			"NVMLFAN_UUID=" + c.uuid,
+			"NVMLFAN_HOOK=" + h.event,
+			"NVMLFAN_THRESHOLD=" + strconv.Itoa(h.Threshold),
+			"NVMLFAN_TEMP=" + strconv.Itoa(snapshot.Temp),
+			"NVMLFAN_FAN=" + strconv.Itoa(snapshot.Speed),
+		}
+		// The control loop keeps running while the command does
+		go func(command []string) {
+			defer RestoreOnPanic()
+			out, err := runCommand(command, env, defaultHookTimeout)
+			if err != nil {
+				c.logger.Warn("Hook failed", "hook", h.event, "command", command, "error", err, "output", out)
+				return
+			}
+			c.logger.Debug("Hook finished", "hook", h.event, "command", command, "output", out)
+		}(h.Exec)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/IvanBayan/nvmlfan/internal/gpu"
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+// verifyFan commands speed on one fan and waits up to settle for the
+// measured speed to reach it within tolerance.
+func verifyFan(device nvml.Device, idx, fi, speed, tolerance int, settle time.Duration) error {
+	if ret := gpu.SetSingleFanSpeed(idx, fi, speed); ret != nvml.SUCCESS {
+		return fmt.Errorf("SetFanSpeed_v2 failed: %v", nvml.ErrorString(ret))
+	}
+	target, ret := device.GetTargetFanSpeed(fi)
+	if ret != nvml.SUCCESS {
+		return fmt.Errorf("GetTargetFanSpeed failed: %v", nvml.ErrorString(ret))
+	}
+	if target != speed {
+		return fmt.Errorf("target speed is %d%% instead of commanded %d%%, the card ignores manual control", target, speed)
+	}
+	policy, ret := device.GetFanControlPolicy_v2(fi)
+	if ret == nvml.SUCCESS && policy != nvml.FAN_POLICY_MANUAL {
+		return fmt.Errorf("fan policy is %s after setting speed", gpu.FanPolicyName(policy))
+	}
+
+	deadline := time.Now().Add(settle)
+	var measured uint32
+	for {
+		measured, ret = device.GetFanSpeed_v2(fi)
+		if ret != nvml.SUCCESS {
+			return fmt.Errorf("GetFanSpeed_v2 failed: %v", nvml.ErrorString(ret))
+		}
+		if diff := int(measured) - speed; diff >= -tolerance && diff <= tolerance {
+			fmt.Printf("  fan %d reached %d%% (commanded %d%%)\n", fi, measured, speed)
+			return nil
+		}
+		if time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	return fmt.Errorf("measured speed is %d%% after %v, commanded %d%%", measured, settle, speed)
+}
+
+// VerifyCommand checks that a GPU actually honors manual fan control: every
+// fan is driven to test speeds, target and measured speeds are read back,
+// then default control is restored.
+func VerifyCommand(args []string) int {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	idx := fs.Int("gpu", -1, "GPU index")
+	settle := fs.Duration("settle", 10*time.Second, "How long to wait for fans to reach test speed")
+	tolerance := fs.Int("tolerance", 10, "Allowed difference between commanded and measured speed")
+	configPath := fs.String("config", defaultConfigPath, "Configuration with GPUs to exclude")
+	fs.Parse(args)
+
+	if *idx < 0 {
+		fmt.Fprintln(os.Stderr, "-gpu is required")
+		fs.Usage()
+		return 2
+	}
+	if err := loadExclude(fs, *configPath); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := gpu.InitNVML(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer gpu.Shutdown()
+	if *idx >= gpu.GetDeviceCount() {
+		fmt.Fprintf(os.Stderr, "GPU %d not found\n", *idx)
+		return 1
+	}
+	if err := checkExcluded(*idx); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	// Never leave fans at a test speed, even when interrupted
+	defer gpu.DefaultFansSpeed(*idx)
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-stop
+		gpu.DefaultFansSpeed(*idx)
+		os.Exit(1)
+	}()
+
+	device, err := gpu.DeviceGetHandleByIndex(*idx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	minSpeed, maxSpeed := gpu.GetMinMaxFanSpeed(device)
+	// Two speeds far apart, so the fan has to move whatever speed it had
+	speeds := []int{minSpeed + (maxSpeed-minSpeed)*3/4, minSpeed + (maxSpeed-minSpeed)/4}
+	failed := 0
+	for fi := 0; fi < gpu.GetNumFans(*idx); fi++ {
+		for _, speed := range speeds {
+			if err := verifyFan(device, *idx, fi, speed, *tolerance, *settle); err != nil {
+				fmt.Printf("[FAIL] GPU %d fan %d: %v\n", *idx, fi, err)
+				failed++
+				break
+			}
+		}
+		if ret := gpu.SetSingleFanDefault(*idx, fi); ret != nvml.SUCCESS {
+			fmt.Printf("[FAIL] GPU %d fan %d: can't restore default control: %v\n", *idx, fi, nvml.ErrorString(ret))
+			failed++
+			continue
+		}
+		fmt.Printf("[ OK ] GPU %d fan %d: default control restored\n", *idx, fi)
+	}
+	if failed > 0 {
+		fmt.Printf("GPU %d doesn't honor manual fan control reliably, don't use it with nvmlfan.\n", *idx)
+		return 1
+	}
+	fmt.Printf("GPU %d honors manual fan control.\n", *idx)
+	return 0
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+// Set at build time with -ldflags "-X main.version=... -X main.commit=...",
+// otherwise taken from module and VCS information embedded by go build.
+var (
+	version = ""
+	commit  = ""
+)
+
+// BuildVersion returns version and commit of the binary and version of go-nvml it's linked with.
+func BuildVersion() (string, string, string) {
+	ver, rev, nvmlVer := version, commit, "unknown"
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ver, rev, nvmlVer
+	}
+	if ver == "" {
+		ver = info.Main.Version
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" && rev == "" {
+			rev = setting.Value
+		}
+		if setting.Key == "vcs.modified" && setting.Value == "true" && rev != "" && commit == "" {
+			rev += "-dirty"
+		}
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == "github.com/NVIDIA/go-nvml" {
+			nvmlVer = dep.Version
+		}
+	}
+	return ver, rev, nvmlVer
+}
+
+func VersionCommand(args []string) int {
+	ver, rev, nvmlVer := BuildVersion()
+	if ver == "" {
+		ver = "unknown"
+	}
+	if rev == "" {
+		rev = "unknown"
+	}
+	fmt.Printf("nvmlfan:  %s (commit %s)\n", ver, rev)
+	fmt.Printf("Go:       %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	fmt.Printf("go-nvml:  %s\n", nvmlVer)
+	// Runtime versions are best effort, version must work on broken systems too
+	if ret := nvml.Init(); ret != nvml.SUCCESS {
+		fmt.Printf("Driver:   unavailable (%v)\n", nvml.ErrorString(ret))
+		return 0
+	}
+	defer nvml.Shutdown()
+	if driver, ret := nvml.SystemGetDriverVersion(); ret == nvml.SUCCESS {
+		fmt.Printf("Driver:   %s\n", driver)
+	}
+	if nvmlVersion, ret := nvml.SystemGetNVMLVersion(); ret == nvml.SUCCESS {
+		fmt.Printf("NVML:     %s\n", nvmlVersion)
+	}
+	if cuda, ret := nvml.SystemGetCudaDriverVersion_v2(); ret == nvml.SUCCESS {
+		fmt.Printf("CUDA:     %d.%d\n", cuda/1000, cuda%1000/10)
+	}
+	return 0
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/IvanBayan/nvmlfan/internal/gpu"
+)
+
+// Controllers which panic or stop cycling are restarted, a card whose
+// controller fails more than maxControllerRestarts times, or can't be built
+// again, is left at its failsafe speed.
+const (
+	maxControllerRestarts = 3
+	defaultFailsafeSpeed  = 100
+)
+
+// failsafeSpeed returns the fan speed of GPU idx whose controller is dead.
+func failsafeSpeed(idx int) int {
+	if card, ok := CardConfig(conf, idx); ok && card.FailsafeSpeed > 0 {
+		return card.FailsafeSpeed
+	}
+	return defaultFailsafeSpeed
+}
+
+// restartCard returns a new controller replacing c, which died for reason.
+// The error is only returned when even the failsafe speed can't be set.
+func restartCard(c *cardControl, reason string) (*cardControl, error) {
+	restarts := c.restarts + 1
+	c.logger.Error("Controller "+reason+", restarting it", "restarts", restarts)
+	RecordEvent(c.idx, "watchdog", "Controller "+reason)
+	if restarts > maxControllerRestarts {
+		return failsafeCard(c, fmt.Errorf("controller %s %d times", reason, restarts))
+	}
+	fresh, err := newCardControl(c.idx, c.mode)
+	if err != nil {
+		return failsafeCard(c, err)
+	}
+	fresh.restarts = restarts
+	goroutineRestarts.Add(1)
+	return fresh, nil
+}
+
+// failsafeCard sets fans of the card c to failsafe speed and returns a
+// controller which does nothing but keep the watchdog of systemd happy.
+func failsafeCard(c *cardControl, cause error) (*cardControl, error) {
+	failed := &cardControl{idx: c.idx, uuid: c.uuid, mode: c.mode, logger: c.logger, restarts: c.restarts, failed: true}
+	if c.mode == "monitor" {
+		c.logger.Error("GPU is no longer monitored", "error", cause)
+		SetHealth(c.idx, HealthDegraded, cause.Error())
+		return failed, nil
+	}
+	speed := failsafeSpeed(c.idx)
+	c.logger.Error("Controller can't be restarted, setting failsafe speed", "error", cause, "speed", speed)
+	RecordEvent(c.idx, "watchdog", fmt.Sprintf("Controller can't be restarted (%v), fans set to %d%%", cause, speed))
+	if err := gpu.SetFanSpeed(c.idx, speed); err != nil {
+		return nil, fmt.Errorf("GPU %d: can't set failsafe speed: %w", c.idx, err)
+	}
+	SetHealth(c.idx, HealthFailsafe, cause.Error())
+	return failed, nil
+}
+
+// watch replaces the loop of s when one of its phases doesn't end in time.
+// A hung NVML call can't be the cause, the broker gives up on those sooner
+// and shuts the daemon down.
+func (s *scheduler) watch(parent, ctx context.Context) {
+	stall := gpu.CallTimeout + 3*time.Duration(conf.Period)*time.Second
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		started := s.phaseStart.Load()
+		if started == 0 || time.Since(time.Unix(0, started)) < stall {
+			continue
+		}
+		// The stuck goroutine exits once its call returns
+		s.cancel()
+		next, err := s.successor(int(s.current.Load()))
+		if err != nil {
+			Fail(err)
+			return
+		}
+		next.start(parent)
+		return
+	}
+}
+
+// successor returns a scheduler with new controllers of the cards and
+// outputs of s, whose loop got stuck in the phase of card stuck, or of hwmon
+// outputs when it is negative.
+func (s *scheduler) successor(stuck int) (*scheduler, error) {
+	next := &scheduler{restarts: s.restarts}
+	if stuck < 0 {
+		next.restarts++
+		controllerLog.Error("Control loop stalled in hwmon outputs, restarting it", "restarts", next.restarts)
+		if next.restarts > maxControllerRestarts {
+			return nil, fmt.Errorf("control loop stalled in hwmon outputs %d times", next.restarts)
+		}
+		goroutineRestarts.Add(1)
+	}
+	for i, c := range s.cards {
+		var card *cardControl
+		var err error
+		switch {
+		case i == stuck:
+			card, err = restartCard(c, "stalled")
+		case c.failed:
+			card = &cardControl{idx: c.idx, uuid: c.uuid, mode: c.mode, logger: c.logger, restarts: c.restarts, failed: true}
+		default:
+			if card, err = newCardControl(c.idx, c.mode); err != nil {
+				card, err = failsafeCard(c, err)
+			} else {
+				card.restarts = c.restarts
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		next.cards = append(next.cards, card)
+	}
+	next.outputs = hwmonControls()
+	return next, nil
+}
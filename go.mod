@@ -1,8 +1,11 @@
-module nvmlfan
+module github.com/IvanBayan/nvmlfan
 
 go 1.22.10
 
 require (
 	github.com/NVIDIA/go-nvml v0.12.4-0
+	go.etcd.io/bbolt v1.3.11
 	gopkg.in/yaml.v3 v3.0.1
 )
+
+require golang.org/x/sys v0.4.0
@@ -0,0 +1,169 @@
+// Package clock abstracts time for control loops and simulated GPUs, so
+// simulations can run faster than real time and timing can be controlled
+// in tests.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells time and makes tickers.
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker delivers ticks on C every period, like time.Ticker it drops ticks
+// for slow receivers instead of drifting.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real is the wall clock.
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
+
+// Scaled returns a clock which starts at the current time and runs speed
+// times faster than real time.
+func Scaled(speed float64) Clock {
+	now := time.Now()
+	return &scaledClock{origin: now, speed: speed}
+}
+
+type scaledClock struct {
+	origin time.Time
+	speed  float64
+}
+
+func (c *scaledClock) Now() time.Time {
+	return c.scale(time.Now())
+}
+
+func (c *scaledClock) scale(t time.Time) time.Time {
+	return c.origin.Add(time.Duration(float64(t.Sub(c.origin)) * c.speed))
+}
+
+func (c *scaledClock) NewTicker(d time.Duration) Ticker {
+	t := &scaledTicker{
+		ticker: time.NewTicker(time.Duration(float64(d) / c.speed)),
+		c:      make(chan time.Time, 1),
+		done:   make(chan struct{}),
+	}
+	go func() {
+		for {
+			select {
+			case <-t.done:
+				return
+			case tick := <-t.ticker.C:
+				select {
+				case t.c <- c.scale(tick):
+				default:
+				}
+			}
+		}
+	}()
+	return t
+}
+
+type scaledTicker struct {
+	ticker *time.Ticker
+	c      chan time.Time
+	done   chan struct{}
+	once   sync.Once
+}
+
+func (t *scaledTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *scaledTicker) Stop() {
+	t.once.Do(func() {
+		t.ticker.Stop()
+		close(t.done)
+	})
+}
+
+// Manual is a clock which only moves when Advance is called, tickers fire
+// for every period passed.
+type Manual struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*manualTicker
+}
+
+// NewManual returns a manual clock set to start.
+func NewManual(start time.Time) *Manual {
+	return &Manual{now: start}
+}
+
+func (m *Manual) Now() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.now
+}
+
+func (m *Manual) NewTicker(d time.Duration) Ticker {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := &manualTicker{clock: m, period: d, next: m.now.Add(d), c: make(chan time.Time, 1)}
+	m.tickers = append(m.tickers, t)
+	return t
+}
+
+// Advance moves the clock forward by d and fires due tickers.
+func (m *Manual) Advance(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.now = m.now.Add(d)
+	for _, t := range m.tickers {
+		for !t.next.After(m.now) {
+			select {
+			case t.c <- t.next:
+			default:
+			}
+			t.next = t.next.Add(t.period)
+		}
+	}
+}
+
+type manualTicker struct {
+	clock  *Manual
+	period time.Duration
+	next   time.Time
+	c      chan time.Time
+}
+
+func (t *manualTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *manualTicker) Stop() {
+	m := t.clock
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, other := range m.tickers {
+		if other == t {
+			m.tickers = append(m.tickers[:i], m.tickers[i+1:]...)
+			break
+		}
+	}
+}
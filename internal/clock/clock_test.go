@@ -0,0 +1,86 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+var start = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// tick returns the pending tick of t, zero when there is none.
+func tick(t Ticker) time.Time {
+	select {
+	case tick := <-t.C():
+		return tick
+	default:
+		return time.Time{}
+	}
+}
+
+func TestManualTicker(t *testing.T) {
+	clk := NewManual(start)
+	ticker := clk.NewTicker(time.Second)
+	tests := []struct {
+		name    string
+		advance time.Duration
+		want    time.Time // Zero for no tick.
+	}{
+		{"not due", 500 * time.Millisecond, time.Time{}},
+		{"due", 500 * time.Millisecond, start.Add(time.Second)},
+		{"due again", time.Second, start.Add(2 * time.Second)},
+		{"slow receiver gets the first missed tick", 3 * time.Second, start.Add(3 * time.Second)},
+		{"missed ticks dropped", 0, time.Time{}},
+		{"period kept", time.Second, start.Add(6 * time.Second)},
+	}
+	for _, tt := range tests {
+		clk.Advance(tt.advance)
+		if got := tick(ticker); !got.Equal(tt.want) {
+			t.Errorf("%s: tick %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if now := clk.Now(); !now.Equal(start.Add(6 * time.Second)) {
+		t.Errorf("now %v, want %v", now, start.Add(6*time.Second))
+	}
+
+	ticker.Stop()
+	clk.Advance(time.Minute)
+	if got := tick(ticker); !got.IsZero() {
+		t.Errorf("stopped ticker ticked at %v", got)
+	}
+}
+
+func TestManualTickersIndependent(t *testing.T) {
+	clk := NewManual(start)
+	fast, slow := clk.NewTicker(time.Second), clk.NewTicker(3*time.Second)
+	fastTicks, slowTicks := 0, 0
+	for range 6 {
+		clk.Advance(time.Second)
+		if !tick(fast).IsZero() {
+			fastTicks++
+		}
+		if !tick(slow).IsZero() {
+			slowTicks++
+		}
+	}
+	if fastTicks != 6 || slowTicks != 2 {
+		t.Errorf("%d and %d ticks, want 6 and 2", fastTicks, slowTicks)
+	}
+}
+
+func TestScaled(t *testing.T) {
+	clk := Scaled(100)
+	begin := clk.Now()
+	ticker := clk.NewTicker(time.Second)
+	defer ticker.Stop()
+	select {
+	case tick := <-ticker.C():
+		if elapsed := tick.Sub(begin); elapsed < 900*time.Millisecond {
+			t.Errorf("tick after %v of scaled time, want a second", elapsed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no tick within a second of real time")
+	}
+	if elapsed := clk.Now().Sub(begin); elapsed < time.Second {
+		t.Errorf("%v of scaled time passed, want at least a second", elapsed)
+	}
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"path"
+	"strconv"
+)
+
+// CardKey returns the key of GPU idx in cards configuration. Cards can be
+// keyed by UUID, by index or by a glob matched against the product name.
+// UUID wins since it survives changes of enumeration order, then index, then
+// the longest matching name pattern, so "*" can serve as a default.
+func (cfg Config) CardKey(idx int, uuid, name string) (string, bool) {
+	if cfg.Excluded(idx, uuid, name) {
+		return "", false
+	}
+	if uuid != "" {
+		if _, ok := cfg.Cards[uuid]; ok {
+			return uuid, true
+		}
+	}
+	key := strconv.Itoa(idx)
+	if _, ok := cfg.Cards[key]; ok {
+		return key, true
+	}
+	best := ""
+	for pattern := range cfg.Cards {
+		if matched, _ := path.Match(pattern, name); !matched || name == "" {
+			continue
+		}
+		if len(pattern) > len(best) || len(pattern) == len(best) && pattern < best {
+			best = pattern
+		}
+	}
+	return best, best != ""
+}
+
+// Excluded reports whether GPU idx is in the exclude list, such cards are
+// passed through to VMs or managed by other software.
+func (cfg Config) Excluded(idx int, uuid, name string) bool {
+	for _, item := range cfg.Exclude {
+		if item == strconv.Itoa(idx) || item == uuid {
+			return true
+		}
+		if matched, _ := path.Match(item, name); matched && name != "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,455 @@
+// Package config describes nvmlfan configuration file.
+package config
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Exit behaviors of fans on shutdown.
+const (
+	ExitAuto  = "auto"  // Driver controls fans again, the default.
+	ExitHold  = "hold"  // Keep the last commanded speed.
+	ExitFixed = "fixed" // Set exit_speed.
+)
+
+// GPUConfig holds the configuration for a single GPU card.
+type GPUConfig struct {
+	Mode      string    `yaml:"mode" json:"mode"`             // Control mode (e.g., "curve" or "target").
+	Target    int       `yaml:"target" json:"target"`         // Target temperature for PID control.
+	PID       []float64 `yaml:"pid" json:"pid"`               // PID control coefficients [Kp, Ki, Kd].
+	Curve     [][2]int  `yaml:"curve" json:"curve"`           // Fan curve
+	Preset    string    `yaml:"preset" json:"preset"`         // Built-in curve scaled to the card, instead of curve.
+	OnExit    string    `yaml:"on_exit" json:"on_exit"`       // What fans do on shutdown: auto, hold or fixed.
+	ExitSpeed int       `yaml:"exit_speed" json:"exit_speed"` // Fan speed for on_exit: fixed.
+	// Fan speed when the controller dies and can't be restarted, 100 by default.
+	FailsafeSpeed int `yaml:"failsafe_speed" json:"failsafe_speed"`
+	// Control fans another program had set to manual mode, they are left alone otherwise.
+	TakeOver bool `yaml:"take_over" json:"take_over"`
+	// Lowest fan speed while compute processes run on the card, whatever its temperature.
+	BusyFloor int `yaml:"busy_floor" json:"busy_floor"`
+	// Fans of cards sharing a group follow the controller output of the hottest of them.
+	Group string `yaml:"group" json:"group"`
+	// Control settings replaced while the card is in a performance state, keyed like "P8".
+	PStates map[string]GPUConfig `yaml:"pstates" json:"pstates"`
+	// Control settings replaced by sustained utilization of the card.
+	Utilization *UtilizationBands `yaml:"utilization_bands" json:"utilization_bands"`
+	// Lower the power limit when fans at maximum can't hold the temperature.
+	PowerLimit *PowerLimitConfig `yaml:"power_limit" json:"power_limit"`
+	// Cap clocks when fans at maximum can't hold the temperature.
+	ClockCap *ClockCapConfig `yaml:"clock_cap" json:"clock_cap"`
+	// Act when fans at maximum can't keep the card below a critical temperature.
+	Emergency *EmergencyConfig `yaml:"emergency" json:"emergency"`
+	// Commands run before fans of the card are taken under control and after they were given back.
+	Hooks *HooksConfig `yaml:"hooks" json:"hooks"`
+	// Commands run when temperature or average fan speed crosses a threshold.
+	OnTempAbove []ThresholdHook `yaml:"on_temp_above" json:"on_temp_above"`
+	OnTempBelow []ThresholdHook `yaml:"on_temp_below" json:"on_temp_below"`
+	OnFanAbove  []ThresholdHook `yaml:"on_fan_above" json:"on_fan_above"`
+	OnFanBelow  []ThresholdHook `yaml:"on_fan_below" json:"on_fan_below"`
+}
+
+// ThresholdHook runs Exec once a value crossed Threshold and stayed past it
+// for For.
+type ThresholdHook struct {
+	Threshold int           `yaml:"threshold" json:"threshold"` // °C or fan speed in percent.
+	Exec      []string      `yaml:"exec" json:"exec"`           // Command and its arguments.
+	For       time.Duration `yaml:"for" json:"for"`             // Debounce of crossings both ways, none by default.
+}
+
+// PowerLimitConfig lowers the power limit of a card in steps while its fans
+// run at maximum speed and it stays above ceiling, and raises it back once
+// it cooled down to recover.
+type PowerLimitConfig struct {
+	Ceiling int           `yaml:"ceiling" json:"ceiling"` // Temperature in °C.
+	After   time.Duration `yaml:"after" json:"after"`     // How long a state lasts before each step, 30s by default.
+	Step    int           `yaml:"step" json:"step"`       // Watts, 10% of the default limit by default.
+	Min     int           `yaml:"min" json:"min"`         // Lowest limit in watts, the lowest the card allows by default.
+	Recover int           `yaml:"recover" json:"recover"` // Temperature in °C, 5 below ceiling by default.
+}
+
+// UtilizationBands choose control settings by utilization averaged over
+// window, the first band above it applies.
+type UtilizationBands struct {
+	Window time.Duration     `yaml:"window" json:"window"` // 1m by default.
+	Bands  []UtilizationBand `yaml:"bands" json:"bands"`
+}
+
+// UtilizationBand replaces control settings while utilization is below
+// Below, the last band may leave it unset to cover the rest.
+type UtilizationBand struct {
+	Below     int `yaml:"below" json:"below"`
+	GPUConfig `yaml:",inline"`
+}
+
+type Config struct {
+	Foreground  bool                 `yaml:"foreground" json:"foreground"`
+	Verbosity   int                  `yaml:"verbosity" json:"verbosity"` // 1 forces debug level, 2 also adds source locations.
+	Period      int                  `yaml:"period" json:"period"`
+	Cards       map[string]GPUConfig `yaml:"cards" json:"cards"` // Keyed by GPU index or UUID.
+	Logging     LoggingConfig        `yaml:"logging" json:"logging"`
+	LogLevels   LogLevelsConfig      `yaml:"log_levels" json:"log_levels"`
+	Telemetry   *TelemetryConfig     `yaml:"telemetry" json:"telemetry"`
+	Stats       *StatsConfig         `yaml:"stats" json:"stats"`
+	Summary     SummaryConfig        `yaml:"summary" json:"summary"`
+	Metrics     *MetricsConfig       `yaml:"metrics" json:"metrics"`
+	API         *APIConfig           `yaml:"api" json:"api"`
+	StatusEvery int                  `yaml:"status_every" json:"status_every"` // Log GPU status at info level every N cycles.
+	Monitor     bool                 `yaml:"monitor" json:"monitor"`           // Only record telemetry, never take fan control.
+	Exclude     []string             `yaml:"exclude" json:"exclude"`           // GPUs by index, UUID or name glob which are never touched.
+	PidFile     string               `yaml:"pidfile" json:"pidfile"`           // Locked while running, so only one instance controls fans.
+	Sandbox     bool                 `yaml:"sandbox" json:"sandbox"`           // Restrict daemon with Landlock and seccomp.
+	Priority    *PriorityConfig      `yaml:"priority" json:"priority"`         // CPU scheduling of the daemon.
+	Supervisor  SupervisorConfig     `yaml:"supervisor" json:"supervisor"`
+	NVMLTimeout time.Duration        `yaml:"nvml_timeout" json:"nvml_timeout"` // NVML calls running longer are treated as hung.
+	Adaptive    *AdaptiveConfig      `yaml:"adaptive" json:"adaptive"`         // Poll cards faster or slower than period by thermal activity.
+	Idle        *IdleConfig          `yaml:"idle" json:"idle"`                 // Poll idle and cool cards rarely.
+	Backend     string               `yaml:"backend" json:"backend"`           // "nvml", "sim" for simulated GPUs or "replay".
+	Sim         SimConfig            `yaml:"sim" json:"sim"`
+	Replay      ReplayConfig         `yaml:"replay" json:"replay"`
+	Hwmon       []HwmonConfig        `yaml:"hwmon" json:"hwmon"` // PWM outputs driven by GPU or hwmon temperatures.
+	// Fan control through X server for GPUs whose fans NVML can't set.
+	NvidiaSettings *NvidiaSettingsConfig `yaml:"nvidia_settings" json:"nvidia_settings"`
+	// Check every N cycles that fans still have the commanded state, 60 by default.
+	ReassertEvery int `yaml:"reassert_every" json:"reassert_every"`
+	// Consecutive failed readings of a card before its fans get failsafe speed, 5 by default.
+	ErrorBudget int `yaml:"error_budget" json:"error_budget"`
+	// Named sets of card settings, activated by schedule or by processes running on cards.
+	Profiles map[string]ProfileConfig `yaml:"profiles" json:"profiles"`
+	Schedule *ScheduleConfig          `yaml:"schedule" json:"schedule"`
+	Activity *ActivityConfig          `yaml:"activity" json:"activity"`
+	// Conditions on card state activating profiles, e.g. "power > 250 && temp > 70 for 30s -> profile performance".
+	Rules []string `yaml:"rules" json:"rules"`
+	// Commands run before the daemon takes fans under control and after it gave them back.
+	Hooks *HooksConfig `yaml:"hooks" json:"hooks"`
+	// Alerts about events sent to webhooks and desktops.
+	Notify *NotifyConfig `yaml:"notify" json:"notify"`
+	// Fans of controlled cards run at maximum speed on a schedule.
+	Exercise *ExerciseConfig `yaml:"exercise" json:"exercise"`
+}
+
+// ExerciseConfig runs fans of every controlled card, one card after another,
+// at maximum speed for a while and checks that they reach it.
+type ExerciseConfig struct {
+	Cron      string        `yaml:"cron" json:"cron"`           // When to start, e.g. "0 4 * * sun".
+	Duration  time.Duration `yaml:"duration" json:"duration"`   // How long fans of a card run, 3m by default.
+	Settle    time.Duration `yaml:"settle" json:"settle"`       // Fans must reach the speed within it, 30s by default.
+	Tolerance int           `yaml:"tolerance" json:"tolerance"` // Allowed shortfall of measured speed behind the fastest fan of the card in percent, 10 by default.
+}
+
+// ProfileConfig replaces control settings of cards while it is active.
+type ProfileConfig struct {
+	// Keyed like cards, only mode, target, pid, curve and preset set here
+	// replace those of the card.
+	Cards map[string]GPUConfig `yaml:"cards" json:"cards"`
+}
+
+// ScheduleConfig activates profiles by local time, the first matching rule
+// wins and cards use their own settings when none matches.
+type ScheduleConfig struct {
+	Transition time.Duration  `yaml:"transition" json:"transition"` // Fan speeds blend into a new profile over it, 1m by default.
+	Rules      []ScheduleRule `yaml:"rules" json:"rules"`
+}
+
+// ScheduleRule activates a profile between two times on days of week, or
+// during every minute matching a cron expression.
+type ScheduleRule struct {
+	Profile string   `yaml:"profile" json:"profile"`
+	From    string   `yaml:"from" json:"from"` // e.g. "22:00"
+	To      string   `yaml:"to" json:"to"`     // e.g. "08:00", a rule ending before it starts spans midnight.
+	Days    []string `yaml:"days" json:"days"` // e.g. ["mon-fri"], every day when empty. Whole days without from and to.
+	Cron    string   `yaml:"cron" json:"cron"` // e.g. "* 9-17 * * mon-fri", replaces from, to and days.
+
+	parsed *schedule // Set by Load, nil for invalid rules.
+}
+
+// ActivityConfig switches profiles of every card by processes using it,
+// replacing settings of the scheduled profile.
+type ActivityConfig struct {
+	Rules    []ActivityRule `yaml:"rules" json:"rules"`         // Checked in order before load.
+	Load     string         `yaml:"load" json:"load"`           // Profile while processes matching no rule run on the card.
+	Idle     string         `yaml:"idle" json:"idle"`           // Profile without processes, own settings when empty.
+	CoolDown time.Duration  `yaml:"cool_down" json:"cool_down"` // A profile is left this long after its processes stopped, 2m by default.
+}
+
+// ActivityRule selects a profile by processes running on a card.
+type ActivityRule struct {
+	Process string `yaml:"process" json:"process"` // Regular expression matching process name or command line.
+	Only    bool   `yaml:"only" json:"only"`       // Every process of the card has to match, not just one.
+	Profile string `yaml:"profile" json:"profile"`
+}
+
+// ClockCapConfig locks clocks of a card below caps while its fans run at
+// maximum speed and it stays above ceiling, until it cooled down to recover.
+type ClockCapConfig struct {
+	Ceiling  int           `yaml:"ceiling" json:"ceiling"`   // Temperature in °C.
+	After    time.Duration `yaml:"after" json:"after"`       // How long a state lasts before clocks change, 30s by default.
+	Recover  int           `yaml:"recover" json:"recover"`   // Temperature in °C, 5 below ceiling by default.
+	Graphics int           `yaml:"graphics" json:"graphics"` // Highest graphics clock in MHz.
+	Memory   int           `yaml:"memory" json:"memory"`     // Highest memory clock in MHz.
+}
+
+// EmergencyConfig is what is done when a card reaches a critical
+// temperature with its fans at maximum speed. Every action is written to the
+// audit log.
+type EmergencyConfig struct {
+	Critical int           `yaml:"critical" json:"critical"`   // Temperature in °C.
+	Exec     []string      `yaml:"exec" json:"exec"`           // Command and its arguments to run.
+	Signal   string        `yaml:"signal" json:"signal"`       // Signal for compute processes of the card: TERM, INT or KILL.
+	Drain    bool          `yaml:"drain" json:"drain"`         // Mark the card drained until `nvmlfan undrain`.
+	CoolDown time.Duration `yaml:"cool_down" json:"cool_down"` // Least time between actions, 10m by default.
+	AuditLog string        `yaml:"audit_log" json:"audit_log"` // Path of the audit log, /var/log/nvmlfan-audit.log by default.
+}
+
+// HooksConfig are commands, each given with its arguments, run around fan
+// control.
+type HooksConfig struct {
+	PreTakeover []string      `yaml:"pre_takeover" json:"pre_takeover"`
+	PostRestore []string      `yaml:"post_restore" json:"post_restore"`
+	Timeout     time.Duration `yaml:"timeout" json:"timeout"` // A command is killed after it, 10s by default.
+}
+
+// NotifyConfig sends events to alert sinks.
+type NotifyConfig struct {
+	Webhooks []WebhookConfig `yaml:"webhooks" json:"webhooks"`
+	Desktop  *DesktopConfig  `yaml:"desktop" json:"desktop"`
+	Email    *EmailConfig    `yaml:"email" json:"email"`
+	// A card staying above this temperature with fans at maximum for
+	// overheat_for is recorded as an overheat event, 90 and 30s by default.
+	Overheat    int           `yaml:"overheat" json:"overheat"`
+	OverheatFor time.Duration `yaml:"overheat_for" json:"overheat_for"`
+	// Least time between notifications of an event type on a card, cooldowns
+	// sets it for some types.
+	Cooldown  time.Duration            `yaml:"cooldown" json:"cooldown"`
+	Cooldowns map[string]time.Duration `yaml:"cooldowns" json:"cooldowns"`
+	// More than flap_count events of a type on a card within flap_window
+	// (30m by default) are flapping and held back, no limit by default.
+	FlapCount  int           `yaml:"flap_count" json:"flap_count"`
+	FlapWindow time.Duration `yaml:"flap_window" json:"flap_window"`
+}
+
+// EmailConfig mails events through an SMTP server.
+type EmailConfig struct {
+	Host     string   `yaml:"host" json:"host"`
+	Port     int      `yaml:"port" json:"port"`         // 587 by default, 465 with tls security.
+	Security string   `yaml:"security" json:"security"` // "starttls", "tls" or "none", STARTTLS when the server offers it by default.
+	Username string   `yaml:"username" json:"username"`
+	Password string   `yaml:"password" json:"password"`
+	From     string   `yaml:"from" json:"from"`
+	To       []string `yaml:"to" json:"to"`
+	Subject  string   `yaml:"subject" json:"subject"` // Go template of the event.
+	Body     string   `yaml:"body" json:"body"`       // Go template of the event.
+	Events   []string `yaml:"events" json:"events"`   // Event types, "*" for all, critical ones by default.
+	Retries  int      `yaml:"retries" json:"retries"` // Retries of a failed delivery, 3 by default.
+}
+
+// DesktopConfig shows events as freedesktop notifications.
+type DesktopConfig struct {
+	Bus    string   `yaml:"bus" json:"bus"`       // D-Bus address of the session, sessions of logged in users by default.
+	Events []string `yaml:"events" json:"events"` // Event types, "*" for all, failures and exits by default.
+}
+
+// WebhookConfig posts events as JSON to URL.
+type WebhookConfig struct {
+	URL      string            `yaml:"url" json:"url"`
+	Format   string            `yaml:"format" json:"format"`     // Payload for "slack", "discord" or "generic" (default) receivers.
+	Template string            `yaml:"template" json:"template"` // Payload as a Go template of the event, instead of format.
+	Headers  map[string]string `yaml:"headers" json:"headers"`
+	Events   []string          `yaml:"events" json:"events"`   // Event types, "*" for all, failures and exits by default.
+	Retries  int               `yaml:"retries" json:"retries"` // Retries of a failed delivery, 3 by default.
+	Timeout  time.Duration     `yaml:"timeout" json:"timeout"` // Of a single delivery, 10s by default.
+}
+
+// PriorityConfig keeps fan updates on time on a loaded machine (Linux only).
+type PriorityConfig struct {
+	Nice     int    `yaml:"nice" json:"nice"`         // -20 (highest) to 19, unchanged when 0.
+	Policy   string `yaml:"policy" json:"policy"`     // Real-time policy "fifo" or "rr", none by default.
+	Priority int    `yaml:"priority" json:"priority"` // Real-time priority 1-99, 10 by default.
+}
+
+// AdaptiveConfig varies the polling interval of every card: fast while its
+// temperature changes or is close to the slowdown threshold, slow while it
+// is stable.
+type AdaptiveConfig struct {
+	Min    time.Duration `yaml:"min" json:"min"`       // 500ms by default.
+	Max    time.Duration `yaml:"max" json:"max"`       // 5s by default.
+	Rate   float64       `yaml:"rate" json:"rate"`     // °C per second which counts as a change, 0.5 by default.
+	Margin int           `yaml:"margin" json:"margin"` // Distance to the slowdown threshold polled fast, 5°C by default.
+}
+
+// IdleConfig slows polling of GPUs down while they are idle and cool,
+// optionally handing their fans back to the driver meanwhile.
+type IdleConfig struct {
+	Interval    time.Duration `yaml:"interval" json:"interval"`       // 30s by default.
+	Utilization int           `yaml:"utilization" json:"utilization"` // Highest idle utilization, 5% by default.
+	Power       float64       `yaml:"power" json:"power"`             // Highest idle power draw in watts, not checked by default.
+	Temp        int           `yaml:"temp" json:"temp"`               // Highest idle temperature, 50°C by default.
+	Release     bool          `yaml:"release" json:"release"`         // Return fans to the driver while idle.
+	// Release fans only after the GPU has been idle this long, right away by default.
+	ReleaseAfter time.Duration `yaml:"release_after" json:"release_after"`
+}
+
+// NvidiaSettingsConfig tells how to reach nvidia-settings and the X server
+// running with Coolbits, which older GPUs need for manual fan control.
+type NvidiaSettingsConfig struct {
+	Path       string `yaml:"path" json:"path"`             // nvidia-settings from PATH by default.
+	Display    string `yaml:"display" json:"display"`       // :0 by default.
+	Xauthority string `yaml:"xauthority" json:"xauthority"` // Cookie file, if the display needs one.
+}
+
+// HwmonConfig is a hwmon PWM output, like a fan of an Intel GPU or a chassis
+// fan, controlled with the same modes as cards. Its temperature comes from
+// a hwmon input or from GPUs.
+type HwmonConfig struct {
+	Name      string `yaml:"name" json:"name"`
+	PWM       string `yaml:"pwm" json:"pwm"`             // e.g. /sys/class/hwmon/hwmon3/pwm1
+	Temp      string `yaml:"temp" json:"temp"`           // e.g. /sys/class/hwmon/hwmon3/temp1_input
+	GPU       string `yaml:"gpu" json:"gpu"`             // GPU index or UUID instead of temp, "*" for the hottest GPU.
+	MinSpeed  int    `yaml:"min_speed" json:"min_speed"` // Lowest duty cycle in percent, some fans stall below it.
+	MaxTemp   int    `yaml:"max_temp" json:"max_temp"`   // Top of presets, 90 by default.
+	GPUConfig `yaml:",inline"`
+}
+
+// LogLevelsConfig overrides levels of log outputs for single components and GPUs.
+type LogLevelsConfig struct {
+	Components map[string]string `yaml:"components" json:"components"` // e.g. controller: debug
+	GPUs       map[int]string    `yaml:"gpus" json:"gpus"`             // e.g. 3: debug
+}
+
+// LoggingConfig is a list of log outputs. A single output may be given as
+// a plain mapping for compatibility with older configs.
+type LoggingConfig []map[string]string
+
+func (l *LoggingConfig) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.MappingNode {
+		var output map[string]string
+		if err := node.Decode(&output); err != nil {
+			return err
+		}
+		*l = LoggingConfig{output}
+		return nil
+	}
+	var outputs []map[string]string
+	if err := node.Decode(&outputs); err != nil {
+		return err
+	}
+	*l = outputs
+	return nil
+}
+
+// TelemetryConfig describes where per-cycle samples are written.
+type TelemetryConfig struct {
+	Type     string `yaml:"type" json:"type"`           // Sink type (e.g., "csv").
+	Path     string `yaml:"path" json:"path"`           // Output file path.
+	MaxSize  int    `yaml:"max_size" json:"max_size"`   // Rotate after this many megabytes, 0 disables rotation.
+	MaxFiles int    `yaml:"max_files" json:"max_files"` // Number of rotated files to keep.
+}
+
+// StatsConfig describes the embedded statistics database.
+type StatsConfig struct {
+	Path      string `yaml:"path" json:"path"`           // Database file.
+	Retention int    `yaml:"retention" json:"retention"` // Days of history to keep.
+}
+
+// SummaryConfig controls the periodic thermal summary log line.
+type SummaryConfig struct {
+	Disabled bool          `yaml:"disabled" json:"disabled"`
+	Interval time.Duration `yaml:"interval" json:"interval"` // How often summary is logged.
+	Warning  int           `yaml:"warning" json:"warning"`   // Temperature counted as "above warning".
+}
+
+// MetricsConfig describes the Prometheus metrics endpoint.
+type MetricsConfig struct {
+	Listen string `yaml:"listen" json:"listen"` // Address of HTTP listener, e.g. "127.0.0.1:9835".
+}
+
+// APIConfig describes the local control API served over a Unix socket.
+type APIConfig struct {
+	Disabled bool   `yaml:"disabled" json:"disabled"`
+	Socket   string `yaml:"socket" json:"socket"`
+}
+
+// SupervisorConfig controls the parent process which restores fans when the
+// controller dies without doing it itself.
+type SupervisorConfig struct {
+	Disabled    bool `yaml:"disabled" json:"disabled"`
+	Restart     bool `yaml:"restart" json:"restart"`           // Start the controller again after a crash.
+	MaxRestarts int  `yaml:"max_restarts" json:"max_restarts"` // Give up after this many restarts.
+}
+
+// SimConfig describes GPUs of the simulated backend, a single default GPU
+// is simulated when the list is empty.
+type SimConfig struct {
+	Speed float64        `yaml:"speed" json:"speed"` // Simulated time runs this many times faster than real time.
+	GPUs  []SimGPUConfig `yaml:"gpus" json:"gpus"`
+}
+
+// SimGPUConfig describes a simulated GPU. Its temperature follows a first
+// order model: it approaches ambient + power * resistance, where resistance
+// falls linearly with fan speed, with the given time constant.
+type SimGPUConfig struct {
+	Name         string        `yaml:"name" json:"name"`
+	Fans         int           `yaml:"fans" json:"fans"`
+	Ambient      float64       `yaml:"ambient" json:"ambient"`             // °C
+	IdlePower    float64       `yaml:"idle_power" json:"idle_power"`       // Watts at 0% load.
+	MaxPower     float64       `yaml:"max_power" json:"max_power"`         // Watts at 100% load.
+	Resistance   [2]float64    `yaml:"resistance" json:"resistance"`       // °C/W at minimum and maximum fan speed.
+	TimeConstant time.Duration `yaml:"time_constant" json:"time_constant"` // How fast temperature settles.
+	MaxTemp      int           `yaml:"max_temp" json:"max_temp"`           // Clocks are throttled above it.
+	Profile      string        `yaml:"profile" json:"profile"`             // Built-in load profile: idle, full, square or ramp.
+	Load         []SimLoadStep `yaml:"load" json:"load"`                   // Custom load profile, repeated.
+	Attach       time.Duration `yaml:"attach" json:"attach"`               // Appears this long after start, like a hot plugged GPU.
+	Detach       time.Duration `yaml:"detach" json:"detach"`               // Disappears this long after start, never when zero.
+	Consumer     bool          `yaml:"consumer" json:"consumer"`           // Lacks serial number, slowdown threshold and fan policy like GeForce cards, and fan speed measurement.
+	WornFans     []int         `yaml:"worn_fans" json:"worn_fans"`         // Fans which don't get faster than 60% whatever is commanded.
+}
+
+// SimLoadStep keeps a load for a while.
+type SimLoadStep struct {
+	Duration time.Duration `yaml:"duration" json:"duration"`
+	Load     int           `yaml:"load" json:"load"` // Percent.
+}
+
+// ReplayConfig describes the replay backend, which plays back temperatures
+// and power draw recorded by CSV telemetry.
+type ReplayConfig struct {
+	Path  string  `yaml:"path" json:"path"`   // Telemetry file.
+	Speed float64 `yaml:"speed" json:"speed"` // Playback speed, 1 is real time.
+	Loop  bool    `yaml:"loop" json:"loop"`   // Start over at the end instead of holding the last sample.
+}
+
+// Load reads configuration file at path.
+func Load(path string) (Config, error) {
+	var cfg Config
+
+	// Open the configuration file
+	file, err := os.Open(path)
+	if err != nil {
+		return cfg, err
+	}
+	defer file.Close()
+
+	// Decode the YAML configuration
+	decoder := yaml.NewDecoder(file)
+	if err := decoder.Decode(&cfg); err != nil {
+		return cfg, fmt.Errorf("%s: %w", path, err)
+	}
+	// Preset is a curve, mode can be omitted
+	for key, card := range cfg.Cards {
+		if card.Mode == "" && card.Preset != "" {
+			card.Mode = "curve"
+			cfg.Cards[key] = card
+		}
+	}
+	// Rules are checked every minute, errors are left to Validate
+	if cfg.Schedule != nil {
+		for i := range cfg.Schedule.Rules {
+			rule := &cfg.Schedule.Rules[i]
+			rule.parsed, _ = rule.parse()
+		}
+	}
+	return cfg, nil
+}
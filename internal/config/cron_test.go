@@ -0,0 +1,90 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// at returns a time in 2025, whose January 1st is a Wednesday.
+func at(month time.Month, day, hour, minute int) time.Time {
+	return time.Date(2025, month, day, hour, minute, 0, 0, time.UTC)
+}
+
+func TestCronMatches(t *testing.T) {
+	tests := []struct {
+		expr  string
+		match []time.Time
+		miss  []time.Time
+	}{
+		{"* * * * *", []time.Time{at(1, 1, 0, 0), at(12, 31, 23, 59)}, nil},
+		{"30 4 * * *", []time.Time{at(3, 7, 4, 30)}, []time.Time{at(3, 7, 4, 31), at(3, 7, 5, 30)}},
+		// Steps
+		{"*/15 * * * *", []time.Time{at(1, 1, 0, 0), at(1, 1, 0, 45)}, []time.Time{at(1, 1, 0, 10)}},
+		{"5/20 * * * *", []time.Time{at(1, 1, 0, 5), at(1, 1, 0, 25), at(1, 1, 0, 45)}, []time.Time{at(1, 1, 0, 0), at(1, 1, 0, 20)}},
+		{"0 8-18/5 * * *", []time.Time{at(1, 1, 8, 0), at(1, 1, 13, 0), at(1, 1, 18, 0)}, []time.Time{at(1, 1, 9, 0), at(1, 1, 19, 0)}},
+		// Ranges and lists
+		{"0 9-17 * * *", []time.Time{at(1, 1, 9, 0), at(1, 1, 17, 0)}, []time.Time{at(1, 1, 8, 0), at(1, 1, 18, 0)}},
+		{"0,30 1,13 * * *", []time.Time{at(1, 1, 1, 30), at(1, 1, 13, 0)}, []time.Time{at(1, 1, 2, 0), at(1, 1, 13, 15)}},
+		{"0 0 1,15-17 * *", []time.Time{at(1, 1, 0, 0), at(1, 16, 0, 0)}, []time.Time{at(1, 2, 0, 0), at(1, 18, 0, 0)}},
+		// Names, in any case
+		{"0 0 * jun-aug *", []time.Time{at(6, 1, 0, 0), at(8, 31, 0, 0)}, []time.Time{at(5, 31, 0, 0), at(9, 1, 0, 0)}},
+		{"0 0 * * MON-fri", []time.Time{at(1, 6, 0, 0), at(1, 10, 0, 0)}, []time.Time{at(1, 4, 0, 0), at(1, 5, 0, 0)}},
+		{"0 0 * DEC *", []time.Time{at(12, 25, 0, 0)}, []time.Time{at(11, 25, 0, 0)}},
+		// Sunday is 0 or 7
+		{"0 0 * * 0", []time.Time{at(1, 5, 0, 0)}, []time.Time{at(1, 6, 0, 0)}},
+		{"0 0 * * 7", []time.Time{at(1, 5, 0, 0)}, []time.Time{at(1, 4, 0, 0)}},
+		{"0 0 * * 5-7", []time.Time{at(1, 3, 0, 0), at(1, 4, 0, 0), at(1, 5, 0, 0)}, []time.Time{at(1, 6, 0, 0)}},
+		// Restricted day of month and day of week match when either does
+		{"0 0 13 * fri", []time.Time{at(1, 13, 0, 0), at(1, 3, 0, 0), at(6, 13, 0, 0)}, []time.Time{at(1, 14, 0, 0)}},
+		// Only one restricted, it alone decides
+		{"0 0 13 * *", []time.Time{at(1, 13, 0, 0)}, []time.Time{at(1, 3, 0, 0)}},
+		{"0 0 * * fri", []time.Time{at(1, 3, 0, 0)}, []time.Time{at(1, 13, 0, 0)}},
+		{"0 0 */2 * fri", []time.Time{at(1, 3, 0, 0)}, []time.Time{at(1, 1, 0, 0), at(1, 4, 0, 0)}},
+	}
+	for _, tt := range tests {
+		c, err := ParseCron(tt.expr)
+		if err != nil {
+			t.Errorf("ParseCron(%q): %v", tt.expr, err)
+			continue
+		}
+		for _, m := range tt.match {
+			if !c.Matches(m) {
+				t.Errorf("%q doesn't match %v", tt.expr, m.Format("Mon Jan 2 15:04"))
+			}
+		}
+		for _, m := range tt.miss {
+			if c.Matches(m) {
+				t.Errorf("%q matches %v", tt.expr, m.Format("Mon Jan 2 15:04"))
+			}
+		}
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		err  string
+	}{
+		{"", "expected 5 fields"},
+		{"* * * *", "expected 5 fields"},
+		{"* * * * * *", "expected 5 fields"},
+		{"60 * * * *", "minute: invalid value '60', expected 0-59"},
+		{"* 24 * * *", "hour: invalid value '24', expected 0-23"},
+		{"* * 0 * *", "day of month: invalid value '0', expected 1-31"},
+		{"* * * 13 *", "month: invalid value '13', expected 1-12"},
+		{"* * * * 8", "day of week: invalid value '8', expected 0-7"},
+		{"* * * * funday", "day of week: invalid value 'funday'"},
+		{"*/0 * * * *", "minute: invalid step in '*/0'"},
+		{"*/x * * * *", "minute: invalid step in '*/x'"},
+		{"30-10 * * * *", "minute: invalid range '30-10'"},
+		{"* * * dec-jan *", "month: invalid range 'dec-jan'"},
+		{"1,,2 * * * *", "minute: invalid value ''"},
+	}
+	for _, tt := range tests {
+		_, err := ParseCron(tt.expr)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("ParseCron(%q): %v, want %q", tt.expr, err, tt.err)
+		}
+	}
+}
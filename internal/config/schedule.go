@@ -0,0 +1,139 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseClock returns minutes since midnight of a time of day like "22:00".
+func ParseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day '%s', expected HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// ParseDays returns days of week like "mon-fri" or "sat" as bits by
+// time.Weekday, all days when there are none.
+func ParseDays(days []string) (uint8, error) {
+	if len(days) == 0 {
+		return 0x7f, nil
+	}
+	var bits uint64
+	for _, day := range days {
+		b, err := parseCronField(day, 0, 7, dayNames)
+		if err != nil {
+			return 0, fmt.Errorf("days: %w", err)
+		}
+		bits |= b
+	}
+	if bits&(1<<7) != 0 {
+		bits |= 1
+	}
+	return uint8(bits & 0x7f), nil
+}
+
+// schedule is a ScheduleRule parsed once, rules are checked every minute.
+type schedule struct {
+	cron     *Cron
+	days     uint8
+	from, to int // Minutes since midnight, -1 for whole days.
+}
+
+// parse returns the parsed form of r, Validate reports its errors in detail.
+func (r ScheduleRule) parse() (*schedule, error) {
+	if r.Cron != "" {
+		c, err := ParseCron(r.Cron)
+		if err != nil {
+			return nil, err
+		}
+		return &schedule{cron: &c}, nil
+	}
+	days, err := ParseDays(r.Days)
+	if err != nil {
+		return nil, err
+	}
+	s := &schedule{days: days, from: -1, to: -1}
+	if r.From == "" && r.To == "" {
+		return s, nil
+	}
+	if s.from, err = ParseClock(r.From); err != nil {
+		return nil, err
+	}
+	if s.to, err = ParseClock(r.To); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Active reports whether the rule applies at t, in the location of t. A
+// window spanning midnight belongs to the day it starts on. Rules loaded
+// with the configuration are parsed already, others are parsed here.
+func (r ScheduleRule) Active(t time.Time) bool {
+	s := r.parsed
+	if s == nil {
+		var err error
+		if s, err = r.parse(); err != nil {
+			return false
+		}
+	}
+	if s.cron != nil {
+		return s.cron.Matches(t)
+	}
+	on := func(day time.Weekday) bool { return s.days&(1<<day) != 0 }
+	if s.from < 0 {
+		return on(t.Weekday())
+	}
+	now := t.Hour()*60 + t.Minute()
+	if s.from <= s.to {
+		return now >= s.from && now < s.to && on(t.Weekday())
+	}
+	return now >= s.from && on(t.Weekday()) || now < s.to && on((t.Weekday()+6)%7)
+}
+
+// Profile returns the name of the profile scheduled at t, empty when no rule
+// matches.
+func (s *ScheduleConfig) Profile(t time.Time) string {
+	if s == nil {
+		return ""
+	}
+	for _, rule := range s.Rules {
+		if rule.Active(t) {
+			return rule.Profile
+		}
+	}
+	return ""
+}
+
+// ParsePState returns the number of a performance state like "P8".
+func ParsePState(s string) (int, error) {
+	n, err := strconv.Atoi(strings.TrimPrefix(strings.ToUpper(s), "P"))
+	if err != nil || n < 0 || n > 15 || !strings.HasPrefix(strings.ToUpper(s), "P") {
+		return 0, fmt.Errorf("invalid performance state '%s', expected P0-P15", s)
+	}
+	return n, nil
+}
+
+// Merge returns card with control settings set in override replacing its
+// own. A curve replaces a preset and the other way around.
+func (card GPUConfig) Merge(override GPUConfig) GPUConfig {
+	if override.Mode != "" {
+		card.Mode = override.Mode
+	}
+	if override.Target != 0 {
+		card.Target = override.Target
+	}
+	if len(override.PID) > 0 {
+		card.PID = override.PID
+	}
+	if len(override.Curve) > 0 {
+		card.Curve, card.Preset = override.Curve, ""
+	}
+	if override.Preset != "" {
+		card.Curve, card.Preset = nil, override.Preset
+	}
+	return card
+}
@@ -0,0 +1,113 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestScheduleRuleActive(t *testing.T) {
+	tests := []struct {
+		name   string
+		rule   ScheduleRule
+		active []time.Time
+		not    []time.Time
+	}{
+		{
+			name:   "evening",
+			rule:   ScheduleRule{From: "18:00", To: "22:00"},
+			active: []time.Time{at(1, 1, 18, 0), at(1, 1, 21, 59)},
+			not:    []time.Time{at(1, 1, 17, 59), at(1, 1, 22, 0)},
+		},
+		{
+			name:   "night spans midnight",
+			rule:   ScheduleRule{From: "22:00", To: "08:00"},
+			active: []time.Time{at(1, 1, 22, 0), at(1, 2, 0, 0), at(1, 2, 7, 59)},
+			not:    []time.Time{at(1, 1, 8, 0), at(1, 1, 12, 0)},
+		},
+		{
+			// Friday night lasts into Saturday, Sunday night isn't on
+			name:   "weekday nights",
+			rule:   ScheduleRule{From: "22:00", To: "08:00", Days: []string{"mon-fri"}},
+			active: []time.Time{at(1, 3, 23, 0), at(1, 4, 7, 0), at(1, 7, 3, 0)},
+			not:    []time.Time{at(1, 4, 23, 0), at(1, 5, 23, 0), at(1, 6, 3, 0)},
+		},
+		{
+			name:   "whole days",
+			rule:   ScheduleRule{Days: []string{"sat", "sun"}},
+			active: []time.Time{at(1, 4, 0, 0), at(1, 5, 23, 59)},
+			not:    []time.Time{at(1, 3, 23, 59), at(1, 6, 0, 0)},
+		},
+		{
+			name:   "cron",
+			rule:   ScheduleRule{Cron: "* 9-17 * * mon-fri"},
+			active: []time.Time{at(1, 6, 9, 0), at(1, 6, 17, 59)},
+			not:    []time.Time{at(1, 6, 18, 0), at(1, 4, 12, 0)},
+		},
+		{
+			name: "invalid",
+			rule: ScheduleRule{From: "25:00", To: "08:00"},
+			not:  []time.Time{at(1, 1, 0, 0), at(1, 1, 12, 0)},
+		},
+	}
+	for _, tt := range tests {
+		parsed := tt.rule
+		parsed.parsed, _ = parsed.parse()
+		// Parsed once or on every call, rules behave the same
+		for _, rule := range []ScheduleRule{tt.rule, parsed} {
+			for _, a := range tt.active {
+				if !rule.Active(a) {
+					t.Errorf("%s: not active at %v", tt.name, a.Format("Mon 15:04"))
+				}
+			}
+			for _, n := range tt.not {
+				if rule.Active(n) {
+					t.Errorf("%s: active at %v", tt.name, n.Format("Mon 15:04"))
+				}
+			}
+		}
+	}
+}
+
+func TestLoadParsesSchedule(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nvmlfan.yaml")
+	err := os.WriteFile(path, []byte(`
+schedule:
+  rules:
+    - profile: quiet
+      from: "22:00"
+      to: "08:00"
+    - profile: work
+      cron: "* 9-17 * * mon-fri"
+    - profile: broken
+      cron: "* * *"
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := cfg.Schedule.Rules
+	if rules[0].parsed == nil || rules[1].parsed == nil || rules[1].parsed.cron == nil {
+		t.Fatalf("rules weren't parsed on load: %+v", rules)
+	}
+	if rules[2].parsed != nil {
+		t.Errorf("invalid rule was parsed")
+	}
+	tests := []struct {
+		at      time.Time
+		profile string
+	}{
+		{at(1, 6, 23, 0), "quiet"},
+		{at(1, 6, 10, 0), "work"},
+		{at(1, 4, 10, 0), ""},
+	}
+	for _, tt := range tests {
+		if got := cfg.Schedule.Profile(tt.at); got != tt.profile {
+			t.Errorf("profile at %v: %q, want %q", tt.at.Format("Mon 15:04"), got, tt.profile)
+		}
+	}
+}
@@ -0,0 +1,401 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/IvanBayan/nvmlfan/internal/notify"
+	"github.com/IvanBayan/nvmlfan/internal/rules"
+	"github.com/IvanBayan/nvmlfan/pkg/controller"
+)
+
+// Validate returns all problems found in configuration, it doesn't
+// need access to GPUs so hardware limits aren't checked.
+func Validate(cfg Config) []error {
+	var errs []error
+	if cfg.Period < 0 {
+		errs = append(errs, fmt.Errorf("period must not be negative"))
+	}
+	if cfg.NVMLTimeout < 0 {
+		errs = append(errs, fmt.Errorf("nvml_timeout must not be negative"))
+	}
+	if p := cfg.Priority; p != nil {
+		if p.Nice < -20 || p.Nice > 19 {
+			errs = append(errs, fmt.Errorf("priority: nice %d is out of -20-19 range", p.Nice))
+		}
+		if p.Policy != "" && p.Policy != "fifo" && p.Policy != "rr" {
+			errs = append(errs, fmt.Errorf("priority: invalid policy '%s', expected fifo or rr", p.Policy))
+		}
+		if p.Priority < 0 || p.Priority > 99 {
+			errs = append(errs, fmt.Errorf("priority: priority %d is out of 1-99 range", p.Priority))
+		}
+		if p.Priority != 0 && p.Policy == "" {
+			errs = append(errs, fmt.Errorf("priority: priority needs a real-time policy"))
+		}
+	}
+	if cfg.ReassertEvery < 0 {
+		errs = append(errs, fmt.Errorf("reassert_every %d must not be negative", cfg.ReassertEvery))
+	}
+	if cfg.ErrorBudget < 0 {
+		errs = append(errs, fmt.Errorf("error_budget %d must not be negative", cfg.ErrorBudget))
+	}
+	if a := cfg.Adaptive; a != nil {
+		if a.Min < 0 || a.Max < 0 || a.Rate < 0 || a.Margin < 0 {
+			errs = append(errs, fmt.Errorf("adaptive: settings must not be negative"))
+		}
+		if a.Min > 0 && a.Max > 0 && a.Min > a.Max {
+			errs = append(errs, fmt.Errorf("adaptive: min %s is above max %s", a.Min, a.Max))
+		}
+	}
+	if i := cfg.Idle; i != nil {
+		if i.Interval < 0 || i.Power < 0 || i.Temp < 0 || i.ReleaseAfter < 0 {
+			errs = append(errs, fmt.Errorf("idle: settings must not be negative"))
+		}
+		if i.Utilization < 0 || i.Utilization > 100 {
+			errs = append(errs, fmt.Errorf("idle: utilization %d is out of 0-100 range", i.Utilization))
+		}
+	}
+	if cfg.Hooks != nil && cfg.Hooks.Timeout < 0 {
+		errs = append(errs, fmt.Errorf("hooks: timeout must not be negative"))
+	}
+	if n := cfg.Notify; n != nil {
+		for i, w := range n.Webhooks {
+			if u, err := url.Parse(w.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				errs = append(errs, fmt.Errorf("notify: webhook %d: invalid url '%s'", i, w.URL))
+			}
+			if _, err := notify.NewWebhook(w.URL, w.Format, w.Template, nil, 0); err != nil {
+				errs = append(errs, fmt.Errorf("notify: webhook %d: %w", i, err))
+			}
+			if w.Retries < 0 || w.Timeout < 0 {
+				errs = append(errs, fmt.Errorf("notify: webhook %d: retries and timeout must not be negative", i))
+			}
+		}
+		if e := n.Email; e != nil {
+			if e.Host == "" || e.From == "" || len(e.To) == 0 {
+				errs = append(errs, fmt.Errorf("notify: email needs host, from and to"))
+			}
+			if e.Port < 0 || e.Port > 65535 || e.Retries < 0 {
+				errs = append(errs, fmt.Errorf("notify: email port %d or retries %d is out of range", e.Port, e.Retries))
+			}
+			server := notify.EmailServer{Host: e.Host, Port: e.Port, Security: e.Security}
+			if _, err := notify.NewEmail(server, e.From, e.To, e.Subject, e.Body); err != nil && e.From != "" {
+				errs = append(errs, fmt.Errorf("notify: %w", err))
+			}
+		}
+		if n.Overheat < 0 || n.OverheatFor < 0 {
+			errs = append(errs, fmt.Errorf("notify: overheat settings must not be negative"))
+		}
+		if n.Cooldown < 0 || n.FlapCount < 0 || n.FlapWindow < 0 {
+			errs = append(errs, fmt.Errorf("notify: cooldown and flap settings must not be negative"))
+		}
+		for eventType, cooldown := range n.Cooldowns {
+			if cooldown < 0 {
+				errs = append(errs, fmt.Errorf("notify: cooldown of %s must not be negative", eventType))
+			}
+		}
+	}
+	if cfg.Supervisor.MaxRestarts < 0 {
+		errs = append(errs, fmt.Errorf("supervisor: max_restarts must not be negative"))
+	}
+	for idx, card := range cfg.Cards {
+		if _, err := path.Match(idx, ""); err != nil {
+			errs = append(errs, fmt.Errorf("card %s: invalid name pattern", idx))
+		}
+		errs = append(errs, validateControl("card "+idx, card)...)
+		if card.Hooks != nil && card.Hooks.Timeout < 0 {
+			errs = append(errs, fmt.Errorf("card %s: hooks timeout must not be negative", idx))
+		}
+		for name, hooks := range map[string][]ThresholdHook{"on_temp_above": card.OnTempAbove, "on_temp_below": card.OnTempBelow,
+			"on_fan_above": card.OnFanAbove, "on_fan_below": card.OnFanBelow} {
+			for i, h := range hooks {
+				if len(h.Exec) == 0 {
+					errs = append(errs, fmt.Errorf("card %s: %s %d has no exec", idx, name, i))
+				}
+				if h.For < 0 {
+					errs = append(errs, fmt.Errorf("card %s: %s %d: for must not be negative", idx, name, i))
+				}
+				if strings.HasPrefix(name, "on_fan") && (h.Threshold < 0 || h.Threshold > 100) {
+					errs = append(errs, fmt.Errorf("card %s: %s %d: threshold %d is out of 0-100 range", idx, name, i, h.Threshold))
+				}
+			}
+		}
+		if card.BusyFloor < 0 || card.BusyFloor > 100 {
+			errs = append(errs, fmt.Errorf("card %s: busy floor %d is out of 0-100 range", idx, card.BusyFloor))
+		}
+		for pstate, override := range card.PStates {
+			if _, err := ParsePState(pstate); err != nil {
+				errs = append(errs, fmt.Errorf("card %s: %w", idx, err))
+				continue
+			}
+			errs = append(errs, validateControl("card "+idx+": "+pstate, card.Merge(override))...)
+		}
+		if p := card.PowerLimit; p != nil {
+			if p.Ceiling <= 0 {
+				errs = append(errs, fmt.Errorf("card %s: power limit ceiling is not set", idx))
+			}
+			if p.After < 0 || p.Step < 0 || p.Min < 0 || p.Recover < 0 {
+				errs = append(errs, fmt.Errorf("card %s: power limit settings must not be negative", idx))
+			}
+			if p.Recover >= p.Ceiling && p.Recover > 0 {
+				errs = append(errs, fmt.Errorf("card %s: power limit recover %d must be below ceiling %d", idx, p.Recover, p.Ceiling))
+			}
+		}
+		if cc := card.ClockCap; cc != nil {
+			if cc.Ceiling <= 0 {
+				errs = append(errs, fmt.Errorf("card %s: clock cap ceiling is not set", idx))
+			}
+			if cc.After < 0 || cc.Recover < 0 || cc.Graphics < 0 || cc.Memory < 0 {
+				errs = append(errs, fmt.Errorf("card %s: clock cap settings must not be negative", idx))
+			}
+			if cc.Graphics == 0 && cc.Memory == 0 {
+				errs = append(errs, fmt.Errorf("card %s: clock cap needs graphics or memory clock", idx))
+			}
+			if cc.Recover >= cc.Ceiling && cc.Recover > 0 {
+				errs = append(errs, fmt.Errorf("card %s: clock cap recover %d must be below ceiling %d", idx, cc.Recover, cc.Ceiling))
+			}
+		}
+		if e := card.Emergency; e != nil {
+			if e.Critical <= 0 {
+				errs = append(errs, fmt.Errorf("card %s: emergency critical temperature is not set", idx))
+			}
+			if len(e.Exec) == 0 && e.Signal == "" && !e.Drain {
+				errs = append(errs, fmt.Errorf("card %s: emergency needs exec, signal or drain", idx))
+			}
+			switch e.Signal {
+			case "", "TERM", "INT", "KILL":
+			default:
+				errs = append(errs, fmt.Errorf("card %s: unknown emergency signal '%s', use TERM, INT or KILL", idx, e.Signal))
+			}
+			if e.CoolDown < 0 {
+				errs = append(errs, fmt.Errorf("card %s: emergency cool_down must not be negative", idx))
+			}
+		}
+		if u := card.Utilization; u != nil {
+			if u.Window < 0 {
+				errs = append(errs, fmt.Errorf("card %s: utilization window must not be negative", idx))
+			}
+			previous := 0
+			for i, band := range u.Bands {
+				what := fmt.Sprintf("card %s: utilization band %d", idx, i)
+				switch {
+				case band.Below == 0 && i < len(u.Bands)-1:
+					errs = append(errs, fmt.Errorf("%s: below is only optional for the last band", what))
+				case band.Below != 0 && (band.Below <= previous || band.Below > 100):
+					errs = append(errs, fmt.Errorf("%s: below %d has to be above %d and at most 100", what, band.Below, previous))
+				}
+				previous = band.Below
+				errs = append(errs, validateControl(what, card.Merge(band.GPUConfig))...)
+			}
+		}
+	}
+	for name, profile := range cfg.Profiles {
+		for key, override := range profile.Cards {
+			card, ok := cfg.Cards[key]
+			if !ok {
+				errs = append(errs, fmt.Errorf("profile %s: card %s isn't configured in cards", name, key))
+				continue
+			}
+			errs = append(errs, validateControl("profile "+name+": card "+key, card.Merge(override))...)
+		}
+	}
+	if e := cfg.Exercise; e != nil {
+		if _, err := ParseCron(e.Cron); err != nil {
+			errs = append(errs, fmt.Errorf("exercise: cron: %w", err))
+		}
+		if e.Duration < 0 || e.Settle < 0 || e.Tolerance < 0 || e.Tolerance > 100 {
+			errs = append(errs, fmt.Errorf("exercise: duration, settle and tolerance must not be negative, tolerance at most 100"))
+		}
+		if e.Duration > 0 && e.Settle > 0 && e.Settle >= e.Duration {
+			errs = append(errs, fmt.Errorf("exercise: settle must be shorter than duration"))
+		}
+	}
+	if s := cfg.Schedule; s != nil {
+		if s.Transition < 0 {
+			errs = append(errs, fmt.Errorf("schedule: transition must not be negative"))
+		}
+		for i, rule := range s.Rules {
+			if _, ok := cfg.Profiles[rule.Profile]; !ok {
+				errs = append(errs, fmt.Errorf("schedule: rule %d: unknown profile '%s'", i, rule.Profile))
+			}
+			if rule.Cron != "" {
+				if rule.From != "" || rule.To != "" || len(rule.Days) > 0 {
+					errs = append(errs, fmt.Errorf("schedule: rule %d: set either cron or from, to and days", i))
+				}
+				if _, err := ParseCron(rule.Cron); err != nil {
+					errs = append(errs, fmt.Errorf("schedule: rule %d: cron: %w", i, err))
+				}
+				continue
+			}
+			if _, err := ParseDays(rule.Days); err != nil {
+				errs = append(errs, fmt.Errorf("schedule: rule %d: %w", i, err))
+			}
+			if rule.From == "" && rule.To == "" {
+				if len(rule.Days) == 0 {
+					errs = append(errs, fmt.Errorf("schedule: rule %d: set from and to, days or cron", i))
+				}
+				continue
+			}
+			from, fromErr := ParseClock(rule.From)
+			if fromErr != nil {
+				errs = append(errs, fmt.Errorf("schedule: rule %d: from: %w", i, fromErr))
+			}
+			to, toErr := ParseClock(rule.To)
+			if toErr != nil {
+				errs = append(errs, fmt.Errorf("schedule: rule %d: to: %w", i, toErr))
+			}
+			if fromErr == nil && toErr == nil && from == to {
+				errs = append(errs, fmt.Errorf("schedule: rule %d: from and to are the same time", i))
+			}
+		}
+	}
+	if a := cfg.Activity; a != nil {
+		if a.CoolDown < 0 {
+			errs = append(errs, fmt.Errorf("activity: cool_down must not be negative"))
+		}
+		if a.Load == "" && a.Idle == "" && len(a.Rules) == 0 {
+			errs = append(errs, fmt.Errorf("activity: set rules, load or idle profile"))
+		}
+		for _, profile := range []string{a.Load, a.Idle} {
+			if _, ok := cfg.Profiles[profile]; profile != "" && !ok {
+				errs = append(errs, fmt.Errorf("activity: unknown profile '%s'", profile))
+			}
+		}
+		for i, rule := range a.Rules {
+			if _, err := regexp.Compile(rule.Process); err != nil || rule.Process == "" {
+				errs = append(errs, fmt.Errorf("activity: rule %d: invalid process pattern '%s'", i, rule.Process))
+			}
+			if _, ok := cfg.Profiles[rule.Profile]; !ok {
+				errs = append(errs, fmt.Errorf("activity: rule %d: unknown profile '%s'", i, rule.Profile))
+			}
+		}
+	}
+	for i, source := range cfg.Rules {
+		rule, err := rules.Parse(source)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("rule %d: %w", i, err))
+			continue
+		}
+		if _, ok := cfg.Profiles[rule.Profile]; !ok {
+			errs = append(errs, fmt.Errorf("rule %d: unknown profile '%s'", i, rule.Profile))
+		}
+	}
+	for i, output := range cfg.Hwmon {
+		what := fmt.Sprintf("hwmon %d", i)
+		if output.Name != "" {
+			what = "hwmon " + output.Name
+		}
+		if output.PWM == "" {
+			errs = append(errs, fmt.Errorf("%s: pwm is not set", what))
+		}
+		if (output.Temp == "") == (output.GPU == "") {
+			errs = append(errs, fmt.Errorf("%s: set either temp or gpu", what))
+		}
+		if output.MinSpeed < 0 || output.MinSpeed > 100 {
+			errs = append(errs, fmt.Errorf("%s: min speed %d is out of 0-100 range", what, output.MinSpeed))
+		}
+		errs = append(errs, validateControl(what, output.GPUConfig)...)
+	}
+	for _, item := range cfg.Exclude {
+		if _, err := path.Match(item, ""); err != nil {
+			errs = append(errs, fmt.Errorf("exclude: invalid name pattern '%s'", item))
+		}
+	}
+	for _, output := range cfg.Logging {
+		switch output["type"] {
+		case "stdout", "console", "json", "file", "syslog":
+		default:
+			errs = append(errs, fmt.Errorf("logging: unknown type '%s'", output["type"]))
+		}
+		switch output["level"] {
+		case "", "debug", "info", "warn", "error":
+		default:
+			errs = append(errs, fmt.Errorf("logging: unknown level '%s'", output["level"]))
+		}
+	}
+	if cfg.Telemetry != nil && cfg.Telemetry.Type != "csv" {
+		errs = append(errs, fmt.Errorf("telemetry: unknown type '%s'", cfg.Telemetry.Type))
+	}
+	switch cfg.Backend {
+	case "", "nvml", "sim":
+	case "replay":
+		if cfg.Replay.Path == "" {
+			errs = append(errs, fmt.Errorf("replay: path is not set"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown backend '%s', use nvml, sim or replay", cfg.Backend))
+	}
+	if cfg.Replay.Speed < 0 {
+		errs = append(errs, fmt.Errorf("replay: speed must not be negative"))
+	}
+	if cfg.Sim.Speed < 0 {
+		errs = append(errs, fmt.Errorf("sim: speed must not be negative"))
+	}
+	for i, sim := range cfg.Sim.GPUs {
+		switch sim.Profile {
+		case "", "idle", "full", "square", "ramp":
+		default:
+			errs = append(errs, fmt.Errorf("sim: gpu %d: unknown profile '%s'", i, sim.Profile))
+		}
+		for _, step := range sim.Load {
+			if step.Duration <= 0 || step.Load < 0 || step.Load > 100 {
+				errs = append(errs, fmt.Errorf("sim: gpu %d: load steps need positive duration and load in 0-100 range", i))
+				break
+			}
+		}
+	}
+	return errs
+}
+
+// validateControl checks fan control settings of a card or a hwmon output.
+func validateControl(what string, card GPUConfig) []error {
+	var errs []error
+	switch card.Mode {
+	case "curve":
+		if card.Preset != "" {
+			if !controller.IsPreset(card.Preset) {
+				errs = append(errs, fmt.Errorf("%s: unknown preset '%s', use one of %s", what, card.Preset, strings.Join(controller.PresetNames(), ", ")))
+			}
+			if len(card.Curve) > 0 {
+				errs = append(errs, fmt.Errorf("%s: both preset and curve are set", what))
+			}
+		} else if len(card.Curve) == 0 {
+			errs = append(errs, fmt.Errorf("%s: curve has no points", what))
+		}
+		for i, point := range card.Curve {
+			if point[1] < 0 || point[1] > 100 {
+				errs = append(errs, fmt.Errorf("%s: fan speed %d of point %d is out of 0-100 range", what, point[1], i))
+			}
+			if i > 0 && point[0] <= card.Curve[i-1][0] {
+				errs = append(errs, fmt.Errorf("%s: temperature of point %d is not above point %d", what, i, i-1))
+			}
+			if i > 0 && point[1] < card.Curve[i-1][1] {
+				errs = append(errs, fmt.Errorf("%s: fan speed of point %d is below point %d", what, i, i-1))
+			}
+		}
+	case "target":
+		if len(card.PID) != 3 {
+			errs = append(errs, fmt.Errorf("%s: pid must have 3 coefficients, got %d", what, len(card.PID)))
+		}
+		if card.Target <= 0 {
+			errs = append(errs, fmt.Errorf("%s: target temperature is not set", what))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("%s: unknown mode '%s'", what, card.Mode))
+	}
+	if card.FailsafeSpeed < 0 || card.FailsafeSpeed > 100 {
+		errs = append(errs, fmt.Errorf("%s: failsafe speed %d is out of 0-100 range", what, card.FailsafeSpeed))
+	}
+	switch card.OnExit {
+	case "", ExitAuto, ExitHold:
+	case ExitFixed:
+		if card.ExitSpeed < 0 || card.ExitSpeed > 100 {
+			errs = append(errs, fmt.Errorf("%s: exit speed %d is out of 0-100 range", what, card.ExitSpeed))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("%s: unknown exit behavior '%s', use auto, hold or fixed", what, card.OnExit))
+	}
+	return errs
+}
@@ -0,0 +1,404 @@
+package gpu
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+// slowWait is how long a call may wait for another one before it is logged.
+const slowWait = 100 * time.Millisecond
+
+// CallTimeout is how long a call may run before the library is considered
+// hung, e.g. by a wedged driver or a fallen off GPU.
+var CallTimeout = 10 * time.Second
+
+// OnHang is called when a call of GPU idx, or -1 for calls not tied to a
+// GPU, exceeds CallTimeout.
+var OnHang = func(call string, idx int) {}
+
+// safetyCalls set failsafe speed or give fans and limits back to the
+// driver, they are tried even while a call of the GPU hangs: a hung GPU
+// still needs its fans to spin and shutdown has no later cycle to wait for.
+var safetyCalls = map[string]bool{
+	"DeviceGetHandleByIndex":         true,
+	"GetNumFans":                     true,
+	"SetFanSpeed_v2":                 true,
+	"SetDefaultFanSpeed_v2":          true,
+	"SetFanControlPolicy":            true,
+	"GetPowerManagementLimit":        true,
+	"GetPowerManagementDefaultLimit": true,
+	"SetPowerManagementLimit":        true,
+	"ResetGpuLockedClocks":           true,
+	"ResetMemoryLockedClocks":        true,
+	"Shutdown":                       true,
+}
+
+// broker serializes calls to the library on one worker goroutine, so
+// control loops of several GPUs and API handlers never run NVML calls
+// concurrently, and reports every call to Observe. Calls not used by
+// nvmlfan pass through the embedded interfaces unguarded.
+//
+// A call running longer than CallTimeout fails with ERROR_TIMEOUT and its
+// GPU is hung: its calls fail right away until the hung one returns, except
+// safetyCalls while none of them hangs too. The worker stuck in it is
+// abandoned to a new one, so calls of other GPUs go on.
+type broker struct {
+	nvml.Interface
+	requests chan *request
+	mu       sync.Mutex
+	hung     map[int]*hang // By GPU, -1 for calls not tied to one.
+}
+
+// hang counts calls of a GPU running past CallTimeout.
+type hang struct {
+	calls, safety int
+}
+
+// States of a request
+const (
+	requestQueued int32 = iota
+	requestRunning
+	requestCanceled
+)
+
+// request is a call waiting for or run by the worker.
+type request struct {
+	name      string
+	idx       int
+	f         func() nvml.Return
+	ret       nvml.Return
+	state     atomic.Int32
+	start     time.Time
+	done      chan struct{}
+	abandoned bool // Its worker was replaced, guarded by broker.mu.
+}
+
+// newBroker guards lib.
+func newBroker(lib nvml.Interface) *broker {
+	b := &broker{Interface: lib, requests: make(chan *request), hung: map[int]*hang{}}
+	go b.work()
+	return b
+}
+
+// work runs requests until it is abandoned in a hung one.
+func (b *broker) work() {
+	for r := range b.requests {
+		r.start = time.Now()
+		if !r.state.CompareAndSwap(requestQueued, requestRunning) {
+			continue
+		}
+		ret := r.f()
+		b.mu.Lock()
+		if r.abandoned {
+			h := b.hung[r.idx]
+			h.calls--
+			if safetyCalls[r.name] {
+				h.safety--
+			}
+			if h.calls == 0 {
+				delete(b.hung, r.idx)
+			}
+			b.mu.Unlock()
+			Log.Warn("Hung NVML call returned", "call", r.name, "GPU", r.idx, "duration", time.Since(r.start))
+			return
+		}
+		r.ret = ret
+		close(r.done)
+		b.mu.Unlock()
+		Observe(r.name, r.start, ret)
+	}
+}
+
+// call runs f on the worker and returns its results, its duration is
+// measured from the moment it starts so waiting for other calls isn't
+// attributed to it. Results of a call which timed out are dropped, they
+// arrive when nobody waits for them.
+func call[T any](b *broker, name string, idx int, f func() (T, nvml.Return)) (T, nvml.Return) {
+	var value, zero T
+	b.mu.Lock()
+	h := b.hung[idx]
+	hung := h != nil && (!safetyCalls[name] || h.safety > 0)
+	b.mu.Unlock()
+	if hung {
+		return zero, nvml.ERROR_TIMEOUT
+	}
+	r := &request{name: name, idx: idx, done: make(chan struct{})}
+	r.f = func() nvml.Return {
+		var ret nvml.Return
+		value, ret = f()
+		return ret
+	}
+	queued := time.Now()
+	timeout := time.NewTimer(CallTimeout)
+	defer timeout.Stop()
+	select {
+	case b.requests <- r:
+	case <-timeout.C:
+		// The call ahead of us hung and wasn't abandoned yet
+		return zero, nvml.ERROR_TIMEOUT
+	}
+	for {
+		select {
+		case <-r.done:
+			if wait := r.start.Sub(queued); wait > slowWait {
+				Log.Debug("NVML call waited for another one", "call", name, "GPU", idx, "wait", wait)
+			}
+			return value, r.ret
+		case <-timeout.C:
+		}
+		if r.state.CompareAndSwap(requestQueued, requestCanceled) {
+			return zero, nvml.ERROR_TIMEOUT
+		}
+		// The time spent waiting for another call doesn't count
+		if ran := time.Since(r.start); ran < CallTimeout {
+			timeout.Reset(CallTimeout - ran)
+			continue
+		}
+		break
+	}
+	b.mu.Lock()
+	select {
+	case <-r.done:
+		// Returned just now
+		b.mu.Unlock()
+		return value, r.ret
+	default:
+	}
+	r.abandoned = true
+	h = b.hung[idx]
+	if h == nil {
+		h = &hang{}
+		b.hung[idx] = h
+	}
+	h.calls++
+	if safetyCalls[name] {
+		h.safety++
+	}
+	b.mu.Unlock()
+	go b.work()
+	Log.Error("NVML call hung, failing calls of the GPU until it returns", "call", name, "GPU", idx, "timeout", CallTimeout)
+	OnHang(name, idx)
+	return zero, nvml.ERROR_TIMEOUT
+}
+
+// callReturn is call for functions returning only a result code.
+func callReturn(b *broker, name string, idx int, f func() nvml.Return) nvml.Return {
+	_, ret := call(b, name, idx, func() (struct{}, nvml.Return) { return struct{}{}, f() })
+	return ret
+}
+
+func (b *broker) Init() nvml.Return {
+	return callReturn(b, "Init", -1, b.Interface.Init)
+}
+
+func (b *broker) Shutdown() nvml.Return {
+	return callReturn(b, "Shutdown", -1, b.Interface.Shutdown)
+}
+
+func (b *broker) SystemGetDriverVersion() (string, nvml.Return) {
+	return call(b, "SystemGetDriverVersion", -1, b.Interface.SystemGetDriverVersion)
+}
+
+func (b *broker) SystemGetNVMLVersion() (string, nvml.Return) {
+	return call(b, "SystemGetNVMLVersion", -1, b.Interface.SystemGetNVMLVersion)
+}
+
+func (b *broker) DeviceGetCount() (int, nvml.Return) {
+	return call(b, "DeviceGetCount", -1, b.Interface.DeviceGetCount)
+}
+
+func (b *broker) DeviceGetHandleByIndex(idx int) (nvml.Device, nvml.Return) {
+	device, ret := call(b, "DeviceGetHandleByIndex", idx, func() (nvml.Device, nvml.Return) {
+		return b.Interface.DeviceGetHandleByIndex(idx)
+	})
+	if ret != nvml.SUCCESS {
+		return nil, ret
+	}
+	return brokerDevice{Device: device, broker: b, idx: idx}, ret
+}
+
+// brokerDevice is a device whose calls go through the broker.
+type brokerDevice struct {
+	nvml.Device
+	broker *broker
+	idx    int
+}
+
+func (d brokerDevice) GetName() (string, nvml.Return) {
+	return call(d.broker, "GetName", d.idx, d.Device.GetName)
+}
+
+func (d brokerDevice) GetUUID() (string, nvml.Return) {
+	return call(d.broker, "GetUUID", d.idx, d.Device.GetUUID)
+}
+
+func (d brokerDevice) GetSerial() (string, nvml.Return) {
+	return call(d.broker, "GetSerial", d.idx, d.Device.GetSerial)
+}
+
+func (d brokerDevice) GetMinorNumber() (int, nvml.Return) {
+	return call(d.broker, "GetMinorNumber", d.idx, d.Device.GetMinorNumber)
+}
+
+func (d brokerDevice) GetPciInfo() (nvml.PciInfo, nvml.Return) {
+	return call(d.broker, "GetPciInfo", d.idx, d.Device.GetPciInfo)
+}
+
+func (d brokerDevice) GetVbiosVersion() (string, nvml.Return) {
+	return call(d.broker, "GetVbiosVersion", d.idx, d.Device.GetVbiosVersion)
+}
+
+func (d brokerDevice) GetPersistenceMode() (nvml.EnableState, nvml.Return) {
+	return call(d.broker, "GetPersistenceMode", d.idx, d.Device.GetPersistenceMode)
+}
+
+func (d brokerDevice) GetTemperature(sensor nvml.TemperatureSensors) (uint32, nvml.Return) {
+	return call(d.broker, "GetTemperature", d.idx, func() (uint32, nvml.Return) {
+		return d.Device.GetTemperature(sensor)
+	})
+}
+
+func (d brokerDevice) GetTemperatureThreshold(threshold nvml.TemperatureThresholds) (uint32, nvml.Return) {
+	return call(d.broker, "GetTemperatureThreshold", d.idx, func() (uint32, nvml.Return) {
+		return d.Device.GetTemperatureThreshold(threshold)
+	})
+}
+
+func (d brokerDevice) GetPowerUsage() (uint32, nvml.Return) {
+	return call(d.broker, "GetPowerUsage", d.idx, d.Device.GetPowerUsage)
+}
+
+func (d brokerDevice) GetUtilizationRates() (nvml.Utilization, nvml.Return) {
+	return call(d.broker, "GetUtilizationRates", d.idx, d.Device.GetUtilizationRates)
+}
+
+func (d brokerDevice) GetComputeRunningProcesses() ([]nvml.ProcessInfo, nvml.Return) {
+	return call(d.broker, "GetComputeRunningProcesses", d.idx, d.Device.GetComputeRunningProcesses)
+}
+
+func (d brokerDevice) GetGraphicsRunningProcesses() ([]nvml.ProcessInfo, nvml.Return) {
+	return call(d.broker, "GetGraphicsRunningProcesses", d.idx, d.Device.GetGraphicsRunningProcesses)
+}
+
+func (d brokerDevice) GetPerformanceState() (nvml.Pstates, nvml.Return) {
+	return call(d.broker, "GetPerformanceState", d.idx, d.Device.GetPerformanceState)
+}
+
+func (d brokerDevice) GetPowerManagementLimit() (uint32, nvml.Return) {
+	return call(d.broker, "GetPowerManagementLimit", d.idx, d.Device.GetPowerManagementLimit)
+}
+
+func (d brokerDevice) GetPowerManagementDefaultLimit() (uint32, nvml.Return) {
+	return call(d.broker, "GetPowerManagementDefaultLimit", d.idx, d.Device.GetPowerManagementDefaultLimit)
+}
+
+func (d brokerDevice) GetPowerManagementLimitConstraints() (uint32, uint32, nvml.Return) {
+	limits, ret := call(d.broker, "GetPowerManagementLimitConstraints", d.idx, func() ([2]uint32, nvml.Return) {
+		minLimit, maxLimit, ret := d.Device.GetPowerManagementLimitConstraints()
+		return [2]uint32{minLimit, maxLimit}, ret
+	})
+	return limits[0], limits[1], ret
+}
+
+func (d brokerDevice) SetPowerManagementLimit(limit uint32) nvml.Return {
+	return callReturn(d.broker, "SetPowerManagementLimit", d.idx, func() nvml.Return {
+		return d.Device.SetPowerManagementLimit(limit)
+	})
+}
+
+func (d brokerDevice) SetGpuLockedClocks(minMHz, maxMHz uint32) nvml.Return {
+	return callReturn(d.broker, "SetGpuLockedClocks", d.idx, func() nvml.Return {
+		return d.Device.SetGpuLockedClocks(minMHz, maxMHz)
+	})
+}
+
+func (d brokerDevice) ResetGpuLockedClocks() nvml.Return {
+	return callReturn(d.broker, "ResetGpuLockedClocks", d.idx, d.Device.ResetGpuLockedClocks)
+}
+
+func (d brokerDevice) SetMemoryLockedClocks(minMHz, maxMHz uint32) nvml.Return {
+	return callReturn(d.broker, "SetMemoryLockedClocks", d.idx, func() nvml.Return {
+		return d.Device.SetMemoryLockedClocks(minMHz, maxMHz)
+	})
+}
+
+func (d brokerDevice) ResetMemoryLockedClocks() nvml.Return {
+	return callReturn(d.broker, "ResetMemoryLockedClocks", d.idx, d.Device.ResetMemoryLockedClocks)
+}
+
+func (d brokerDevice) GetEnforcedPowerLimit() (uint32, nvml.Return) {
+	return call(d.broker, "GetEnforcedPowerLimit", d.idx, d.Device.GetEnforcedPowerLimit)
+}
+
+func (d brokerDevice) GetClockInfo(clock nvml.ClockType) (uint32, nvml.Return) {
+	return call(d.broker, "GetClockInfo", d.idx, func() (uint32, nvml.Return) {
+		return d.Device.GetClockInfo(clock)
+	})
+}
+
+func (d brokerDevice) GetCurrentClocksThrottleReasons() (uint64, nvml.Return) {
+	return call(d.broker, "GetCurrentClocksThrottleReasons", d.idx, d.Device.GetCurrentClocksThrottleReasons)
+}
+
+func (d brokerDevice) GetNumFans() (int, nvml.Return) {
+	return call(d.broker, "GetNumFans", d.idx, d.Device.GetNumFans)
+}
+
+func (d brokerDevice) GetMinMaxFanSpeed() (int, int, nvml.Return) {
+	speeds, ret := call(d.broker, "GetMinMaxFanSpeed", d.idx, func() ([2]int, nvml.Return) {
+		minSpeed, maxSpeed, ret := d.Device.GetMinMaxFanSpeed()
+		return [2]int{minSpeed, maxSpeed}, ret
+	})
+	return speeds[0], speeds[1], ret
+}
+
+func (d brokerDevice) GetFanSpeed_v2(fan int) (uint32, nvml.Return) {
+	return call(d.broker, "GetFanSpeed_v2", d.idx, func() (uint32, nvml.Return) {
+		return d.Device.GetFanSpeed_v2(fan)
+	})
+}
+
+// GetFanSpeedRPM passes the call to devices measuring fan speed, see
+// rpmDevice.
+func (d brokerDevice) GetFanSpeedRPM(fan int) (int, nvml.Return) {
+	device, ok := d.Device.(rpmDevice)
+	if !ok {
+		return 0, nvml.ERROR_NOT_SUPPORTED
+	}
+	return call(d.broker, "GetFanSpeedRPM", d.idx, func() (int, nvml.Return) {
+		return device.GetFanSpeedRPM(fan)
+	})
+}
+
+func (d brokerDevice) GetTargetFanSpeed(fan int) (int, nvml.Return) {
+	return call(d.broker, "GetTargetFanSpeed", d.idx, func() (int, nvml.Return) {
+		return d.Device.GetTargetFanSpeed(fan)
+	})
+}
+
+func (d brokerDevice) GetFanControlPolicy_v2(fan int) (nvml.FanControlPolicy, nvml.Return) {
+	return call(d.broker, "GetFanControlPolicy_v2", d.idx, func() (nvml.FanControlPolicy, nvml.Return) {
+		return d.Device.GetFanControlPolicy_v2(fan)
+	})
+}
+
+func (d brokerDevice) SetFanControlPolicy(fan int, policy nvml.FanControlPolicy) nvml.Return {
+	return callReturn(d.broker, "SetFanControlPolicy", d.idx, func() nvml.Return {
+		return d.Device.SetFanControlPolicy(fan, policy)
+	})
+}
+
+func (d brokerDevice) SetFanSpeed_v2(fan int, speed int) nvml.Return {
+	return callReturn(d.broker, "SetFanSpeed_v2", d.idx, func() nvml.Return {
+		return d.Device.SetFanSpeed_v2(fan, speed)
+	})
+}
+
+func (d brokerDevice) SetDefaultFanSpeed_v2(fan int) nvml.Return {
+	return callReturn(d.broker, "SetDefaultFanSpeed_v2", d.idx, func() nvml.Return {
+		return d.Device.SetDefaultFanSpeed_v2(fan)
+	})
+}
@@ -0,0 +1,145 @@
+package gpu
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+// fakeLibrary has devices whose temperature reads, or fan speed writes,
+// block while their channel is open.
+type fakeLibrary struct {
+	nvml.Interface
+	devices []fakeDevice
+}
+
+func (l fakeLibrary) DeviceGetHandleByIndex(idx int) (nvml.Device, nvml.Return) {
+	return l.devices[idx], nvml.SUCCESS
+}
+
+type fakeDevice struct {
+	nvml.Device
+	block     chan struct{}
+	blockFans chan struct{}
+	running   *atomic.Int32 // Temperature reads in progress.
+}
+
+func (d fakeDevice) GetTemperature(nvml.TemperatureSensors) (uint32, nvml.Return) {
+	if d.block != nil {
+		<-d.block
+	}
+	if d.running != nil {
+		defer d.running.Add(-1)
+		if d.running.Add(1) > 1 {
+			return 0, nvml.ERROR_IN_USE
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return 50, nvml.SUCCESS
+}
+
+func (d fakeDevice) SetFanSpeed_v2(int, int) nvml.Return {
+	if d.blockFans != nil {
+		<-d.blockFans
+	}
+	return nvml.SUCCESS
+}
+
+func (d fakeDevice) SetDefaultFanSpeed_v2(int) nvml.Return {
+	return nvml.SUCCESS
+}
+
+func temperature(t *testing.T, b *broker, idx int) nvml.Return {
+	t.Helper()
+	device, ret := b.DeviceGetHandleByIndex(idx)
+	if ret != nvml.SUCCESS {
+		t.Fatalf("GPU %d: no handle: %v", idx, ret)
+	}
+	_, ret = device.GetTemperature(nvml.TEMPERATURE_GPU)
+	return ret
+}
+
+// shortTimeout makes calls time out quickly and returns hang reports.
+func shortTimeout(t *testing.T) <-chan int {
+	timeout, onHang := CallTimeout, OnHang
+	t.Cleanup(func() { CallTimeout, OnHang = timeout, onHang })
+	hangs := make(chan int, 1)
+	CallTimeout = 50 * time.Millisecond
+	OnHang = func(call string, idx int) { hangs <- idx }
+	return hangs
+}
+
+func TestBrokerHangIsPerGPU(t *testing.T) {
+	hangs := shortTimeout(t)
+
+	block := make(chan struct{})
+	b := newBroker(fakeLibrary{devices: []fakeDevice{{block: block}, {}}})
+	if ret := temperature(t, b, 0); ret != nvml.ERROR_TIMEOUT {
+		t.Fatalf("hung call returned %v, want timeout", ret)
+	}
+	if idx := <-hangs; idx != 0 {
+		t.Errorf("hang reported for GPU %d, want 0", idx)
+	}
+
+	start := time.Now()
+	if ret := temperature(t, b, 0); ret != nvml.ERROR_TIMEOUT {
+		t.Errorf("call of hung GPU returned %v, want timeout", ret)
+	}
+	if took := time.Since(start); took >= CallTimeout {
+		t.Errorf("call of hung GPU took %v, want it to fail right away", took)
+	}
+	if ret := temperature(t, b, 1); ret != nvml.SUCCESS {
+		t.Errorf("call of another GPU returned %v, want success", ret)
+	}
+	device, _ := b.DeviceGetHandleByIndex(0)
+	if ret := device.SetFanSpeed_v2(0, 100); ret != nvml.SUCCESS {
+		t.Errorf("failsafe speed of hung GPU returned %v, want success", ret)
+	}
+	if ret := device.SetDefaultFanSpeed_v2(0); ret != nvml.SUCCESS {
+		t.Errorf("restoring fans of hung GPU returned %v, want success", ret)
+	}
+
+	close(block)
+	deadline := time.Now().Add(time.Second)
+	for temperature(t, b, 0) != nvml.SUCCESS {
+		if time.Now().After(deadline) {
+			t.Fatal("GPU still hung after its call returned")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestBrokerHungSafetyCall(t *testing.T) {
+	hangs := shortTimeout(t)
+	block := make(chan struct{})
+	defer close(block)
+	b := newBroker(fakeLibrary{devices: []fakeDevice{{blockFans: block}, {}}})
+	device, _ := b.DeviceGetHandleByIndex(0)
+	if ret := device.SetFanSpeed_v2(0, 100); ret != nvml.ERROR_TIMEOUT {
+		t.Fatalf("hung call returned %v, want timeout", ret)
+	}
+	<-hangs
+	// Another hung safety call would only pile up behind it
+	if ret := device.SetDefaultFanSpeed_v2(0); ret != nvml.ERROR_TIMEOUT {
+		t.Errorf("restoring fans after a hung one returned %v, want timeout", ret)
+	}
+	if ret := temperature(t, b, 1); ret != nvml.SUCCESS {
+		t.Errorf("call of another GPU returned %v, want success", ret)
+	}
+}
+
+func TestBrokerSerializesCalls(t *testing.T) {
+	running := &atomic.Int32{}
+	b := newBroker(fakeLibrary{devices: []fakeDevice{{running: running}, {running: running}}})
+	done := make(chan nvml.Return)
+	for i := range 20 {
+		go func() { done <- temperature(t, b, i%2) }()
+	}
+	for range 20 {
+		if ret := <-done; ret != nvml.SUCCESS {
+			t.Errorf("calls overlapped: %v", ret)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package gpu
+
+import (
+	"sync"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+// Handles and properties which don't change while the driver is loaded are
+// cached, control loops would repeat the same calls every cycle otherwise.
+// Only successful results are kept.
+var (
+	cacheMu    sync.Mutex
+	handles    = map[int]nvml.Device{}
+	fanCounts  = map[int]int{}
+	fanRanges  = map[nvml.Device][2]int{}
+	tempLimits = map[nvml.Device]int{}
+)
+
+// ResetCache forgets cached handles and device properties. It has to be
+// called when GPUs are added or removed, InitNVML and Shutdown call it.
+func ResetCache() {
+	cacheMu.Lock()
+	handles = map[int]nvml.Device{}
+	fanCounts = map[int]int{}
+	fanRanges = map[nvml.Device][2]int{}
+	tempLimits = map[nvml.Device]int{}
+	cacheMu.Unlock()
+
+	identityMu.Lock()
+	identities = map[int]DeviceIdentity{}
+	identityMu.Unlock()
+
+	appliedMu.Lock()
+	applied = map[[2]int]int{}
+	appliedMu.Unlock()
+}
+
+// Speeds set by SetFanSpeed by GPU and fan, so unchanged speeds are skipped
+// without a round trip to NVML. Anything else changing fans through this
+// package forgets them, VerifyFans catches changes made elsewhere.
+var (
+	appliedMu sync.Mutex
+	applied   = map[[2]int]int{}
+)
+
+func lastApplied(idx, fi int) (int, bool) {
+	appliedMu.Lock()
+	defer appliedMu.Unlock()
+	last, ok := applied[[2]int{idx, fi}]
+	return last, ok
+}
+
+func setApplied(idx, fi, speed int) {
+	appliedMu.Lock()
+	defer appliedMu.Unlock()
+	applied[[2]int{idx, fi}] = speed
+}
+
+// forgetApplied makes the next SetFanSpeed set fan fi of GPU idx, or all
+// its fans when fi is negative.
+func forgetApplied(idx, fi int) {
+	appliedMu.Lock()
+	defer appliedMu.Unlock()
+	for key := range applied {
+		if key[0] == idx && (fi < 0 || key[1] == fi) {
+			delete(applied, key)
+		}
+	}
+}
+
+// cached returns the value of key in cache, calling get when it's missing.
+func cached[K comparable, V any](cache *map[K]V, key K, get func() (V, bool)) V {
+	cacheMu.Lock()
+	value, ok := (*cache)[key]
+	cacheMu.Unlock()
+	if ok {
+		return value
+	}
+	value, ok = get()
+	if ok {
+		cacheMu.Lock()
+		(*cache)[key] = value
+		cacheMu.Unlock()
+	}
+	return value
+}
@@ -0,0 +1,172 @@
+// Package gpu wraps NVML calls used to read GPU state and control fans.
+package gpu
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+// Log is used for NVML errors, it is replaced when logging is configured.
+var Log = slog.With("component", "nvml")
+
+// Observe is called after every NVML call with its start time and result,
+// e.g. to collect latency metrics. Calls are serialized, so it is never
+// called concurrently. Calls which hung aren't observed.
+var Observe = func(call string, start time.Time, ret nvml.Return) {}
+
+// NVML is the library behind all calls of this package, guarded by a
+// broker. It is replaced by Use, e.g. to run the daemon against simulated
+// GPUs.
+var NVML nvml.Interface = newBroker(library)
+
+var (
+	backend = "nvml"
+	library = nvml.New() // Behind the broker.
+)
+
+// Use makes calls go to lib instead of the NVIDIA library, it has to be
+// called before InitNVML.
+func Use(name string, lib nvml.Interface) {
+	backend, library = name, lib
+	NVML = newBroker(lib)
+	ResetCache()
+}
+
+// Backend returns name of the library in use, "nvml" for real hardware.
+func Backend() string {
+	return backend
+}
+
+// InitNVML initializes NVML library, every command talking to GPUs needs it.
+func InitNVML() error {
+	if ret := NVML.Init(); ret != nvml.SUCCESS {
+		return fmt.Errorf("failed to initialize NVML: %v", nvml.ErrorString(ret))
+	}
+	// Handles of an earlier initialization are no longer valid
+	ResetCache()
+	return nil
+}
+
+// Shutdown releases NVML library initialized by InitNVML.
+func Shutdown() {
+	NVML.Shutdown()
+	ResetCache()
+}
+
+// DeviceIdentity identifies a physical card independently of its enumeration index.
+type DeviceIdentity struct {
+	UUID string
+	Name string
+}
+
+var (
+	identityMu sync.Mutex
+	identities = map[int]DeviceIdentity{}
+)
+
+// GetDeviceIdentity returns UUID and product name of GPU idx, they never
+// change while the driver is loaded so the result is cached.
+func GetDeviceIdentity(idx int) DeviceIdentity {
+	identityMu.Lock()
+	defer identityMu.Unlock()
+	if id, ok := identities[idx]; ok {
+		return id
+	}
+	device, err := DeviceGetHandleByIndex(idx)
+	if err != nil {
+		Log.Error("Can't get identity", "GPU", idx, "error", err)
+		return DeviceIdentity{}
+	}
+	uuid, ret := device.GetUUID()
+	if ret != nvml.SUCCESS {
+		Log.Error("Can't get UUID", "GPU", idx, "error", nvml.ErrorString(ret))
+		return DeviceIdentity{}
+	}
+	name, ret := device.GetName()
+	if ret != nvml.SUCCESS {
+		Log.Error("Can't get name", "GPU", idx, "error", nvml.ErrorString(ret))
+		return DeviceIdentity{UUID: uuid}
+	}
+	id := DeviceIdentity{UUID: uuid, Name: name}
+	identities[idx] = id
+	return id
+}
+
+func GetDeviceCount() int {
+	deviceCount, err := NVML.DeviceGetCount()
+	if err != nvml.SUCCESS {
+		Log.Error("Can't get device count", "error", err)
+	}
+	return deviceCount
+}
+
+func deviceHandle(idx int) (nvml.Device, nvml.Return) {
+	ret := nvml.SUCCESS
+	device := cached(&handles, idx, func() (nvml.Device, bool) {
+		var device nvml.Device
+		device, ret = NVML.DeviceGetHandleByIndex(idx)
+		return device, ret == nvml.SUCCESS
+	})
+	return device, ret
+}
+
+func DeviceGetHandleByIndex(idx int) (nvml.Device, error) {
+	device, ret := deviceHandle(idx)
+	if ret != nvml.SUCCESS {
+		return nil, fmt.Errorf("can't get handle of GPU %d: %w", idx, returnError(ret))
+	}
+	return device, nil
+}
+
+// ErrLost is wrapped by errors of calls which failed because the GPU or the
+// driver went away, e.g. on a driver reload or a GPU reset. Indexes may
+// have changed once they are back, see Rescan.
+var ErrLost = errors.New("GPU is lost")
+
+// ErrTransient is wrapped by errors of calls which may succeed when
+// retried, e.g. the GPU was busy.
+var ErrTransient = errors.New("temporary failure")
+
+// ErrHung is wrapped by errors of calls which timed out, or failed because
+// an earlier call of the GPU still hangs.
+var ErrHung = errors.New("NVML call hung")
+
+// returnError returns ret as an error, wrapping ErrLost, ErrTransient or
+// ErrHung when it means that.
+func returnError(ret nvml.Return) error {
+	switch ret {
+	case nvml.ERROR_TIMEOUT:
+		return fmt.Errorf("%w: %v", ErrHung, nvml.ErrorString(ret))
+	case nvml.ERROR_GPU_IS_LOST, nvml.ERROR_RESET_REQUIRED, nvml.ERROR_UNINITIALIZED, nvml.ERROR_DRIVER_NOT_LOADED:
+		return fmt.Errorf("%w: %v", ErrLost, nvml.ErrorString(ret))
+	case nvml.ERROR_UNKNOWN, nvml.ERROR_IN_USE, nvml.ERROR_INSUFFICIENT_RESOURCES, nvml.ERROR_MEMORY, nvml.ERROR_IRQ_ISSUE:
+		return fmt.Errorf("%w: %v", ErrTransient, nvml.ErrorString(ret))
+	}
+	return errors.New(nvml.ErrorString(ret))
+}
+
+const (
+	retryAttempts = 4
+	retryDelay    = 100 * time.Millisecond
+)
+
+// Retry calls f until it succeeds or fails with an error which isn't
+// transient, at most retryAttempts times doubling the delay between them.
+// It's meant for commands, the daemon retries in later cycles instead.
+func Retry(f func() error) error {
+	delay := retryDelay
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || !errors.Is(err, ErrTransient) || attempt == retryAttempts {
+			return err
+		}
+		Log.Debug("Retrying", "in", delay, "error", err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
@@ -0,0 +1,314 @@
+package gpu
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+// DefaultFansSpeed returns all fans of GPU idx to driver control, it tries
+// every fan and returns the last error.
+func DefaultFansSpeed(idx int) error {
+	device, err := DeviceGetHandleByIndex(idx)
+	if err != nil {
+		Log.Error("Can't restore fans", "GPU", idx, "error", err)
+		return err
+	}
+	forgetApplied(idx, -1)
+	fan_count := GetNumFans(idx)
+	fallback := false
+	for fan_index := 0; fan_index < fan_count; fan_index++ {
+		ret := device.SetDefaultFanSpeed_v2(fan_index)
+		if ret == nvml.ERROR_NOT_SUPPORTED && Settings != nil {
+			fallback = true
+			continue
+		}
+		if ret != nvml.SUCCESS {
+			Log.Error("Error resetting fan speed", "GPU", idx, "fan", fan_index, "error", ret)
+			err = fmt.Errorf("can't restore GPU %d fan %d: %v", idx, fan_index, nvml.ErrorString(ret))
+			continue
+		}
+		Log.Debug("Default fan control restored", "fan", fan_index)
+	}
+	if fallback {
+		if e := Settings.Release(idx); e != nil {
+			Log.Error("Error resetting fan speed", "GPU", idx, "error", e)
+			err = e
+		}
+	}
+	return err
+}
+
+// ErrNotMeasured is returned for fans whose speed neither the library in use
+// nor nvidia-settings measures.
+var ErrNotMeasured = errors.New("fan speed isn't measured")
+
+// rpmDevice is a device measuring speeds of its fans. The NVML bindings in
+// use don't have the call, simulated GPUs do.
+type rpmDevice interface {
+	GetFanSpeedRPM(fan int) (int, nvml.Return)
+}
+
+// GetFanRPM returns the measured speed of fan fi of GPU idx in revolutions
+// per minute, read through nvidia-settings when the library can't. Unlike
+// GetFanSpeed_v2, which reports the speed the fan is driven at, it shows
+// whether the fan gets there.
+func GetFanRPM(idx, fi int) (int, error) {
+	device, err := DeviceGetHandleByIndex(idx)
+	if err != nil {
+		return 0, err
+	}
+	if d, ok := device.(rpmDevice); ok {
+		rpm, ret := d.GetFanSpeedRPM(fi)
+		if ret == nvml.SUCCESS {
+			return rpm, nil
+		}
+		if ret != nvml.ERROR_NOT_SUPPORTED {
+			return 0, fmt.Errorf("unable to measure fan %d speed: %w", fi, returnError(ret))
+		}
+	}
+	if Settings != nil {
+		return Settings.FanRPM(idx, fi)
+	}
+	return 0, ErrNotMeasured
+}
+
+func GetNumFans(idx int) int {
+	device, err := DeviceGetHandleByIndex(idx)
+	if err != nil {
+		Log.Error("Unable to get fan count of device", "error", err)
+		return 0
+	}
+	return cached(&fanCounts, idx, func() (int, bool) {
+		fan_count, ret := device.GetNumFans()
+		if ret != nvml.SUCCESS {
+			Log.Error("Unable to get fan count of device", "error", nvml.ErrorString(ret))
+		}
+		return fan_count, ret == nvml.SUCCESS
+	})
+}
+
+// ErrUnsupported is wrapped by errors of cards which don't allow manual fan
+// control.
+var ErrUnsupported = errors.New("fan control is unsupported")
+
+// CheckSupport verifies that GPU idx reports what fan control needs, its
+// fans and their speed range. Setting a speed has to be probed separately.
+func CheckSupport(idx int) error {
+	device, err := DeviceGetHandleByIndex(idx)
+	if err != nil {
+		return err
+	}
+	count, ret := device.GetNumFans()
+	switch {
+	case ret == nvml.ERROR_NOT_SUPPORTED:
+		return fmt.Errorf("%w: GPU %d doesn't report its fans", ErrUnsupported, idx)
+	case ret != nvml.SUCCESS:
+		return fmt.Errorf("can't get fan count of GPU %d: %w", idx, returnError(ret))
+	case count == 0:
+		return fmt.Errorf("%w: GPU %d has no fans", ErrUnsupported, idx)
+	}
+	// The fallback needs no range, the driver enforces the minimum
+	if _, _, ret := device.GetMinMaxFanSpeed(); ret == nvml.ERROR_NOT_SUPPORTED && Settings == nil {
+		return fmt.Errorf("%w: GPU %d doesn't report its fan speed range", ErrUnsupported, idx)
+	}
+	return nil
+}
+
+// GetFanSpeed returns the average speed of all fans of the card.
+func GetFanSpeed(idx int) int {
+	device, err := DeviceGetHandleByIndex(idx)
+	if err != nil {
+		Log.Error("Can't get fan speed", "GPU", idx, "error", err)
+		return 0
+	}
+	fanCount := GetNumFans(idx)
+	if fanCount == 0 {
+		return 0
+	}
+	total := 0
+	for fi := 0; fi < fanCount; fi++ {
+		speed, ret := device.GetFanSpeed_v2(fi)
+		if ret != nvml.SUCCESS {
+			Log.Error("Can't get fan speed", "GPU", idx, "fan", fi, "error", nvml.ErrorString(ret))
+		}
+		total += int(speed)
+	}
+	return total / fanCount
+}
+
+func GetMinMaxFanSpeed(device nvml.Device) (int, int) {
+	speeds := cached(&fanRanges, device, func() ([2]int, bool) {
+		minSpeed, maxSpeed, ret := device.GetMinMaxFanSpeed()
+		if ret == nvml.ERROR_NOT_SUPPORTED && Settings != nil {
+			// Older GPUs don't report it, the driver enforces their minimum
+			return [2]int{0, 100}, true
+		}
+		if ret != nvml.SUCCESS {
+			Log.Error("Error can't get min/max fan speed", "error", ret)
+		}
+		return [2]int{minSpeed, maxSpeed}, ret == nvml.SUCCESS
+	})
+	return speeds[0], speeds[1]
+}
+
+// fallbackMaxTemp is assumed for cards which don't report their slowdown
+// threshold.
+const fallbackMaxTemp = 90
+
+func GetMaxGPUTempThreshold(device nvml.Device) int {
+	if temp := maxTempThreshold(device); temp > 0 {
+		return temp
+	}
+	return fallbackMaxTemp
+}
+
+// maxTempThreshold returns the maximum operating temperature (GPU_MAX
+// threshold) reported by the card, 0 when it doesn't report one.
+func maxTempThreshold(device nvml.Device) int {
+	return cached(&tempLimits, device, func() (int, bool) {
+		temp, ret := device.GetTemperatureThreshold(nvml.TEMPERATURE_THRESHOLD_GPU_MAX)
+		if ret == nvml.ERROR_NOT_SUPPORTED {
+			Log.Warn("Card doesn't report its slowdown threshold, assuming it", "temp", fallbackMaxTemp)
+			return 0, true
+		}
+		if ret != nvml.SUCCESS {
+			Log.Error("Error can't get max temperature threshold", "error", ret)
+		}
+		return int(temp), ret == nvml.SUCCESS
+	})
+}
+
+func GetTemperature(idx int) (int, error) {
+	device, err := DeviceGetHandleByIndex(idx)
+	if err != nil {
+		return 0, err
+	}
+	temp, ret := device.GetTemperature(nvml.TEMPERATURE_GPU)
+	if ret != nvml.SUCCESS {
+		return 0, fmt.Errorf("can't get temperature of GPU %d: %w", idx, returnError(ret))
+	}
+	return int(temp), nil
+}
+
+// SetFanSpeed sets speed of all fans of GPU idx. Fans which already got
+// the speed from it are skipped without asking the driver, VerifyFans
+// checks they still have it.
+func SetFanSpeed(idx int, speed int) error {
+	device, err := DeviceGetHandleByIndex(idx)
+	if err != nil {
+		return err
+	}
+	for fi := 0; fi < GetNumFans(idx); fi++ {
+		if last, ok := lastApplied(idx, fi); ok && last == speed {
+			Log.Debug("Skip, speed unchanged", "GPU", idx, "fan", fi)
+			continue
+		}
+		ret := device.SetFanSpeed_v2(fi, speed)
+		if ret == nvml.ERROR_NOT_SUPPORTED && Settings != nil {
+			if err := Settings.SetFanSpeed(idx, fi, speed); err != nil {
+				return err
+			}
+			setApplied(idx, fi, speed)
+			continue
+		}
+		if ret != nvml.SUCCESS {
+			return fmt.Errorf("unable to set fan %d speed %d: %w", fi, speed, returnError(ret))
+		}
+		setApplied(idx, fi, speed)
+	}
+	return nil
+}
+
+// VerifyFans checks that fans of GPU idx set by SetFanSpeed are still in
+// manual mode at that speed, and sets them again when something else, like
+// a driver reset or a vendor tool, changed them. It returns the fans which
+// had to be reasserted. Values the card doesn't report are trusted.
+func VerifyFans(idx int) ([]int, error) {
+	device, err := DeviceGetHandleByIndex(idx)
+	if err != nil {
+		return nil, err
+	}
+	var reasserted []int
+	for fi := 0; fi < GetNumFans(idx); fi++ {
+		speed, ok := lastApplied(idx, fi)
+		if !ok {
+			continue
+		}
+		policy, ret := device.GetFanControlPolicy_v2(fi)
+		manual := ret != nvml.SUCCESS || policy == nvml.FAN_POLICY_MANUAL
+		target, ret := device.GetTargetFanSpeed(fi)
+		if manual && (ret != nvml.SUCCESS || target == speed) {
+			continue
+		}
+		Log.Warn("Fan left commanded state, reasserting", "GPU", idx, "fan", fi,
+			"policy", FanPolicyName(policy), "target", target, "speed", speed)
+		if ret := SetSingleFanSpeed(idx, fi, speed); ret != nvml.SUCCESS {
+			return reasserted, fmt.Errorf("can't reassert GPU %d fan %d speed %d: %v", idx, fi, speed, nvml.ErrorString(ret))
+		}
+		setApplied(idx, fi, speed)
+		reasserted = append(reasserted, fi)
+	}
+	return reasserted, nil
+}
+
+// SetSingleFanSpeed sets speed of one fan, unlike SetFanSpeed it reports errors to the caller.
+func SetSingleFanSpeed(idx, fi, speed int) nvml.Return {
+	device, ret := deviceHandle(idx)
+	if ret != nvml.SUCCESS {
+		return ret
+	}
+	forgetApplied(idx, fi)
+	ret = device.SetFanSpeed_v2(fi, speed)
+	if ret == nvml.ERROR_NOT_SUPPORTED && Settings != nil {
+		return settingsReturn(Settings.SetFanSpeed(idx, fi, speed))
+	}
+	return ret
+}
+
+// SetSingleFanDefault returns one fan to driver control.
+func SetSingleFanDefault(idx, fi int) nvml.Return {
+	device, ret := deviceHandle(idx)
+	if ret != nvml.SUCCESS {
+		return ret
+	}
+	forgetApplied(idx, fi)
+	ret = device.SetDefaultFanSpeed_v2(fi)
+	if ret == nvml.ERROR_NOT_SUPPORTED && Settings != nil {
+		// Control state is per GPU, the first fan releases all of them
+		return settingsReturn(Settings.Release(idx))
+	}
+	return ret
+}
+
+func GetThermalInfo(idx int) (int, int, int, error) {
+	device, err := DeviceGetHandleByIndex(idx)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	minSpeed, maxSpeed := GetMinMaxFanSpeed(device)
+	Log.Debug("Fan speed range", "GPU", idx, "min", minSpeed, "max", maxSpeed)
+	maxTemp := GetMaxGPUTempThreshold(device)
+	Log.Debug("Max temperature", "GPU", idx, "temp", maxTemp)
+	return minSpeed, maxSpeed, maxTemp, nil
+}
+
+// Clock throttle reasons caused by temperature.
+const thermalThrottleReasons = nvml.ClocksThrottleReasonSwThermalSlowdown |
+	nvml.ClocksThrottleReasonHwThermalSlowdown |
+	nvml.ClocksThrottleReasonHwSlowdown
+
+// IsThermalThrottled reports whether GPU clocks are currently reduced because of temperature.
+func IsThermalThrottled(idx int) bool {
+	device, err := DeviceGetHandleByIndex(idx)
+	if err != nil {
+		return false
+	}
+	reasons, ret := device.GetCurrentClocksThrottleReasons()
+	if ret != nvml.SUCCESS {
+		Log.Debug("Can't get clock throttle reasons", "GPU", idx, "error", nvml.ErrorString(ret))
+		return false
+	}
+	return reasons&thermalThrottleReasons != 0
+}
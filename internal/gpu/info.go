@@ -0,0 +1,172 @@
+package gpu
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+// FanInfo describes one fan in device listing.
+type FanInfo struct {
+	Index    int    `json:"index"`
+	Speed    int    `json:"speed"`
+	MinSpeed int    `json:"min_speed"`
+	MaxSpeed int    `json:"max_speed"`
+	Policy   string `json:"policy,omitempty"`
+}
+
+// CardInfo describes one GPU in device listing. Optional fields are left
+// empty when the card doesn't support the query.
+type CardInfo struct {
+	Index       int       `json:"index"`
+	UUID        string    `json:"uuid"`
+	Serial      string    `json:"serial,omitempty"`
+	Name        string    `json:"name"`
+	PCI         string    `json:"pci_bus_id,omitempty"`
+	VBIOS       string    `json:"vbios,omitempty"`
+	Persistence *bool     `json:"persistence_mode,omitempty"`
+	Power       float64   `json:"power_draw_watts,omitempty"`
+	PowerLimit  float64   `json:"power_limit_watts,omitempty"`
+	GraphicsMHz int       `json:"graphics_clock_mhz,omitempty"`
+	SMMHz       int       `json:"sm_clock_mhz,omitempty"`
+	MemoryMHz   int       `json:"memory_clock_mhz,omitempty"`
+	Temperature int       `json:"temperature"`
+	MaxTemp     int       `json:"max_temperature,omitempty"`
+	Fans        []FanInfo `json:"fans"`
+}
+
+// SystemInfo describes the host in device listing.
+type SystemInfo struct {
+	DriverVersion string     `json:"driver_version"`
+	NVMLVersion   string     `json:"nvml_version"`
+	Cards         []CardInfo `json:"cards"`
+}
+
+func GetSystemInfo() SystemInfo {
+	driver, ret := NVML.SystemGetDriverVersion()
+	if ret != nvml.SUCCESS {
+		Log.Debug("Can't get driver version", "error", nvml.ErrorString(ret))
+	}
+	version, ret := NVML.SystemGetNVMLVersion()
+	if ret != nvml.SUCCESS {
+		Log.Debug("Can't get NVML version", "error", nvml.ErrorString(ret))
+	}
+	return SystemInfo{DriverVersion: driver, NVMLVersion: version, Cards: []CardInfo{}}
+}
+
+// busID returns the PCI bus id reported by NVML, which includes the function
+// number.
+func busID(pci nvml.PciInfo) string {
+	var id strings.Builder
+	for _, c := range pci.BusId {
+		if c == 0 {
+			break
+		}
+		id.WriteByte(byte(c))
+	}
+	return id.String()
+}
+
+// getExtendedInfo fills inventory fields of the listing, which aren't
+// needed for fan control and are missing on some cards.
+func getExtendedInfo(device nvml.Device, info *CardInfo) {
+	if pci, ret := device.GetPciInfo(); ret == nvml.SUCCESS {
+		info.PCI = busID(pci)
+	}
+	if vbios, ret := device.GetVbiosVersion(); ret == nvml.SUCCESS {
+		info.VBIOS = vbios
+	}
+	if mode, ret := device.GetPersistenceMode(); ret == nvml.SUCCESS {
+		enabled := mode == nvml.FEATURE_ENABLED
+		info.Persistence = &enabled
+	}
+	if power, ret := device.GetPowerUsage(); ret == nvml.SUCCESS {
+		info.Power = float64(power) / 1000
+	}
+	if limit, ret := device.GetEnforcedPowerLimit(); ret == nvml.SUCCESS {
+		info.PowerLimit = float64(limit) / 1000
+	}
+	if clock, ret := device.GetClockInfo(nvml.CLOCK_GRAPHICS); ret == nvml.SUCCESS {
+		info.GraphicsMHz = int(clock)
+	}
+	if clock, ret := device.GetClockInfo(nvml.CLOCK_SM); ret == nvml.SUCCESS {
+		info.SMMHz = int(clock)
+	}
+	if clock, ret := device.GetClockInfo(nvml.CLOCK_MEM); ret == nvml.SUCCESS {
+		info.MemoryMHz = int(clock)
+	}
+}
+
+func GetCardInfo(idx int) (CardInfo, error) {
+	device, err := DeviceGetHandleByIndex(idx)
+	if err != nil {
+		return CardInfo{}, err
+	}
+	// Consumer cards have no serial number
+	sn, ret := device.GetSerial()
+	if ret == nvml.ERROR_NOT_SUPPORTED {
+		sn = ""
+	} else if ret != nvml.SUCCESS {
+		return CardInfo{}, fmt.Errorf("can't get serial number of GPU %d: %w", idx, returnError(ret))
+	}
+	uuid, ret := device.GetUUID()
+	if ret != nvml.SUCCESS {
+		return CardInfo{}, fmt.Errorf("can't get UUID of GPU %d: %w", idx, returnError(ret))
+	}
+	name, ret := device.GetName()
+	if ret != nvml.SUCCESS {
+		return CardInfo{}, fmt.Errorf("can't get name of GPU %d: %w", idx, returnError(ret))
+	}
+	minSpeed, maxSpeed, _, err := GetThermalInfo(idx)
+	if err != nil {
+		return CardInfo{}, err
+	}
+	temp, err := GetTemperature(idx)
+	if err != nil {
+		return CardInfo{}, err
+	}
+	info := CardInfo{
+		Index:       idx,
+		UUID:        uuid,
+		Serial:      sn,
+		Name:        name,
+		Temperature: temp,
+		MaxTemp:     maxTempThreshold(device),
+		Fans:        []FanInfo{},
+	}
+	getExtendedInfo(device, &info)
+	for i := 0; i < GetNumFans(idx); i++ {
+		policy, ret := device.GetFanControlPolicy_v2(i)
+		name := FanPolicyName(policy)
+		if ret == nvml.ERROR_NOT_SUPPORTED {
+			name = ""
+		} else if ret != nvml.SUCCESS {
+			return info, fmt.Errorf("can't get fan control policy of GPU %d fan %d: %w", idx, i, returnError(ret))
+		}
+		speed, ret := device.GetFanSpeed_v2(i)
+		if ret != nvml.SUCCESS {
+			return info, fmt.Errorf("can't get speed of GPU %d fan %d: %w", idx, i, returnError(ret))
+		}
+		info.Fans = append(info.Fans, FanInfo{
+			Index:    i,
+			Speed:    int(speed),
+			MinSpeed: minSpeed,
+			MaxSpeed: maxSpeed,
+			Policy:   name,
+		})
+	}
+	return info, nil
+}
+
+// FanPolicyName returns a short name of fan control policy.
+func FanPolicyName(policy nvml.FanControlPolicy) string {
+	switch policy {
+	case nvml.FAN_POLICY_TEMPERATURE_CONTINOUS_SW:
+		return "auto"
+	case nvml.FAN_POLICY_MANUAL:
+		return "manual"
+	default:
+		return fmt.Sprintf("%d", policy)
+	}
+}
@@ -0,0 +1,134 @@
+package gpu
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+// FanState is what a fan did before nvmlfan took control of it.
+type FanState struct {
+	Policy nvml.FanControlPolicy
+	Target int // Speed set by a manual policy.
+}
+
+var (
+	capturedMu sync.Mutex
+	// Original state of fans by GPU UUID, recorded before the first speed was
+	// set. Indexes change when GPUs are attached or removed.
+	captured = map[string][]FanState{}
+)
+
+// ManualFans returns fans of GPU idx which CaptureFans found in manual
+// mode, set by another program or left so by an earlier run.
+func ManualFans(idx int) []int {
+	uuid := GetDeviceIdentity(idx).UUID
+	capturedMu.Lock()
+	defer capturedMu.Unlock()
+	var fans []int
+	for fi, state := range captured[uuid] {
+		if state.Policy == nvml.FAN_POLICY_MANUAL {
+			fans = append(fans, fi)
+		}
+	}
+	return fans
+}
+
+// CaptureFans records control policy and target speed of all fans of GPU
+// idx, so RestoreFans can return them exactly. The first capture is kept,
+// later ones would see fans already set by us.
+func CaptureFans(idx int) error {
+	uuid := GetDeviceIdentity(idx).UUID
+	capturedMu.Lock()
+	defer capturedMu.Unlock()
+	if _, ok := captured[uuid]; ok {
+		return nil
+	}
+	device, err := DeviceGetHandleByIndex(idx)
+	if err != nil {
+		return err
+	}
+	var states []FanState
+	for fi := 0; fi < GetNumFans(idx); fi++ {
+		policy, ret := device.GetFanControlPolicy_v2(fi)
+		if ret == nvml.ERROR_NOT_SUPPORTED {
+			// Such fans only know the driver curve and manual speeds
+			policy = nvml.FAN_POLICY_TEMPERATURE_CONTINOUS_SW
+		} else if ret != nvml.SUCCESS {
+			return fmt.Errorf("can't get policy of GPU %d fan %d: %v", idx, fi, nvml.ErrorString(ret))
+		}
+		target, ret := device.GetTargetFanSpeed(fi)
+		if ret != nvml.SUCCESS {
+			return fmt.Errorf("can't get target speed of GPU %d fan %d: %v", idx, fi, nvml.ErrorString(ret))
+		}
+		Log.Debug("Captured fan state", "GPU", idx, "fan", fi, "policy", FanPolicyName(policy), "target", target)
+		states = append(states, FanState{Policy: policy, Target: target})
+	}
+	captured[uuid] = states
+	return nil
+}
+
+// RestoreFans returns fans of GPU idx to the state recorded by CaptureFans:
+// fans found in manual mode get their old speed back, the rest go to the
+// driver. Without a capture, e.g. after a crash of the controlling process,
+// all fans go to the driver.
+func RestoreFans(idx int) error {
+	uuid := GetDeviceIdentity(idx).UUID
+	capturedMu.Lock()
+	states, ok := captured[uuid]
+	delete(captured, uuid)
+	capturedMu.Unlock()
+	if !ok {
+		return DefaultFansSpeed(idx)
+	}
+	device, err := DeviceGetHandleByIndex(idx)
+	if err != nil {
+		return err
+	}
+	forgetApplied(idx, -1)
+	for fi, state := range states {
+		if state.Policy == nvml.FAN_POLICY_MANUAL {
+			ret := device.SetFanSpeed_v2(fi, state.Target)
+			if ret != nvml.SUCCESS {
+				err = fmt.Errorf("can't restore speed of GPU %d fan %d: %v", idx, fi, nvml.ErrorString(ret))
+				Log.Error("Restoring default fan control", "GPU", idx, "fan", fi, "error", err)
+				SetSingleFanDefault(idx, fi)
+			}
+			continue
+		}
+		if ret := SetSingleFanDefault(idx, fi); ret != nvml.SUCCESS {
+			err = fmt.Errorf("can't restore GPU %d fan %d: %v", idx, fi, nvml.ErrorString(ret))
+			Log.Error("Error resetting fan speed", "GPU", idx, "fan", fi, "error", err)
+		}
+	}
+	return err
+}
+
+// SetManualPolicy switches all fans of GPU idx to manual control policy
+// before speeds are set, and checks that the driver kept it. Drivers which
+// don't have the call, or cards relying on the nvidia-settings fallback,
+// switch fans to manual when a speed is set.
+func SetManualPolicy(idx int) error {
+	device, err := DeviceGetHandleByIndex(idx)
+	if err != nil {
+		return err
+	}
+	for fi := 0; fi < GetNumFans(idx); fi++ {
+		switch ret := device.SetFanControlPolicy(fi, nvml.FAN_POLICY_MANUAL); ret {
+		case nvml.SUCCESS:
+		case nvml.ERROR_NOT_SUPPORTED, nvml.ERROR_FUNCTION_NOT_FOUND:
+			Log.Debug("Manual fan policy can't be set, relying on setting speed", "GPU", idx, "fan", fi, "error", nvml.ErrorString(ret))
+			continue
+		case nvml.ERROR_NO_PERMISSION:
+			return fmt.Errorf("driver refused manual policy of GPU %d fan %d with NO_PERMISSION, run nvmlfan as root", idx, fi)
+		default:
+			return fmt.Errorf("driver refused manual policy of GPU %d fan %d: %w", idx, fi, returnError(ret))
+		}
+		policy, ret := device.GetFanControlPolicy_v2(fi)
+		if ret == nvml.SUCCESS && policy != nvml.FAN_POLICY_MANUAL {
+			return fmt.Errorf("GPU %d fan %d stayed in %s policy after switching it to manual, the driver ignores the request", idx, fi, FanPolicyName(policy))
+		}
+	}
+	return nil
+}
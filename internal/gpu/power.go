@@ -0,0 +1,97 @@
+package gpu
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+// PowerLimits are power management limits of a GPU in watts.
+type PowerLimits struct {
+	Current int
+	Default int
+	Min     int
+	Max     int
+}
+
+// GetPowerLimits reads power management limits of GPU idx.
+func GetPowerLimits(idx int) (PowerLimits, error) {
+	device, err := DeviceGetHandleByIndex(idx)
+	if err != nil {
+		return PowerLimits{}, err
+	}
+	current, ret := device.GetPowerManagementLimit()
+	if ret != nvml.SUCCESS {
+		return PowerLimits{}, fmt.Errorf("can't get power limit of GPU %d: %w", idx, returnError(ret))
+	}
+	limits := PowerLimits{Current: int(current / 1000), Default: int(current / 1000), Min: int(current / 1000), Max: int(current / 1000)}
+	if def, ret := device.GetPowerManagementDefaultLimit(); ret == nvml.SUCCESS {
+		limits.Default = int(def / 1000)
+	}
+	if minLimit, maxLimit, ret := device.GetPowerManagementLimitConstraints(); ret == nvml.SUCCESS {
+		limits.Min, limits.Max = int(minLimit/1000), int(maxLimit/1000)
+	}
+	return limits, nil
+}
+
+// SetPowerLimit sets power management limit of GPU idx in watts.
+func SetPowerLimit(idx, watts int) error {
+	device, err := DeviceGetHandleByIndex(idx)
+	if err != nil {
+		return err
+	}
+	if ret := device.SetPowerManagementLimit(uint32(watts * 1000)); ret != nvml.SUCCESS {
+		if ret == nvml.ERROR_NO_PERMISSION {
+			return fmt.Errorf("no permission to set power limit of GPU %d, root is required", idx)
+		}
+		return fmt.Errorf("can't set power limit of GPU %d to %d W: %w", idx, watts, returnError(ret))
+	}
+	return nil
+}
+
+// LockClocks caps graphics and memory clocks of GPU idx in MHz, a zero cap
+// leaves that clock alone.
+func LockClocks(idx, graphics, memory int) error {
+	device, err := DeviceGetHandleByIndex(idx)
+	if err != nil {
+		return err
+	}
+	if graphics > 0 {
+		if ret := device.SetGpuLockedClocks(0, uint32(graphics)); ret != nvml.SUCCESS {
+			return fmt.Errorf("can't cap graphics clock of GPU %d at %d MHz: %w", idx, graphics, clockError(ret))
+		}
+	}
+	if memory > 0 {
+		if ret := device.SetMemoryLockedClocks(0, uint32(memory)); ret != nvml.SUCCESS {
+			return fmt.Errorf("can't cap memory clock of GPU %d at %d MHz: %w", idx, memory, clockError(ret))
+		}
+	}
+	return nil
+}
+
+// ResetClocks removes graphics and memory clock locks of GPU idx.
+func ResetClocks(idx int, graphics, memory bool) error {
+	device, err := DeviceGetHandleByIndex(idx)
+	if err != nil {
+		return err
+	}
+	if graphics {
+		if ret := device.ResetGpuLockedClocks(); ret != nvml.SUCCESS {
+			return fmt.Errorf("can't reset graphics clock of GPU %d: %w", idx, clockError(ret))
+		}
+	}
+	if memory {
+		if ret := device.ResetMemoryLockedClocks(); ret != nvml.SUCCESS {
+			return fmt.Errorf("can't reset memory clock of GPU %d: %w", idx, clockError(ret))
+		}
+	}
+	return nil
+}
+
+func clockError(ret nvml.Return) error {
+	if ret == nvml.ERROR_NO_PERMISSION {
+		return errors.New("root is required")
+	}
+	return returnError(ret)
+}
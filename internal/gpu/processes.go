@@ -0,0 +1,38 @@
+package gpu
+
+import (
+	"slices"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+// Process is a process using a GPU.
+type Process struct {
+	PID     int    `json:"pid"`
+	Memory  uint64 `json:"memory"`  // Used GPU memory in bytes, 0 when unknown.
+	Compute bool   `json:"compute"` // A compute process rather than only a graphics one.
+}
+
+// Processes returns compute and graphics processes running on GPU idx by
+// PID. It fails only when neither of them can be listed.
+func Processes(idx int) ([]Process, error) {
+	device, err := DeviceGetHandleByIndex(idx)
+	if err != nil {
+		return nil, err
+	}
+	compute, ret := device.GetComputeRunningProcesses()
+	graphics, gret := device.GetGraphicsRunningProcesses()
+	if ret != nvml.SUCCESS && gret != nvml.SUCCESS {
+		return nil, returnError(ret)
+	}
+	var processes []Process
+	for i, info := range append(compute, graphics...) {
+		pid := int(info.Pid)
+		if slices.ContainsFunc(processes, func(p Process) bool { return p.PID == pid }) {
+			continue
+		}
+		processes = append(processes, Process{PID: pid, Memory: info.UsedGpuMemory, Compute: i < len(compute)})
+	}
+	slices.SortFunc(processes, func(a, b Process) int { return a.PID - b.PID })
+	return processes, nil
+}
@@ -0,0 +1,147 @@
+package gpu
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+// settingsTimeout limits a single nvidia-settings run, a stuck X server
+// must not freeze a control loop.
+const settingsTimeout = 10 * time.Second
+
+// NvidiaSettings sets fans through nvidia-settings and the Coolbits option
+// of a running X server, for GPUs older than Turing whose fans NVML can't
+// set.
+type NvidiaSettings struct {
+	Path       string // Executable, nvidia-settings from PATH when empty.
+	Display    string // X display, :0 when empty.
+	Xauthority string // Cookie file of the display, if it needs one.
+
+	mu   sync.Mutex
+	last map[int]int // Last set speed by nvidia-settings fan index.
+}
+
+// Settings takes over fans whose NVML calls return NOT_SUPPORTED, nil
+// disables the fallback.
+var Settings *NvidiaSettings
+
+// run assigns attributes in a single nvidia-settings run.
+func (s *NvidiaSettings) run(assignments ...string) error {
+	var args []string
+	for _, a := range assignments {
+		args = append(args, "-a", a)
+	}
+	out, err := s.command(args...)
+	// Some failed assignments are only reported in the output
+	if err == nil && strings.Contains(string(out), "ERROR") {
+		err = fmt.Errorf("assignment failed")
+	}
+	if err != nil {
+		return fmt.Errorf("nvidia-settings: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// query returns the value of attribute, e.g. "[fan:0]/GPUCurrentFanSpeedRPM".
+func (s *NvidiaSettings) query(attribute string) (string, error) {
+	out, err := s.command("-t", "-q", attribute)
+	value := strings.TrimSpace(string(out))
+	if err == nil && (value == "" || strings.Contains(value, "ERROR")) {
+		err = fmt.Errorf("query failed")
+	}
+	if err != nil {
+		return "", fmt.Errorf("nvidia-settings: %w: %s", err, value)
+	}
+	return value, nil
+}
+
+// command runs nvidia-settings on the display with args and returns its output.
+func (s *NvidiaSettings) command(args ...string) ([]byte, error) {
+	path, display := s.Path, s.Display
+	if path == "" {
+		path = "nvidia-settings"
+	}
+	if display == "" {
+		display = ":0"
+	}
+	args = append([]string{"-c", display}, args...)
+	ctx, cancel := context.WithTimeout(context.Background(), settingsTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, path, args...)
+	if s.Xauthority != "" {
+		cmd.Env = append(os.Environ(), "XAUTHORITY="+s.Xauthority)
+	}
+	Log.Debug("Running nvidia-settings", "args", args)
+	return cmd.CombinedOutput()
+}
+
+// fanIndex returns the nvidia-settings index of fan fi of GPU idx, which
+// counts fans of all GPUs in order.
+func fanIndex(idx, fi int) int {
+	for i := 0; i < idx; i++ {
+		fi += GetNumFans(i)
+	}
+	return fi
+}
+
+// SetFanSpeed takes control of GPU idx and sets speed of its fan fi.
+func (s *NvidiaSettings) SetFanSpeed(idx, fi, speed int) error {
+	fan := fanIndex(idx, fi)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Every run connects to X, don't repeat unchanged speeds
+	if last, ok := s.last[fan]; ok && last == speed {
+		return nil
+	}
+	err := s.run(fmt.Sprintf("[gpu:%d]/GPUFanControlState=1", idx),
+		fmt.Sprintf("[fan:%d]/GPUTargetFanSpeed=%d", fan, speed))
+	if err != nil {
+		return err
+	}
+	if s.last == nil {
+		s.last = map[int]int{}
+	}
+	s.last[fan] = speed
+	return nil
+}
+
+// Release returns all fans of GPU idx to the driver.
+func (s *NvidiaSettings) Release(idx int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	first := fanIndex(idx, 0)
+	for fi := 0; fi < GetNumFans(idx); fi++ {
+		delete(s.last, first+fi)
+	}
+	return s.run(fmt.Sprintf("[gpu:%d]/GPUFanControlState=0", idx))
+}
+
+// FanRPM returns the measured speed of fan fi of GPU idx.
+func (s *NvidiaSettings) FanRPM(idx, fi int) (int, error) {
+	value, err := s.query(fmt.Sprintf("[fan:%d]/GPUCurrentFanSpeedRPM", fanIndex(idx, fi)))
+	if err != nil {
+		return 0, err
+	}
+	rpm, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("nvidia-settings: invalid fan speed '%s'", value)
+	}
+	return rpm, nil
+}
+
+// settingsReturn converts an error of the fallback to a NVML result.
+func settingsReturn(err error) nvml.Return {
+	if err != nil {
+		Log.Error("Fan control through nvidia-settings failed", "error", err)
+		return nvml.ERROR_UNKNOWN
+	}
+	return nvml.SUCCESS
+}
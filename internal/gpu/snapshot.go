@@ -0,0 +1,70 @@
+package gpu
+
+import (
+	"time"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+// Snapshot is the state of a GPU read once per control cycle. Controllers,
+// telemetry, metrics and the API all use it instead of querying NVML for the
+// same values again.
+type Snapshot struct {
+	Time        time.Time `json:"time"`
+	GPU         int       `json:"gpu"`
+	Temp        int       `json:"temp"`
+	Fans        []int     `json:"fans"`           // Speed of every fan in percent.
+	Speed       int       `json:"speed"`          // Average speed of all fans.
+	Power       float64   `json:"power"`          // Power draw in watts.
+	Utilization int       `json:"utilization"`    // GPU utilization in percent, -1 when unknown.
+	Graphics    int       `json:"graphics_clock"` // Graphics clock in MHz.
+	Memory      int       `json:"memory_clock"`   // Memory clock in MHz.
+	Throttled   bool      `json:"throttled"`      // Clocks are reduced for thermal reasons.
+	PState      int       `json:"pstate"`         // Performance state, 0 is the fastest, -1 when unknown.
+}
+
+// TakeSnapshot reads the state of GPU idx at now. Only the temperature is
+// required, other values which can't be read are left zero.
+func TakeSnapshot(idx int, now time.Time) (Snapshot, error) {
+	s := Snapshot{Time: now, GPU: idx, Utilization: -1, PState: -1}
+	temp, err := GetTemperature(idx)
+	if err != nil {
+		return s, err
+	}
+	s.Temp = temp
+	device, err := DeviceGetHandleByIndex(idx)
+	if err != nil {
+		return s, err
+	}
+
+	s.Fans = make([]int, GetNumFans(idx))
+	for fi := range s.Fans {
+		speed, ret := device.GetFanSpeed_v2(fi)
+		if ret != nvml.SUCCESS {
+			Log.Error("Can't get fan speed", "GPU", idx, "fan", fi, "error", nvml.ErrorString(ret))
+		}
+		s.Fans[fi] = int(speed)
+		s.Speed += int(speed)
+	}
+	if len(s.Fans) > 0 {
+		s.Speed /= len(s.Fans)
+	}
+
+	if power, ret := device.GetPowerUsage(); ret == nvml.SUCCESS {
+		s.Power = float64(power) / 1000
+	}
+	if utilization, ret := device.GetUtilizationRates(); ret == nvml.SUCCESS {
+		s.Utilization = int(utilization.Gpu)
+	}
+	if clock, ret := device.GetClockInfo(nvml.CLOCK_GRAPHICS); ret == nvml.SUCCESS {
+		s.Graphics = int(clock)
+	}
+	if clock, ret := device.GetClockInfo(nvml.CLOCK_MEM); ret == nvml.SUCCESS {
+		s.Memory = int(clock)
+	}
+	if pstate, ret := device.GetPerformanceState(); ret == nvml.SUCCESS && pstate <= nvml.PSTATE_15 {
+		s.PState = int(pstate)
+	}
+	s.Throttled = IsThermalThrottled(idx)
+	return s, nil
+}
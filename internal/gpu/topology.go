@@ -0,0 +1,21 @@
+package gpu
+
+// Topology identifies the set of GPUs present in the system, or is empty
+// when it can't be told. Unlike the device count it needs no NVML
+// initialization, so it also changes when GPUs are attached after it.
+func Topology() string {
+	if t, ok := library.(interface{ Topology() string }); ok {
+		return t.Topology()
+	}
+	if backend != "nvml" {
+		return ""
+	}
+	return pciTopology()
+}
+
+// Rescan initializes NVML again, so GPUs attached or removed since it was
+// initialized are enumerated. Indexes may change, cached handles are dropped.
+func Rescan() error {
+	NVML.Shutdown()
+	return InitNVML()
+}
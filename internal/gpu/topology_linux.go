@@ -0,0 +1,22 @@
+package gpu
+
+import (
+	"os"
+	"strings"
+)
+
+// pciTopology lists PCI addresses of devices bound to the NVIDIA driver,
+// they change when an eGPU is attached or a GPU is rebound to VFIO.
+func pciTopology() string {
+	entries, err := os.ReadDir("/sys/bus/pci/drivers/nvidia")
+	if err != nil {
+		return ""
+	}
+	var addresses []string
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), ":") {
+			addresses = append(addresses, entry.Name())
+		}
+	}
+	return strings.Join(addresses, ",")
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package gpu
+
+// pciTopology is unknown, GPUs are only enumerated when NVML is initialized.
+func pciTopology() string {
+	return ""
+}
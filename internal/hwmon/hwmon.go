@@ -0,0 +1,118 @@
+// Package hwmon drives PWM outputs of the Linux hwmon subsystem, such as
+// fans of Intel and AMD GPUs or chassis fans on the motherboard.
+package hwmon
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Values of pwmN_enable, automatic modes above 2 are driver specific.
+const (
+	enableManual = "1"
+	enableAuto   = "2"
+)
+
+// PWM is a fan output like /sys/class/hwmon/hwmon3/pwm1, its duty cycle is
+// written as 0-255 after switching the output to manual mode.
+type PWM struct {
+	path     string
+	enable   string // Empty when the driver has no mode switch.
+	original string // Mode found on Open.
+	manual   bool   // Output was switched to manual mode.
+}
+
+// Open checks that the output at path can be read and remembers its mode,
+// so Restore can return it to the driver.
+func Open(path string) (*PWM, error) {
+	if _, err := readInt(path); err != nil {
+		return nil, err
+	}
+	p := &PWM{path: path}
+	enable := path + "_enable"
+	data, err := os.ReadFile(enable)
+	if err == nil {
+		p.enable = enable
+		p.original = strings.TrimSpace(string(data))
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Path returns the path of the output.
+func (p *PWM) Path() string {
+	return p.path
+}
+
+// Speed returns the current duty cycle in percent.
+func (p *PWM) Speed() (int, error) {
+	value, err := readInt(p.path)
+	if err != nil {
+		return 0, err
+	}
+	return int(math.Round(float64(value) * 100 / 255)), nil
+}
+
+// SetSpeed sets the duty cycle of the output in percent, switching it to
+// manual mode first unless it is already. The mode is switched again after
+// a failed write.
+func (p *PWM) SetSpeed(percent int) error {
+	if p.enable != "" && !p.manual {
+		if err := write(p.enable, enableManual); err != nil {
+			return err
+		}
+		p.manual = true
+	}
+	value := int(math.Round(float64(min(max(percent, 0), 100)) * 255 / 100))
+	if err := write(p.path, strconv.Itoa(value)); err != nil {
+		p.manual = false
+		return err
+	}
+	return nil
+}
+
+// Restore returns the output to the mode it had on Open. Outputs found in
+// manual mode, e.g. left by a crashed controller, go to automatic mode.
+func (p *PWM) Restore() error {
+	if p.enable == "" {
+		// Nothing decides the speed but us, run at full speed to be safe
+		return write(p.path, "255")
+	}
+	mode := p.original
+	if mode == enableManual {
+		mode = enableAuto
+	}
+	p.manual = false
+	return write(p.enable, mode)
+}
+
+// ReadTemp reads a temperature input like /sys/class/hwmon/hwmon3/temp1_input
+// in °C.
+func ReadTemp(path string) (int, error) {
+	value, err := readInt(path)
+	if err != nil {
+		return 0, err
+	}
+	// Inputs are in millidegrees
+	return int(math.Round(float64(value) / 1000)), nil
+}
+
+func readInt(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	value, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", path, err)
+	}
+	return value, nil
+}
+
+func write(path, value string) error {
+	return os.WriteFile(path, []byte(value), 0o644)
+}
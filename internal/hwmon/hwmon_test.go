@@ -0,0 +1,75 @@
+package hwmon
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// output creates a fake pwm output in manual mode when enable isn't empty.
+func output(t *testing.T, enable string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "pwm1")
+	if err := os.WriteFile(path, []byte("128\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if enable != "" {
+		if err := os.WriteFile(path+"_enable", []byte(enable+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+func read(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func TestSetSpeedSwitchesModeOnce(t *testing.T) {
+	path := output(t, enableAuto)
+	p, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetSpeed(50); err != nil {
+		t.Fatal(err)
+	}
+	if mode, value := read(t, path+"_enable"), read(t, path); mode != enableManual || value != "128" {
+		t.Errorf("mode %s and value %s, want %s and 128", mode, value, enableManual)
+	}
+	// A marker shows whether the mode is written again
+	os.WriteFile(path+"_enable", []byte("marker"), 0o644)
+	if err := p.SetSpeed(100); err != nil {
+		t.Fatal(err)
+	}
+	if mode, value := read(t, path+"_enable"), read(t, path); mode != "marker" || value != "255" {
+		t.Errorf("mode %s and value %s, want mode left alone and 255", mode, value)
+	}
+
+	// A failed write switches the mode again next time
+	os.Remove(path)
+	os.Mkdir(path, 0o755)
+	if err := p.SetSpeed(50); err == nil {
+		t.Fatal("write to a directory succeeded")
+	}
+	os.Remove(path)
+	if err := p.SetSpeed(50); err != nil {
+		t.Fatal(err)
+	}
+	if mode := read(t, path+"_enable"); mode != enableManual {
+		t.Errorf("mode %s after a failed write, want %s", mode, enableManual)
+	}
+
+	if err := p.Restore(); err != nil {
+		t.Fatal(err)
+	}
+	if mode := read(t, path+"_enable"); mode != enableAuto {
+		t.Errorf("restored mode %s, want %s", mode, enableAuto)
+	}
+}
@@ -0,0 +1,321 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Just enough of the D-Bus wire protocol to call a method on a bus and wait
+// for its reply, little endian only.
+
+const (
+	dbusMethodCall   = 1
+	dbusMethodReturn = 2
+	dbusError        = 3
+
+	dbusFieldPath        = 1
+	dbusFieldInterface   = 2
+	dbusFieldMember      = 3
+	dbusFieldErrorName   = 4
+	dbusFieldReplySerial = 5
+	dbusFieldDestination = 6
+	dbusFieldSignature   = 8
+)
+
+// dbusConn is an authenticated connection to a bus.
+type dbusConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	serial uint32
+}
+
+// dialDBus connects to the bus at address, like
+// "unix:path=/run/user/1000/bus", and registers on it.
+func dialDBus(ctx context.Context, address string) (*dbusConn, error) {
+	var network, path string
+	// Only the first of alternative addresses is tried
+	address, _, _ = strings.Cut(address, ";")
+	transport, params, _ := strings.Cut(address, ":")
+	if transport != "unix" {
+		return nil, fmt.Errorf("unsupported D-Bus address '%s'", address)
+	}
+	for _, param := range strings.Split(params, ",") {
+		key, value, _ := strings.Cut(param, "=")
+		switch key {
+		case "path":
+			network, path = "unix", value
+		case "abstract":
+			network, path = "unix", "@"+value
+		}
+	}
+	if path == "" {
+		return nil, fmt.Errorf("unsupported D-Bus address '%s'", address)
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, path)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	c := &dbusConn{conn: conn, reader: bufio.NewReader(conn)}
+	if err := c.auth(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("D-Bus authentication: %w", err)
+	}
+	if _, err := c.call("org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "Hello", "", nil); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// auth authenticates as the user of the process.
+func (c *dbusConn) auth() error {
+	uid := hex.EncodeToString([]byte(strconv.Itoa(os.Getuid())))
+	if _, err := c.conn.Write([]byte("\x00AUTH EXTERNAL " + uid + "\r\n")); err != nil {
+		return err
+	}
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "OK ") {
+		return fmt.Errorf("rejected: %s", strings.TrimSpace(line))
+	}
+	_, err = c.conn.Write([]byte("BEGIN\r\n"))
+	return err
+}
+
+func (c *dbusConn) Close() error {
+	return c.conn.Close()
+}
+
+// call calls method of destination with body of signature and returns the
+// body of the reply.
+func (c *dbusConn) call(destination, path, iface, member, signature string, body []byte) ([]byte, error) {
+	c.serial++
+	var e encoder
+	e.bytes('l', dbusMethodCall, 0, 1)
+	e.uint32(uint32(len(body)))
+	e.uint32(c.serial)
+	fields := e.beginArray(8)
+	e.field(dbusFieldPath, "o", path)
+	e.field(dbusFieldDestination, "s", destination)
+	e.field(dbusFieldInterface, "s", iface)
+	e.field(dbusFieldMember, "s", member)
+	if signature != "" {
+		e.field(dbusFieldSignature, "g", signature)
+	}
+	e.endArray(fields)
+	e.align(8)
+	if _, err := c.conn.Write(append(e.buf, body...)); err != nil {
+		return nil, err
+	}
+	// Signals may come before the reply
+	for {
+		kind, fields, reply, err := c.read()
+		if err != nil {
+			return nil, err
+		}
+		if fields.replySerial != c.serial {
+			continue
+		}
+		switch kind {
+		case dbusMethodReturn:
+			return reply, nil
+		case dbusError:
+			return nil, fmt.Errorf("%s: %s", fields.errorName, errorMessage(reply))
+		}
+	}
+}
+
+type dbusFields struct {
+	replySerial uint32
+	errorName   string
+}
+
+// read reads the next message and returns its type, header fields and body.
+func (c *dbusConn) read() (byte, dbusFields, []byte, error) {
+	var fields dbusFields
+	head := make([]byte, 16)
+	if _, err := io.ReadFull(c.reader, head); err != nil {
+		return 0, fields, nil, err
+	}
+	if head[0] != 'l' {
+		return 0, fields, nil, errors.New("big endian D-Bus messages aren't supported")
+	}
+	bodyLen := binary.LittleEndian.Uint32(head[4:])
+	fieldsLen := binary.LittleEndian.Uint32(head[12:])
+	rest := make([]byte, (fieldsLen+7)&^7+bodyLen)
+	if _, err := io.ReadFull(c.reader, rest); err != nil {
+		return 0, fields, nil, err
+	}
+	// Offsets count from the start of the message for alignment
+	d := decoder{buf: append(head, rest...), pos: 16}
+	end := 16 + int(fieldsLen)
+	for d.pos < end && d.err == nil {
+		d.align(8)
+		code := d.byte()
+		signature := d.signature()
+		switch signature {
+		case "u":
+			value := d.uint32()
+			if code == dbusFieldReplySerial {
+				fields.replySerial = value
+			}
+		case "s", "o":
+			value := d.string()
+			if code == dbusFieldErrorName {
+				fields.errorName = value
+			}
+		case "g":
+			d.signature()
+		default:
+			return 0, fields, nil, fmt.Errorf("unexpected D-Bus header field type '%s'", signature)
+		}
+	}
+	if d.err != nil {
+		return 0, fields, nil, d.err
+	}
+	return head[1], fields, d.buf[len(d.buf)-int(bodyLen):], nil
+}
+
+// errorMessage returns the message of an error reply, its first argument
+// when it's a string.
+func errorMessage(body []byte) string {
+	d := decoder{buf: body}
+	message := d.string()
+	if d.err != nil {
+		return "no message"
+	}
+	return message
+}
+
+// encoder marshals D-Bus values, aligned from the start of its buffer.
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) align(n int) {
+	for len(e.buf)%n != 0 {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+func (e *encoder) bytes(b ...byte) {
+	e.buf = append(e.buf, b...)
+}
+
+func (e *encoder) uint32(v uint32) {
+	e.align(4)
+	e.buf = binary.LittleEndian.AppendUint32(e.buf, v)
+}
+
+func (e *encoder) int32(v int32) {
+	e.uint32(uint32(v))
+}
+
+func (e *encoder) string(s string) {
+	e.uint32(uint32(len(s)))
+	e.buf = append(append(e.buf, s...), 0)
+}
+
+func (e *encoder) signature(s string) {
+	e.buf = append(append(e.buf, byte(len(s))), s...)
+	e.buf = append(e.buf, 0)
+}
+
+// beginArray starts an array of elements aligned to alignment, endArray
+// with the returned mark finishes it.
+func (e *encoder) beginArray(alignment int) arrayMark {
+	e.uint32(0)
+	lengthAt := len(e.buf) - 4
+	e.align(alignment)
+	return arrayMark{lengthAt, len(e.buf)}
+}
+
+// arrayMark is where the length of an array goes and where its elements
+// start, the length doesn't count padding before them.
+type arrayMark struct {
+	lengthAt, start int
+}
+
+func (e *encoder) endArray(m arrayMark) {
+	binary.LittleEndian.PutUint32(e.buf[m.lengthAt:], uint32(len(e.buf)-m.start))
+}
+
+// field writes a header field with a value of type kind: o, s or g.
+func (e *encoder) field(code byte, kind, value string) {
+	e.align(8)
+	e.bytes(code)
+	e.signature(kind)
+	if kind == "g" {
+		e.signature(value)
+	} else {
+		e.string(value)
+	}
+}
+
+// decoder unmarshals D-Bus values, the first error stops it.
+type decoder struct {
+	buf []byte
+	pos int
+	err error
+}
+
+func (d *decoder) align(n int) {
+	d.pos = (d.pos + n - 1) / n * n
+}
+
+func (d *decoder) need(n int) bool {
+	if d.err == nil && d.pos+n > len(d.buf) {
+		d.err = io.ErrUnexpectedEOF
+	}
+	return d.err == nil
+}
+
+func (d *decoder) byte() byte {
+	if !d.need(1) {
+		return 0
+	}
+	d.pos++
+	return d.buf[d.pos-1]
+}
+
+func (d *decoder) uint32() uint32 {
+	d.align(4)
+	if !d.need(4) {
+		return 0
+	}
+	d.pos += 4
+	return binary.LittleEndian.Uint32(d.buf[d.pos-4:])
+}
+
+func (d *decoder) string() string {
+	n := int(d.uint32())
+	if !d.need(n + 1) {
+		return ""
+	}
+	d.pos += n + 1
+	return string(d.buf[d.pos-n-1 : d.pos-1])
+}
+
+func (d *decoder) signature() string {
+	n := int(d.byte())
+	if !d.need(n + 1) {
+		return ""
+	}
+	d.pos += n + 1
+	return string(d.buf[d.pos-n-1 : d.pos-1])
+}
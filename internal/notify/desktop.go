@@ -0,0 +1,110 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/IvanBayan/nvmlfan/internal/telemetry"
+)
+
+// desktopTimeout limits showing a notification on one bus: connecting,
+// authentication and the call.
+const desktopTimeout = 5 * time.Second
+
+// Urgency levels of freedesktop notifications.
+const (
+	urgencyNormal   = 1
+	urgencyCritical = 2
+)
+
+// criticalEvents are shown with critical urgency, they stay until dismissed.
+var criticalEvents = map[string]bool{"failsafe": true, "emergency": true, "overheat": true, "hang": true, "fan_failure": true}
+
+// Desktop shows events as freedesktop notifications on session buses.
+type Desktop struct {
+	bus string
+}
+
+// NewDesktop returns a sink showing notifications on the session bus at
+// address. When it's empty the session bus of the process is used, or when
+// there is none, like for a system service, buses of all logged in users.
+func NewDesktop(address string) *Desktop {
+	return &Desktop{bus: address}
+}
+
+// buses returns addresses of session buses to notify.
+func (d *Desktop) buses() []string {
+	if d.bus != "" {
+		return []string{d.bus}
+	}
+	if address := os.Getenv("DBUS_SESSION_BUS_ADDRESS"); address != "" {
+		return []string{address}
+	}
+	paths, _ := filepath.Glob("/run/user/*/bus")
+	var buses []string
+	for _, path := range paths {
+		// Skip buses of system users like gdm
+		if uid, err := strconv.Atoi(filepath.Base(filepath.Dir(path))); err == nil && uid >= 1000 {
+			buses = append(buses, "unix:path="+path)
+		}
+	}
+	return buses
+}
+
+func (d *Desktop) Send(ctx context.Context, e telemetry.Event) error {
+	buses := d.buses()
+	if len(buses) == 0 {
+		return errors.New("no desktop session bus found")
+	}
+	var errs []error
+	for _, bus := range buses {
+		if err := notifyDesktop(ctx, bus, e); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", bus, err))
+		}
+	}
+	// Users without a notification server don't fail the others
+	if len(errs) == len(buses) {
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+// notifyDesktop calls org.freedesktop.Notifications.Notify for e on bus.
+func notifyDesktop(ctx context.Context, bus string, e telemetry.Event) error {
+	ctx, cancel := context.WithTimeout(ctx, desktopTimeout)
+	defer cancel()
+	conn, err := dialDBus(ctx, bus)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	urgency := byte(urgencyNormal)
+	summary := fmt.Sprintf("GPU %d %s", e.GPU, e.Type)
+	if e.Resolved {
+		summary += " resolved"
+	} else if criticalEvents[e.Type] {
+		urgency = urgencyCritical
+	}
+	var body encoder
+	body.string("nvmlfan")
+	body.uint32(0) // Replaces no notification
+	body.string("dialog-warning")
+	body.string(summary)
+	body.string(fmt.Sprintf("%s: %s", e.Name, e.Message))
+	body.endArray(body.beginArray(4)) // No actions
+	hints := body.beginArray(8)
+	body.align(8)
+	body.string("urgency")
+	body.signature("y")
+	body.bytes(urgency)
+	body.endArray(hints)
+	body.int32(-1) // Server's default expiration
+	_, err = conn.call("org.freedesktop.Notifications", "/org/freedesktop/Notifications",
+		"org.freedesktop.Notifications", "Notify", "susssasa{sv}i", body.buf)
+	return err
+}
@@ -0,0 +1,191 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IvanBayan/nvmlfan/internal/telemetry"
+)
+
+// testBus starts a private session bus and returns its address. Tests are
+// skipped where dbus-daemon isn't installed.
+func testBus(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("dbus-daemon"); err != nil {
+		t.Skip("dbus-daemon isn't installed")
+	}
+	dir := t.TempDir()
+	config := filepath.Join(dir, "bus.conf")
+	err := os.WriteFile(config, []byte(`<busconfig>
+  <type>session</type>
+  <listen>unix:dir=`+dir+`</listen>
+  <auth>EXTERNAL</auth>
+  <policy context="default">
+    <allow send_destination="*" eavesdrop="true"/>
+    <allow eavesdrop="true"/>
+    <allow own="*"/>
+  </policy>
+</busconfig>
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := start(t, "", "dbus-daemon", "--config-file="+config, "--print-address", "--nofork")
+	select {
+	case address := <-lines:
+		return address
+	case <-time.After(5 * time.Second):
+		t.Fatal("dbus-daemon didn't print its address")
+		return ""
+	}
+}
+
+// start runs a program connected to bus until the test ends and returns
+// lines of its output.
+func start(t *testing.T, bus, name string, args ...string) <-chan string {
+	t.Helper()
+	if _, err := exec.LookPath(name); err != nil {
+		t.Skipf("%s isn't installed", name)
+	}
+	cmd := exec.Command(name, args...)
+	cmd.Env = append(os.Environ(), "DBUS_SESSION_BUS_ADDRESS="+bus)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	lines := make(chan string, 1000)
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+	return lines
+}
+
+// waitForName waits until name is owned on bus.
+func waitForName(t *testing.T, bus, name string) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		conn, err := dialDBus(context.Background(), bus)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body encoder
+		body.string(name)
+		reply, err := conn.call("org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "NameHasOwner", "s", body.buf)
+		conn.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := decoder{buf: reply}
+		if d.uint32() == 1 {
+			return
+		}
+	}
+	t.Fatalf("%s didn't appear on the bus", name)
+}
+
+// notifyCall returns arguments of the next Notify call printed by
+// dbus-monitor.
+func notifyCall(t *testing.T, monitor <-chan string) string {
+	t.Helper()
+	var args []string
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case line, ok := <-monitor:
+			if !ok {
+				t.Fatal("dbus-monitor exited")
+			}
+			switch {
+			case strings.Contains(line, "member=Notify"):
+				args = []string{}
+			case args != nil && strings.HasPrefix(line, " "):
+				args = append(args, strings.TrimSpace(line))
+			case args != nil:
+				return strings.Join(args, "\n")
+			}
+		case <-timeout:
+			t.Fatal("no Notify call seen")
+		}
+	}
+}
+
+func TestDesktopSend(t *testing.T) {
+	bus := testBus(t)
+	start(t, bus, "dbus-test-tool", "echo", "--name=org.freedesktop.Notifications")
+	waitForName(t, bus, "org.freedesktop.Notifications")
+	monitor := start(t, bus, "dbus-monitor", "--address", bus)
+	// The monitor greets itself once it's listening
+	for line := range monitor {
+		if strings.Contains(line, "NameLost") {
+			break
+		}
+	}
+
+	tests := []struct {
+		event telemetry.Event
+		want  []string
+	}{
+		{
+			telemetry.Event{GPU: 0, Name: "RTX 3090", Type: "overheat", Message: "91°C with fans at 100%"},
+			[]string{`string "nvmlfan"`, `string "GPU 0 overheat"`, `string "RTX 3090: 91°C with fans at 100%"`, `byte 2`, `int32 -1`},
+		},
+		{
+			telemetry.Event{GPU: 1, Name: "RTX 3090", Type: "overheat", Message: "cooled down", Resolved: true},
+			[]string{`string "GPU 1 overheat resolved"`, `byte 1`},
+		},
+		{
+			telemetry.Event{GPU: 0, Name: "RTX 3090", Type: "profile", Message: "quiet"},
+			[]string{`string "GPU 0 profile"`, `byte 1`},
+		},
+	}
+	desktop := NewDesktop(bus)
+	for _, tt := range tests {
+		if err := desktop.Send(context.Background(), tt.event); err != nil {
+			t.Errorf("%s: %v", tt.event.Type, err)
+			continue
+		}
+		args := notifyCall(t, monitor)
+		for _, want := range tt.want {
+			if !strings.Contains(args, want) {
+				t.Errorf("%s: Notify arguments lack %s:\n%s", tt.event.Type, want, args)
+			}
+		}
+	}
+}
+
+func TestDesktopSendErrors(t *testing.T) {
+	bus := testBus(t)
+	event := telemetry.Event{GPU: 0, Type: "failsafe", Message: "NVML error"}
+	tests := []struct {
+		name string
+		bus  string
+		err  string
+	}{
+		{"no notification server", bus, "org.freedesktop.DBus.Error.ServiceUnknown"},
+		{"no bus", "unix:path=" + filepath.Join(t.TempDir(), "bus"), "no such file or directory"},
+		{"tcp bus", "tcp:host=localhost,port=1234", "unsupported D-Bus address"},
+	}
+	for _, tt := range tests {
+		err := NewDesktop(tt.bus).Send(context.Background(), event)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
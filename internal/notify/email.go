@@ -0,0 +1,175 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/IvanBayan/nvmlfan/internal/telemetry"
+)
+
+const (
+	defaultEmailSubject = `nvmlfan on {{hostname}}: GPU {{.GPU}} {{.Type}}{{if .Resolved}} resolved{{end}}`
+	defaultEmailBody    = `{{.Message}}
+
+Host:  {{hostname}}
+GPU:   {{.GPU}} {{.Name}}
+UUID:  {{.UUID}}
+Event: {{.Type}} at {{.Time.Format "2006-01-02 15:04:05 MST"}}
+`
+	// emailTimeout limits a whole delivery to the server.
+	emailTimeout = 30 * time.Second
+)
+
+// EmailServer is where and how mail is submitted.
+type EmailServer struct {
+	Host     string
+	Port     int    // 587 by default, 465 with implicit TLS.
+	Security string // "starttls" required, "tls" implicit, "none", or STARTTLS when offered if empty.
+	Username string // No authentication when empty.
+	Password string
+}
+
+// Email sends events as mail.
+type Email struct {
+	server        EmailServer
+	from          string   // As written, for the header.
+	to            []string // As written, for the header.
+	sender        string
+	recipients    []string
+	subject, body *template.Template
+}
+
+// NewEmail returns a sink mailing events from from to recipients to
+// through server, with subject and body templates of the event, defaults
+// when empty.
+func NewEmail(server EmailServer, from string, to []string, subject, body string) (*Email, error) {
+	switch server.Security {
+	case "", "starttls", "tls", "none":
+	default:
+		return nil, fmt.Errorf("unknown email security '%s', use starttls, tls or none", server.Security)
+	}
+	if server.Port == 0 {
+		server.Port = 587
+		if server.Security == "tls" {
+			server.Port = 465
+		}
+	}
+	if subject == "" {
+		subject = defaultEmailSubject
+	}
+	if body == "" {
+		body = defaultEmailBody
+	}
+	e := &Email{server: server, from: from, to: to}
+	sender, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid email sender '%s': %w", from, err)
+	}
+	e.sender = sender.Address
+	for _, address := range to {
+		recipient, err := mail.ParseAddress(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid email recipient '%s': %w", address, err)
+		}
+		e.recipients = append(e.recipients, recipient.Address)
+	}
+	if e.subject, err = template.New("subject").Funcs(templateFuncs).Parse(subject); err != nil {
+		return nil, fmt.Errorf("invalid email subject template: %w", err)
+	}
+	if e.body, err = template.New("body").Funcs(templateFuncs).Parse(body); err != nil {
+		return nil, fmt.Errorf("invalid email body template: %w", err)
+	}
+	return e, nil
+}
+
+// message returns e as a mail message.
+func (m *Email) message(e telemetry.Event) ([]byte, error) {
+	var subject, body bytes.Buffer
+	if err := m.subject.Execute(&subject, e); err != nil {
+		return nil, fmt.Errorf("can't build subject: %w", err)
+	}
+	if err := m.body.Execute(&body, e); err != nil {
+		return nil, fmt.Errorf("can't build body: %w", err)
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", m.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(m.to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", strings.TrimSpace(subject.String())))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: 8bit\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(strings.ReplaceAll(body.String(), "\r\n", "\n"), "\n", "\r\n"))
+	return msg.Bytes(), nil
+}
+
+func (m *Email) Send(ctx context.Context, e telemetry.Event) error {
+	msg, err := m.message(e)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, emailTimeout)
+	defer cancel()
+	addr := net.JoinHostPort(m.server.Host, strconv.Itoa(m.server.Port))
+	tlsConfig := &tls.Config{ServerName: m.server.Host}
+	var conn net.Conn
+	if m.server.Security == "tls" {
+		dialer := tls.Dialer{Config: tlsConfig}
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	} else {
+		var dialer net.Dialer
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	client, err := smtp.NewClient(conn, m.server.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	if m.server.Security == "" || m.server.Security == "starttls" {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(tlsConfig); err != nil {
+				return err
+			}
+		} else if m.server.Security == "starttls" {
+			return fmt.Errorf("%s doesn't support STARTTLS", addr)
+		}
+	}
+	if m.server.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", m.server.Username, m.server.Password, m.server.Host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(m.sender); err != nil {
+		return err
+	}
+	for _, to := range m.recipients {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
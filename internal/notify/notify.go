@@ -0,0 +1,149 @@
+// Package notify delivers events to alert sinks like webhooks.
+package notify
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/IvanBayan/nvmlfan/internal/telemetry"
+)
+
+var Log = slog.With("component", "notify")
+
+const (
+	// queueSize is how many events wait for a slow sink before new ones are
+	// dropped.
+	queueSize = 64
+	// firstRetry is the delay before the first retry, it doubles with every
+	// next one.
+	firstRetry = time.Second
+)
+
+// Sink delivers an event somewhere.
+type Sink interface {
+	Send(ctx context.Context, e telemetry.Event) error
+}
+
+type route struct {
+	name    string
+	sink    Sink
+	events  []string
+	retries int
+	queue   chan telemetry.Event
+}
+
+func (r *route) wants(eventType string) bool {
+	return slices.Contains(r.events, "*") || slices.Contains(r.events, eventType)
+}
+
+// Notifier passes events to sinks, each sink has its own queue so a slow
+// one doesn't hold the others.
+type Notifier struct {
+	routes []*route
+	policy Policy
+	mu     sync.Mutex
+	alerts map[alertKey]*alertState
+	closed bool // Events are ignored after Close.
+	wg     sync.WaitGroup
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// New returns a notifier applying policy to events.
+func New(policy Policy) *Notifier {
+	n := &Notifier{policy: policy, alerts: map[alertKey]*alertState{}}
+	n.ctx, n.cancel = context.WithCancel(context.Background())
+	return n
+}
+
+// Add starts delivering events of types in events, all of them when it
+// contains "*", to sink named name. A failed delivery is retried retries
+// times.
+func (n *Notifier) Add(name string, sink Sink, events []string, retries int) {
+	r := &route{name: name, sink: sink, events: events, retries: retries, queue: make(chan telemetry.Event, queueSize)}
+	n.routes = append(n.routes, r)
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		for e := range r.queue {
+			n.deliver(r, e)
+		}
+	}()
+}
+
+// Notify queues e for sinks which want its type unless the policy holds it
+// back, it never blocks.
+func (n *Notifier) Notify(e telemetry.Event) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed || !slices.ContainsFunc(n.routes, func(r *route) bool { return r.wants(e.Type) }) {
+		return
+	}
+	if !n.admit(&e) {
+		Log.Debug("Notification held back", "GPU", e.GPU, "type", e.Type, "resolved", e.Resolved)
+		return
+	}
+	n.dispatch(e)
+}
+
+// dispatch queues e for sinks which want its type. Called with n.mu held.
+func (n *Notifier) dispatch(e telemetry.Event) {
+	for _, r := range n.routes {
+		if !r.wants(e.Type) {
+			continue
+		}
+		select {
+		case r.queue <- e:
+		default:
+			Log.Warn("Notification queue is full, event dropped", "sink", r.name, "type", e.Type)
+		}
+	}
+}
+
+func (n *Notifier) deliver(r *route, e telemetry.Event) {
+	delay := firstRetry
+	for attempt := 0; ; attempt++ {
+		err := r.sink.Send(n.ctx, e)
+		if err == nil {
+			Log.Debug("Notification sent", "sink", r.name, "type", e.Type)
+			return
+		}
+		if attempt >= r.retries || n.ctx.Err() != nil {
+			Log.Error("Can't send notification", "sink", r.name, "type", e.Type, "attempts", attempt+1, "error", err)
+			return
+		}
+		Log.Debug("Notification failed, retrying", "sink", r.name, "type", e.Type, "in", delay, "error", err)
+		select {
+		case <-time.After(delay):
+		case <-n.ctx.Done():
+		}
+		delay *= 2
+	}
+}
+
+// Close delivers queued events, giving up on those still waiting after
+// timeout.
+func (n *Notifier) Close(timeout time.Duration) {
+	defer n.cancel()
+	n.mu.Lock()
+	n.closed = true
+	for _, r := range n.routes {
+		close(r.queue)
+	}
+	n.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		n.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		Log.Warn("Notifications not sent before exit", "timeout", timeout)
+		n.cancel()
+		<-done
+	}
+}
@@ -0,0 +1,140 @@
+package notify
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/IvanBayan/nvmlfan/internal/telemetry"
+)
+
+// Policy limits how many notifications a condition coming and going causes,
+// it applies to all sinks together.
+type Policy struct {
+	// Cooldown is the least time between events of a type on a GPU,
+	// Cooldowns overrides it for some types. Events in between are counted
+	// and the count comes with the next one sent.
+	Cooldown  time.Duration
+	Cooldowns map[string]time.Duration
+	// Events of a type on a GPU coming more often than FlapCount times in
+	// FlapWindow are flapping: the first of them is sent with a note, the
+	// others are held back until there are fewer again. When none comes for
+	// FlapWindow, a held back resolve is sent.
+	FlapCount  int
+	FlapWindow time.Duration
+}
+
+type alertKey struct {
+	gpu       int
+	eventType string
+}
+
+type alertState struct {
+	sent       time.Time
+	suppressed int
+	recent     []time.Time // Times of events within the flap window.
+	flapping   bool
+	// held is the last event held back while flapping, settle fires once
+	// events stopped for the flap window.
+	held   *telemetry.Event
+	settle *time.Timer
+	active bool // An event was sent and not resolved yet.
+}
+
+func (p Policy) cooldown(eventType string) time.Duration {
+	if cooldown, ok := p.Cooldowns[eventType]; ok {
+		return cooldown
+	}
+	return p.Cooldown
+}
+
+// admit tells if e should be sent, updating its message with what was held
+// back before it. Called with n.mu held.
+func (n *Notifier) admit(e *telemetry.Event) bool {
+	key := alertKey{e.GPU, e.Type}
+	st := n.alerts[key]
+	if st == nil {
+		st = &alertState{}
+		n.alerts[key] = st
+	}
+	if e.Resolved {
+		if st.flapping {
+			n.hold(key, st, *e)
+			return false
+		}
+		// Only what was reported gets resolved
+		if !st.active {
+			return false
+		}
+		st.active = false
+		return true
+	}
+	if p := n.policy; p.FlapCount > 0 && p.FlapWindow > 0 {
+		recent := st.recent[:0]
+		for _, t := range st.recent {
+			if e.Time.Sub(t) < p.FlapWindow {
+				recent = append(recent, t)
+			}
+		}
+		st.recent = append(recent, e.Time)
+		switch flapping := len(st.recent) > p.FlapCount; {
+		case flapping && st.flapping:
+			st.suppressed++
+			n.hold(key, st, *e)
+			return false
+		case flapping:
+			st.flapping = true
+			e.Message += fmt.Sprintf(" (flapping, %d events in %v, more are held back until it settles)", len(st.recent), p.FlapWindow)
+			n.send(st, e)
+			n.hold(key, st, *e)
+			return true
+		case st.flapping:
+			st.flapping, st.held = false, nil
+			st.settle.Stop()
+			Log.Info("Event no longer flapping", "GPU", e.GPU, "type", e.Type)
+		}
+	}
+	if cooldown := n.policy.cooldown(e.Type); cooldown > 0 && !st.sent.IsZero() && e.Time.Sub(st.sent) < cooldown {
+		st.suppressed++
+		return false
+	}
+	n.send(st, e)
+	return true
+}
+
+// send marks e of st sent.
+func (n *Notifier) send(st *alertState, e *telemetry.Event) {
+	if st.suppressed > 0 {
+		e.Message += fmt.Sprintf(" (%d more held back)", st.suppressed)
+		st.suppressed = 0
+	}
+	st.sent, st.active = e.Time, true
+}
+
+// hold keeps e as the last event of flapping st with key and restarts the
+// wait for events to settle.
+func (n *Notifier) hold(key alertKey, st *alertState, e telemetry.Event) {
+	st.held = &e
+	if st.settle == nil {
+		st.settle = time.AfterFunc(n.policy.FlapWindow, func() { n.settleAlert(key) })
+		return
+	}
+	st.settle.Reset(n.policy.FlapWindow)
+}
+
+// settleAlert ends flapping of the alert with key once no event came for the
+// flap window. A held back resolve is sent then, the condition is gone.
+func (n *Notifier) settleAlert(key alertKey) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	st := n.alerts[key]
+	if n.closed || !st.flapping {
+		return
+	}
+	held := st.held
+	st.flapping, st.held, st.recent = false, nil, nil
+	Log.Info("Event no longer flapping", "GPU", key.gpu, "type", key.eventType)
+	if held.Resolved && st.active {
+		st.active = false
+		n.dispatch(*held)
+	}
+}
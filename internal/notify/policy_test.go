@@ -0,0 +1,79 @@
+package notify
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IvanBayan/nvmlfan/internal/telemetry"
+)
+
+// recorder is a sink remembering events it got.
+type recorder struct {
+	mu     sync.Mutex
+	events []telemetry.Event
+}
+
+func (r *recorder) Send(ctx context.Context, e telemetry.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+	return nil
+}
+
+// summary returns sent events like "overheat, overheat resolved".
+func (r *recorder) summary() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var events []string
+	for _, e := range r.events {
+		if e.Resolved {
+			events = append(events, e.Type+" resolved")
+		} else {
+			events = append(events, e.Type)
+		}
+	}
+	return strings.Join(events, ", ")
+}
+
+func TestPolicyFlapping(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		events []bool // Resolved, a millisecond apart.
+		want   string
+	}{
+		{
+			name:   "below the count",
+			events: []bool{false, true, false, true},
+			want:   "overheat, overheat resolved, overheat, overheat resolved",
+		},
+		{
+			name:   "resolve held back while flapping is sent once it settles",
+			events: []bool{false, true, false, true, false, true, false, true},
+			want:   "overheat, overheat resolved, overheat, overheat resolved, overheat, overheat resolved",
+		},
+		{
+			name:   "flapping condition staying on isn't resolved",
+			events: []bool{false, true, false, true, false, true, false},
+			want:   "overheat, overheat resolved, overheat, overheat resolved, overheat",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := New(Policy{FlapCount: 2, FlapWindow: 50 * time.Millisecond})
+			sink := &recorder{}
+			n.Add("recorder", sink, []string{"*"}, 0)
+			for i, resolved := range tt.events {
+				n.Notify(telemetry.Event{Time: start.Add(time.Duration(i) * time.Millisecond), Type: "overheat", Resolved: resolved})
+			}
+			time.Sleep(200 * time.Millisecond)
+			n.Close(time.Second)
+			if got := sink.summary(); got != tt.want {
+				t.Errorf("sent %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
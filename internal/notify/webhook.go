@@ -0,0 +1,98 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"text/template"
+	"time"
+
+	"github.com/IvanBayan/nvmlfan/internal/telemetry"
+)
+
+// Payload templates of webhook formats, executed with the event.
+var webhookFormats = map[string]string{
+	"slack":   `{"text": {{json (text .)}}}`,
+	"discord": `{"content": {{json (text .)}}}`,
+	"generic": `{{json .}}`,
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"text":     Text,
+	"hostname": hostname,
+}
+
+func hostname() string {
+	name, _ := os.Hostname()
+	return name
+}
+
+// Text is a one line description of e for people.
+func Text(e telemetry.Event) string {
+	if e.Resolved {
+		return fmt.Sprintf("GPU %d (%s) %s resolved: %s", e.GPU, e.Name, e.Type, e.Message)
+	}
+	return fmt.Sprintf("GPU %d (%s) %s: %s", e.GPU, e.Name, e.Type, e.Message)
+}
+
+// Webhook posts events as JSON to a URL.
+type Webhook struct {
+	url     string
+	headers map[string]string
+	payload *template.Template
+	client  *http.Client
+}
+
+// NewWebhook returns a webhook posting to url payloads by format (slack,
+// discord or generic) or by payloadTemplate when it's set.
+func NewWebhook(url, format, payloadTemplate string, headers map[string]string, timeout time.Duration) (*Webhook, error) {
+	if payloadTemplate == "" {
+		if format == "" {
+			format = "generic"
+		}
+		var ok bool
+		if payloadTemplate, ok = webhookFormats[format]; !ok {
+			return nil, fmt.Errorf("unknown webhook format '%s', use slack, discord or generic", format)
+		}
+	}
+	payload, err := template.New(url).Funcs(templateFuncs).Parse(payloadTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook template: %w", err)
+	}
+	return &Webhook{url: url, headers: headers, payload: payload, client: &http.Client{Timeout: timeout}}, nil
+}
+
+func (w *Webhook) Send(ctx context.Context, e telemetry.Event) error {
+	var body bytes.Buffer
+	if err := w.payload.Execute(&body, e); err != nil {
+		return fmt.Errorf("can't build payload: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "nvmlfan")
+	for name, value := range w.headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(detail))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
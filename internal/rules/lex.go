@@ -0,0 +1,97 @@
+package rules
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokNumber
+	tokDuration // Number with a unit, e.g. 30s.
+	tokClock    // Time of day, e.g. 22:00.
+	tokString
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int // Byte offset in the rule.
+}
+
+var operators = []string{"->", "&&", "||", "<=", ">=", "==", "!=", "<", ">", "!", "(", ")"}
+
+func lex(s string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		c, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case unicode.IsSpace(c):
+			i += size
+		case c == '"' || c == '\'':
+			end := strings.IndexRune(s[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			tokens = append(tokens, token{tokString, s[i+1 : i+1+end], i})
+			i += end + 2
+		case isDigit(c):
+			j := skip(s, i, func(r rune) bool { return isDigit(r) || r == '.' })
+			kind := tokNumber
+			if next, _ := utf8.DecodeRuneInString(s[j:]); next == ':' {
+				kind = tokClock
+				j = skip(s, j+1, isDigit)
+			} else if unicode.IsLetter(next) {
+				kind = tokDuration
+				j = skip(s, j, func(r rune) bool { return unicode.IsLetter(r) || isDigit(r) || r == '.' })
+			}
+			tokens = append(tokens, token{kind, s[i:j], i})
+			i = j
+		case unicode.IsLetter(c) || c == '_':
+			j := skip(s, i, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-' })
+			// "-" belongs to names like "my-profile", not to "->"
+			if j < len(s) && s[j-1] == '-' && s[j] == '>' {
+				j--
+			}
+			tokens = append(tokens, token{tokIdent, s[i:j], i})
+			i = j
+		default:
+			op := ""
+			for _, o := range operators {
+				if strings.HasPrefix(s[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected '%c' at %d", c, i)
+			}
+			tokens = append(tokens, token{tokOp, op, i})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{tokEOF, "end of rule", len(s)}), nil
+}
+
+// skip returns the offset of the first rune of s from i on not accepted by f.
+func skip(s string, i int, f func(rune) bool) int {
+	for i < len(s) {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if !f(r) {
+			break
+		}
+		i += size
+	}
+	return i
+}
+
+// isDigit reports ASCII digits only, numbers are parsed by strconv.
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
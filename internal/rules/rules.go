@@ -0,0 +1,330 @@
+// Package rules parses and evaluates conditions on GPU state like
+// "power > 250 && temp > 70 for 30s -> profile performance".
+package rules
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rule activates a profile once its condition held for For.
+type Rule struct {
+	Source  string
+	Cond    Expr
+	For     time.Duration
+	Profile string
+}
+
+// State is what conditions are evaluated against.
+type State struct {
+	Temp        float64
+	Power       float64 // Watts.
+	Utilization float64 // Percent, -1 when unknown.
+	Fan         float64 // Average fan speed in percent.
+	PState      float64 // -1 when unknown.
+	Time        time.Time
+	Processes   []string // Names and command lines of processes using the GPU.
+}
+
+// Variables are names conditions can compare.
+var Variables = []string{"temp", "power", "utilization", "fan", "pstate", "time", "day", "processes"}
+
+var dayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// Expr is a parsed condition.
+type Expr interface {
+	value(s State) float64
+}
+
+// Parse parses a rule: an optional "when", a condition, optionally "for"
+// and a duration, then "->" and an action. The only action is "profile"
+// with a profile name.
+func Parse(source string) (*Rule, error) {
+	tokens, err := lex(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	p.accept(tokIdent, "when")
+	cond, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	r := &Rule{Source: source, Cond: cond}
+	if p.accept(tokIdent, "for") {
+		t := p.next()
+		if t.kind != tokDuration {
+			return nil, errorAt(t, "expected duration after 'for', got '%s'", t.text)
+		}
+		if r.For, err = time.ParseDuration(t.text); err != nil {
+			return nil, errorAt(t, "invalid duration '%s'", t.text)
+		}
+	}
+	if t := p.peek(); !p.accept(tokOp, "->") {
+		return nil, errorAt(t, "expected '->' and an action, got '%s'", t.text)
+	}
+	if t := p.peek(); !p.accept(tokIdent, "profile") {
+		return nil, errorAt(t, "unknown action '%s', expected profile", t.text)
+	}
+	name := p.next()
+	if name.kind != tokIdent && name.kind != tokString {
+		return nil, errorAt(name, "expected profile name, got '%s'", name.text)
+	}
+	r.Profile = name.text
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, errorAt(t, "unexpected '%s' after action", t.text)
+	}
+	return r, nil
+}
+
+// Holds tells whether the condition of r is true in s.
+func (r *Rule) Holds(s State) bool {
+	return r.Cond.value(s) != 0
+}
+
+// UsesProcesses tells whether the condition of r looks at processes.
+func (r *Rule) UsesProcesses() bool {
+	return usesProcesses(r.Cond)
+}
+
+func usesProcesses(e Expr) bool {
+	switch e := e.(type) {
+	case match:
+		return true
+	case variable:
+		return e == "processes"
+	case binary:
+		return usesProcesses(e.left) || usesProcesses(e.right)
+	case not:
+		return usesProcesses(e.expr)
+	}
+	return false
+}
+
+type number float64
+
+func (n number) value(State) float64 { return float64(n) }
+
+type variable string
+
+func (v variable) value(s State) float64 {
+	switch v {
+	case "temp":
+		return s.Temp
+	case "power":
+		return s.Power
+	case "utilization":
+		return s.Utilization
+	case "fan":
+		return s.Fan
+	case "pstate":
+		return s.PState
+	case "time":
+		return float64(s.Time.Hour()*60 + s.Time.Minute())
+	case "day":
+		return float64(s.Time.Weekday())
+	case "processes":
+		return float64(len(s.Processes))
+	}
+	return 0
+}
+
+// match is process("pattern"), true when a process matches.
+type match struct {
+	re *regexp.Regexp
+}
+
+func (m match) value(s State) float64 {
+	for _, p := range s.Processes {
+		if m.re.MatchString(p) {
+			return 1
+		}
+	}
+	return 0
+}
+
+type not struct {
+	expr Expr
+}
+
+func (n not) value(s State) float64 {
+	return truth(n.expr.value(s) == 0)
+}
+
+type binary struct {
+	op          string
+	left, right Expr
+}
+
+func (b binary) value(s State) float64 {
+	switch b.op {
+	case "&&":
+		return truth(b.left.value(s) != 0 && b.right.value(s) != 0)
+	case "||":
+		return truth(b.left.value(s) != 0 || b.right.value(s) != 0)
+	}
+	l, r := b.left.value(s), b.right.value(s)
+	switch b.op {
+	case "<":
+		return truth(l < r)
+	case "<=":
+		return truth(l <= r)
+	case ">":
+		return truth(l > r)
+	case ">=":
+		return truth(l >= r)
+	case "==":
+		return truth(l == r)
+	case "!=":
+		return truth(l != r)
+	}
+	return 0
+}
+
+func truth(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// errorAt returns an error about t, with its position in the rule.
+func errorAt(t token, format string, args ...any) error {
+	return fmt.Errorf(format+" at %d", append(args, t.pos)...)
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next token if it is kind with text.
+func (p *parser) accept(kind tokenKind, text string) bool {
+	if t := p.peek(); t.kind == kind && t.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) or() (Expr, error) {
+	left, err := p.and()
+	for err == nil && p.accept(tokOp, "||") {
+		var right Expr
+		if right, err = p.and(); err == nil {
+			left = binary{"||", left, right}
+		}
+	}
+	return left, err
+}
+
+func (p *parser) and() (Expr, error) {
+	left, err := p.unary()
+	for err == nil && p.accept(tokOp, "&&") {
+		var right Expr
+		if right, err = p.unary(); err == nil {
+			left = binary{"&&", left, right}
+		}
+	}
+	return left, err
+}
+
+func (p *parser) unary() (Expr, error) {
+	if p.accept(tokOp, "!") {
+		e, err := p.unary()
+		return not{e}, err
+	}
+	if p.accept(tokOp, "(") {
+		e, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.peek(); !p.accept(tokOp, ")") {
+			return nil, errorAt(t, "expected ')', got '%s'", t.text)
+		}
+		return e, nil
+	}
+	if p.accept(tokIdent, "process") {
+		if t := p.peek(); !p.accept(tokOp, "(") {
+			return nil, errorAt(t, "expected '(' after process, got '%s'", t.text)
+		}
+		pattern := p.next()
+		if pattern.kind != tokString {
+			return nil, errorAt(pattern, "expected quoted pattern in process(), got '%s'", pattern.text)
+		}
+		re, err := regexp.Compile(pattern.text)
+		if err != nil {
+			return nil, errorAt(pattern, "invalid process pattern '%s'", pattern.text)
+		}
+		if t := p.peek(); !p.accept(tokOp, ")") {
+			return nil, errorAt(t, "expected ')' after process pattern, got '%s'", t.text)
+		}
+		return match{re}, nil
+	}
+	left, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	op := p.peek()
+	switch op.text {
+	case "<", "<=", ">", ">=", "==", "!=":
+		p.next()
+	default:
+		// A bare processes means there are any
+		if left == variable("processes") {
+			return binary{">", left, number(0)}, nil
+		}
+		return nil, errorAt(op, "expected comparison after '%s', got '%s'", p.tokens[p.pos-1].text, op.text)
+	}
+	right, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	return binary{op.text, left, right}, nil
+}
+
+func (p *parser) operand() (Expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		n, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, errorAt(t, "invalid number '%s'", t.text)
+		}
+		return number(n), nil
+	case tokClock:
+		c, err := time.Parse("15:04", t.text)
+		if err != nil {
+			return nil, errorAt(t, "invalid time of day '%s'", t.text)
+		}
+		return number(c.Hour()*60 + c.Minute()), nil
+	case tokIdent:
+		for i, day := range dayNames {
+			if strings.EqualFold(t.text, day) {
+				return number(i), nil
+			}
+		}
+		for _, v := range Variables {
+			if t.text == v {
+				return variable(v), nil
+			}
+		}
+		return nil, errorAt(t, "unknown name '%s', expected one of %s", t.text, strings.Join(Variables, ", "))
+	}
+	return nil, errorAt(t, "expected value, got '%s'", t.text)
+}
@@ -0,0 +1,147 @@
+package rules
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		source  string
+		profile string
+		wait    time.Duration
+	}{
+		{"temp > 70 -> profile cool", "cool", 0},
+		{"when temp > 70 for 30s -> profile cool", "cool", 30 * time.Second},
+		{"temp > 70 for 1m30s -> profile cool", "cool", 90 * time.Second},
+		{"temp>70->profile cool", "cool", 0},
+		{"temp > 70 -> profile my-profile", "my-profile", 0},
+		{"temp > 70 -> profile my-profile ", "my-profile", 0},
+		{"temp > 70 -> profile 'quiet night'", "quiet night", 0},
+		{"temp > 70 -> profile тихий", "тихий", 0},
+		{`process("blender") -> profile render`, "render", 0},
+		{"processes -> profile busy", "busy", 0},
+		{"time >= 22:00 || day == sat -> profile quiet", "quiet", 0},
+	}
+	for _, tt := range tests {
+		r, err := Parse(tt.source)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.source, err)
+			continue
+		}
+		if r.Profile != tt.profile || r.For != tt.wait {
+			t.Errorf("Parse(%q) = profile %q for %v, want %q for %v", tt.source, r.Profile, r.For, tt.profile, tt.wait)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		err    string
+	}{
+		{"", "expected value, got 'end of rule' at 0"},
+		{"temp > 70", "expected '->' and an action, got 'end of rule' at 9"},
+		{"temp > 70 -> fans 100", "unknown action 'fans', expected profile at 13"},
+		{"temp > 70 -> profile", "expected profile name, got 'end of rule' at 20"},
+		{"temp > 70 -> profile a b", "unexpected 'b' after action at 23"},
+		{"temp > 70 -> profile a->", "unexpected '->' after action at 22"},
+		{"temp > 70 for soon -> profile a", "expected duration after 'for', got 'soon' at 14"},
+		{"temp > 70 for 30 -> profile a", "expected duration after 'for', got '30' at 14"},
+		{"temp > 70 for 30x -> profile a", "invalid duration '30x' at 14"},
+		{"temp 70 -> profile a", "expected comparison after 'temp', got '70' at 5"},
+		{"temp > -> profile a", "expected value, got '->' at 7"},
+		{"heat > 70 -> profile a", "unknown name 'heat', expected one of"},
+		{"temp > 7.0.1 -> profile a", "invalid number '7.0.1' at 7"},
+		{"time > 25:00 -> profile a", "invalid time of day '25:00' at 7"},
+		{"(temp > 70 -> profile a", "expected ')', got '->' at 11"},
+		{"temp > 70 & fan > 50 -> profile a", "unexpected '&' at 10"},
+		{"temp > 70° -> profile a", "unexpected '°' at 9"},
+		{`process("python) -> profile a`, "unterminated string at 8"},
+		{"temp > 70 -> profile 'a", "unterminated string at 21"},
+		{"process(python) -> profile a", "expected quoted pattern in process(), got 'python' at 8"},
+		{`process "python" -> profile a`, "expected '(' after process, got 'python' at 8"},
+		{`process("(") -> profile a`, "invalid process pattern '(' at 8"},
+		{`process("a" -> profile a`, "expected ')' after process pattern, got '->' at 12"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.source)
+		if err == nil {
+			t.Errorf("Parse(%q) succeeded, want %q", tt.source, tt.err)
+			continue
+		}
+		if got := err.Error(); len(got) < len(tt.err) || got[:len(tt.err)] != tt.err {
+			t.Errorf("Parse(%q): %q, want %q", tt.source, got, tt.err)
+		}
+	}
+}
+
+func TestHolds(t *testing.T) {
+	// A Saturday evening
+	evening := time.Date(2025, 1, 4, 22, 30, 0, 0, time.UTC)
+	state := State{Temp: 75, Power: 200, Utilization: 90, Fan: 60, PState: 0, Time: evening,
+		Processes: []string{"python train.py", "Xorg"}}
+	tests := []struct {
+		cond string
+		want bool
+	}{
+		{"temp > 70", true},
+		{"temp >= 75", true},
+		{"temp < 75", false},
+		{"temp <= 75", true},
+		{"temp == 75", true},
+		{"temp != 75", false},
+		{"power > 250", false},
+		{"utilization >= 90 && fan < 70", true},
+		{"pstate == 0", true},
+		// && binds tighter than ||
+		{"temp > 80 || power > 100 && fan > 50", true},
+		{"temp > 80 || power > 100 && fan > 70", false},
+		{"(temp > 80 || power > 100) && fan > 70", false},
+		{"!temp > 80", true},
+		{"!temp > 70 || fan > 50", true},
+		{"!(temp > 70 || fan > 50)", false},
+		{"!!temp > 70", true},
+		{"time >= 22:00", true},
+		{"time < 22:30", false},
+		{"day == sat", true},
+		{"day == SAT", true},
+		{"day == sun", false},
+		{"processes", true},
+		{"processes > 2", false},
+		{`process("^python ")`, true},
+		{`process("blender")`, false},
+		{`!process("blender") && temp > 70`, true},
+	}
+	for _, tt := range tests {
+		r, err := Parse(tt.cond + " -> profile p")
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.cond, err)
+			continue
+		}
+		if got := r.Holds(state); got != tt.want {
+			t.Errorf("%q holds: %v, want %v", tt.cond, got, tt.want)
+		}
+	}
+}
+
+func TestUsesProcesses(t *testing.T) {
+	tests := []struct {
+		cond string
+		want bool
+	}{
+		{"temp > 70", false},
+		{"processes > 0", true},
+		{`temp > 70 && !process("x")`, true},
+		{"fan > 50 || (temp > 60 && processes)", true},
+	}
+	for _, tt := range tests {
+		r, err := Parse(tt.cond + " -> profile p")
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.cond, err)
+		}
+		if got := r.UsesProcesses(); got != tt.want {
+			t.Errorf("%q uses processes: %v, want %v", tt.cond, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package sim
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/IvanBayan/nvmlfan/internal/clock"
+	"github.com/IvanBayan/nvmlfan/internal/config"
+)
+
+// tracePoint is one recorded sample, offset is counted from the first
+// sample of the whole file so GPUs stay in step.
+type tracePoint struct {
+	offset   time.Duration
+	temp     float64
+	power    float64
+	graphics int
+	memory   int
+}
+
+type trace struct {
+	points []tracePoint
+	loop   bool
+}
+
+// at returns the last sample recorded before offset. The last sample is
+// held at the end unless the trace loops.
+func (t *trace) at(offset time.Duration) tracePoint {
+	end := t.points[len(t.points)-1].offset
+	if t.loop && end > 0 {
+		offset %= end
+	}
+	i := sort.Search(len(t.points), func(i int) bool { return t.points[i].offset > offset })
+	return t.points[max(i-1, 0)]
+}
+
+// NewReplay returns a library with GPUs which play back a telemetry file
+// written by the CSV sink. Temperatures come from the file, so fan speeds
+// set by controllers have no effect on them. Playback speed is that of clk.
+func NewReplay(cfg config.ReplayConfig, clk clock.Clock) (*Library, error) {
+	file, err := os.Open(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", cfg.Path, err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[name] = i
+	}
+	for _, name := range []string{"time", "gpu", "temp"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("%s: no '%s' column", cfg.Path, name)
+		}
+	}
+	field := func(row []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+
+	l := &Library{}
+	var first time.Time
+	for line := 2; ; line++ {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", cfg.Path, err)
+		}
+		t, err := time.Parse(time.RFC3339, field(row, "time"))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", cfg.Path, line, err)
+		}
+		idx, err := strconv.Atoi(field(row, "gpu"))
+		if err != nil || idx < 0 {
+			return nil, fmt.Errorf("%s:%d: invalid GPU index '%s'", cfg.Path, line, field(row, "gpu"))
+		}
+		temp, err := strconv.ParseFloat(field(row, "temp"), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid temperature '%s'", cfg.Path, line, field(row, "temp"))
+		}
+		// Optional columns are left zero
+		power, _ := strconv.ParseFloat(field(row, "power"), 64)
+		graphics, _ := strconv.Atoi(field(row, "graphics_clock"))
+		memory, _ := strconv.Atoi(field(row, "memory_clock"))
+
+		if first.IsZero() {
+			first = t
+		}
+		for len(l.devices) <= idx {
+			d := newDevice(len(l.devices), config.SimGPUConfig{}, clk)
+			d.trace = &trace{loop: cfg.Loop}
+			l.devices = append(l.devices, d)
+		}
+		d := l.devices[idx]
+		if name := field(row, "name"); name != "" {
+			d.name = name
+		}
+		d.uuid = field(row, "uuid")
+		d.trace.points = append(d.trace.points, tracePoint{
+			offset:   t.Sub(first),
+			temp:     temp,
+			power:    power,
+			graphics: graphics,
+			memory:   memory,
+		})
+	}
+	if len(l.devices) == 0 {
+		return nil, fmt.Errorf("%s: no samples", cfg.Path)
+	}
+	for i, d := range l.devices {
+		if len(d.trace.points) == 0 {
+			return nil, fmt.Errorf("%s: no samples of GPU %d", cfg.Path, i)
+		}
+		d.temp = d.trace.points[0].temp
+	}
+	return l, nil
+}
@@ -0,0 +1,105 @@
+package sim
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IvanBayan/nvmlfan/internal/clock"
+	"github.com/IvanBayan/nvmlfan/internal/config"
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+const testTrace = `time,gpu,uuid,name,temp,power
+2024-01-01T00:00:00Z,0,GPU-a,Recorded GPU,40,100
+2024-01-01T00:00:00Z,1,GPU-b,Recorded GPU,35,50
+2024-01-01T00:00:10Z,0,GPU-a,Recorded GPU,50,150
+2024-01-01T00:00:20Z,0,GPU-a,Recorded GPU,60,200
+2024-01-01T00:00:20Z,1,GPU-b,Recorded GPU,45,80
+`
+
+func writeTrace(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "trace.csv")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReplay(t *testing.T) {
+	tests := []struct {
+		name  string
+		loop  bool
+		after time.Duration
+		temps []uint32 // By GPU.
+	}{
+		{"start", false, 0, []uint32{40, 35}},
+		{"between samples", false, 15 * time.Second, []uint32{50, 35}},
+		{"last sample", false, 20 * time.Second, []uint32{60, 45}},
+		{"held at the end", false, time.Hour, []uint32{60, 45}},
+		{"looped", true, 25 * time.Second, []uint32{40, 35}},
+	}
+	path := writeTrace(t, testTrace)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk := clock.NewManual(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+			lib, err := NewReplay(config.ReplayConfig{Path: path, Loop: tt.loop}, clk)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lib.Init()
+			if count, _ := lib.DeviceGetCount(); count != len(tt.temps) {
+				t.Fatalf("%d GPUs replayed, want %d", count, len(tt.temps))
+			}
+			clk.Advance(tt.after)
+			for idx, want := range tt.temps {
+				device, _ := lib.DeviceGetHandleByIndex(idx)
+				if temp, _ := device.GetTemperature(nvml.TEMPERATURE_GPU); temp != want {
+					t.Errorf("GPU %d at %v: %d°C, want %d°C", idx, tt.after, temp, want)
+				}
+			}
+		})
+	}
+}
+
+func TestReplayIdentity(t *testing.T) {
+	lib, err := NewReplay(config.ReplayConfig{Path: writeTrace(t, testTrace)}, clock.NewManual(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lib.Init()
+	device, _ := lib.DeviceGetHandleByIndex(1)
+	if uuid, _ := device.GetUUID(); uuid != "GPU-b" {
+		t.Errorf("UUID %s, want GPU-b", uuid)
+	}
+	if name, _ := device.GetName(); name != "Recorded GPU" {
+		t.Errorf("name %s, want Recorded GPU", name)
+	}
+}
+
+func TestReplayErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{"empty", "", "EOF"},
+		{"no temperature", "time,gpu\n", "no 'temp' column"},
+		{"no samples", "time,gpu,temp\n", "no samples"},
+		{"bad time", "time,gpu,temp\nyesterday,0,40\n", ":2:"},
+		{"bad index", "time,gpu,temp\n2024-01-01T00:00:00Z,-1,40\n", "invalid GPU index '-1'"},
+		{"bad temperature", "time,gpu,temp\n2024-01-01T00:00:00Z,0,hot\n", "invalid temperature 'hot'"},
+		{"GPU without samples", "time,gpu,temp\n2024-01-01T00:00:00Z,1,40\n", "no samples of GPU 0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewReplay(config.ReplayConfig{Path: writeTrace(t, tt.content)}, clock.Real)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("error %v, want it to contain %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,634 @@
+// Package sim is a GPU backend without hardware. It implements the part of
+// NVML used by nvmlfan on top of a first order thermal model or a recorded
+// trace, so the daemon and its controllers can be exercised on any machine.
+package sim
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IvanBayan/nvmlfan/internal/clock"
+	"github.com/IvanBayan/nvmlfan/internal/config"
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+const (
+	defaultFans         = 1
+	defaultAmbient      = 25
+	defaultIdlePower    = 30
+	defaultMaxPower     = 250
+	defaultTimeConstant = time.Minute
+	defaultMaxTemp      = 88
+	minFanSpeed         = 30
+	maxFanSpeed         = 100
+	// Fans don't change speed instantly.
+	fanTimeConstant = 3 * time.Second
+	// wornFanSpeed is as fast as worn fans get.
+	wornFanSpeed = 60
+	// maxFanRPM is the measured speed of fans at 100%.
+	maxFanRPM = 3000
+	// A process with PID simPID plus index runs on a GPU loaded above
+	// backgroundLoad.
+	simPID         = 10000
+	backgroundLoad = 10
+)
+
+// Default thermal resistance in °C/W at minimum and maximum fan speed.
+var defaultResistance = [2]float64{0.25, 0.12}
+
+// Built-in load profiles, they are repeated.
+var profiles = map[string][]config.SimLoadStep{
+	"idle":   {{Duration: time.Hour, Load: 5}},
+	"full":   {{Duration: time.Hour, Load: 100}},
+	"square": {{Duration: 5 * time.Minute, Load: 10}, {Duration: 10 * time.Minute, Load: 100}},
+	"ramp": {
+		{Duration: time.Minute, Load: 10}, {Duration: time.Minute, Load: 20}, {Duration: time.Minute, Load: 30},
+		{Duration: time.Minute, Load: 40}, {Duration: time.Minute, Load: 50}, {Duration: time.Minute, Load: 60},
+		{Duration: time.Minute, Load: 70}, {Duration: time.Minute, Load: 80}, {Duration: time.Minute, Load: 90},
+		{Duration: time.Minute, Load: 100},
+	},
+}
+
+// Library is a simulated NVML library. Methods which nvmlfan doesn't use
+// aren't implemented and panic.
+type Library struct {
+	nvml.Interface
+	devices []*Device
+
+	mu      sync.Mutex
+	visible []*Device // Devices present at the last Init.
+}
+
+// New returns a library with GPUs described by cfg, their time is told by clk.
+func New(cfg config.SimConfig, clk clock.Clock) *Library {
+	gpus := cfg.GPUs
+	if len(gpus) == 0 {
+		gpus = []config.SimGPUConfig{{}}
+	}
+	l := &Library{}
+	for i, gpu := range gpus {
+		l.devices = append(l.devices, newDevice(i, gpu, clk))
+	}
+	return l
+}
+
+// Init enumerates devices present at the moment, like NVML it doesn't see
+// devices attached later until it is initialized again.
+func (l *Library) Init() nvml.Return {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.visible = nil
+	for _, d := range l.devices {
+		if d.present() {
+			l.visible = append(l.visible, d)
+		}
+	}
+	return nvml.SUCCESS
+}
+
+// Topology lists UUIDs of devices present at the moment.
+func (l *Library) Topology() string {
+	var uuids []string
+	for _, d := range l.devices {
+		if d.present() {
+			uuid, _ := d.GetUUID()
+			uuids = append(uuids, uuid)
+		}
+	}
+	return strings.Join(uuids, ",")
+}
+
+func (l *Library) Shutdown() nvml.Return {
+	return nvml.SUCCESS
+}
+
+func (l *Library) ErrorString(ret nvml.Return) string {
+	return nvml.ErrorString(ret)
+}
+
+func (l *Library) SystemGetDriverVersion() (string, nvml.Return) {
+	return "sim", nvml.SUCCESS
+}
+
+func (l *Library) SystemGetNVMLVersion() (string, nvml.Return) {
+	return "sim", nvml.SUCCESS
+}
+
+func (l *Library) SystemGetCudaDriverVersion_v2() (int, nvml.Return) {
+	return 0, nvml.ERROR_NOT_SUPPORTED
+}
+
+func (l *Library) DeviceGetCount() (int, nvml.Return) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.visible), nvml.SUCCESS
+}
+
+func (l *Library) DeviceGetHandleByIndex(idx int) (nvml.Device, nvml.Return) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if idx < 0 || idx >= len(l.visible) {
+		return nil, nvml.ERROR_INVALID_ARGUMENT
+	}
+	return l.visible[idx], nvml.SUCCESS
+}
+
+// Device is a simulated GPU. Its state is advanced to the current time on
+// every call.
+type Device struct {
+	nvml.Device
+
+	mu           sync.Mutex
+	clock        clock.Clock
+	index        int
+	name         string
+	ambient      float64
+	idlePower    float64
+	maxPower     float64
+	resistance   [2]float64
+	timeConstant time.Duration
+	maxTemp      int
+	load         []config.SimLoadStep
+	period       time.Duration // Length of the load profile.
+	uuid         string
+	trace        *trace        // Replaces the model when set.
+	attach       time.Duration // Present from start plus attach until start plus detach.
+	detach       time.Duration
+	consumer     bool
+	powerLimit   float64
+	clockCap     int // Highest graphics clock in MHz, 0 when not locked.
+
+	start  time.Time
+	last   time.Time
+	temp   float64
+	power  float64
+	fans   []float64 // Actual speeds.
+	target []int
+	manual []bool
+	worn   []bool
+}
+
+func newDevice(idx int, cfg config.SimGPUConfig, clk clock.Clock) *Device {
+	start := clk.Now()
+	d := &Device{
+		clock:        clk,
+		index:        idx,
+		name:         cfg.Name,
+		ambient:      cfg.Ambient,
+		idlePower:    cfg.IdlePower,
+		maxPower:     cfg.MaxPower,
+		resistance:   cfg.Resistance,
+		timeConstant: cfg.TimeConstant,
+		maxTemp:      cfg.MaxTemp,
+		load:         cfg.Load,
+		attach:       cfg.Attach,
+		detach:       cfg.Detach,
+		consumer:     cfg.Consumer,
+		start:        start,
+		last:         start,
+	}
+	if d.name == "" {
+		d.name = "Simulated GPU"
+	}
+	if d.ambient == 0 {
+		d.ambient = defaultAmbient
+	}
+	if d.idlePower == 0 {
+		d.idlePower = defaultIdlePower
+	}
+	if d.maxPower == 0 {
+		d.maxPower = defaultMaxPower
+	}
+	d.powerLimit = d.maxPower
+	if d.resistance == [2]float64{} {
+		d.resistance = defaultResistance
+	}
+	if d.timeConstant <= 0 {
+		d.timeConstant = defaultTimeConstant
+	}
+	if d.maxTemp == 0 {
+		d.maxTemp = defaultMaxTemp
+	}
+	if len(d.load) == 0 {
+		profile := cfg.Profile
+		if profile == "" {
+			profile = "square"
+		}
+		d.load = profiles[profile]
+	}
+	for _, step := range d.load {
+		d.period += step.Duration
+	}
+	fans := cfg.Fans
+	if fans <= 0 {
+		fans = defaultFans
+	}
+	d.fans = make([]float64, fans)
+	d.target = make([]int, fans)
+	d.manual = make([]bool, fans)
+	d.worn = make([]bool, fans)
+	for _, fan := range cfg.WornFans {
+		if fan >= 0 && fan < fans {
+			d.worn[fan] = true
+		}
+	}
+	d.temp = d.ambient
+	for i := range d.fans {
+		d.fans[i] = minFanSpeed
+		d.target[i] = minFanSpeed
+	}
+	return d
+}
+
+// loadAt returns load in percent at t according to the repeated profile.
+func (d *Device) loadAt(t time.Time) int {
+	if d.period <= 0 {
+		return 0
+	}
+	offset := t.Sub(d.start) % d.period
+	for _, step := range d.load {
+		if offset < step.Duration {
+			return step.Load
+		}
+		offset -= step.Duration
+	}
+	return 0
+}
+
+// autoSpeed is the fan curve of the simulated driver.
+func (d *Device) autoSpeed() int {
+	speed := minFanSpeed + int(d.temp-50)*2
+	return min(max(speed, minFanSpeed), maxFanSpeed)
+}
+
+// driven is the speed fan is driven at, by the driver or manually.
+func (d *Device) driven(fan int) int {
+	if !d.manual[fan] {
+		return d.autoSpeed()
+	}
+	return d.target[fan]
+}
+
+func (d *Device) throttled() bool {
+	return d.temp >= float64(d.maxTemp)
+}
+
+// advance moves the model from the last update to now. Every quantity
+// approaches its steady value exponentially, which is exact for constant
+// inputs and stable for any step.
+func (d *Device) advance() {
+	now := d.clock.Now()
+	dt := now.Sub(d.last)
+	if dt <= 0 {
+		return
+	}
+	d.last = now
+
+	fanDecay := 1 - math.Exp(-dt.Seconds()/fanTimeConstant.Seconds())
+	avg := 0.0
+	for i := range d.fans {
+		target := d.driven(i)
+		if d.worn[i] {
+			target = min(target, wornFanSpeed)
+		}
+		d.fans[i] += (float64(target) - d.fans[i]) * fanDecay
+		avg += d.fans[i]
+	}
+	avg /= float64(len(d.fans))
+
+	if d.trace != nil {
+		p := d.trace.at(now.Sub(d.start))
+		d.temp, d.power = p.temp, p.power
+		return
+	}
+	d.power = d.idlePower + (d.maxPower-d.idlePower)*float64(d.loadAt(now))/100
+	if d.throttled() {
+		d.power *= 0.8
+	}
+	if natural := d.naturalClock(now); d.clockCap > 0 && d.clockCap < natural {
+		d.power = d.idlePower + (d.power-d.idlePower)*float64(d.clockCap)/float64(natural)
+	}
+	d.power = min(d.power, d.powerLimit)
+	share := (avg - minFanSpeed) / (maxFanSpeed - minFanSpeed)
+	resistance := d.resistance[0] + (d.resistance[1]-d.resistance[0])*share
+	steady := d.ambient + d.power*resistance
+	d.temp += (steady - d.temp) * (1 - math.Exp(-dt.Seconds()/d.timeConstant.Seconds()))
+}
+
+func (d *Device) GetName() (string, nvml.Return) {
+	return d.name, nvml.SUCCESS
+}
+
+func (d *Device) GetUUID() (string, nvml.Return) {
+	if d.uuid != "" {
+		return d.uuid, nvml.SUCCESS
+	}
+	return fmt.Sprintf("GPU-00000000-0000-0000-0000-%012d", d.index), nvml.SUCCESS
+}
+
+func (d *Device) GetSerial() (string, nvml.Return) {
+	if d.consumer {
+		return "", nvml.ERROR_NOT_SUPPORTED
+	}
+	return fmt.Sprintf("SIM%07d", d.index), nvml.SUCCESS
+}
+
+func (d *Device) GetMinorNumber() (int, nvml.Return) {
+	return d.index, nvml.SUCCESS
+}
+
+func (d *Device) GetPciInfo() (nvml.PciInfo, nvml.Return) {
+	info := nvml.PciInfo{Bus: uint32(d.index + 1)}
+	for i, c := range fmt.Sprintf("00000000:%02X:00.0", info.Bus) {
+		info.BusId[i] = int8(c)
+	}
+	return info, nvml.SUCCESS
+}
+
+func (d *Device) GetVbiosVersion() (string, nvml.Return) {
+	return "sim", nvml.SUCCESS
+}
+
+func (d *Device) GetPersistenceMode() (nvml.EnableState, nvml.Return) {
+	return nvml.FEATURE_ENABLED, nvml.SUCCESS
+}
+
+// present tells whether the device is attached now.
+func (d *Device) present() bool {
+	age := d.clock.Now().Sub(d.start)
+	return age >= d.attach && (d.detach == 0 || age < d.detach)
+}
+
+func (d *Device) GetTemperature(sensor nvml.TemperatureSensors) (uint32, nvml.Return) {
+	if !d.present() {
+		return 0, nvml.ERROR_GPU_IS_LOST
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.advance()
+	return uint32(math.Round(d.temp)), nvml.SUCCESS
+}
+
+func (d *Device) GetTemperatureThreshold(threshold nvml.TemperatureThresholds) (uint32, nvml.Return) {
+	if d.consumer {
+		return 0, nvml.ERROR_NOT_SUPPORTED
+	}
+	return uint32(d.maxTemp), nvml.SUCCESS
+}
+
+func (d *Device) GetPowerUsage() (uint32, nvml.Return) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.advance()
+	return uint32(d.power * 1000), nvml.SUCCESS
+}
+
+// GetUtilizationRates reports load of the profile, traces don't record it.
+func (d *Device) GetUtilizationRates() (nvml.Utilization, nvml.Return) {
+	if d.trace != nil {
+		return nvml.Utilization{}, nvml.ERROR_NOT_SUPPORTED
+	}
+	load := uint32(d.loadAt(d.clock.Now()))
+	return nvml.Utilization{Gpu: load, Memory: load / 2}, nvml.SUCCESS
+}
+
+// GetComputeRunningProcesses reports one process while the profile puts
+// more than background load on the GPU.
+func (d *Device) GetComputeRunningProcesses() ([]nvml.ProcessInfo, nvml.Return) {
+	if d.trace != nil {
+		return nil, nvml.ERROR_NOT_SUPPORTED
+	}
+	if d.loadAt(d.clock.Now()) <= backgroundLoad {
+		return nil, nvml.SUCCESS
+	}
+	return []nvml.ProcessInfo{{Pid: uint32(simPID + d.index), UsedGpuMemory: 1 << 30}}, nvml.SUCCESS
+}
+
+func (d *Device) GetGraphicsRunningProcesses() ([]nvml.ProcessInfo, nvml.Return) {
+	if d.trace != nil {
+		return nil, nvml.ERROR_NOT_SUPPORTED
+	}
+	return nil, nvml.SUCCESS
+}
+
+// GetPerformanceState reports P2 under more than background load, P8
+// otherwise.
+func (d *Device) GetPerformanceState() (nvml.Pstates, nvml.Return) {
+	if d.trace != nil {
+		return nvml.PSTATE_UNKNOWN, nvml.ERROR_NOT_SUPPORTED
+	}
+	if d.loadAt(d.clock.Now()) > backgroundLoad {
+		return nvml.PSTATE_2, nvml.SUCCESS
+	}
+	return nvml.PSTATE_8, nvml.SUCCESS
+}
+
+func (d *Device) GetEnforcedPowerLimit() (uint32, nvml.Return) {
+	return d.GetPowerManagementLimit()
+}
+
+func (d *Device) GetPowerManagementLimit() (uint32, nvml.Return) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return uint32(d.powerLimit * 1000), nvml.SUCCESS
+}
+
+func (d *Device) GetPowerManagementDefaultLimit() (uint32, nvml.Return) {
+	return uint32(d.maxPower * 1000), nvml.SUCCESS
+}
+
+// GetPowerManagementLimitConstraints allows limits from 40% of maximum
+// power.
+func (d *Device) GetPowerManagementLimitConstraints() (uint32, uint32, nvml.Return) {
+	return uint32(d.maxPower * 400), uint32(d.maxPower * 1000), nvml.SUCCESS
+}
+
+func (d *Device) SetPowerManagementLimit(limit uint32) nvml.Return {
+	watts := float64(limit) / 1000
+	if watts < d.maxPower*0.4 || watts > d.maxPower {
+		return nvml.ERROR_INVALID_ARGUMENT
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.advance()
+	d.powerLimit = watts
+	return nvml.SUCCESS
+}
+
+func (d *Device) GetClockInfo(clock nvml.ClockType) (uint32, nvml.Return) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.advance()
+	if d.trace != nil {
+		p := d.trace.at(d.last.Sub(d.start))
+		switch clock {
+		case nvml.CLOCK_GRAPHICS, nvml.CLOCK_SM:
+			return uint32(p.graphics), nvml.SUCCESS
+		case nvml.CLOCK_MEM:
+			return uint32(p.memory), nvml.SUCCESS
+		}
+		return 0, nvml.ERROR_INVALID_ARGUMENT
+	}
+	switch clock {
+	case nvml.CLOCK_GRAPHICS, nvml.CLOCK_SM:
+		mhz := d.naturalClock(d.last)
+		if d.throttled() {
+			mhz = mhz * 8 / 10
+		}
+		if d.clockCap > 0 {
+			mhz = min(mhz, d.clockCap)
+		}
+		return uint32(mhz), nvml.SUCCESS
+	case nvml.CLOCK_MEM:
+		return 5000, nvml.SUCCESS
+	}
+	return 0, nvml.ERROR_INVALID_ARGUMENT
+}
+
+// naturalClock is the graphics clock at t without throttling or locks.
+func (d *Device) naturalClock(t time.Time) int {
+	return 300 + 15*d.loadAt(t)
+}
+
+// SetGpuLockedClocks caps the graphics clock at maxMHz, lower clocks cut
+// power in proportion.
+func (d *Device) SetGpuLockedClocks(minMHz, maxMHz uint32) nvml.Return {
+	if minMHz > maxMHz {
+		return nvml.ERROR_INVALID_ARGUMENT
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.advance()
+	d.clockCap = int(maxMHz)
+	return nvml.SUCCESS
+}
+
+func (d *Device) ResetGpuLockedClocks() nvml.Return {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.advance()
+	d.clockCap = 0
+	return nvml.SUCCESS
+}
+
+// SetMemoryLockedClocks is accepted, memory clock doesn't change the model.
+func (d *Device) SetMemoryLockedClocks(minMHz, maxMHz uint32) nvml.Return {
+	if minMHz > maxMHz {
+		return nvml.ERROR_INVALID_ARGUMENT
+	}
+	return nvml.SUCCESS
+}
+
+func (d *Device) ResetMemoryLockedClocks() nvml.Return {
+	return nvml.SUCCESS
+}
+
+func (d *Device) GetCurrentClocksThrottleReasons() (uint64, nvml.Return) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.advance()
+	if d.throttled() {
+		return nvml.ClocksThrottleReasonSwThermalSlowdown, nvml.SUCCESS
+	}
+	return 0, nvml.SUCCESS
+}
+
+func (d *Device) GetNumFans() (int, nvml.Return) {
+	return len(d.fans), nvml.SUCCESS
+}
+
+func (d *Device) GetMinMaxFanSpeed() (int, int, nvml.Return) {
+	return minFanSpeed, maxFanSpeed, nvml.SUCCESS
+}
+
+// GetFanSpeed_v2 returns the speed fan is driven at, like NVML it isn't
+// measured and a worn fan doesn't show here.
+func (d *Device) GetFanSpeed_v2(fan int) (uint32, nvml.Return) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if fan < 0 || fan >= len(d.fans) {
+		return 0, nvml.ERROR_INVALID_ARGUMENT
+	}
+	d.advance()
+	return uint32(d.driven(fan)), nvml.SUCCESS
+}
+
+// GetFanSpeedRPM returns the measured speed of fan, worn fans fall short of
+// what GetFanSpeed_v2 reports. Consumer cards don't measure it.
+func (d *Device) GetFanSpeedRPM(fan int) (int, nvml.Return) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if fan < 0 || fan >= len(d.fans) {
+		return 0, nvml.ERROR_INVALID_ARGUMENT
+	}
+	if d.consumer {
+		return 0, nvml.ERROR_NOT_SUPPORTED
+	}
+	d.advance()
+	return int(math.Round(d.fans[fan] * maxFanRPM / 100)), nvml.SUCCESS
+}
+
+func (d *Device) GetTargetFanSpeed(fan int) (int, nvml.Return) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if fan < 0 || fan >= len(d.fans) {
+		return 0, nvml.ERROR_INVALID_ARGUMENT
+	}
+	d.advance()
+	return d.driven(fan), nvml.SUCCESS
+}
+
+func (d *Device) GetFanControlPolicy_v2(fan int) (nvml.FanControlPolicy, nvml.Return) {
+	if d.consumer {
+		return 0, nvml.ERROR_NOT_SUPPORTED
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if fan < 0 || fan >= len(d.fans) {
+		return 0, nvml.ERROR_INVALID_ARGUMENT
+	}
+	if d.manual[fan] {
+		return nvml.FAN_POLICY_MANUAL, nvml.SUCCESS
+	}
+	return nvml.FAN_POLICY_TEMPERATURE_CONTINOUS_SW, nvml.SUCCESS
+}
+
+func (d *Device) SetFanControlPolicy(fan int, policy nvml.FanControlPolicy) nvml.Return {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if fan < 0 || fan >= len(d.fans) {
+		return nvml.ERROR_INVALID_ARGUMENT
+	}
+	d.advance()
+	if policy == nvml.FAN_POLICY_MANUAL && !d.manual[fan] {
+		d.target[fan] = d.autoSpeed()
+	}
+	d.manual[fan] = policy == nvml.FAN_POLICY_MANUAL
+	return nvml.SUCCESS
+}
+
+func (d *Device) SetFanSpeed_v2(fan int, speed int) nvml.Return {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if fan < 0 || fan >= len(d.fans) || speed < minFanSpeed || speed > maxFanSpeed {
+		return nvml.ERROR_INVALID_ARGUMENT
+	}
+	d.advance()
+	d.manual[fan] = true
+	d.target[fan] = speed
+	return nvml.SUCCESS
+}
+
+func (d *Device) SetDefaultFanSpeed_v2(fan int) nvml.Return {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if fan < 0 || fan >= len(d.fans) {
+		return nvml.ERROR_INVALID_ARGUMENT
+	}
+	d.advance()
+	d.manual[fan] = false
+	return nvml.SUCCESS
+}
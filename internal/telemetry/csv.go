@@ -0,0 +1,125 @@
+package telemetry
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultCSVMaxFiles = 5
+)
+
+var csvHeader = []string{"time", "gpu", "uuid", "name", "temp", "speed", "output", "power", "graphics_clock", "memory_clock"}
+
+// CSVSink writes samples as CSV rows and rotates the file by size.
+type CSVSink struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	writer   *csv.Writer
+	size     int64
+}
+
+func NewCSVSink(path string, maxSizeMB, maxFiles int) (*CSVSink, error) {
+	if maxFiles <= 0 {
+		maxFiles = DefaultCSVMaxFiles
+	}
+	sink := &CSVSink{
+		path:     path,
+		maxSize:  int64(maxSizeMB) * 1024 * 1024,
+		maxFiles: maxFiles,
+	}
+	if err := sink.open(); err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+func (s *CSVSink) open() error {
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	s.file = file
+	s.size = info.Size()
+	s.writer = csv.NewWriter(file)
+	if s.size == 0 {
+		s.write(csvHeader)
+	}
+	return nil
+}
+
+func (s *CSVSink) write(row []string) {
+	if err := s.writer.Write(row); err != nil {
+		Log.Error("Can't write telemetry", "path", s.path, "error", err)
+		return
+	}
+	s.writer.Flush()
+	// Quoting of product names is rare and ignored, rotation doesn't need to be exact.
+	for _, field := range row {
+		s.size += int64(len(field)) + 1
+	}
+}
+
+// rotate shifts path.N to path.N+1, dropping the oldest, and reopens a fresh file.
+func (s *CSVSink) rotate() {
+	s.file.Close()
+	for i := s.maxFiles - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", s.path, i), fmt.Sprintf("%s.%d", s.path, i+1))
+	}
+	if err := os.Rename(s.path, s.path+".1"); err != nil {
+		Log.Error("Can't rotate telemetry file", "path", s.path, "error", err)
+	}
+	if err := s.open(); err != nil {
+		Log.Error("Can't reopen telemetry file", "path", s.path, "error", err)
+		s.file = nil
+	}
+	Log.Debug("Telemetry file rotated", "path", s.path)
+}
+
+func (s *CSVSink) Record(sample Sample) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return
+	}
+	if s.maxSize > 0 && s.size >= s.maxSize {
+		s.rotate()
+		if s.file == nil {
+			return
+		}
+	}
+	s.write([]string{
+		sample.Time.Format(time.RFC3339),
+		strconv.Itoa(sample.GPU),
+		sample.UUID,
+		sample.Name,
+		strconv.Itoa(sample.Temp),
+		strconv.Itoa(sample.Speed),
+		strconv.Itoa(sample.Output),
+		strconv.FormatFloat(sample.Power, 'f', 1, 64),
+		strconv.Itoa(sample.Graphics),
+		strconv.Itoa(sample.Memory),
+	})
+}
+
+func (s *CSVSink) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file != nil {
+		s.writer.Flush()
+		s.file.Close()
+		s.file = nil
+	}
+}
@@ -0,0 +1,32 @@
+// Package telemetry records control loop samples and GPU events.
+package telemetry
+
+import (
+	"log/slog"
+	"time"
+)
+
+// Log is used by sinks, it is replaced when logging is configured.
+var Log = slog.With("component", "telemetry")
+
+// Sample is a single control loop observation of a GPU.
+type Sample struct {
+	Time      time.Time `json:"time"`
+	GPU       int       `json:"gpu"`
+	UUID      string    `json:"uuid"`
+	Name      string    `json:"name"`
+	Temp      int       `json:"temp"`           // GPU temperature.
+	Speed     int       `json:"speed"`          // Fan speed reported by the card.
+	Output    int       `json:"output"`         // Fan speed requested by the controller.
+	MaxFan    bool      `json:"max_fan"`        // Output is at the maximum fan speed.
+	Throttled bool      `json:"throttled"`      // Clocks are reduced for thermal reasons.
+	Power     float64   `json:"power"`          // Power draw in watts.
+	Graphics  int       `json:"graphics_clock"` // Graphics clock in MHz.
+	Memory    int       `json:"memory_clock"`   // Memory clock in MHz.
+}
+
+// Sink receives every sample, e.g. to write it to a file.
+type Sink interface {
+	Record(s Sample)
+	Close()
+}
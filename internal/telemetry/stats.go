@@ -0,0 +1,342 @@
+package telemetry
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Event is something noteworthy that happened to a GPU.
+type Event struct {
+	Time    time.Time `json:"time"`
+	GPU     int       `json:"gpu"`
+	UUID    string    `json:"uuid"`
+	Name    string    `json:"name"`
+	Type    string    `json:"type"`
+	Message string    `json:"message"`
+	// Resolved ends the condition reported by an earlier event of the type.
+	Resolved bool `json:"resolved,omitempty"`
+}
+
+const (
+	DefaultStatsRetention = 30
+	// Samples are buffered in memory and written in batches by a goroutine
+	// of the store, which keeps the database open. Control loops never wait
+	// for the disk.
+	statsFlushInterval = 30 * time.Second
+	// statsMaxPending bounds samples and events of each kind kept while the
+	// database can't be written, the oldest are dropped beyond it.
+	statsMaxPending = 100000
+	// Gaps between samples longer than this are not counted as time spent in
+	// the sampled state (daemon was stopped, host suspended, ...).
+	statsMaxGap = time.Minute
+)
+
+var (
+	samplesBucket = []byte("samples")
+	eventsBucket  = []byte("events")
+)
+
+// StatsStore persists samples and events into a bbolt database.
+type StatsStore struct {
+	mu        sync.Mutex
+	db        *bolt.DB
+	path      string
+	retention time.Duration
+	samples   []Sample
+	events    []Event
+	// flushes asks the writer to flush now and receives where it reports
+	// the result, stop ends it.
+	flushes chan chan error
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+func NewStatsStore(path string, retentionDays int) (*StatsStore, error) {
+	if retentionDays <= 0 {
+		retentionDays = DefaultStatsRetention
+	}
+	db, err := OpenStatsDB(path, false)
+	if err != nil {
+		return nil, err
+	}
+	s := &StatsStore{
+		db:        db,
+		path:      path,
+		retention: time.Duration(retentionDays) * 24 * time.Hour,
+		flushes:   make(chan chan error),
+		stop:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+	// Make sure the database is usable before the control loops start.
+	if err := s.flush(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	go s.write()
+	return s, nil
+}
+
+// OpenStatsDB opens statistics database at path.
+func OpenStatsDB(path string, readOnly bool) (*bolt.DB, error) {
+	return bolt.Open(path, 0644, &bolt.Options{Timeout: 5 * time.Second, ReadOnly: readOnly})
+}
+
+// statsKey orders records by time, the GPU index keeps keys of samples
+// taken at the same instant unique.
+func statsKey(t time.Time, gpu int, seq int) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	binary.BigEndian.PutUint32(key[8:], uint32(gpu))
+	binary.BigEndian.PutUint32(key[12:], uint32(seq))
+	return key
+}
+
+// write flushes buffered records every statsFlushInterval and when asked
+// to, until the store is closed.
+func (s *StatsStore) write() {
+	defer close(s.stopped)
+	ticker := time.NewTicker(statsFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.flush(); err != nil {
+				Log.Error("Can't write statistics", "path", s.path, "error", err)
+			}
+		case result := <-s.flushes:
+			result <- s.flush()
+		case <-s.stop:
+			if err := s.flush(); err != nil {
+				Log.Error("Can't write statistics", "path", s.path, "error", err)
+			}
+			s.db.Close()
+			return
+		}
+	}
+}
+
+// flush writes buffered records in one transaction and drops expired ones.
+// Records are forgotten only once they are committed.
+func (s *StatsStore) flush() error {
+	s.mu.Lock()
+	samples := slices.Clone(s.samples)
+	events := slices.Clone(s.events)
+	s.mu.Unlock()
+
+	cutoff := statsKey(time.Now().Add(-s.retention), 0, 0)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		for i, sample := range samples {
+			if err := putJSON(tx, samplesBucket, statsKey(sample.Time, sample.GPU, i), sample); err != nil {
+				return err
+			}
+		}
+		for i, event := range events {
+			if err := putJSON(tx, eventsBucket, statsKey(event.Time, event.GPU, i), event); err != nil {
+				return err
+			}
+		}
+
+		// Drop everything older than retention period
+		for _, name := range [][]byte{samplesBucket, eventsBucket} {
+			bucket, err := tx.CreateBucketIfNotExists(name)
+			if err != nil {
+				return err
+			}
+			c := bucket.Cursor()
+			for k, _ := c.First(); k != nil && string(k) < string(cutoff); k, _ = c.Next() {
+				if err := c.Delete(); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		// Only the writer flushes, nothing was forgotten meanwhile
+		s.samples = trimPending(s.samples)
+		s.events = trimPending(s.events)
+		return err
+	}
+	s.samples = slices.Delete(s.samples, 0, len(samples))
+	s.events = slices.Delete(s.events, 0, len(events))
+	return nil
+}
+
+func putJSON(tx *bolt.Tx, name, key []byte, v any) error {
+	bucket, err := tx.CreateBucketIfNotExists(name)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(key, data)
+}
+
+// trimPending drops the oldest of records beyond statsMaxPending.
+func trimPending[T any](records []T) []T {
+	if len(records) > statsMaxPending {
+		Log.Warn("Statistics dropped, database can't be written", "dropped", len(records)-statsMaxPending)
+		return slices.Delete(records, 0, len(records)-statsMaxPending)
+	}
+	return records
+}
+
+func (s *StatsStore) Record(sample Sample) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.samples = append(s.samples, sample)
+}
+
+func (s *StatsStore) RecordEvent(event Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, event)
+}
+
+// Flush writes buffered records now and waits for them.
+func (s *StatsStore) Flush() {
+	result := make(chan error, 1)
+	select {
+	case s.flushes <- result:
+	case <-s.stopped:
+		return
+	}
+	if err := <-result; err != nil {
+		Log.Error("Can't write statistics", "path", s.path, "error", err)
+	}
+}
+
+// Close writes buffered records and closes the database.
+func (s *StatsStore) Close() {
+	select {
+	case <-s.stopped:
+	default:
+		close(s.stop)
+		<-s.stopped
+	}
+}
+
+// View runs fn with the database of the store, which can't be opened by
+// other processes while the store is open.
+func (s *StatsStore) View(fn func(db *bolt.DB) error) error {
+	return fn(s.db)
+}
+
+// DayStats aggregates samples of one GPU over one day.
+type DayStats struct {
+	GPU      int           `json:"gpu"` // Index of the card when the last sample was taken.
+	UUID     string        `json:"uuid"`
+	Name     string        `json:"name"`
+	Day      string        `json:"day"`
+	Min      int           `json:"min"`
+	Max      int           `json:"max"`
+	Avg      float64       `json:"avg"`
+	AtMax    time.Duration `json:"at_max"`
+	Throttle time.Duration `json:"throttle"`
+	sum      int
+	count    int
+}
+
+// matchGPU reports whether a record belongs to the GPU selected by filter,
+// which is either an index or an UUID. Empty filter matches everything.
+func matchGPU(filter string, idx int, uuid string) bool {
+	return filter == "" || filter == uuid || filter == strconv.Itoa(idx)
+}
+
+// QueryStats reads samples newer than since and aggregates them per GPU and day.
+// GPUs are told apart by UUID, so history survives changes of enumeration order.
+func QueryStats(db *bolt.DB, since time.Time, gpu string) ([]*DayStats, error) {
+	days := map[string]*DayStats{}
+	last := map[string]time.Time{}
+	err := db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(samplesBucket)
+		if bucket == nil {
+			return nil
+		}
+		c := bucket.Cursor()
+		for k, v := c.Seek(statsKey(since, 0, 0)); k != nil; k, v = c.Next() {
+			var sample Sample
+			if err := json.Unmarshal(v, &sample); err != nil {
+				return err
+			}
+			if !matchGPU(gpu, sample.GPU, sample.UUID) {
+				continue
+			}
+			t := sample.Time.Local()
+			day := t.Format(time.DateOnly)
+			key := day + "/" + sample.UUID
+			d, ok := days[key]
+			if !ok {
+				d = &DayStats{UUID: sample.UUID, Day: day, Min: sample.Temp, Max: sample.Temp}
+				days[key] = d
+			}
+			d.GPU, d.Name = sample.GPU, sample.Name
+			d.Min = min(d.Min, sample.Temp)
+			d.Max = max(d.Max, sample.Temp)
+			d.sum += sample.Temp
+			d.count++
+			if prev, ok := last[sample.UUID]; ok {
+				if dt := t.Sub(prev); dt < statsMaxGap {
+					if sample.MaxFan {
+						d.AtMax += dt
+					}
+					if sample.Throttled {
+						d.Throttle += dt
+					}
+				}
+			}
+			last[sample.UUID] = t
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*DayStats, 0, len(days))
+	for _, d := range days {
+		d.Avg = float64(d.sum) / float64(d.count)
+		result = append(result, d)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].UUID != result[j].UUID {
+			return result[i].UUID < result[j].UUID
+		}
+		return result[i].Day < result[j].Day
+	})
+	return result, nil
+}
+
+// QueryEvents returns events newer than since.
+func QueryEvents(db *bolt.DB, since time.Time, gpu string) ([]Event, error) {
+	var events []Event
+	err := db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(eventsBucket)
+		if bucket == nil {
+			return nil
+		}
+		c := bucket.Cursor()
+		for k, v := c.Seek(statsKey(since, 0, 0)); k != nil; k, v = c.Next() {
+			var event Event
+			if err := json.Unmarshal(v, &event); err != nil {
+				return err
+			}
+			if !matchGPU(gpu, event.GPU, event.UUID) {
+				continue
+			}
+			events = append(events, event)
+		}
+		return nil
+	})
+	return events, err
+}
@@ -0,0 +1,73 @@
+package telemetry
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestStatsStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.db")
+	s, err := NewStatsStore(path, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for i := range 3 {
+		s.Record(Sample{Time: now.Add(time.Duration(i) * time.Second), UUID: "GPU-1", Temp: 50 + i})
+	}
+	s.RecordEvent(Event{Time: now, UUID: "GPU-1", Type: "control"})
+	// Expired records are dropped on flush
+	s.Record(Sample{Time: now.Add(-48 * time.Hour), UUID: "GPU-1", Temp: 90})
+	s.Flush()
+	if len(s.samples) != 0 || len(s.events) != 0 {
+		t.Errorf("%d samples and %d events left after a commit", len(s.samples), len(s.events))
+	}
+
+	// Readers of the daemon's store go through View
+	err = s.View(func(db *bolt.DB) error {
+		days, err := QueryStats(db, now.Add(-72*time.Hour), "")
+		if err != nil {
+			return err
+		}
+		if len(days) != 1 || days[0].Min != 50 || days[0].Max != 52 || days[0].Avg != 51 {
+			t.Errorf("statistics %+v, want one day of 50-52°C", days)
+		}
+		events, err := QueryEvents(db, now.Add(-time.Hour), "GPU-1")
+		if len(events) != 1 {
+			t.Errorf("%d events, want 1", len(events))
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.Close()
+	s.Close()
+	s.Flush()
+	db, err := OpenStatsDB(path, true)
+	if err != nil {
+		t.Fatalf("database is still locked after Close: %v", err)
+	}
+	db.Close()
+}
+
+func TestStatsStoreKeepsUncommitted(t *testing.T) {
+	s, err := NewStatsStore(filepath.Join(t.TempDir(), "stats.db"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.Record(Sample{Time: time.Now(), UUID: "GPU-1", Temp: 50})
+	// A closed database fails every transaction
+	s.View(func(db *bolt.DB) error { return db.Close() })
+	if err := s.flush(); err == nil {
+		t.Fatal("flush to a closed database succeeded")
+	}
+	if len(s.samples) != 1 {
+		t.Errorf("%d samples left after a failed commit, want 1", len(s.samples))
+	}
+}
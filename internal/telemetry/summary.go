@@ -0,0 +1,98 @@
+package telemetry
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	DefaultSummaryInterval = 24 * time.Hour
+	DefaultSummaryWarning  = 80
+)
+
+type gpuSummary struct {
+	idx            int
+	uuid, name     string
+	start          time.Time
+	last           time.Time
+	min, max       int
+	tempSum        int
+	speedSum       int
+	count          int
+	aboveWarning   time.Duration
+	throttled      bool
+	throttleEvents int
+}
+
+// SummarySink aggregates samples and logs one info line per GPU every interval.
+type SummarySink struct {
+	mu       sync.Mutex
+	interval time.Duration
+	warning  int
+	gpus     map[string]*gpuSummary // Keyed by UUID
+}
+
+func NewSummarySink(interval time.Duration, warning int) *SummarySink {
+	if interval <= 0 {
+		interval = DefaultSummaryInterval
+	}
+	if warning <= 0 {
+		warning = DefaultSummaryWarning
+	}
+	return &SummarySink{interval: interval, warning: warning, gpus: map[string]*gpuSummary{}}
+}
+
+func (s *SummarySink) Record(sample Sample) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g, ok := s.gpus[sample.UUID]
+	if !ok {
+		g = &gpuSummary{start: sample.Time}
+		s.gpus[sample.UUID] = g
+	}
+	g.idx, g.uuid, g.name = sample.GPU, sample.UUID, sample.Name
+	if g.count > 0 && sample.Temp > s.warning {
+		if dt := sample.Time.Sub(g.last); dt < statsMaxGap {
+			g.aboveWarning += dt
+		}
+	}
+	if g.count == 0 {
+		g.min, g.max = sample.Temp, sample.Temp
+	}
+	g.min = min(g.min, sample.Temp)
+	g.max = max(g.max, sample.Temp)
+	g.tempSum += sample.Temp
+	g.speedSum += sample.Speed
+	g.count++
+	if sample.Throttled && !g.throttled {
+		g.throttleEvents++
+	}
+	g.throttled = sample.Throttled
+	g.last = sample.Time
+
+	if sample.Time.Sub(g.start) >= s.interval {
+		s.log(g)
+		*g = gpuSummary{start: sample.Time, last: sample.Time, throttled: g.throttled}
+	}
+}
+
+func (s *SummarySink) log(g *gpuSummary) {
+	if g.count == 0 {
+		return
+	}
+	Log.Info("Thermal summary", "GPU", g.idx, "uuid", g.uuid, "name", g.name,
+		"period", g.last.Sub(g.start).Round(time.Second),
+		"min", g.min, "avg", g.tempSum/g.count, "max", g.max,
+		"above_warning", g.aboveWarning.Round(time.Second), "warning", s.warning,
+		"fan_avg", g.speedSum/g.count, "throttle_events", g.throttleEvents)
+}
+
+// Close logs whatever was collected for the incomplete period.
+func (s *SummarySink) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, g := range s.gpus {
+		s.log(g)
+	}
+	s.gpus = map[string]*gpuSummary{}
+}
@@ -0,0 +1,115 @@
+// Package controller holds fan speed controllers of nvmlfan: temperature
+// curves and PID. They do no I/O, so they can be embedded in other programs
+// which read temperatures and set fan speeds themselves.
+//
+// A curve is a list of [temperature, fan speed] points with both values
+// increasing, speeds between points are interpolated linearly.
+package controller
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// ComputeFanSpeed calculates the fan speed based on the temperature and the curve.
+func ComputeFanSpeed(temp int, curve [][2]int, minSpeed, maxSpeed int) int {
+	// If temperature is below the first point in the curve, or there is none
+	if len(curve) == 0 || temp < curve[0][0] {
+		return minSpeed
+	}
+
+	// If temperature is above the last point in the curve
+	if temp > curve[len(curve)-1][0] {
+		return maxSpeed
+	}
+
+	// If temperature is between two points in the curve
+	for i := 0; i < len(curve)-1; i++ {
+		t1, f1 := curve[i][0], curve[i][1]
+		t2, f2 := curve[i+1][0], curve[i+1][1]
+
+		if temp >= t1 && temp < t2 {
+			// Linear interpolation
+			return f1 + (f2-f1)*(temp-t1)/(t2-t1)
+		}
+	}
+
+	// Temperature of the last point, the only one or points of the same
+	// temperature, the fastest of them wins
+	speed := -1
+	for _, point := range curve {
+		if point[0] == temp {
+			speed = max(speed, point[1])
+		}
+	}
+	if speed < 0 {
+		// Curve is not increasing
+		return maxSpeed
+	}
+	return speed
+}
+
+// ClampCurve returns a copy of curve limited to the fan speed range and
+// maximum temperature of the card. Points of the same temperature, given so or
+// clamped to the maximum, are merged into the faster one. Clamps and curve
+// problems are logged to logger, nil means the default logger.
+func ClampCurve(curve [][2]int, minSpeed, maxSpeed, maxTemp int, logger *slog.Logger) [][2]int {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger.Debug("Clamping curve", "dump", curve)
+	clamped := make([][2]int, 0, len(curve))
+	for i, point := range curve {
+		if point[0] > maxTemp {
+			logger.Debug("Clamping temperature above maximum GPU threshold", "temp", point[0], "point", i, "max", maxTemp)
+			point[0] = maxTemp
+		}
+		if point[1] < minSpeed {
+			logger.Debug("Clamping fan below allowed range", "speed", point[1], "point", i, "min", minSpeed)
+			point[1] = minSpeed
+		}
+		if point[1] > maxSpeed {
+			logger.Debug("Clamping fan above allowed range", "speed", point[1], "point", i, "max", maxSpeed)
+			point[1] = maxSpeed
+		}
+		if len(clamped) > 0 {
+			prev := &clamped[len(clamped)-1]
+			if point[0] == prev[0] {
+				logger.Warn("Merging curve points of the same temperature", "temp", point[0], "point", i)
+				prev[1] = max(prev[1], point[1])
+				continue
+			}
+			if point[0] < prev[0] {
+				logger.Error("Temperature curve is not increasing", "point", i-1, "next", i)
+			}
+			if point[1] <= prev[1] {
+				logger.Error("Fan speed curve is not increasing", "point", i-1, "next", i)
+			}
+		}
+		clamped = append(clamped, point)
+	}
+	logger.Debug("Clamped curve", "dump", clamped)
+	return clamped
+}
+
+// CurveSegment describes which part of the curve maps temp to a speed.
+func CurveSegment(temp int, curve [][2]int) string {
+	if len(curve) == 0 {
+		return "no curve, minimum speed"
+	}
+	if temp < curve[0][0] {
+		return fmt.Sprintf("below first point %d°C, minimum speed", curve[0][0])
+	}
+	last := curve[len(curve)-1]
+	if temp > last[0] {
+		return fmt.Sprintf("above last point %d°C, maximum speed", last[0])
+	}
+	for i := 0; i < len(curve)-1; i++ {
+		t1, f1 := curve[i][0], curve[i][1]
+		t2, f2 := curve[i+1][0], curve[i+1][1]
+		if temp >= t1 && temp <= t2 {
+			return fmt.Sprintf("points %d-%d: %d°C/%d%% - %d°C/%d%%", i, i+1, t1, f1, t2, f2)
+		}
+	}
+	return fmt.Sprintf("single point %d°C/%d%%", last[0], last[1])
+}
@@ -0,0 +1,128 @@
+package controller
+
+import (
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testCurve = [][2]int{{40, 30}, {60, 50}, {80, 100}}
+
+func TestComputeFanSpeed(t *testing.T) {
+	tests := []struct {
+		name  string
+		temp  int
+		curve [][2]int
+		want  int
+	}{
+		{"below first point", 20, testCurve, 25},
+		{"first point", 40, testCurve, 30},
+		{"interpolated", 50, testCurve, 40},
+		{"rounded down", 45, testCurve, 35},
+		{"inner point", 60, testCurve, 50},
+		{"steeper segment", 70, testCurve, 75},
+		{"last point", 80, testCurve, 100},
+		{"above last point", 95, testCurve, 90},
+		{"single point below", 59, [][2]int{{60, 70}}, 25},
+		{"single point above", 61, [][2]int{{60, 70}}, 90},
+		{"single point", 60, [][2]int{{60, 70}}, 70},
+		{"empty curve", 60, nil, 25},
+		{"same temperature", 60, [][2]int{{40, 30}, {60, 50}, {60, 80}, {80, 90}}, 80},
+		{"after same temperature", 70, [][2]int{{40, 30}, {60, 50}, {60, 80}, {80, 90}}, 85},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ComputeFanSpeed(tt.temp, tt.curve, 25, 90); got != tt.want {
+				t.Errorf("ComputeFanSpeed(%d) = %d, want %d", tt.temp, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClampCurve(t *testing.T) {
+	tests := []struct {
+		name   string
+		curve  [][2]int
+		want   [][2]int
+		errors []string // Logged curve problems.
+	}{
+		{"within limits", testCurve, testCurve, nil},
+		{"speeds clamped", [][2]int{{40, 10}, {80, 120}}, [][2]int{{40, 30}, {80, 100}}, nil},
+		{"temperature clamped", [][2]int{{40, 30}, {95, 100}}, [][2]int{{40, 30}, {88, 100}}, nil},
+		{"temperature not increasing", [][2]int{{60, 30}, {50, 100}}, [][2]int{{60, 30}, {50, 100}},
+			[]string{"Temperature curve is not increasing"}},
+		{"speed not increasing", [][2]int{{40, 60}, {80, 50}}, [][2]int{{40, 60}, {80, 50}},
+			[]string{"Fan speed curve is not increasing"}},
+		{"clamped flat", [][2]int{{40, 100}, {80, 110}}, [][2]int{{40, 100}, {80, 100}},
+			[]string{"Fan speed curve is not increasing"}},
+		{"same temperature merged", [][2]int{{40, 30}, {60, 70}, {60, 50}, {80, 100}}, [][2]int{{40, 30}, {60, 70}, {80, 100}}, nil},
+		{"clamped to same temperature", [][2]int{{40, 30}, {90, 80}, {95, 100}}, [][2]int{{40, 30}, {88, 100}}, nil},
+		{"empty", nil, [][2]int{}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var log strings.Builder
+			logger := slog.New(slog.NewTextHandler(&log, &slog.HandlerOptions{Level: slog.LevelError}))
+			original := append([][2]int(nil), tt.curve...)
+			got := ClampCurve(tt.curve, 30, 100, 88, logger)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ClampCurve() = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(tt.curve, original) {
+				t.Errorf("ClampCurve() changed its argument to %v", tt.curve)
+			}
+			for _, message := range tt.errors {
+				if !strings.Contains(log.String(), message) {
+					t.Errorf("ClampCurve() didn't log %q, logged %q", message, log.String())
+				}
+			}
+			if len(tt.errors) == 0 && log.Len() > 0 {
+				t.Errorf("ClampCurve() logged %q", log.String())
+			}
+		})
+	}
+}
+
+func TestCurveSegment(t *testing.T) {
+	tests := []struct {
+		temp int
+		want string
+	}{
+		{30, "below first point 40°C, minimum speed"},
+		{40, "points 0-1: 40°C/30% - 60°C/50%"},
+		{70, "points 1-2: 60°C/50% - 80°C/100%"},
+		{81, "above last point 80°C, maximum speed"},
+	}
+	for _, tt := range tests {
+		if got := CurveSegment(tt.temp, testCurve); got != tt.want {
+			t.Errorf("CurveSegment(%d) = %q, want %q", tt.temp, got, tt.want)
+		}
+	}
+	if got := CurveSegment(50, nil); got != "no curve, minimum speed" {
+		t.Errorf("CurveSegment() of no curve = %q", got)
+	}
+}
+
+func TestScalePreset(t *testing.T) {
+	tests := []struct {
+		preset string
+		want   [][2]int
+	}{
+		{"silent", [][2]int{{43, 30}, {63, 51}, {78, 79}, {85, 100}}},
+		{"balanced", [][2]int{{38, 30}, {58, 65}, {73, 100}}},
+		{"max", [][2]int{{-2, 100}}},
+	}
+	for _, tt := range tests {
+		got, err := ScalePreset(tt.preset, 30, 100, 88)
+		if err != nil {
+			t.Fatalf("ScalePreset(%s): %v", tt.preset, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ScalePreset(%s) = %v, want %v", tt.preset, got, tt.want)
+		}
+	}
+	if _, err := ScalePreset("loud", 30, 100, 88); err == nil {
+		t.Error("ScalePreset(loud) succeeded")
+	}
+}
@@ -0,0 +1,63 @@
+package controller
+
+// PIDTerms are the internals of one PID controller step.
+type PIDTerms struct {
+	Target     int     `json:"target"`
+	Error      float64 `json:"error"`
+	Kp         float64 `json:"kp"`
+	Ki         float64 `json:"ki"`
+	Kd         float64 `json:"kd"`
+	P          float64 `json:"p"`
+	I          float64 `json:"i"` // Integral accumulator.
+	D          float64 `json:"d"`
+	Antiwindup bool    `json:"antiwindup"`
+}
+
+// PID keeps GPU temperature at Target by changing fan speed. Output is
+// positive when the GPU is hotter than target. Min and Max are the fan
+// speed range of the card, the integral stops winding up beyond them.
+type PID struct {
+	Target     int
+	Kp, Ki, Kd float64
+	Min, Max   int
+
+	prevError float64
+	integral  float64
+}
+
+// Update computes fan speed for temp, it is called once per control cycle.
+// The result isn't clamped to Min and Max, the caller does it.
+func (p *PID) Update(temp int) (int, PIDTerms) {
+	return p.UpdateDt(temp, 1)
+}
+
+// UpdateDt is Update for a cycle which lasted dt periods, e.g. 2 after a
+// missed tick. Gains stay tuned per period: the integral grows and the
+// derivative shrinks with dt.
+func (p *PID) UpdateDt(temp int, dt float64) (int, PIDTerms) {
+	if dt <= 0 {
+		dt = 1
+	}
+	// Invert direction of pid
+	err := -float64(p.Target - temp)
+	pTerm := err * p.Kp
+	dTerm := p.Kd * (err - p.prevError) / dt
+	iTerm := p.Ki * err * dt
+	p.prevError = err
+
+	// Antiwindup
+	// If proportional and integral part out of range
+	// and integral is changing in the same direction
+	// integral accumulator is winding up
+	antiwindup := false
+	if pTerm+p.integral > float64(p.Max) && iTerm > 0 ||
+		pTerm+p.integral < float64(p.Min) && iTerm < 0 {
+		iTerm = 0
+		antiwindup = true
+	}
+	p.integral += iTerm
+
+	terms := PIDTerms{Target: p.Target, Error: err, Kp: p.Kp, Ki: p.Ki, Kd: p.Kd,
+		P: pTerm, I: p.integral, D: dTerm, Antiwindup: antiwindup}
+	return int(pTerm + p.integral + dTerm), terms
+}
@@ -0,0 +1,97 @@
+package controller
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPIDUpdate(t *testing.T) {
+	tests := []struct {
+		name  string
+		pid   PID
+		temps []int
+		dt    float64
+		want  []int // Output after every temperature.
+		terms PIDTerms
+	}{
+		{
+			name:  "proportional",
+			pid:   PID{Target: 60, Kp: 2, Min: 30, Max: 100},
+			temps: []int{60, 70, 50},
+			dt:    1,
+			want:  []int{0, 20, -20},
+			terms: PIDTerms{Target: 60, Error: -10, Kp: 2, P: -20},
+		},
+		{
+			name:  "integral accumulates",
+			pid:   PID{Target: 60, Ki: 5, Min: 30, Max: 100},
+			temps: []int{70, 70, 70, 70},
+			dt:    1,
+			want:  []int{50, 100, 150, 150},
+			terms: PIDTerms{Target: 60, Error: 10, Ki: 5, I: 150, Antiwindup: true},
+		},
+		{
+			name:  "integral scaled by dt",
+			pid:   PID{Target: 60, Ki: 1, Min: 30, Max: 100},
+			temps: []int{70, 70},
+			dt:    2,
+			want:  []int{20, 40},
+			terms: PIDTerms{Target: 60, Error: 10, Ki: 1, I: 40},
+		},
+		{
+			name:  "derivative divided by dt",
+			pid:   PID{Target: 60, Kd: 4, Min: 30, Max: 100},
+			temps: []int{60, 70},
+			dt:    2,
+			want:  []int{0, 20},
+			terms: PIDTerms{Target: 60, Error: 10, Kd: 4, D: 20},
+		},
+		{
+			name:  "antiwindup below minimum",
+			pid:   PID{Target: 60, Ki: 10, Min: 30, Max: 100},
+			temps: []int{50, 50},
+			dt:    1,
+			want:  []int{0, 0},
+			terms: PIDTerms{Target: 60, Error: -10, Ki: 10, Antiwindup: true},
+		},
+		{
+			name:  "zero dt counts as a period",
+			pid:   PID{Target: 60, Ki: 1, Min: 30, Max: 100},
+			temps: []int{70},
+			dt:    0,
+			want:  []int{10},
+			terms: PIDTerms{Target: 60, Error: 10, Ki: 1, I: 10},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pid := tt.pid
+			var terms PIDTerms
+			for i, temp := range tt.temps {
+				var got int
+				got, terms = pid.UpdateDt(temp, tt.dt)
+				if got != tt.want[i] {
+					t.Errorf("update %d at %d°C = %d, want %d", i, temp, got, tt.want[i])
+				}
+			}
+			if terms != tt.terms {
+				t.Errorf("last terms = %+v, want %+v", terms, tt.terms)
+			}
+		})
+	}
+}
+
+func TestPIDSettles(t *testing.T) {
+	// A first order plant which cools by fan speed
+	pid := PID{Target: 65, Kp: 3, Ki: 0.5, Kd: 1, Min: 30, Max: 100}
+	temp := 85.0
+	for range 300 {
+		speed, _ := pid.Update(int(math.Round(temp)))
+		speed = min(max(speed, pid.Min), pid.Max)
+		steady := 95 - 0.45*float64(speed)
+		temp += (steady - temp) * 0.1
+	}
+	if math.Abs(temp-65) > 1 {
+		t.Errorf("temperature settled at %.1f°C, want 65°C", temp)
+	}
+}
@@ -0,0 +1,52 @@
+package controller
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// PresetPoint is a curve point relative to card limits: temperature is an
+// offset from the slowdown threshold, speed is a fraction of fan speed range.
+type PresetPoint struct {
+	TempOffset int
+	Speed      float64
+}
+
+var presets = map[string][]PresetPoint{
+	"silent":     {{-45, 0}, {-25, 0.3}, {-10, 0.7}, {-3, 1}},
+	"balanced":   {{-50, 0}, {-30, 0.5}, {-15, 1}},
+	"aggressive": {{-60, 0}, {-40, 0.5}, {-25, 1}},
+	// Full speed at any realistic temperature
+	"max": {{-90, 1}},
+}
+
+// IsPreset reports whether name is a built-in preset.
+func IsPreset(name string) bool {
+	_, ok := presets[name]
+	return ok
+}
+
+// PresetNames returns names of built-in presets, sorted.
+func PresetNames() []string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ScalePreset turns preset into a curve for card limits.
+func ScalePreset(name string, minSpeed, maxSpeed, maxTemp int) ([][2]int, error) {
+	points, ok := presets[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown preset '%s'", name)
+	}
+	curve := make([][2]int, len(points))
+	for i, p := range points {
+		speed := float64(minSpeed) + p.Speed*float64(maxSpeed-minSpeed)
+		curve[i] = [2]int{maxTemp + p.TempOffset, int(math.Round(speed))}
+	}
+	return curve, nil
+}